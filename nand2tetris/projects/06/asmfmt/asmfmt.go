@@ -0,0 +1,141 @@
+// Package asmfmt lays out Hack assembly the standard way, the way the
+// VMtranslator lays out optimized code:
+//
+//   - labels start in column 0 and every other instruction is indented by a tab
+//   - instructions have no spaces in them, e.g. D=D+A;JGT
+//   - a comment after an instruction is separated from it by one space
+//   - comment lines stay in column 0 if they start there, and are indented like
+//     instructions otherwise
+//   - there's at most one blank line in a row, none at the start or the end, and
+//     lines end in \n
+//
+// Instructions are read with the lexer of the assembler, so only instructions
+// the assembler can read are formatted. Mnemonics aren't checked.
+package asmfmt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"assembler/isa"
+	"assembler/lexer"
+)
+
+// Format returns the Hack assembly in src formatted.
+func Format(src []byte) ([]byte, error) {
+	return FormatWithISA(src, isa.Hack)
+}
+
+// FormatWithISA returns the assembly for the instruction set set in src
+// formatted.
+func FormatWithISA(src []byte, set *isa.ISA) ([]byte, error) {
+	var out bytes.Buffer
+	blank := false // a blank line is due before the next line
+	for i, line := range strings.Split(string(src), "\n") {
+		code, comment := line, ""
+		if j := strings.Index(line, "//"); j != -1 {
+			code, comment = line[:j], strings.TrimRightFunc(line[j:], isSpace)
+		}
+		code = strings.Join(strings.Fields(code), "")
+		if code == "" && comment == "" {
+			blank = blank || out.Len() != 0
+			continue
+		}
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+
+		if code == "" {
+			if !strings.HasPrefix(line, "//") {
+				out.WriteString("\t")
+			}
+			out.WriteString(comment + "\n")
+			continue
+		}
+		formatted, isLabel, err := formatCode(code, set)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !isLabel {
+			out.WriteString("\t")
+		}
+		out.WriteString(formatted)
+		if comment != "" {
+			out.WriteString(" " + comment)
+		}
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r'
+}
+
+// allowed holds the characters, other than letters and digits, that
+// instructions are made of.
+const allowed = "_.$:@=;()!-+&|<>^"
+
+// shapes are the sequences of tokens that make up an instruction.
+var shapes = [][]lexer.Token{
+	{lexer.AT, lexer.CONSTANT},
+	{lexer.AT, lexer.SYMBOL},
+	{lexer.LEFT_PAREN, lexer.LABEL, lexer.RIGHT_PAREN},
+	{lexer.DEST, lexer.EQUALS, lexer.COMP},
+	{lexer.DEST, lexer.EQUALS, lexer.COMP, lexer.SEMICOLON, lexer.JUMP},
+	{lexer.COMP, lexer.SEMICOLON, lexer.JUMP},
+}
+
+// formatCode returns the instruction in code, which has no spaces, and whether
+// it's a label.
+func formatCode(code string, set *isa.ISA) (string, bool, error) {
+	for _, r := range code {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(allowed, r) {
+			return "", false, fmt.Errorf("unexpected character %q in %q", r, code)
+		}
+	}
+	// Every part between = and ; has to start like a token, or the lexer can't
+	// read it.
+	for _, part := range strings.FieldsFunc(code, func(r rune) bool { return r == '=' || r == ';' }) {
+		r := rune(part[0])
+		if !strings.ContainsRune("@(_.$:", r) && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !set.StartsComp(r) {
+			return "", false, fmt.Errorf("unexpected %q in %q", part, code)
+		}
+	}
+
+	// A comp on its own computes without storing or jumping. The lexer can't
+	// tell it from a stray word, so it's left as it is.
+	if !strings.ContainsAny(code, "@()=;") {
+		return code, false, nil
+	}
+
+	l := lexer.NewLexerWithISA(strings.NewReader(code), set)
+	tokens, values := []lexer.Token{}, []string{}
+	for token, value := l.NextToken(); token != lexer.EOF; token, value = l.NextToken() {
+		tokens, values = append(tokens, token), append(values, value)
+		if len(tokens) > 5 {
+			break
+		}
+	}
+	for _, shape := range shapes {
+		if equal(tokens, shape) {
+			return strings.Join(values, ""), tokens[0] == lexer.LEFT_PAREN, nil
+		}
+	}
+	return "", false, fmt.Errorf("%q is not an instruction", code)
+}
+
+func equal(a, b []lexer.Token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
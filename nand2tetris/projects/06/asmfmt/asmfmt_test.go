@@ -0,0 +1,85 @@
+package asmfmt
+
+import (
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"indents instructions", "@R0\nD=M\n", "\t@R0\n\tD=M\n"},
+		{"labels in column 0", "  (LOOP)\n\t0;JMP\n", "(LOOP)\n\t0;JMP\n"},
+		{"spaces around = and ;", "  D = D + A\n D ; JGT\nAM = M - 1 ; JNE\n", "\tD=D+A\n\tD;JGT\n\tAM=M-1;JNE\n"},
+		{"comp on its own", "   D\n", "\tD\n"},
+		{"trailing comments", "@i    // counter   \n(END)\t// stop\n", "\t@i // counter\n(END) // stop\n"},
+		{
+			"comment lines keep column 0",
+			"// header\n   // indented\n\t//tab\n",
+			"// header\n\t// indented\n\t//tab\n",
+		},
+		{"blank lines", "\n\n@1\n\n\n\nD=A\n\n\n", "\t@1\n\n\tD=A\n"},
+		{"crlf", "@1\r\nD=A\r\n", "\t@1\n\tD=A\n"},
+		{"no newline at the end", "@1\nD=A", "\t@1\n\tD=A\n"},
+		{"formatted already", "// x\n(LOOP)\n\t@LOOP // again\n\t0;JMP\n", "// x\n(LOOP)\n\t@LOOP // again\n\t0;JMP\n"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			formatted, err := Format([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(formatted) != test.expected {
+				t.Errorf("expected %q got %q", test.expected, formatted)
+			}
+			again, err := Format(formatted)
+			if err != nil || string(again) != string(formatted) {
+				t.Errorf("formatting twice changed %q to %q (%v)", formatted, again, err)
+			}
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"illegal character", "@R0\nD=M#1\n"},
+		{"two instructions", "@R0 D=M\n"},
+		{"unclosed label", "(LOOP\n"},
+		{"text after label", "(LOOP)x\n"},
+		{"no comp", "D=;JMP\n"},
+		{"operator first", "D=&;1\n"},
+	}
+	for _, test := range tests {
+		if _, err := Format([]byte(test.input)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+	if _, err := Format([]byte("@R0\n\nD=M#1\n")); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("expected the error on line 3 got %v", err)
+	}
+}
+
+func TestFormatWithISA(t *testing.T) {
+	set, err := isa.Load(strings.NewReader(`{"name": "shift", "base": "hack", "comp": {"D<<": "101 0110000"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := FormatWithISA([]byte("D = D<<\n"), set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != "\tD=D<<\n" {
+		t.Errorf("expected D=D<< got %q", formatted)
+	}
+}
@@ -1,126 +1,257 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 
-	"assembler/code"
-	"assembler/parser"
-	"assembler/symboltable"
+	"assembler/isa"
+	"assembler/source"
 )
 
+// stdio is the file argument that assembles stdin to stdout, so the assembler
+// can be chained with other tools, e.g. `VMtranslator - | assembler - > out.hack`.
+const stdio = "-"
+
 func main() {
 
-	if len(os.Args) != 2 {
-		fmt.Println("Assembler expects one argument: *filename*.asm")
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	isaName := flags.String("isa", isa.Hack.Name, "instruction set: the name of a built-in one or a JSON file describing it")
+	disassembleFile := flags.Bool("d", false, "disassemble a .hack file, or - for stdin, to stdout")
+	canonicalizeFiles := flags.Bool("canonicalize", false, "rewrite the .asm files with every comp and dest spelled the canonical way, e.g. D+A for A+D, instead of assembling")
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+
+	if len(args) < 1 {
+		fmt.Println("Assembler expects one argument: *filename*.asm, or - to assemble stdin to stdout")
+		fmt.Println("Several .asm files or directories of them are assembled at the same time")
 		os.Exit(1)
 	}
-	filePath := os.Args[1]
-	asmFile, err := os.Open(filePath)
+	filePath := args[0]
 
+	set, err := isa.Open(*isaName)
 	if err != nil {
-		fmt.Printf("Could not open .asm file: %s\n", asmFile.Name())
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer asmFile.Close()
 
-	fmt.Printf("Assembling \"%s\"\n", filePath)
-	p := parser.NewParser(asmFile)
-
-	outputFileName := strings.Split(filePath, ".")[0] + ".hack"
-	hackFile, err := os.Create(outputFileName)
-
-	if err != nil {
-		fmt.Printf("Error: Could not create file %s\n", outputFileName)
-		panic(err)
+	if *disassembleFile {
+		in := os.Stdin
+		if filePath != stdio {
+			if in, err = os.Open(filePath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer in.Close()
+		}
+		if err := disassemble(in, os.Stdout, set); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not disassemble %s: %v\n", filePath, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	const baseTwo = 2
-	const baseTen = 10
-	const sixteenBit = 16
-	const newLine = "\n"
+	if *canonicalizeFiles {
+		if filePath == stdio {
+			if err := canonicalize(os.Stdin, os.Stdout, set); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not canonicalize stdin: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		paths, err := asmPaths(args)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, path := range paths {
+			changed, err := canonicalizeFile(path, set)
+			if err != nil {
+				fmt.Printf("Could not canonicalize %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			if changed {
+				fmt.Printf("Canonicalized \"%s\"\n", path)
+			}
+		}
+		return
+	}
 
-	// First pass to build symbol table
-	romAddress := 0
-	st := symboltable.NewSymbolTable()
-	for p.HasMoreCommands() {
-		if err := p.Advance(); err != nil {
-			panic(err)
+	if filePath == stdio && len(args) == 1 {
+		if err := assembleWithISA(os.Stdin, os.Stdout, set); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not assemble stdin: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		ct := p.CommandType()
-		if ct == (parser.A_COMMAND{}) || ct == (parser.C_COMMAND{}) {
-			romAddress += 1
+	if len(args) > 1 || isDir(filePath) {
+		paths, err := asmPaths(args)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		if ct == (parser.L_COMMAND{}) {
-			symbol, err := p.Symbol()
+		failed := false
+		for i, err := range assembleFiles(paths, runtime.NumCPU(), set) {
 			if err != nil {
-				panic(err)
+				fmt.Printf("Could not assemble %s: %v\n", paths[i], err)
+				failed = true
+			} else {
+				fmt.Printf("Assembled \"%s\" to \"%s\"\n", paths[i], hackPath(paths[i]))
 			}
-			st.AddEntry(symbol, romAddress)
 		}
+		if failed {
+			os.Exit(1)
+		}
+		return
 	}
 
-	if err := p.Reset(); err != nil {
-		fmt.Printf("could not reset parser after first pass got error: %v", err)
+	fmt.Printf("Assembling \"%s\"\n", filePath)
+	if err := assembleFile(filePath, set); err != nil {
+		fmt.Printf("Could not assemble %s: %v\n", filePath, err)
 		os.Exit(1)
 	}
+}
 
-	// Second pass
-	ramAddress := 16
-	for p.HasMoreCommands() {
-		if err := p.Advance(); err != nil {
-			panic(err)
-		}
+// hackPath returns the name of the .hack file the .asm file at path assembles to.
+func hackPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".hack"
+}
 
-		if p.CommandType() == (parser.A_COMMAND{}) {
-			symbol, err := p.Symbol()
-			if err != nil {
-				panic(err)
-			}
-			// Parse symbol into decimal representation
-			symbolAsInt, err := strconv.ParseInt(symbol, baseTen, sixteenBit)
-
-			if err != nil { // @Xxx is a symbol, not a decimal
-				if address := st.GetAddress(symbol); address != -1 { // symbol is in table; replace with numeric meaning
-					symbolAsInt = int64(st.GetAddress(symbol))
-				} else { // symbol is a new variable
-					st.AddEntry(symbol, ramAddress)
-					symbolAsInt = int64(ramAddress)
-					ramAddress += 1
-				}
-			}
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
 
-			// Write A command as binary string to file
-			symbolAsBinary := fmt.Sprintf("%016s", strconv.FormatInt(symbolAsInt, baseTwo))
-			line := symbolAsBinary + newLine
-			hackFile.WriteString(line)
+// asmPaths returns the .asm files named by args, which are .asm files or
+// directories whose .asm files are all assembled.
+func asmPaths(args []string) ([]string, error) {
+	paths := []string{}
+	for _, arg := range args {
+		if !isDir(arg) {
+			paths = append(paths, arg)
+			continue
 		}
-		if p.CommandType() == (parser.C_COMMAND{}) {
-			dest, err := p.Dest()
-			if err != nil {
-				panic(err)
-			}
-			comp, err := p.Comp()
-			if err != nil {
-				panic(err)
-			}
-			jump, err := p.Jump()
-			if err != nil {
-				panic(err)
+		files, err := filepath.Glob(filepath.Join(arg, "*.asm"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no .asm files in %s", arg)
+		}
+		paths = append(paths, files...)
+	}
+	return paths, nil
+}
+
+// assembleFile assembles the .asm file at path for the instruction set set to
+// a .hack file next to it.
+func assembleFile(path string, set *isa.ISA) error {
+	asmFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer asmFile.Close()
+
+	hackFile, err := os.Create(hackPath(path))
+	if err != nil {
+		return err
+	}
+	if err := assembleWithISA(asmFile, hackFile, set); err != nil {
+		hackFile.Close()
+		return err
+	}
+	return hackFile.Close()
+}
+
+// assembleFiles assembles the .asm files at paths for the instruction set set
+// on up to jobs goroutines and
+// returns the error of every file, nil for the ones that were assembled. Every
+// assembly has its own parser and symbol table, so the result of a file
+// doesn't depend on the others or on the order they're assembled in.
+func assembleFiles(paths []string, jobs int, set *isa.ISA) []error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs && j < len(paths); j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = assembleFile(paths[i], set)
 			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
 
-			// convert mnemonics to bits
-			destBits, compBits, jumpBits := code.Dest(dest), code.Comp(comp), code.Jump(jump)
-			line := "111" + code.BytesToBitString(compBits) + code.BytesToBitString(destBits) + code.BytesToBitString(jumpBits) + newLine
-			hackFile.WriteString(line)
+// assemble reads Hack assembly from r and writes the binary code to w, one
+// instruction per line.
+func assemble(r io.Reader, w io.Writer) error {
+	return assembleWithISA(r, w, isa.Hack)
+}
+
+// assembleWithISA reads assembly for the instruction set set from r and writes
+// the binary code to w, one instruction per line. Errors give the line of the
+// source they're on.
+func assembleWithISA(r io.Reader, w io.Writer, set *isa.ISA) error {
+	prog, err := source.Parse(r, set)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	for _, in := range prog.Instructions {
+		if in.IsLabel() {
+			continue
+		}
+		word, err := prog.Encode(in, set)
+		if err != nil {
+			return &source.Error{Line: in.Pos.Line, Err: fmt.Errorf("%s: %w", in.Text, err)}
 		}
+		out.WriteString(set.FormatWord(word) + "\n")
 	}
+	return out.Flush()
+}
 
-	if err := hackFile.Close(); err != nil {
-		fmt.Printf("Could not close .hack file: %s\n", hackFile.Name())
-		panic(err)
+// disassemble reads binary code for the instruction set set from r, one
+// instruction per line, and writes the assembly of each to w. Labels and
+// variables have been replaced by addresses, so those are all that's written.
+func disassemble(r io.Reader, w io.Writer, set *isa.ISA) error {
+	scanner := bufio.NewScanner(r)
+	out := bufio.NewWriter(w)
+	for line := 1; scanner.Scan(); line++ {
+		bits := strings.TrimSpace(scanner.Text())
+		if bits == "" {
+			continue
+		}
+		word, err := strconv.ParseUint(bits, 2, 32)
+		if err != nil || len(bits) != set.WordBits {
+			return fmt.Errorf("line %d: expected %d binary digits, got %q", line, set.WordBits, bits)
+		}
+		asm, err := set.Disassemble(uint32(word))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		out.WriteString(asm + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
+	return out.Flush()
 }
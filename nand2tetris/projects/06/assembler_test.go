@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"assembler/isa"
 )
 
 // linesEqual compares two hack binary files line-by-line after stripping whitespace.
@@ -190,3 +192,186 @@ func TestSymbols(t *testing.T) {
 		}
 	}
 }
+
+func TestAssembleReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"Add",
+			"// Computes R0 = 2 + 3\n@2\nD=A\n@3\nD=D+A\n@0\nM=D\n",
+			"0000000000000010\n1110110000010000\n0000000000000011\n1110000010010000\n0000000000000000\n1110001100001000\n",
+		},
+		{
+			"labels and variables without a trailing newline",
+			"(LOOP)\n@i\nM=1\n@LOOP\n0;JMP",
+			"0000000000010000\n1110111111001000\n0000000000000000\n1110101010000111\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := assemble(strings.NewReader(test.input), &out); err != nil {
+				t.Fatalf("could not assemble %q: %v", test.input, err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
+
+// programs are small programs whose symbols collide: LOOP is a label in some
+// and a variable in others, and R0 is redefined as a label.
+var programs = []string{
+	"(LOOP)\n@LOOP\n0;JMP\n",
+	"@LOOP\nM=1\n@i\nM=0\n",
+	"@i\nM=1\n@j\nM=1\n(LOOP)\n@LOOP\n0;JMP\n",
+	"@2\nD=A\n(R0)\n@R0\nD;JGT\n",
+	"@SCREEN\nM=-1\n@KBD\nD=M\n",
+}
+
+func TestAssembleConcurrently(t *testing.T) {
+	expected := make([]string, len(programs))
+	for i, program := range programs {
+		var out bytes.Buffer
+		if err := assemble(strings.NewReader(program), &out); err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = out.String()
+	}
+
+	// the same programs again, in parallel and in every order; run with -race
+	for round := 0; round < 20; round++ {
+		round := round
+		t.Run(fmt.Sprint(round), func(t *testing.T) {
+			t.Parallel()
+			i := round % len(programs)
+			var out bytes.Buffer
+			if err := assemble(strings.NewReader(programs[i]), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != expected[i] {
+				t.Errorf("program %d: expected\n%s\ngot\n%s", i, expected[i], out.String())
+			}
+		})
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{}
+	for i, program := range programs {
+		path := filepath.Join(dir, fmt.Sprintf("Prog%d.asm", i))
+		if err := os.WriteFile(path, []byte(program), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	found, err := asmPaths([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(paths) {
+		t.Fatalf("expected %d .asm files in %s got %v", len(paths), dir, found)
+	}
+
+	for _, err := range assembleFiles(found, 3, isa.Hack) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, path := range paths {
+		var expected bytes.Buffer
+		if err := assemble(strings.NewReader(programs[i]), &expected); err != nil {
+			t.Fatal(err)
+		}
+		hack, err := os.ReadFile(hackPath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(hack) != expected.String() {
+			t.Errorf("%s: expected\n%s\ngot\n%s", path, expected.String(), hack)
+		}
+	}
+
+	errs := assembleFiles([]string{paths[0], filepath.Join(dir, "Missing.asm")}, 2, isa.Hack)
+	if errs[0] != nil || errs[1] == nil {
+		t.Errorf("expected only the missing file to fail got %v", errs)
+	}
+}
+
+func TestAssembleWithISA(t *testing.T) {
+	shift, err := isa.Load(strings.NewReader(`{
+		"name": "shift",
+		"base": "hack",
+		"comp": {"D<<": "101 0110000", "M>>": "101 1000000"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "@3\nD=A\nD=D<<\n@R0\nM=M>>\n"
+	expected := "0000000000000011\n1110110000010000\n1010110000010000\n0000000000000000\n1011000000001000\n"
+	var out bytes.Buffer
+	if err := assembleWithISA(strings.NewReader(input), &out, shift); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	// stock Hack has no shifts
+	if err := assemble(strings.NewReader(input), &out); err == nil || !strings.Contains(err.Error(), "D<<") {
+		t.Errorf("expected an error about D<< in stock Hack got %v", err)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	// the labels and comments make the line differ from the command's index
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(START)\n@1\n// multiply\n(LOOP)\nD=D*A\n", "line 5: "},
+		{"(START)\n\n@40000\n", "line 3: @40000: "},
+		{"@1\n(LOOP)\nD;JUMP\n", "line 3: "},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := assemble(strings.NewReader(test.input), &out)
+		if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("%q: expected an error starting %q got %v", test.input, test.expected, err)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	for i, program := range programs {
+		var hack, asm bytes.Buffer
+		if err := assemble(strings.NewReader(program), &hack); err != nil {
+			t.Fatal(err)
+		}
+		binary := hack.String()
+		if err := disassemble(&hack, &asm, isa.Hack); err != nil {
+			t.Fatalf("program %d: %v", i, err)
+		}
+
+		// labels and variables come back as addresses, which assemble the same
+		var reassembled bytes.Buffer
+		if err := assemble(&asm, &reassembled); err != nil {
+			t.Fatal(err)
+		}
+		if reassembled.String() != binary {
+			t.Errorf("program %d: expected\n%s\ngot\n%s", i, binary, reassembled.String())
+		}
+	}
+
+	if err := disassemble(strings.NewReader("0101\n"), io.Discard, isa.Hack); err == nil {
+		t.Error("expected an error for a word of 4 bits")
+	}
+}
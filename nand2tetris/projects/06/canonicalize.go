@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"assembler/isa"
+)
+
+// canonicalize copies the assembly from r to w with the comp and dest of every
+// C-instruction spelled the canonical way for set, e.g. D=D+A for D=A+D and
+// MD=M+1 for DM=1+M. Comments and the layout of the lines are kept.
+func canonicalize(r io.Reader, w io.Writer, set *isa.ISA) error {
+	scanner := bufio.NewScanner(r)
+	out := bufio.NewWriter(w)
+	for line := 1; scanner.Scan(); line++ {
+		text, err := canonicalizeLine(scanner.Text(), set)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		out.WriteString(text + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// canonicalizeLine returns line with its C-instruction, if it has one, spelled
+// the canonical way.
+func canonicalizeLine(line string, set *isa.ISA) (string, error) {
+	code, comment := line, ""
+	if i := strings.Index(line, "//"); i != -1 {
+		code, comment = line[:i], line[i:]
+	}
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" || strings.HasPrefix(trimmed, "@") || strings.HasPrefix(trimmed, "(") {
+		return line, nil
+	}
+	indent := code[:strings.Index(code, trimmed)]
+	trailing := code[len(indent)+len(trimmed):]
+
+	instruction := strings.Join(strings.Fields(trimmed), "")
+	dest, comp, jump := "", instruction, ""
+	if i := strings.Index(comp, "="); i != -1 {
+		dest, comp = comp[:i], comp[i+1:]
+	}
+	if i := strings.Index(comp, ";"); i != -1 {
+		comp, jump = comp[:i], comp[i+1:]
+	}
+
+	canonical, ok := set.CanonicalComp(comp)
+	if !ok {
+		return "", fmt.Errorf("unknown comp %q", comp)
+	}
+	instruction = canonical
+	if dest != "" {
+		canonicalDest, ok := set.CanonicalDest(dest)
+		if !ok {
+			return "", fmt.Errorf("unknown dest %q", dest)
+		}
+		instruction = canonicalDest + "=" + instruction
+	}
+	if jump != "" {
+		instruction += ";" + jump
+	}
+	return indent + instruction + trailing + comment, nil
+}
+
+// canonicalizeFile rewrites the .asm file at path with canonical spellings, and
+// reports whether anything changed.
+func canonicalizeFile(path string, set *isa.ISA) (bool, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var out bytes.Buffer
+	if err := canonicalize(bytes.NewReader(src), &out, set); err != nil {
+		return false, err
+	}
+	if bytes.Equal(src, out.Bytes()) {
+		return false, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path, out.Bytes(), fi.Mode().Perm())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+func TestCanonicalize(t *testing.T) {
+	// the parser reads neither dest=comp;jump nor spaces within an
+	// instruction, so some inputs are only canonicalized, not assembled
+	tests := []struct {
+		name      string
+		input     string
+		expected  string
+		assembles bool
+	}{
+		{"swapped operands", "D=A+D\n", "D=D+A\n", true},
+		{"constant first", "M=1+M\n", "M=M+1\n", true},
+		{"and with M first", "D=M&D\n", "D=D&M\n", true},
+		{"or with A first", "AM=A|D\n", "AM=D|A\n", true},
+		{"dest order", "DM=M+D\n", "MD=D+M\n", true},
+		{"jump", "1+D;JGT\n", "D+1;JGT\n", true},
+		{"dest, comp and jump", "AD=M+D;JMP\n", "AD=D+M;JMP\n", false},
+		{"canonical already", "D=D-A\n0;JMP\n", "D=D-A\n0;JMP\n", true},
+		{
+			"comments, labels and layout are kept",
+			"// sum\n(LOOP)\n   @i   // counter\n   D = A + D   // add\n\tM=D\n\n",
+			"// sum\n(LOOP)\n   @i   // counter\n   D=D+A   // add\n\tM=D\n\n",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := canonicalize(strings.NewReader(test.input), &out, isa.Hack); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("expected %q got %q", test.expected, out.String())
+			}
+
+			if !test.assembles {
+				return
+			}
+			// the aliases assemble to the same code as their canonical spelling
+			var aliased, canonical bytes.Buffer
+			if err := assemble(strings.NewReader(test.input), &aliased); err != nil {
+				t.Fatal(err)
+			}
+			if err := assemble(strings.NewReader(test.expected), &canonical); err != nil {
+				t.Fatal(err)
+			}
+			if aliased.String() != canonical.String() {
+				t.Errorf("expected %q to assemble like %q", test.input, test.expected)
+			}
+		})
+	}
+
+	for _, input := range []string{"D=D*A\n", "X=D\n"} {
+		if err := canonicalize(strings.NewReader(input), &bytes.Buffer{}, isa.Hack); err == nil || !strings.HasPrefix(err.Error(), "line 1: unknown") {
+			t.Errorf("%q: expected an error on line 1 got %v", input, err)
+		}
+	}
+}
+
+func TestCanonicalizeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Prog.asm")
+	if err := os.WriteFile(path, []byte("@2\nD=A\n@R0\nM=M+D\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := canonicalizeFile(path, isa.Hack); err != nil || !changed {
+		t.Fatalf("expected the file to change got %v %v", changed, err)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != "@2\nD=A\n@R0\nM=D+M\n" {
+		t.Errorf("unexpected canonical file %q", src)
+	}
+	if changed, err := canonicalizeFile(path, isa.Hack); err != nil || changed {
+		t.Errorf("expected a canonical file to stay the same got %v %v", changed, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around a change.
+const context = 3
+
+// edit is a line of a diff: kept (' '), deleted ('-') or inserted ('+').
+type edit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns the changes from a, the file name, to b in the unified
+// format of diff -u.
+func unifiedDiff(name string, a, b []byte) []byte {
+	edits := diffLines(lines(a), lines(b))
+
+	// aLines[i] and bLines[i] are the lines of a and b before edits[i].
+	aLines, bLines := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if e.op != '+' {
+			aLines[i+1]++
+		}
+		if e.op != '-' {
+			bLines[i+1]++
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "diff -u %s.orig %s\n--- %s.orig\n+++ %s\n", name, name, name, name)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs from context lines before a change to context lines after
+		// the last change that follows within twice that.
+		last := i
+		for j := i; j < len(edits) && j-last <= 2*context; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		start, end := i-context, last+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		aStart, aCount := aLines[start]+1, aLines[end]-aLines[start]
+		bStart, bCount := bLines[start]+1, bLines[end]-bLines[start]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// lines splits text into lines. A last line without a newline is marked the
+// way diff marks it, so it differs from the same line with one.
+func lines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	result := strings.Split(string(text), "\n")
+	last := len(result) - 1
+	if result[last] == "" {
+		return result[:last]
+	}
+	result[last] += "\n\\ No newline at end of file"
+	return result
+}
+
+// diffLines returns the shortest edit script from a to b, found with Myers'
+// algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	// v[offset+k] is the furthest x reached on diagonal k = x - y, and trace[d]
+	// holds the part of v that step d started from.
+	v := make([]int, 2*offset+1)
+	trace := [][]int{}
+	x, y := 0, 0
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	edits := []edit{}
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d] // prev[d+1+k] is v[offset+k]
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && prev[d+1+k-1] < prev[d+1+k+1] {
+			prevK = k + 1
+		}
+		prevX := prev[d+1+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{'+', b[y-1]})
+			} else {
+				edits = append(edits, edit{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
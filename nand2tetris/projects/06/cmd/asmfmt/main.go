@@ -0,0 +1,162 @@
+// Command asmfmt formats Hack assembly, the way gofmt formats Go.
+//
+// Usage:
+//
+//	asmfmt [flags] [path ...]
+//
+// Without paths, it formats stdin to stdout. A path that is a directory stands
+// for every .asm file in it and its subdirectories. By default the formatted
+// files are printed to stdout. The flags are:
+//
+//	-l	list the files whose formatting differs from asmfmt's
+//	-d	print diffs of the files to their formatted versions
+//	-w	write the formatted files back to their source
+//	-check	like -l, and exit with status 1 if any file isn't formatted, for CI
+//	-isa	the instruction set, a built-in one or a JSON file describing it
+//
+// Files that can't be formatted are reported on stderr and exit with status 2.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"assembler/asmfmt"
+	"assembler/isa"
+)
+
+type options struct {
+	list  bool
+	diff  bool
+	write bool
+	set   *isa.ISA
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs asmfmt with the command line arguments args and returns its exit
+// status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("asmfmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	list := flags.Bool("l", false, "list files whose formatting differs from asmfmt's")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	check := flags.Bool("check", false, "list files whose formatting differs from asmfmt's and exit with status 1 if there are any")
+	isaName := flags.String("isa", isa.Hack.Name, "instruction set: the name of a built-in one or a JSON file describing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	set, err := isa.Open(*isaName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	opts := options{list: *list || *check, diff: *diff, write: *write, set: set}
+
+	if flags.NArg() == 0 {
+		if opts.write {
+			fmt.Fprintln(stderr, "asmfmt: cannot use -w with standard input")
+			return 2
+		}
+		if _, err := formatFile("<standard input>", stdin, stdout, opts); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range flags.Args() {
+		paths, err := asmPaths(arg)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = 2
+			continue
+		}
+		for _, path := range paths {
+			changed, err := formatPath(path, stdout, opts)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				status = 2
+			} else if changed && *check && status == 0 {
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// asmPaths returns path if it's a file, or else the .asm files in the directory
+// path and its subdirectories.
+func asmPaths(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	paths := []string{}
+	err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".asm") {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	return paths, err
+}
+
+func formatPath(path string, stdout io.Writer, opts options) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return formatFile(path, f, stdout, opts)
+}
+
+// formatFile formats the assembly read from in, which is the file name, and
+// writes the result as opts ask for. It reports whether the formatting of the
+// file changed.
+func formatFile(name string, in io.Reader, stdout io.Writer, opts options) (bool, error) {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := asmfmt.FormatWithISA(src, opts.set)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	changed := !bytes.Equal(src, formatted)
+
+	if changed {
+		if opts.list {
+			fmt.Fprintln(stdout, name)
+		}
+		if opts.write {
+			fi, err := os.Stat(name)
+			if err != nil {
+				return false, err
+			}
+			if err := os.WriteFile(name, formatted, fi.Mode().Perm()); err != nil {
+				return false, err
+			}
+		}
+		if opts.diff {
+			stdout.Write(unifiedDiff(name, src, formatted))
+		}
+	}
+	if !opts.list && !opts.write && !opts.diff {
+		stdout.Write(formatted)
+	}
+	return changed, nil
+}
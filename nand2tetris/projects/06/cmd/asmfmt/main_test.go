@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	unformatted = "// sum\n(LOOP)\n  @i\n  D = M\n"
+	formatted   = "// sum\n(LOOP)\n\t@i\n\tD=M\n"
+)
+
+// writeFiles writes the files, a map from path to contents, to a new directory
+// and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stdout string
+	}{
+		{"print", []string{"a.asm"}, 0, formatted},
+		{"list", []string{"-l", "."}, 0, "a.asm\nsub/c.asm\n"},
+		{"check", []string{"-check", "."}, 1, "a.asm\nsub/c.asm\n"},
+		{"check formatted", []string{"-check", "b.asm"}, 0, ""},
+		{"diff", []string{"-d", "a.asm"}, 0, "diff -u a.asm.orig a.asm\n--- a.asm.orig\n+++ a.asm\n@@ -1,4 +1,4 @@\n // sum\n (LOOP)\n-  @i\n-  D = M\n+\t@i\n+\tD=M\n"},
+		{"error", []string{"bad.s"}, 2, ""},
+		{"missing file", []string{"none.asm"}, 2, ""},
+		{"unknown isa", []string{"-isa", "none", "a.asm"}, 2, ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"a.asm": unformatted, "b.asm": formatted, "sub/c.asm": unformatted, "bad.s": "D=M#\n"})
+			args := []string{}
+			for _, arg := range test.args {
+				if strings.Contains(arg, ".") {
+					arg = filepath.Join(dir, arg)
+				}
+				args = append(args, arg)
+			}
+
+			var stdout, stderr bytes.Buffer
+			if status := run(args, nil, &stdout, &stderr); status != test.status {
+				t.Errorf("expected status %d got %d: %s", test.status, status, stderr.String())
+			}
+			if actual := strings.ReplaceAll(filepath.ToSlash(stdout.String()), filepath.ToSlash(dir)+"/", ""); actual != test.stdout {
+				t.Errorf("expected output %q got %q", test.stdout, actual)
+			}
+			if contents, _ := os.ReadFile(filepath.Join(dir, "a.asm")); string(contents) != unformatted {
+				t.Errorf("expected a.asm to be left alone, got %q", contents)
+			}
+		})
+	}
+}
+
+func TestRunWrite(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.asm": unformatted, "sub/c.asm": unformatted})
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-w", dir}, nil, &stdout, &stderr); status != 0 || stdout.Len() != 0 {
+		t.Fatalf("expected no output and status 0, got %d: %s%s", status, stdout.String(), stderr.String())
+	}
+	for _, path := range []string{"a.asm", "sub/c.asm"} {
+		if contents, _ := os.ReadFile(filepath.Join(dir, path)); string(contents) != formatted {
+			t.Errorf("expected %s to be formatted, got %q", path, contents)
+		}
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run(nil, strings.NewReader(unformatted), &stdout, &stderr); status != 0 || stdout.String() != formatted {
+		t.Errorf("expected %q got %q with status %d: %s", formatted, stdout.String(), status, stderr.String())
+	}
+	if status := run([]string{"-w"}, strings.NewReader(unformatted), &stdout, &stderr); status != 2 {
+		t.Errorf("expected -w to need files, got status %d", status)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16"
+	b := "1\n2\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n14\n15\n16\n"
+	expected := `diff -u f.orig f
+--- f.orig
++++ f
+@@ -1,5 +1,6 @@
+ 1
+ 2
++x
+ 3
+ 4
+ 5
+@@ -10,7 +11,6 @@
+ 10
+ 11
+ 12
+-13
+ 14
+ 15
+-16
+\ No newline at end of file
++16
+`
+	if actual := string(unifiedDiff("f", []byte(a), []byte(b))); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+	if edits := diffLines(nil, nil); len(edits) != 0 {
+		t.Errorf("expected no edits between empty files got %v", edits)
+	}
+}
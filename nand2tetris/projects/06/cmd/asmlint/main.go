@@ -0,0 +1,165 @@
+// Command asmlint reports mistakes in Hack assembly that the assembler
+// accepts.
+//
+// Usage:
+//
+//	asmlint [flags] [path ...]
+//
+// Without paths, it checks stdin. A path that is a directory stands for every
+// .asm file in it and its subdirectories. Problems are printed as
+// file:line:column: message (rule). The flags are:
+//
+//	-enable	a comma-separated list of the only rules to run
+//	-disable	a comma-separated list of rules not to run
+//	-rules	list the rules and exit
+//	-isa	the instruction set, a built-in one or a JSON file describing it
+//
+// A // lint:ignore rule,... comment on a line, or on the comment line above it,
+// turns the rules off there. asmlint exits with status 1 if it found problems
+// and with status 2 if a file couldn't be checked.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"assembler/isa"
+	"assembler/lint"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs asmlint with the command line arguments args and returns its exit
+// status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("asmlint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	enable := flags.String("enable", "", "comma-separated rules to run instead of all of them")
+	disable := flags.String("disable", "", "comma-separated rules not to run")
+	listRules := flags.Bool("rules", false, "list the rules and exit")
+	isaName := flags.String("isa", isa.Hack.Name, "instruction set: the name of a built-in one or a JSON file describing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *listRules {
+		for _, rule := range lint.Rules {
+			fmt.Fprintf(stdout, "%s\t%s\n", rule.Name, rule.Doc)
+		}
+		return 0
+	}
+	rules, err := selectRules(*enable, *disable)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	set, err := isa.Open(*isaName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		return report("<standard input>", stdin, set, rules, stdout, stderr)
+	}
+	status := 0
+	for _, arg := range flags.Args() {
+		paths, err := asmPaths(arg)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = 2
+			continue
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				status = 2
+				continue
+			}
+			if s := report(path, f, set, rules, stdout, stderr); s > status {
+				status = s
+			}
+			f.Close()
+		}
+	}
+	return status
+}
+
+// selectRules returns the rules in the comma-separated list enable, or all of
+// them if it's empty, without those in disable.
+func selectRules(enable, disable string) ([]*lint.Rule, error) {
+	names := []string{}
+	if enable == "" {
+		for _, rule := range lint.Rules {
+			names = append(names, rule.Name)
+		}
+	} else {
+		names = strings.Split(enable, ",")
+	}
+	disabled := map[string]bool{}
+	if disable != "" {
+		for _, name := range strings.Split(disable, ",") {
+			if _, ok := lint.LookupRule(name); !ok {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			disabled[name] = true
+		}
+	}
+
+	rules := []*lint.Rule{}
+	for _, name := range names {
+		rule, ok := lint.LookupRule(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		if !disabled[name] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// report prints the problems in the assembly read from r, which is the file
+// name, and returns the exit status for it.
+func report(name string, r io.Reader, set *isa.ISA, rules []*lint.Rule, stdout, stderr io.Writer) int {
+	diagnostics, err := lint.Lint(r, set, rules)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 2
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintf(stdout, "%s:%s\n", name, d)
+	}
+	if len(diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// asmPaths returns path if it's a file, or else the .asm files in the directory
+// path and its subdirectories.
+func asmPaths(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	paths := []string{}
+	err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".asm") {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	return paths, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = "(UNUSED)\n@END\n0;JMP\nD=0\n(END)\n@END\n0;JMP\n"
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prog.asm")
+	if err := os.WriteFile(path, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "clean.asm"), []byte("(END)\n@END\n0;JMP\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		stdout string
+	}{
+		{"all rules", []string{dir}, 1, "prog.asm:1:1: label UNUSED is never used (unused-label)\nprog.asm:4:1: unreachable code after an unconditional jump (unreachable)\n"},
+		{"enable", []string{"-enable", "unreachable", path}, 1, "prog.asm:4:1: unreachable code after an unconditional jump (unreachable)\n"},
+		{"disable", []string{"-disable", "unreachable,unused-label", path}, 0, ""},
+		{"unknown rule", []string{"-enable", "nope", path}, 2, ""},
+		{"unknown disabled rule", []string{"-disable", "nope", path}, 2, ""},
+		{"missing file", []string{filepath.Join(dir, "none.asm")}, 2, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if status := run(test.args, nil, &stdout, &stderr); status != test.status {
+			t.Errorf("%s: expected status %d got %d: %s", test.name, test.status, status, stderr.String())
+		}
+		if actual := strings.ReplaceAll(stdout.String(), dir+string(filepath.Separator), ""); actual != test.stdout {
+			t.Errorf("%s: expected %q got %q", test.name, test.stdout, actual)
+		}
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run(nil, strings.NewReader("@x\nD=M\n"), &stdout, &stderr); status != 1 || !strings.HasPrefix(stdout.String(), "<standard input>:1:1: variable x") {
+		t.Errorf("expected a single-use variable on stdin, got status %d: %q", status, stdout.String())
+	}
+	if status := run(nil, strings.NewReader("@\n"), &stdout, &stderr); status != 2 {
+		t.Errorf("expected status 2 for a syntax error got %d", status)
+	}
+	stdout.Reset()
+	if status := run([]string{"-rules"}, nil, &stdout, &stderr); status != 0 || !strings.Contains(stdout.String(), "single-use\t") {
+		t.Errorf("expected the rules to be listed got %q", stdout.String())
+	}
+}
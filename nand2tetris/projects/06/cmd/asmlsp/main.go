@@ -0,0 +1,32 @@
+// Command asmlsp is a language server for Hack assembly. Editors start it and
+// talk the Language Server Protocol with it over stdin and stdout.
+//
+// Usage:
+//
+//	asmlsp [-isa name]
+//
+// where name is a built-in instruction set or a JSON file describing one.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"assembler/isa"
+	"assembler/lsp"
+)
+
+func main() {
+	isaName := flag.String("isa", isa.Hack.Name, "instruction set: the name of a built-in one or a JSON file describing it")
+	flag.Parse()
+	set, err := isa.Open(*isaName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := lsp.NewServer(set).Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
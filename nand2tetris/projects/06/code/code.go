@@ -4,129 +4,61 @@ package code
 import (
 	"strconv"
 	"strings"
+
+	"assembler/isa"
 )
 
+// Dest returns the three dest bits of mnemonic in Hack, or no bits if it isn't
+// a dest mnemonic. The codes come from isa.Hack, see the isa package for other
+// instruction sets. Registers may be named in any order, e.g. DM for MD.
 func Dest(mnemonic string) []byte {
-	var bits []byte
-	switch mnemonic {
-	case "null":
-		bits = []byte{0, 0, 0}
-	case "M":
-		bits = []byte{0, 0, 1}
-	case "D":
-		bits = []byte{0, 1, 0}
-	case "MD":
-		bits = []byte{0, 1, 1}
-	case "A":
-		bits = []byte{1, 0, 0}
-	case "AM":
-		bits = []byte{1, 0, 1}
-	case "AD":
-		bits = []byte{1, 1, 0}
-	case "AMD":
-		bits = []byte{1, 1, 1}
-	case "":
-		bits = []byte{}
-	default:
-		bits = []byte{}
+	if mnemonic == "null" {
+		return bits(0, isa.Hack.DestBits)
+	}
+	canonical, _ := isa.Hack.CanonicalDest(mnemonic)
+	code, ok := isa.Hack.Dest[canonical]
+	if !ok {
+		return []byte{}
 	}
-	return bits
+	return bits(code, isa.Hack.DestBits)
 }
 
-func Comp(mnemonic string) []byte {
-	var bits []byte
+// compBits is the number of bits of a comp in Hack after the 111 prefix: the
+// a-bit and c1..c6.
+const compBits = 7
 
-	switch mnemonic {
-	case "0":
-		bits = []byte{0, 1, 0, 1, 0, 1, 0}
-	case "1":
-		bits = []byte{0, 1, 1, 1, 1, 1, 1}
-	case "-1":
-		bits = []byte{0, 1, 1, 1, 0, 1, 0}
-	case "D":
-		bits = []byte{0, 0, 0, 1, 1, 0, 0}
-	case "A":
-		bits = []byte{0, 1, 1, 0, 0, 0, 0}
-	case "M":
-		bits = []byte{1, 1, 1, 0, 0, 0, 0}
-	case "!D":
-		bits = []byte{0, 0, 0, 1, 1, 0, 1}
-	case "!A":
-		bits = []byte{0, 1, 1, 0, 0, 0, 1}
-	case "!M":
-		bits = []byte{1, 1, 1, 0, 0, 0, 1}
-	case "-D":
-		bits = []byte{0, 0, 0, 1, 1, 1, 1}
-	case "-A":
-		bits = []byte{0, 1, 1, 0, 0, 1, 1}
-	case "-M":
-		bits = []byte{1, 1, 1, 0, 0, 1, 1}
-	case "D+1":
-		bits = []byte{0, 0, 1, 1, 1, 1, 1}
-	case "A+1":
-		bits = []byte{0, 1, 1, 0, 1, 1, 1}
-	case "M+1":
-		bits = []byte{1, 1, 1, 0, 1, 1, 1}
-	case "D-1":
-		bits = []byte{0, 0, 0, 1, 1, 1, 0}
-	case "A-1":
-		bits = []byte{0, 1, 1, 0, 0, 1, 0}
-	case "M-1":
-		bits = []byte{1, 1, 1, 0, 0, 1, 0}
-	case "D+A":
-		bits = []byte{0, 0, 0, 0, 0, 1, 0}
-	case "D+M":
-		bits = []byte{1, 0, 0, 0, 0, 1, 0}
-	case "D-A":
-		bits = []byte{0, 0, 1, 0, 0, 1, 1}
-	case "D-M":
-		bits = []byte{1, 0, 1, 0, 0, 1, 1}
-	case "A-D":
-		bits = []byte{0, 0, 0, 0, 1, 1, 1}
-	case "M-D":
-		bits = []byte{1, 0, 0, 0, 1, 1, 1}
-	case "D&A":
-		bits = []byte{0, 0, 0, 0, 0, 0, 0}
-	case "D&M":
-		bits = []byte{1, 0, 0, 0, 0, 0, 0}
-	case "D|A":
-		bits = []byte{0, 0, 1, 0, 1, 0, 1}
-	case "D|M":
-		bits = []byte{1, 0, 1, 0, 1, 0, 1}
-	case "":
-		bits = []byte{}
-	default:
-		bits = []byte{}
+// Comp returns the a-bit and c1..c6 of mnemonic in Hack, or no bits if it isn't
+// a comp mnemonic. Operands of +, & and | may be written either way round, e.g.
+// A+D for D+A.
+func Comp(mnemonic string) []byte {
+	canonical, _ := isa.Hack.CanonicalComp(mnemonic)
+	code, ok := isa.Hack.Comp[canonical]
+	if !ok {
+		return []byte{}
 	}
-
-	return bits
+	return bits(code, compBits)
 }
 
+// Jump returns the three jump bits of mnemonic in Hack, or no bits if it isn't
+// a jump mnemonic.
 func Jump(mnemonic string) []byte {
-	var bits []byte
-	switch mnemonic {
-	case "null":
-		bits = []byte{0, 0, 0}
-	case "JGT":
-		bits = []byte{0, 0, 1}
-	case "JEQ":
-		bits = []byte{0, 1, 0}
-	case "JGE":
-		bits = []byte{0, 1, 1}
-	case "JLT":
-		bits = []byte{1, 0, 0}
-	case "JNE":
-		bits = []byte{1, 0, 1}
-	case "JLE":
-		bits = []byte{1, 1, 0}
-	case "JMP":
-		bits = []byte{1, 1, 1}
-	case "":
-		bits = []byte{}
-	default:
-		bits = []byte{}
+	if mnemonic == "null" {
+		return bits(0, isa.Hack.JumpBits)
+	}
+	code, ok := isa.Hack.Jump[mnemonic]
+	if !ok {
+		return []byte{}
+	}
+	return bits(code, isa.Hack.JumpBits)
+}
+
+// bits returns the low n bits of code, the highest first.
+func bits(code uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(code >> (n - 1 - i) & 1)
 	}
-	return bits
+	return b
 }
 
 func BytesToBitString(b []byte) string {
@@ -103,3 +103,15 @@ func TestJump(t *testing.T) {
 		})
 	}
 }
+
+func TestCompAliases(t *testing.T) {
+	aliases := map[string]string{"A+D": "D+A", "1+D": "D+1", "M&D": "D&M", "M|D": "D|M", "M+D": "D+M"}
+	for alias, canonical := range aliases {
+		if actual, expected := BytesToBitString(Comp(alias)), BytesToBitString(Comp(canonical)); actual != expected {
+			t.Errorf("expected %s to encode like %s (%s) got %q", alias, canonical, expected, actual)
+		}
+	}
+	if actual := BytesToBitString(Dest("DM")); actual != "011" {
+		t.Errorf("expected dest DM to encode like MD got %q", actual)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+func FuzzAssemble(f *testing.F) {
+	paths, _ := filepath.Glob("testdata/*.asm")
+	if len(paths) == 0 {
+		f.Fatal("no assembly samples in testdata")
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, program := range programs {
+		f.Add(program)
+	}
+	for _, input := range []string{"", "(LOOP", "@99999999999", "@-1", "D=M;JMP", "D=Q", "(A)\n(A)", "@R0\nD=M"} {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var out bytes.Buffer
+		if err := assemble(strings.NewReader(input), &out); err != nil {
+			return
+		}
+		// everything assembled can be disassembled
+		var disassembled bytes.Buffer
+		if err := disassemble(&out, &disassembled, isa.Hack); err != nil {
+			t.Errorf("%q assembled to code that can't be disassembled: %v", input, err)
+		}
+	})
+}
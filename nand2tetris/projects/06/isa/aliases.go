@@ -0,0 +1,113 @@
+package isa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// commutative lists the operators whose operands can be written either way round.
+const commutative = "+&|^"
+
+// compileAliases works out every other spelling of the comps and dests of s:
+// the explicit Aliases, comps with the operands of a commutative operator
+// swapped, like A+D for D+A or 1+D for D+1, and dests with their registers in
+// another order, like DM for MD.
+func (s *ISA) compileAliases() error {
+	s.compAliases = map[string]string{}
+	for _, mnemonic := range sortedKeys(s.Comp) {
+		if len(mnemonic) == 3 && strings.IndexByte(commutative, mnemonic[1]) != -1 {
+			swapped := string([]byte{mnemonic[2], mnemonic[1], mnemonic[0]})
+			if _, ok := s.Comp[swapped]; !ok {
+				s.compAliases[swapped] = mnemonic
+			}
+		}
+	}
+
+	aliases := []string{}
+	for alias := range s.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		mnemonic := s.Aliases[alias]
+		if _, ok := s.Comp[alias]; ok {
+			return fmt.Errorf("%s: alias %s is a comp of its own", s.Name, alias)
+		}
+		code, ok := s.Comp[mnemonic]
+		if !ok {
+			return fmt.Errorf("%s: alias %s stands for %s, which isn't a comp", s.Name, alias, mnemonic)
+		}
+		c, err := compileComp(alias)
+		if err != nil {
+			return fmt.Errorf("%s: alias %w", s.Name, err)
+		}
+		if !s.sameResults(c, s.comps[code]) {
+			return fmt.Errorf("%s: alias %s doesn't compute the same as %s", s.Name, alias, mnemonic)
+		}
+		s.compAliases[alias] = mnemonic
+	}
+
+	s.destAliases = map[string]string{}
+	for mnemonic := range s.Dest {
+		for _, alias := range permutations(mnemonic) {
+			if _, ok := s.Dest[alias]; !ok {
+				s.destAliases[alias] = mnemonic
+			}
+		}
+	}
+	return nil
+}
+
+// samples are the values sameResults tries for D, A and M.
+var samples = []int32{0, 1, -1, 2, -7, 12345, -32768, 32767}
+
+// sameResults reports whether x and y compute the same words from the samples.
+func (s *ISA) sameResults(x, y *comp) bool {
+	mask := int32(1<<s.WordBits - 1)
+	for _, d := range samples {
+		for _, a := range samples {
+			for _, m := range samples {
+				if x.compute(d, a, m)&mask != y.compute(d, a, m)&mask {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// permutations returns every order of the letters of mnemonic.
+func permutations(mnemonic string) []string {
+	if len(mnemonic) <= 1 {
+		return []string{mnemonic}
+	}
+	result := []string{}
+	for i := range mnemonic {
+		rest := mnemonic[:i] + mnemonic[i+1:]
+		for _, p := range permutations(rest) {
+			result = append(result, mnemonic[i:i+1]+p)
+		}
+	}
+	return result
+}
+
+// CanonicalComp returns the comp mnemonic is a spelling of, and whether it's a
+// comp of s at all. A comp is its own canonical spelling.
+func (s *ISA) CanonicalComp(mnemonic string) (string, bool) {
+	if _, ok := s.Comp[mnemonic]; ok {
+		return mnemonic, true
+	}
+	canonical, ok := s.compAliases[mnemonic]
+	return canonical, ok
+}
+
+// CanonicalDest returns the dest mnemonic is a spelling of, and whether it's a
+// dest of s at all. Null, written as "" or "null", is its own canonical spelling.
+func (s *ISA) CanonicalDest(mnemonic string) (string, bool) {
+	if _, ok := s.Dest[mnemonic]; ok || mnemonic == "" || mnemonic == "null" {
+		return mnemonic, true
+	}
+	canonical, ok := s.destAliases[mnemonic]
+	return canonical, ok
+}
@@ -0,0 +1,345 @@
+// Package isa describes a Hack instruction set as a table: the mnemonics of the
+// comp, dest and jump fields of C-instructions, their codes and the width of an
+// instruction. The lexer, the encoder, the disassembler and emulators all work
+// from an ISA, so a variant of Hack, e.g. with shift instructions or wider
+// words, only needs a new table. Hack is the stock instruction set of the book.
+//
+// A word whose top bit is 0 is an A-instruction that loads the rest of the word
+// into A. Any other word is a C-instruction laid out as comp, dest, jump from
+// the top down. The comp code covers every bit above dest and jump, so it
+// includes the prefix of the instruction, e.g. 111 in Hack, and variants may
+// give their extra instructions prefixes of their own.
+//
+// What an instruction does follows from its mnemonics: a comp is one of the
+// operands 0, 1, D, A or M, an operand after ! or -, two operands joined by
+// +, -, &, | or ^, or an operand followed by << or >>, which shift by one bit.
+// >> keeps the sign. A dest names the registers it stores to, and a jump is
+// one of JGT, JEQ, JGE, JLT, JNE, JLE and JMP.
+package isa
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ISA is an instruction set. Build one from its tables and Compile it, or Load
+// it from a file, before use. A compiled ISA must not be modified and is safe
+// for concurrent use.
+type ISA struct {
+	Name     string
+	WordBits int // bits in an instruction, 16 in Hack
+	DestBits int // bits of the dest field
+	JumpBits int // bits of the jump field
+
+	Comp map[string]uint32 // comp mnemonics and the bits above dest and jump
+	Dest map[string]uint32 // dest mnemonics other than null, which is always 0
+	Jump map[string]uint32 // jump mnemonics other than null, which is always 0
+
+	// Aliases maps other spellings of comps to the comp they stand for, e.g.
+	// "0-1" to "-1". Swapped operands, like A+D for D+A, needn't be listed.
+	Aliases map[string]string
+
+	comps       map[uint32]*comp
+	dests       map[uint32]string
+	jumps       map[uint32]string
+	compStart   map[rune]bool
+	compAliases map[string]string
+	destAliases map[string]string
+}
+
+// comp is a comp mnemonic with what it computes.
+type comp struct {
+	mnemonic string
+	compute  func(d, a, m int32) int32
+	readsM   bool
+}
+
+// Hack is the instruction set of the book.
+var Hack = &ISA{
+	Name:     "hack",
+	WordBits: 16,
+	DestBits: 3,
+	JumpBits: 3,
+	Comp: map[string]uint32{
+		"0": 0b1110101010, "1": 0b1110111111, "-1": 0b1110111010,
+		"D": 0b1110001100, "A": 0b1110110000, "!D": 0b1110001101, "!A": 0b1110110001,
+		"-D": 0b1110001111, "-A": 0b1110110011, "D+1": 0b1110011111, "A+1": 0b1110110111,
+		"D-1": 0b1110001110, "A-1": 0b1110110010, "D+A": 0b1110000010, "D-A": 0b1110010011,
+		"A-D": 0b1110000111, "D&A": 0b1110000000, "D|A": 0b1110010101,
+		"M": 0b1111110000, "!M": 0b1111110001, "-M": 0b1111110011, "M+1": 0b1111110111,
+		"M-1": 0b1111110010, "D+M": 0b1111000010, "D-M": 0b1111010011, "M-D": 0b1111000111,
+		"D&M": 0b1111000000, "D|M": 0b1111010101,
+	},
+	Dest: map[string]uint32{"M": 1, "D": 2, "MD": 3, "A": 4, "AM": 5, "AD": 6, "AMD": 7},
+	Jump: map[string]uint32{"JGT": 1, "JEQ": 2, "JGE": 3, "JLT": 4, "JNE": 5, "JLE": 6, "JMP": 7},
+	// the other ways of writing the comps with a subtraction, negation or
+	// bitwise not in them; swapped operands are worked out by Compile
+	Aliases: map[string]string{
+		"0-1": "-1",
+		"0-D": "-D", "0-A": "-A", "0-M": "-M",
+		"-D-1": "!D", "-1-D": "!D", "-A-1": "!A", "-1-A": "!A", "-M-1": "!M", "-1-M": "!M",
+		"D+-1": "D-1", "-1+D": "D-1", "A+-1": "A-1", "-1+A": "A-1", "M+-1": "M-1", "-1+M": "M-1",
+		"D+-A": "D-A", "-A+D": "D-A", "A+-D": "A-D", "-D+A": "A-D",
+		"D+-M": "D-M", "-M+D": "D-M", "M+-D": "M-D", "-D+M": "M-D",
+	},
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*ISA{}
+)
+
+func init() {
+	if err := Register(Hack); err != nil {
+		panic(err)
+	}
+}
+
+// Register compiles s and makes it available by its name to Lookup.
+func Register(s *ISA) error {
+	if err := s.Compile(); err != nil {
+		return err
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[s.Name]; ok {
+		return fmt.Errorf("instruction set %q is already registered", s.Name)
+	}
+	registry[s.Name] = s
+	return nil
+}
+
+// Lookup returns the registered instruction set called name.
+func Lookup(name string) (*ISA, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	s, ok := registry[name]
+	return s, ok
+}
+
+// Names returns the names of the registered instruction sets in order.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompBits returns the number of bits of the comp field, prefix included.
+func (s *ISA) CompBits() int {
+	return s.WordBits - s.DestBits - s.JumpBits
+}
+
+// MaxAddress returns the largest value an A-instruction can load.
+func (s *ISA) MaxAddress() uint32 {
+	return 1<<(s.WordBits-1) - 1
+}
+
+// Compile checks the tables of s and prepares it for use.
+func (s *ISA) Compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("instruction set has no name")
+	}
+	if s.WordBits < 8 || s.WordBits > 32 {
+		return fmt.Errorf("%s: words must have 8 to 32 bits, not %d", s.Name, s.WordBits)
+	}
+	if s.DestBits < 1 || s.JumpBits < 1 || s.CompBits() < 2 {
+		return fmt.Errorf("%s: %d bit words can't hold %d dest and %d jump bits and a comp", s.Name, s.WordBits, s.DestBits, s.JumpBits)
+	}
+
+	s.comps = map[uint32]*comp{}
+	s.compStart = map[rune]bool{}
+	for _, mnemonic := range sortedKeys(s.Comp) {
+		code := s.Comp[mnemonic]
+		if code >= 1<<s.CompBits() || code>>(s.CompBits()-1) == 0 {
+			return fmt.Errorf("%s: comp %s: code %b must have %d bits and start with 1", s.Name, mnemonic, code, s.CompBits())
+		}
+		if other, ok := s.comps[code]; ok {
+			return fmt.Errorf("%s: comps %s and %s have the same code %b", s.Name, other.mnemonic, mnemonic, code)
+		}
+		c, err := compileComp(mnemonic)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Name, err)
+		}
+		s.comps[code] = c
+	}
+	if err := s.compileAliases(); err != nil {
+		return err
+	}
+	for mnemonic := range s.Comp {
+		s.addCompStart(mnemonic)
+	}
+	for alias := range s.compAliases {
+		s.addCompStart(alias)
+	}
+
+	var err error
+	if s.dests, err = s.compileField("dest", s.Dest, s.DestBits, validDest); err != nil {
+		return err
+	}
+	s.jumps, err = s.compileField("jump", s.Jump, s.JumpBits, validJump)
+	return err
+}
+
+// compileField checks the mnemonics of a dest or jump field and returns them by code.
+func (s *ISA) compileField(field string, table map[string]uint32, bits int, valid func(string) bool) (map[uint32]string, error) {
+	byCode := map[uint32]string{}
+	for _, mnemonic := range sortedKeys(table) {
+		code := table[mnemonic]
+		if !valid(mnemonic) {
+			return nil, fmt.Errorf("%s: %s %q doesn't say what it does", s.Name, field, mnemonic)
+		}
+		if code == 0 || code >= 1<<bits {
+			return nil, fmt.Errorf("%s: %s %s: code %b must be non-zero and fit in %d bits", s.Name, field, mnemonic, code, bits)
+		}
+		if other, ok := byCode[code]; ok {
+			return nil, fmt.Errorf("%s: %ss %s and %s have the same code %b", s.Name, field, other, mnemonic, code)
+		}
+		byCode[code] = mnemonic
+	}
+	return byCode, nil
+}
+
+func sortedKeys(table map[string]uint32) []string {
+	keys := []string{}
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *ISA) addCompStart(mnemonic string) {
+	if first := []rune(mnemonic)[0]; !startsSymbol(first) {
+		s.compStart[first] = true
+	}
+}
+
+// StartsComp reports whether ch can start a comp mnemonic of s without being
+// able to start a symbol, like ! and - in Hack.
+func (s *ISA) StartsComp(ch rune) bool {
+	return s.compStart[ch]
+}
+
+// startsSymbol reports whether ch can start a symbol or a number.
+func startsSymbol(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || strings.ContainsRune("_.$:", ch)
+}
+
+// Encode returns the C-instruction dest=comp;jump. An empty dest or jump, or
+// null, leaves the field 0. Other spellings of a comp or dest are encoded like
+// their canonical one.
+func (s *ISA) Encode(dest, comp, jump string) (uint32, error) {
+	canonical, ok := s.CanonicalComp(comp)
+	if !ok {
+		return 0, fmt.Errorf("unknown comp %q", comp)
+	}
+	c := s.Comp[canonical]
+	d, j := uint32(0), uint32(0)
+	if dest != "" && dest != "null" {
+		canonical, ok := s.CanonicalDest(dest)
+		if !ok {
+			return 0, fmt.Errorf("unknown dest %q", dest)
+		}
+		d = s.Dest[canonical]
+	}
+	if jump != "" && jump != "null" {
+		if j, ok = s.Jump[jump]; !ok {
+			return 0, fmt.Errorf("unknown jump %q", jump)
+		}
+	}
+	return c<<(s.DestBits+s.JumpBits) | d<<s.JumpBits | j, nil
+}
+
+// EncodeAddress returns the A-instruction that loads value.
+func (s *ISA) EncodeAddress(value uint32) (uint32, error) {
+	if value > s.MaxAddress() {
+		return 0, fmt.Errorf("%d doesn't fit in an A-instruction of %d bits", value, s.WordBits)
+	}
+	return value, nil
+}
+
+// FormatWord returns word in binary, as wide as a word of s.
+func (s *ISA) FormatWord(word uint32) string {
+	return fmt.Sprintf("%0*s", s.WordBits, strconv.FormatUint(uint64(word), 2))
+}
+
+// Instruction is a decoded instruction.
+type Instruction struct {
+	Address bool   // an A-instruction, which loads Value into A
+	Value   uint32 // value of an A-instruction
+	Comp    string // mnemonics of a C-instruction; Dest and Jump are "" for null
+	Dest    string
+	Jump    string
+
+	Compute func(d, a, m int32) int32 // what comp computes from D, A and M
+	ReadsM  bool                      // comp reads M, the memory A points to
+	StoreA  bool
+	StoreD  bool
+	StoreM  bool
+	JumpLT  bool // jumps if the result is negative
+	JumpEQ  bool // jumps if the result is zero
+	JumpGT  bool // jumps if the result is positive
+}
+
+// String returns the instruction in assembly, e.g. @5 or D=D+A;JGT.
+func (in Instruction) String() string {
+	if in.Address {
+		return "@" + strconv.FormatUint(uint64(in.Value), 10)
+	}
+	code := in.Comp
+	if in.Dest != "" {
+		code = in.Dest + "=" + code
+	}
+	if in.Jump != "" {
+		code += ";" + in.Jump
+	}
+	return code
+}
+
+// Decode returns the instruction word stands for.
+func (s *ISA) Decode(word uint32) (Instruction, error) {
+	if word >= 1<<s.WordBits {
+		return Instruction{}, fmt.Errorf("%b has more than %d bits", word, s.WordBits)
+	}
+	if word>>(s.WordBits-1) == 0 {
+		return Instruction{Address: true, Value: word}, nil
+	}
+	c, ok := s.comps[word>>(s.DestBits+s.JumpBits)]
+	if !ok {
+		return Instruction{}, fmt.Errorf("%s has no comp with code %b", s.Name, word>>(s.DestBits+s.JumpBits))
+	}
+	in := Instruction{Comp: c.mnemonic, Compute: c.compute, ReadsM: c.readsM}
+	if d := word >> s.JumpBits & (1<<s.DestBits - 1); d != 0 {
+		if in.Dest, ok = s.dests[d]; !ok {
+			return Instruction{}, fmt.Errorf("%s has no dest with code %b", s.Name, d)
+		}
+		in.StoreA = strings.Contains(in.Dest, "A")
+		in.StoreD = strings.Contains(in.Dest, "D")
+		in.StoreM = strings.Contains(in.Dest, "M")
+	}
+	if j := word & (1<<s.JumpBits - 1); j != 0 {
+		if in.Jump, ok = s.jumps[j]; !ok {
+			return Instruction{}, fmt.Errorf("%s has no jump with code %b", s.Name, j)
+		}
+		in.JumpLT, in.JumpEQ, in.JumpGT = jumpConditions(in.Jump)
+	}
+	return in, nil
+}
+
+// Disassemble returns word as assembly.
+func (s *ISA) Disassemble(word uint32) (string, error) {
+	in, err := s.Decode(word)
+	if err != nil {
+		return "", err
+	}
+	return in.String(), nil
+}
@@ -0,0 +1,308 @@
+package isa
+
+import (
+	"strings"
+	"testing"
+)
+
+// alu computes the a-bit and c1..c6 of a Hack comp the way the book's ALU does.
+func alu(bits uint32, d, a, m int32) int32 {
+	x, y := d, a
+	if bits&0x40 != 0 {
+		y = m
+	}
+	if bits&0x20 != 0 {
+		x = 0
+	}
+	if bits&0x10 != 0 {
+		x = ^x
+	}
+	if bits&0x08 != 0 {
+		y = 0
+	}
+	if bits&0x04 != 0 {
+		y = ^y
+	}
+	out := x & y
+	if bits&0x02 != 0 {
+		out = x + y
+	}
+	if bits&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+func TestHackComputesLikeTheALU(t *testing.T) {
+	values := []int32{0, 1, -1, 7, -12, 32767, -32768}
+	for mnemonic, code := range Hack.Comp {
+		in, err := Hack.Decode(code << 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range values {
+			for _, a := range values {
+				m := a ^ 0x55
+				if expected, actual := int16(alu(code&0x7f, d, a, m)), int16(in.Compute(d, a, m)); expected != actual {
+					t.Errorf("%s with D=%d A=%d M=%d: expected %d got %d", mnemonic, d, a, m, expected, actual)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for comp := range Hack.Comp {
+		for _, dest := range []string{"", "M", "AD", "AMD"} {
+			for _, jump := range []string{"", "JGT", "JMP"} {
+				word, err := Hack.Encode(dest, comp, jump)
+				if err != nil {
+					t.Fatal(err)
+				}
+				in, err := Hack.Decode(word)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if in.Comp != comp || in.Dest != dest || in.Jump != jump {
+					t.Errorf("%016b: expected %s=%s;%s got %v", word, dest, comp, jump, in)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		word uint32
+		asm  string
+	}{
+		{0b0000000000000101, "@5"},
+		{0b1110000010010000, "D=D+A"},
+		{0b1110001100000001, "D;JGT"},
+		{0b1111110111011000, "MD=M+1"},
+		{0b1110101010000111, "0;JMP"},
+	}
+	for _, test := range tests {
+		if asm, err := Hack.Disassemble(test.word); err != nil || asm != test.asm {
+			t.Errorf("%016b: expected %s got %s (%v)", test.word, test.asm, asm, err)
+		}
+	}
+	if _, err := Hack.Disassemble(0b1000000000000000); err == nil {
+		t.Error("expected a word with the prefix 100 to be no Hack instruction")
+	}
+	if _, err := Hack.Encode("M", "D+D", ""); err == nil {
+		t.Error("expected D+D to be no Hack comp")
+	}
+}
+
+func TestInstructionEffects(t *testing.T) {
+	in, err := Hack.Decode(0b1111110010101101) // AM=M-1;JNE
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !in.StoreA || in.StoreD || !in.StoreM || !in.ReadsM {
+		t.Errorf("AM=M-1 should read M and store A and M, got %+v", in)
+	}
+	if !in.JumpLT || in.JumpEQ || !in.JumpGT {
+		t.Errorf("JNE should jump on non-zero results, got %+v", in)
+	}
+}
+
+const shiftISA = `{
+	"name": "hack-shift",
+	"base": "hack",
+	"comp": {
+		"D<<": "101 0110000",
+		"A<<": "101 0100000",
+		"M<<": "101 1100000",
+		"D>>": "101 0010000",
+		"A>>": "101 0000000",
+		"M>>": "101 1000000"
+	}
+}`
+
+func TestLoad(t *testing.T) {
+	s, err := Load(strings.NewReader(shiftISA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Comp) != len(Hack.Comp)+6 || s.WordBits != 16 {
+		t.Errorf("expected the comps of Hack and 6 shifts in 16 bits, got %d comps in %d bits", len(s.Comp), s.WordBits)
+	}
+
+	word, err := s.Encode("D", "D<<", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if word != 0b1010110000010000 {
+		t.Errorf("expected D=D<< to be 1010110000010000 got %016b", word)
+	}
+	in, err := s.Decode(0b1011000000001000) // M=M>>
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.String() != "M=M>>" || in.Compute(0, 0, -8) != -4 {
+		t.Errorf("expected M=M>> to halve M keeping its sign, got %s computing %d", in, in.Compute(0, 0, -8))
+	}
+	if _, err := Hack.Encode("D", "D<<", ""); err == nil {
+		t.Error("loading a variant changed the stock Hack table")
+	}
+}
+
+func TestLoadWideWords(t *testing.T) {
+	s, err := Load(strings.NewReader(`{
+		"name": "wide",
+		"word_bits": 24,
+		"comp": {"D+A": "1111 1111 0000 0000 00", "0": "1000 0000 0000 0000 00"},
+		"dest": {"D": "010"},
+		"jump": {"JMP": "111"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxAddress() != 1<<23-1 {
+		t.Errorf("expected A-instructions of 23 bits got a maximum of %d", s.MaxAddress())
+	}
+	word, err := s.Encode("D", "D+A", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted := s.FormatWord(word); formatted != "111111110000000000010000" {
+		t.Errorf("expected D=D+A to be 111111110000000000010000 got %s", formatted)
+	}
+	if _, err := s.EncodeAddress(1 << 23); err == nil {
+		t.Error("expected an address of 24 bits not to fit")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown base", `{"name": "x", "base": "nope"}`},
+		{"too few digits", `{"name": "x", "base": "hack", "comp": {"D<<": "101"}}`},
+		{"not binary", `{"name": "x", "base": "hack", "comp": {"D<<": "1012110000"}}`},
+		{"same code as another comp", `{"name": "x", "base": "hack", "comp": {"D<<": "1110101010"}}`},
+		{"A-instruction code", `{"name": "x", "base": "hack", "comp": {"D<<": "0010110000"}}`},
+		{"comp without meaning", `{"name": "x", "base": "hack", "comp": {"D*A": "1010110000"}}`},
+		{"dest without meaning", `{"name": "x", "base": "hack", "dest": {"X": "001"}}`},
+		{"jump without meaning", `{"name": "x", "base": "hack", "jump": {"JXX": "001"}}`},
+		{"unknown field", `{"name": "x", "base": "hack", "comps": {}}`},
+		{"no name", `{"base": "hack"}`},
+		{"too wide", `{"name": "x", "word_bits": 64}`},
+	}
+	for _, test := range tests {
+		if _, err := Load(strings.NewReader(test.input)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s, err := Load(strings.NewReader(strings.Replace(shiftISA, "hack-shift", "registered-shift", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if found, ok := Lookup("registered-shift"); !ok || found != s {
+		t.Error("expected to look up the registered instruction set by name")
+	}
+	if opened, err := Open("registered-shift"); err != nil || opened != s {
+		t.Errorf("expected Open to find the registered instruction set, got %v", err)
+	}
+	if err := Register(s); err == nil {
+		t.Error("expected registering a name twice to fail")
+	}
+	if _, err := Open("no-such-isa.json"); err == nil {
+		t.Error("expected an error opening an unknown instruction set")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	comps := map[string]string{
+		"A+D": "D+A", "1+D": "D+1", "1+M": "M+1", "M&D": "D&M", "A|D": "D|A", "M+D": "D+M",
+		"D+A": "D+A", "A-D": "A-D",
+	}
+	for alias, expected := range comps {
+		if canonical, ok := Hack.CanonicalComp(alias); !ok || canonical != expected {
+			t.Errorf("expected %s to stand for %s got %q", alias, expected, canonical)
+		}
+	}
+	// subtraction doesn't commute
+	for _, comp := range []string{"1-D", "D+D", "D*A", "D--1", "-D+-A"} {
+		if canonical, ok := Hack.CanonicalComp(comp); ok {
+			t.Errorf("expected %s to be no comp got %s", comp, canonical)
+		}
+	}
+	for alias, expected := range map[string]string{"DM": "MD", "MA": "AM", "DAM": "AMD", "MDA": "AMD", "D": "D"} {
+		if canonical, ok := Hack.CanonicalDest(alias); !ok || canonical != expected {
+			t.Errorf("expected dest %s to stand for %s got %q", alias, expected, canonical)
+		}
+	}
+
+	aliased, err := Hack.Encode("DM", "1+M", "JGT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canonical, _ := Hack.Encode("MD", "M+1", "JGT"); aliased != canonical {
+		t.Errorf("expected DM=1+M;JGT to encode like MD=M+1;JGT")
+	}
+}
+
+func TestHackAliases(t *testing.T) {
+	tests := []struct {
+		alias, comp string
+	}{
+		{"0-1", "-1"},
+		{"0-D", "-D"}, {"0-A", "-A"}, {"0-M", "-M"},
+		{"-D-1", "!D"}, {"-1-D", "!D"}, {"-A-1", "!A"}, {"-1-A", "!A"}, {"-M-1", "!M"}, {"-1-M", "!M"},
+		{"D+-1", "D-1"}, {"-1+D", "D-1"}, {"A+-1", "A-1"}, {"-1+A", "A-1"}, {"M+-1", "M-1"}, {"-1+M", "M-1"},
+		{"D+-A", "D-A"}, {"-A+D", "D-A"}, {"A+-D", "A-D"}, {"-D+A", "A-D"},
+		{"D+-M", "D-M"}, {"-M+D", "D-M"}, {"M+-D", "M-D"}, {"-D+M", "M-D"},
+	}
+	if len(tests) != len(Hack.Aliases) {
+		t.Errorf("expected a test for each of the %d aliases got %d", len(Hack.Aliases), len(tests))
+	}
+	for _, test := range tests {
+		if canonical, ok := Hack.CanonicalComp(test.alias); !ok || canonical != test.comp {
+			t.Errorf("expected %s to stand for %s got %q", test.alias, test.comp, canonical)
+		}
+		aliased, err := Hack.Encode("D", test.alias, "JNE")
+		if err != nil {
+			t.Errorf("%s: %v", test.alias, err)
+			continue
+		}
+		if canonical, _ := Hack.Encode("D", test.comp, "JNE"); aliased != canonical {
+			t.Errorf("expected D=%s;JNE to encode like D=%s;JNE got %016b", test.alias, test.comp, aliased)
+		}
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	s, err := Load(strings.NewReader(`{"name": "x", "base": "hack", "aliases": {"0-1": "-1", "D-0": "D"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canonical, ok := s.CanonicalComp("0-1"); !ok || canonical != "-1" {
+		t.Errorf("expected 0-1 to stand for -1 got %q", canonical)
+	}
+	if word, err := s.Encode("D", "D-0", ""); err != nil || word != 0b1110001100010000 {
+		t.Errorf("expected D=D-0 to encode like D=D got %016b %v", word, err)
+	}
+
+	tests := []struct {
+		name    string
+		aliases string
+	}{
+		{"computes something else", `{"D+1": "D"}`},
+		{"stands for no comp", `{"0-1": "-2"}`},
+		{"is a comp itself", `{"D+A": "D+A"}`},
+		{"computes nothing known", `{"D*1": "D"}`},
+	}
+	for _, test := range tests {
+		if _, err := Load(strings.NewReader(`{"name": "x", "base": "hack", "aliases": ` + test.aliases + `}`)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package isa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// file is the JSON form of an ISA. Codes are written in binary, and may be
+// grouped with spaces or underscores. An ISA with a base starts from the tables
+// of the registered ISA of that name, and its own entries add to or replace
+// those. For example, Hack with two shift instructions:
+//
+//	{
+//		"name": "hack-shift",
+//		"base": "hack",
+//		"comp": {"D<<": "101 0110000", "D>>": "101 0010000"}
+//	}
+//
+// Aliases map other spellings of comps to the comp they stand for, like
+// "aliases": {"0-1": "-1"}.
+type file struct {
+	Name     string            `json:"name"`
+	Base     string            `json:"base"`
+	WordBits int               `json:"word_bits"`
+	DestBits int               `json:"dest_bits"`
+	JumpBits int               `json:"jump_bits"`
+	Comp     map[string]string `json:"comp"`
+	Dest     map[string]string `json:"dest"`
+	Jump     map[string]string `json:"jump"`
+	Aliases  map[string]string `json:"aliases"`
+}
+
+// Load reads an ISA in JSON from r and compiles it.
+func Load(r io.Reader) (*ISA, error) {
+	var f file
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&f); err != nil {
+		return nil, err
+	}
+
+	s := &ISA{Name: f.Name, DestBits: 3, JumpBits: 3, Comp: map[string]uint32{}, Dest: map[string]uint32{}, Jump: map[string]uint32{}, Aliases: map[string]string{}}
+	if f.Base != "" {
+		base, ok := Lookup(f.Base)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown base instruction set %q", f.Name, f.Base)
+		}
+		s.WordBits, s.DestBits, s.JumpBits = base.WordBits, base.DestBits, base.JumpBits
+		copyTable(s.Comp, base.Comp)
+		copyTable(s.Dest, base.Dest)
+		copyTable(s.Jump, base.Jump)
+		for alias, mnemonic := range base.Aliases {
+			s.Aliases[alias] = mnemonic
+		}
+	}
+	for alias, mnemonic := range f.Aliases {
+		s.Aliases[alias] = mnemonic
+	}
+	if f.WordBits != 0 {
+		s.WordBits = f.WordBits
+	}
+	if f.DestBits != 0 {
+		s.DestBits = f.DestBits
+	}
+	if f.JumpBits != 0 {
+		s.JumpBits = f.JumpBits
+	}
+
+	fields := []struct {
+		name  string
+		codes map[string]string
+		table map[string]uint32
+		bits  int
+	}{
+		{"comp", f.Comp, s.Comp, s.CompBits()},
+		{"dest", f.Dest, s.Dest, s.DestBits},
+		{"jump", f.Jump, s.Jump, s.JumpBits},
+	}
+	for _, field := range fields {
+		for mnemonic, code := range field.codes {
+			digits := strings.NewReplacer(" ", "", "_", "").Replace(code)
+			value, err := strconv.ParseUint(digits, 2, 32)
+			if err != nil || len(digits) != field.bits {
+				return nil, fmt.Errorf("%s: %s %s: expected %d binary digits, got %q", f.Name, field.name, mnemonic, field.bits, code)
+			}
+			field.table[mnemonic] = uint32(value)
+		}
+	}
+
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func copyTable(dst, src map[string]uint32) {
+	for mnemonic, code := range src {
+		dst[mnemonic] = code
+	}
+}
+
+// Open returns the registered ISA called name, or else loads the ISA in the
+// JSON file name.
+func Open(name string) (*ISA, error) {
+	if s, ok := Lookup(name); ok {
+		return s, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a known instruction set (%s) nor a file: %w", name, strings.Join(Names(), ", "), err)
+	}
+	defer f.Close()
+	s, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return s, nil
+}
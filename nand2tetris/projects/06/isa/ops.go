@@ -0,0 +1,108 @@
+package isa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// operands maps the operands of a comp to the value they stand for.
+var operands = map[byte]func(d, a, m int32) int32{
+	'0': func(d, a, m int32) int32 { return 0 },
+	'1': func(d, a, m int32) int32 { return 1 },
+	'D': func(d, a, m int32) int32 { return d },
+	'A': func(d, a, m int32) int32 { return a },
+	'M': func(d, a, m int32) int32 { return m },
+}
+
+// binaryOps maps the operators that join two operands to what they compute.
+var binaryOps = map[byte]func(x, y int32) int32{
+	'+': func(x, y int32) int32 { return x + y },
+	'-': func(x, y int32) int32 { return x - y },
+	'&': func(x, y int32) int32 { return x & y },
+	'|': func(x, y int32) int32 { return x | y },
+	'^': func(x, y int32) int32 { return x ^ y },
+}
+
+// compileComp works out what the comp mnemonic computes. The operands of a
+// binary operator may be negated, like D+-1 for D-1.
+func compileComp(mnemonic string) (*comp, error) {
+	c := &comp{mnemonic: mnemonic, readsM: strings.Contains(mnemonic, "M")}
+	operand := func(i int) func(d, a, m int32) int32 {
+		if i >= len(mnemonic) {
+			return nil
+		}
+		return operands[mnemonic[i]]
+	}
+	// term returns the operand at i, negated if it's written -x, and where the
+	// rest of mnemonic starts.
+	term := func(i int) (func(d, a, m int32) int32, int) {
+		if i < len(mnemonic) && mnemonic[i] == '-' {
+			if y := operand(i + 1); y != nil {
+				return func(d, a, m int32) int32 { return -y(d, a, m) }, i + 2
+			}
+		}
+		return operand(i), i + 1
+	}
+
+	x := operand(0)
+	switch {
+	case len(mnemonic) == 1 && x != nil:
+		c.compute = x
+	case len(mnemonic) == 2 && mnemonic[0] == '!' && operand(1) != nil:
+		y := operand(1)
+		c.compute = func(d, a, m int32) int32 { return ^y(d, a, m) }
+	case len(mnemonic) == 2 && mnemonic[0] == '-' && operand(1) != nil:
+		y := operand(1)
+		c.compute = func(d, a, m int32) int32 { return -y(d, a, m) }
+	case len(mnemonic) == 3 && x != nil && mnemonic[1:] == "<<":
+		c.compute = func(d, a, m int32) int32 { return x(d, a, m) << 1 }
+	case len(mnemonic) == 3 && x != nil && mnemonic[1:] == ">>":
+		c.compute = func(d, a, m int32) int32 { return x(d, a, m) >> 1 }
+	default:
+		x, i := term(0)
+		if x == nil || i >= len(mnemonic) || binaryOps[mnemonic[i]] == nil {
+			return nil, fmt.Errorf("comp %q doesn't say what it computes", mnemonic)
+		}
+		op := binaryOps[mnemonic[i]]
+		y, end := term(i + 1)
+		if y == nil || end != len(mnemonic) {
+			return nil, fmt.Errorf("comp %q doesn't say what it computes", mnemonic)
+		}
+		c.compute = func(d, a, m int32) int32 { return op(x(d, a, m), y(d, a, m)) }
+	}
+	return c, nil
+}
+
+// validDest reports whether mnemonic names each of A, D and M at most once.
+func validDest(mnemonic string) bool {
+	seen := map[rune]bool{}
+	for _, r := range mnemonic {
+		if !strings.ContainsRune("ADM", r) || seen[r] {
+			return false
+		}
+		seen[r] = true
+	}
+	return mnemonic != ""
+}
+
+// jumps maps the jump mnemonics to when they jump: if the result is negative,
+// zero or positive.
+var jumps = map[string][3]bool{
+	"JGT": {false, false, true},
+	"JEQ": {false, true, false},
+	"JGE": {false, true, true},
+	"JLT": {true, false, false},
+	"JNE": {true, false, true},
+	"JLE": {true, true, false},
+	"JMP": {true, true, true},
+}
+
+func validJump(mnemonic string) bool {
+	_, ok := jumps[mnemonic]
+	return ok
+}
+
+func jumpConditions(mnemonic string) (lt, eq, gt bool) {
+	j := jumps[mnemonic]
+	return j[0], j[1], j[2]
+}
@@ -0,0 +1,44 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addSamples adds the assembly samples in testdata, and inputs that used to
+// crash the lexer, to the corpus of f.
+func addSamples(f *testing.F) {
+	paths, _ := filepath.Glob("../testdata/*.asm")
+	if len(paths) == 0 {
+		f.Fatal("no assembly samples in ../testdata")
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, input := range []string{"", "(LOOP", "/", "D=M#1", "@é", "\xff", "@1\nD=A", "D;", "=;", strings.Repeat("A", 5000)} {
+		f.Add(input)
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		l := NewLexer(strings.NewReader(input))
+		for n := 0; ; n++ {
+			token, value := l.NextToken()
+			if token == EOF {
+				break
+			}
+			if n > len(input) {
+				t.Fatalf("read %d tokens from %d bytes, the last %s %q", n, len(input), token, value)
+			}
+			_ = token.String()
+		}
+	})
+}
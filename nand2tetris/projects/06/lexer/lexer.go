@@ -2,9 +2,10 @@ package lexer
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"log"
-	"os"
+
+	"assembler/isa"
 )
 
 type Token int
@@ -18,6 +19,7 @@ const (
 	BACKSLASH         // /
 	LEFT_PAREN        // (
 	RIGHT_PAREN       // )
+	ILLEGAL           // a character no token starts with
 
 	VALUE
 	CONSTANT
@@ -33,6 +35,7 @@ var tokens = []string{
 	AT:        "@",
 	EQUALS:    "=",
 	SEMICOLON: ";",
+	ILLEGAL:   "ILLEGAL",
 	VALUE:     "VALUE",
 
 	CONSTANT:    "CONSTANT",
@@ -46,17 +49,35 @@ var tokens = []string{
 }
 
 func (t Token) String() string {
+	if t < 0 || int(t) >= len(tokens) {
+		return fmt.Sprintf("Token(%d)", int(t))
+	}
 	return tokens[t]
 }
 
 type Lexer struct {
 	r    *bufio.Reader
 	prev Token
+	set  *isa.ISA // instruction set whose mnemonics are recognized
+	line int      // the line being read
+	// tokenLine is the line of the last token returned.
+	tokenLine int
+	err       error // the first error reading r other than io.EOF
+}
+
+// NewLexer returns a Lexer that reads Hack assembly from r.
+func NewLexer(r io.Reader) *Lexer {
+	return NewLexerWithISA(r, isa.Hack)
 }
 
-func NewLexer(f *os.File) *Lexer {
+// NewLexerWithISA returns a Lexer that reads assembly for the instruction set
+// set from r, so comps can start with any character the set's comps start with.
+func NewLexerWithISA(r io.Reader, set *isa.ISA) *Lexer {
 	return &Lexer{
-		r: bufio.NewReader(f),
+		r:         bufio.NewReader(r),
+		set:       set,
+		line:      1,
+		tokenLine: 1,
 	}
 }
 
@@ -76,15 +97,16 @@ func isSymbolOnlyChar(ch rune) bool {
 	return ch == '_' || ch == '.' || ch == '$' || ch == ':'
 }
 
-func isCompOnlyChar(ch rune) bool {
-	return ch == '!' || ch == '-'
-}
-
-var eofRune = rune(0)
+// eofRune stands for the end of the input. ReadRune never returns it, so a NUL
+// in the input is just an illegal character.
+var eofRune = rune(-1)
 
 func (l *Lexer) getChar() rune {
 	ch, _, err := l.r.ReadRune()
 	if err != nil {
+		if err != io.EOF && l.err == nil {
+			l.err = err
+		}
 		return eofRune
 	}
 	return ch
@@ -94,14 +116,40 @@ func (l *Lexer) unread() {
 	l.r.UnreadRune()
 }
 
-// NextToken returns the next token and its value as a string from the input stream
+// Line returns the line, counted from 1, of the last token NextToken returned.
+func (l *Lexer) Line() int {
+	return l.tokenLine
+}
+
+// NextToken returns the next token and its value as a string from the input
+// stream. Characters that start no token are returned one at a time as
+// ILLEGAL tokens.
 func (l *Lexer) NextToken() (Token, string) {
-	var lastChar = ' '
+	lastChar := l.getChar()
 
-	// Skip whitespace
-	for isWhiteSpace(lastChar) {
-		lastChar = l.getChar()
+	// Skip whitespace and comments
+	for {
+		if lastChar == '\n' {
+			l.line++
+		}
+		if isWhiteSpace(lastChar) {
+			lastChar = l.getChar()
+			continue
+		}
+		if lastChar != '/' {
+			break
+		}
+		if next := l.getChar(); next != '/' {
+			if next != eofRune {
+				l.unread()
+			}
+			break
+		}
+		for lastChar != eofRune && lastChar != '\n' {
+			lastChar = l.getChar()
+		}
 	}
+	l.tokenLine = l.line
 
 	switch lastChar {
 	case '@':
@@ -124,7 +172,7 @@ func (l *Lexer) NextToken() (Token, string) {
 	// If the beginning character is a digit, attempt to tokenize as a constant or comp mnemonic
 	if isDigit(lastChar) {
 		charSeq := []rune{lastChar}
-		for lastChar = l.getChar(); lastChar != ';' && !isWhiteSpace(lastChar); {
+		for lastChar = l.getChar(); lastChar != ';' && !isWhiteSpace(lastChar) && lastChar != eofRune; {
 			charSeq = append(charSeq, lastChar)
 			lastChar = l.getChar()
 		}
@@ -141,12 +189,12 @@ func (l *Lexer) NextToken() (Token, string) {
 			return COMP, string(charSeq)
 		}
 
-		log.Printf("could not tokenize sequence %q as constant or comp with prev char %q", string(charSeq), l.prev.String())
+		l.prev = VALUE
 		return VALUE, string(charSeq)
 
-	} else if isCompOnlyChar(lastChar) {
+	} else if l.set.StartsComp(lastChar) {
 		charSeq := []rune{lastChar}
-		for lastChar = l.getChar(); lastChar != ';' && !isWhiteSpace(lastChar); {
+		for lastChar = l.getChar(); lastChar != ';' && !isWhiteSpace(lastChar) && lastChar != eofRune; {
 			charSeq = append(charSeq, lastChar)
 			lastChar = l.getChar()
 		}
@@ -158,13 +206,12 @@ func (l *Lexer) NextToken() (Token, string) {
 			return COMP, string(charSeq)
 		}
 
-		log.Printf("could not tokenize sequence %q as comp", string(charSeq))
 		l.prev = VALUE
 		return VALUE, string(charSeq)
 	} else if isLetter(lastChar) || isSymbolOnlyChar(lastChar) {
 		// any char sequence that doesn't begin with a digit can be a symbol, label, or dest/comp/jump mnemonic
 		charSeq := []rune{lastChar}
-		for lastChar = l.getChar(); lastChar != ';' && lastChar != '=' && lastChar != ')' && !isWhiteSpace(lastChar); {
+		for lastChar = l.getChar(); lastChar != ';' && lastChar != '=' && lastChar != ')' && !isWhiteSpace(lastChar) && lastChar != eofRune; {
 			charSeq = append(charSeq, lastChar)
 			lastChar = l.getChar()
 		}
@@ -197,7 +244,6 @@ func (l *Lexer) NextToken() (Token, string) {
 			return JUMP, string(charSeq)
 		}
 
-		log.Printf("could not tokenize sequence %q as symbol, label, or mnemonic", string(charSeq))
 		l.prev = VALUE
 		return VALUE, string(charSeq)
 
@@ -208,30 +254,22 @@ func (l *Lexer) NextToken() (Token, string) {
 		return EOF, EOF.String()
 	}
 
-	if lastChar == '/' {
-		// Skip comment and return next token
-		for lastChar != eofRune && lastChar != '\n' && lastChar != '\r' {
-			lastChar = l.getChar()
-		}
-
-		if lastChar != eofRune {
-			return l.NextToken()
-		}
-	}
-
-	log.Printf("could not derive current token %q", lastChar)
-	thisChar := lastChar
-	l.prev = Token(thisChar)
-	_ = l.getChar()
-	return Token(thisChar), string(thisChar)
+	l.prev = ILLEGAL
+	return ILLEGAL, string(lastChar)
 }
 
-func (l *Lexer) HasMoreTokens() bool {
+// HasMoreTokens reports whether any input is left. If reading the input
+// failed, it returns the error, and the tokens read since ended early with EOF.
+func (l *Lexer) HasMoreTokens() (bool, error) {
+	if l.err != nil {
+		return false, l.err
+	}
 	_, err := l.r.Peek(1)
 	if err != nil && err != io.EOF {
-		panic(err)
+		l.err = err
+		return false, err
 	}
-	return err == nil
+	return err == nil, nil
 }
 
 func (l *Lexer) Peek(n int) ([]byte, error) {
@@ -1,9 +1,15 @@
 package lexer
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+
+	"assembler/isa"
 )
 
 type Lexeme struct {
@@ -328,3 +334,45 @@ func TestSymbols(t *testing.T) {
 		})
 	}
 }
+
+func TestISA(t *testing.T) {
+	shift, err := isa.Load(strings.NewReader(`{"name": "shift", "base": "hack", "comp": {"D<<": "1010110000", "M>>": "1011000000"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Lexeme{
+		{DEST, "D"},
+		{EQUALS, "="},
+		{COMP, "D<<"},
+		{COMP, "M>>"},
+		{SEMICOLON, ";"},
+		{JUMP, "JMP"},
+		{COMP, "-1"},
+		{SEMICOLON, ";"},
+		{JUMP, "JGT"},
+	}
+
+	l := NewLexerWithISA(strings.NewReader("D=D<<\nM>>;JMP\n-1;JGT\n"), shift)
+	for i, lexeme := range expected {
+		token, value := l.NextToken()
+		if token != lexeme.token || value != lexeme.value {
+			t.Errorf("token %d: expected %v %q got %v %q", i, lexeme.token, lexeme.value, token, value)
+		}
+	}
+	if token, _ := l.NextToken(); token != EOF {
+		t.Errorf("expected EOF got %v", token)
+	}
+}
+
+func TestHasMoreTokensReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	l := NewLexer(io.MultiReader(strings.NewReader("@1"), iotest.ErrReader(errRead)))
+	if more, err := l.HasMoreTokens(); !more || err != nil {
+		t.Errorf("expected more tokens got %t, %v", more, err)
+	}
+	for tok, _ := l.NextToken(); tok != EOF; tok, _ = l.NextToken() {
+	}
+	if more, err := l.HasMoreTokens(); more || err != errRead {
+		t.Errorf("expected the read error got %t, %v", more, err)
+	}
+}
@@ -0,0 +1,128 @@
+// Package lint finds mistakes in Hack assembly that the assembler accepts, like
+// a misspelled variable, which silently gets a RAM cell of its own.
+//
+// Every line is read with the parser of the assembler, see package source, so
+// the position of each problem is known. A problem is not reported when its
+// line, or a comment line above it, has a comment like
+//
+//	// lint:ignore unused-label,single-use kept for the debugger
+//
+// which ignores the listed rules, or all rules if none are listed.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"assembler/isa"
+	"assembler/source"
+)
+
+// Diagnostic is a problem found by a rule.
+type Diagnostic struct {
+	Pos     source.Pos
+	Rule    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// Rule is a check of a program.
+type Rule struct {
+	Name string
+	Doc  string
+
+	check func(p *program) []Diagnostic
+}
+
+// Rules are all the rules.
+var Rules = []*Rule{singleUse, unusedLabel, unreachable, jumpWithM, registerAlias}
+
+// LookupRule returns the rule called name.
+func LookupRule(name string) (*Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// ignores are the rules lint:ignore comments turn off.
+type ignores struct {
+	all   bool
+	rules []string
+}
+
+func (ig ignores) ignores(rule string) bool {
+	if ig.all {
+		return true
+	}
+	for _, name := range ig.rules {
+		if name == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether the dest or jump of an instruction isn't null.
+func has(field string) bool {
+	return field != "" && field != "null"
+}
+
+// program is a parsed program with the rules ignored at each line.
+type program struct {
+	*source.Program
+	ignored map[int]ignores
+}
+
+// Lint reads the assembly for the instruction set set from r and returns the
+// problems the rules find, in the order of their positions. It fails if a line
+// can't be parsed.
+func Lint(r io.Reader, set *isa.ISA, rules []*Rule) ([]Diagnostic, error) {
+	parsed, err := source.Parse(r, set)
+	if err != nil {
+		return nil, err
+	}
+	p := &program{Program: parsed, ignored: map[int]ignores{}}
+	for _, in := range parsed.Instructions {
+		p.ignored[in.Pos.Line] = ignoreDirectives(in.Comments)
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, rule := range rules {
+		for _, d := range rule.check(p) {
+			d.Rule = rule.Name
+			if !p.ignored[d.Pos.Line].ignores(rule.Name) {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Pos, diagnostics[j].Pos
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return diagnostics, nil
+}
+
+// ignoreDirectives returns the rules the lint:ignore comments among comments
+// ignore.
+func ignoreDirectives(comments []string) ignores {
+	ig := ignores{}
+	for _, comment := range comments {
+		fields := strings.Fields(comment)
+		switch {
+		case len(fields) == 0 || fields[0] != "lint:ignore":
+		case len(fields) == 1:
+			ig.all = true
+		default:
+			ig.rules = append(ig.rules, strings.Split(fields[1], ",")...)
+		}
+	}
+	return ig
+}
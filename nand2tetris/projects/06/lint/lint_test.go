@@ -0,0 +1,112 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			"misspelled variable",
+			"@sum\nM=0\n@smu\nM=D\n@sum\nD=M\n",
+			[]string{"3:1: variable smu is only used once, and gets RAM[17] of its own; did you mean sum? (single-use)"},
+		},
+		{"variables used twice", "@i\nM=1\n@i\nD=M\n", nil},
+		{"unused label", "(LOOP)\n@1\n(END)\n@END\n0;JMP\n", []string{"1:1: label LOOP is never used (unused-label)"}},
+		{
+			"unreachable",
+			"@END\n0;JMP\n\tD=0\n\tD=1\n(END)\n@END\n0;JMP\n",
+			[]string{"3:2: unreachable code after an unconditional jump (unreachable)"},
+		},
+		{"conditional jump falls through", "@END\nD;JGT\nD=0\n(END)\n@END\n0;JMP\n", nil},
+		{
+			"jump reading M",
+			"@END\nM;JGT\n(END)\n@END\n0;JMP\n",
+			[]string{"2:1: M in a jump is RAM[END], the jump target (jump-with-m)"},
+		},
+		{
+			"jump after A was stored",
+			"@R0\nA=M\nM;JEQ\n",
+			[]string{"3:1: M in a jump is the RAM at the jump target the previous instruction stored in A (jump-with-m)"},
+		},
+		{"jump on D", "@R0\nD=M\n@END\nD;JEQ\n(END)\n@END\n0;JMP\n", nil},
+		{
+			"register alias",
+			"@R13\nM=D\n@r13\nM=D\n@r13\nD=M\n",
+			[]string{"3:1: variable r13 is not the predefined R13, it gets RAM[16] of its own (register-alias)"},
+		},
+		{"ignored on the line", "(LOOP) // lint:ignore unused-label\n@1\n", nil},
+		{"ignored on the line above", "// lint:ignore unused-label kept for debugging\n(LOOP)\n@1\n", nil},
+		{"ignored everything", "(LOOP) // lint:ignore\n@x\n", []string{"2:1: variable x is only used once, and gets RAM[16] of its own (single-use)"}},
+		{"ignored another rule", "(LOOP) // lint:ignore unreachable\n", []string{"1:1: label LOOP is never used (unused-label)"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			diagnostics, err := Lint(strings.NewReader(test.input), isa.Hack, Rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, d := range diagnostics {
+				actual = append(actual, d.String())
+			}
+			if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
+
+func TestLintSomeRules(t *testing.T) {
+	rule, ok := LookupRule("unused-label")
+	if !ok {
+		t.Fatal("expected the unused-label rule")
+	}
+	diagnostics, err := Lint(strings.NewReader("(A)\n@x\n(B)\n"), isa.Hack, []*Rule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 2 || diagnostics[0].Pos.Line != 1 || diagnostics[1].Pos.Line != 3 {
+		t.Errorf("expected the two unused labels got %v", diagnostics)
+	}
+	if _, ok := LookupRule("no-such-rule"); ok {
+		t.Error("expected no rule called no-such-rule")
+	}
+}
+
+func TestLintErrors(t *testing.T) {
+	if _, err := Lint(strings.NewReader("@1\n@ \n"), isa.Hack, Rules); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected an error on line 2 got %v", err)
+	}
+	if _, err := Lint(strings.NewReader("@1 @2\n"), isa.Hack, Rules); err == nil {
+		t.Error("expected an error for two instructions on a line")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"sum", "sum", 0},
+		{"sum", "smu", 1},
+		{"sum", "sums", 1},
+		{"sum", "sun", 1},
+		{"i", "j", 1},
+		{"sum", "total", 5},
+	}
+	for _, test := range tests {
+		if actual := editDistance(test.a, test.b); actual != test.expected {
+			t.Errorf("%s to %s: expected %d got %d", test.a, test.b, test.expected, actual)
+		}
+	}
+}
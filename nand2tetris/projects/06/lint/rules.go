@@ -0,0 +1,171 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"assembler/source"
+)
+
+var singleUse = &Rule{
+	Name: "single-use",
+	Doc:  "a variable used only once, which is often a misspelling that gets a RAM cell of its own",
+	check: func(p *program) []Diagnostic {
+		uses := map[string][]source.Instruction{}
+		for _, in := range p.Instructions {
+			if in.IsA() && p.IsVariable(in.Symbol()) {
+				uses[in.Symbol()] = append(uses[in.Symbol()], in)
+			}
+		}
+		diagnostics := []Diagnostic{}
+		for _, variable := range p.Variables {
+			if len(uses[variable]) != 1 {
+				continue
+			}
+			message := fmt.Sprintf("variable %s is only used once, and gets RAM[%d] of its own", variable, p.Symbols.GetAddress(variable))
+			if similar := p.similarSymbol(variable); similar != "" {
+				message += fmt.Sprintf("; did you mean %s?", similar)
+			}
+			diagnostics = append(diagnostics, Diagnostic{Pos: uses[variable][0].Pos, Message: message})
+		}
+		return diagnostics
+	},
+}
+
+// similarSymbol returns a label or another variable of p that's one edit away
+// from symbol, or "" if there isn't one.
+func (p *program) similarSymbol(symbol string) string {
+	candidates := append([]string{}, p.Variables...)
+	for _, in := range p.Instructions {
+		if in.IsLabel() {
+			candidates = append(candidates, in.Symbol())
+		}
+	}
+	for _, candidate := range candidates {
+		if candidate != symbol && editDistance(candidate, symbol) == 1 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// editDistance returns the number of characters to insert, delete or change,
+// or pairs of neighbours to swap, to turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			change := d[i-1][j-1]
+			if a[i-1] != b[j-1] {
+				change++
+			}
+			d[i][j] = minOf(change, d[i-1][j]+1, d[i][j-1]+1)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minOf(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minOf(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+var unusedLabel = &Rule{
+	Name: "unused-label",
+	Doc:  "a label that is never loaded into A, so nothing jumps to it",
+	check: func(p *program) []Diagnostic {
+		used := map[string]bool{}
+		for _, in := range p.Instructions {
+			if in.IsA() {
+				used[in.Symbol()] = true
+			}
+		}
+		diagnostics := []Diagnostic{}
+		for _, in := range p.Instructions {
+			if in.IsLabel() && !used[in.Symbol()] {
+				diagnostics = append(diagnostics, Diagnostic{Pos: in.Pos, Message: fmt.Sprintf("label %s is never used", in.Symbol())})
+			}
+		}
+		return diagnostics
+	},
+}
+
+var unreachable = &Rule{
+	Name: "unreachable",
+	Doc:  "instructions after an unconditional jump that no label leads to",
+	check: func(p *program) []Diagnostic {
+		diagnostics := []Diagnostic{}
+		jumped := false // the previous instruction jumps unconditionally
+		for _, in := range p.Instructions {
+			switch {
+			case in.IsLabel():
+				jumped = false
+			case jumped:
+				diagnostics = append(diagnostics, Diagnostic{Pos: in.Pos, Message: "unreachable code after an unconditional jump"})
+				jumped = false // report the first instruction only
+			default:
+				jumped = in.IsC() && in.Jump() == "JMP"
+			}
+		}
+		return diagnostics
+	},
+}
+
+var jumpWithM = &Rule{
+	Name: "jump-with-m",
+	Doc:  "a jump that reads or writes M, which is the RAM at the jump target A was just set to",
+	check: func(p *program) []Diagnostic {
+		diagnostics := []Diagnostic{}
+		var previous source.Instruction
+		for _, in := range p.Instructions {
+			if in.IsLabel() {
+				continue
+			}
+			usesM := strings.Contains(in.Comp(), "M") || strings.Contains(in.Dest(), "M")
+			if in.IsC() && has(in.Jump()) && usesM {
+				switch {
+				case previous.Command != nil && previous.IsA():
+					diagnostics = append(diagnostics, Diagnostic{Pos: in.Pos, Message: fmt.Sprintf("M in a jump is RAM[%s], the jump target", previous.Symbol())})
+				case previous.Command != nil && strings.Contains(previous.Dest(), "A"):
+					diagnostics = append(diagnostics, Diagnostic{Pos: in.Pos, Message: "M in a jump is the RAM at the jump target the previous instruction stored in A"})
+				}
+			}
+			previous = in
+		}
+		return diagnostics
+	},
+}
+
+var registerAlias = &Rule{
+	Name: "register-alias",
+	Doc:  "a variable named like a predefined symbol but for case, like r13 for R13 or Screen for SCREEN",
+	check: func(p *program) []Diagnostic {
+		diagnostics := []Diagnostic{}
+		reported := map[string]bool{}
+		for _, in := range p.Instructions {
+			symbol := in.Symbol()
+			if !in.IsA() || reported[symbol] || !p.IsVariable(symbol) || !source.IsPredefined(strings.ToUpper(symbol)) {
+				continue
+			}
+			reported[symbol] = true
+			message := fmt.Sprintf("variable %s is not the predefined %s, it gets RAM[%d] of its own", symbol, strings.ToUpper(symbol), p.Symbols.GetAddress(symbol))
+			diagnostics = append(diagnostics, Diagnostic{Pos: in.Pos, Message: message})
+		}
+		return diagnostics
+	},
+}
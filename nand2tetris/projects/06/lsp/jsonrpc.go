@@ -0,0 +1,99 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// maxMessage is the size of the largest message read, so a bad header can't
+// make the server allocate without bounds.
+const maxMessage = 64 << 20
+
+// Error codes of JSON-RPC and LSP.
+const (
+	parseError     = -32700
+	invalidParams  = -32602
+	methodNotFound = -32601
+	invalidRequest = -32600
+)
+
+// message is a JSON-RPC request, notification or response. Notifications have
+// no ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// responseError is the error of a failed request.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// readMessage reads a message framed by a Content-Length header from r.
+func readMessage(r *bufio.Reader) (*message, error) {
+	if _, err := r.Peek(1); err != nil {
+		return nil, err
+	}
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxMessage {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := &message{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return m, &responseError{Code: parseError, Message: err.Error()}
+	}
+	return m, nil
+}
+
+// writeMessage writes v as JSON framed by a Content-Length header to w.
+func writeMessage(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// response returns the response to the request with the ID id. It holds err
+// if it isn't nil, and result otherwise, which may be nil.
+func response(id *json.RawMessage, result interface{}, err error) map[string]interface{} {
+	r := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if err == nil {
+		r["result"] = result
+		return r
+	}
+	e, ok := err.(*responseError)
+	if !ok {
+		e = &responseError{Code: invalidRequest, Message: err.Error()}
+	}
+	r["error"] = e
+	return r
+}
+
+// notification returns the notification of method with params.
+func notification(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
@@ -0,0 +1,470 @@
+// Package lsp is a language server for Hack assembly. It speaks the Language
+// Server Protocol as JSON-RPC over a pair of streams, usually stdin and stdout,
+// and reads documents with package source, so it sees them the way the
+// assembler does. It offers:
+//
+//   - diagnostics when a document is opened or saved: lines that can't be
+//     parsed or encoded as errors, and the problems of package lint as warnings
+//   - go to definition of labels, and of variables, which are defined where
+//     they're first used
+//   - references to labels and variables
+//   - hover with the address a symbol resolves to and the machine code of the
+//     instruction
+//   - completion of symbols after @, and of dest, comp and jump mnemonics
+//   - document symbols for labels
+//
+// Documents are synchronized in full. When a document can't be parsed, the
+// server keeps answering from the last version that could.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"assembler/isa"
+	"assembler/lint"
+	"assembler/source"
+	"assembler/symboltable"
+)
+
+// Server is a language server for the assembly of an instruction set.
+type Server struct {
+	set       *isa.ISA
+	documents map[string]*document // by URI
+	out       io.Writer
+	err       error // the first error writing to out
+	shutdown  bool  // a shutdown request came in
+}
+
+// document is an open document.
+type document struct {
+	text    string
+	program *source.Program // of the last version of text that could be parsed
+	err     error           // parsing text
+}
+
+// NewServer returns a Server for the assembly of the instruction set set.
+func NewServer(set *isa.ISA) *Server {
+	return &Server{set: set, documents: map[string]*document{}}
+}
+
+// ErrNoShutdown is returned by Serve if the client left without asking the
+// server to shut down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// Serve answers the messages read from r with messages written to w until an
+// exit notification or the end of r.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	in := bufio.NewReader(r)
+	s.out = w
+	for s.err == nil {
+		m, err := readMessage(in)
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*responseError); ok {
+			s.send(response(nil, nil, e))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if m.Method == "exit" {
+			break
+		}
+		handle, ok := handlers[m.Method]
+		if m.ID == nil { // a notification, which isn't answered
+			if ok && !s.shutdown {
+				handle(s, m.Params)
+			}
+			continue
+		}
+		var result interface{}
+		switch {
+		case s.shutdown:
+			err = &responseError{Code: invalidRequest, Message: "the server is shutting down"}
+		case !ok:
+			err = &responseError{Code: methodNotFound, Message: fmt.Sprintf("unknown method %q", m.Method)}
+		default:
+			result, err = handle(s, m.Params)
+		}
+		s.send(response(m.ID, result, err))
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if !s.shutdown {
+		return ErrNoShutdown
+	}
+	return nil
+}
+
+// send writes the message v, unless writing failed before.
+func (s *Server) send(v interface{}) {
+	if s.err == nil {
+		s.err = writeMessage(s.out, v)
+	}
+}
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 ignore,
+	"shutdown":                    (*Server).shutdownServer,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didSave":        (*Server).didSave,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/references":     (*Server).references,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/completion":     (*Server).completion,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+}
+
+func ignore(s *Server, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// decode reads the params of a message into v.
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: invalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1, "save": map[string]bool{"includeText": true}},
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"hoverProvider":          true,
+			"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"@", "=", ";"}},
+			"documentSymbolProvider": true,
+		},
+		"serverInfo": map[string]string{"name": "asmlsp"},
+	}, nil
+}
+
+func (s *Server) shutdownServer(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+// update sets the text of the document at uri and parses it.
+func (s *Server) update(uri, text string) *document {
+	doc, ok := s.documents[uri]
+	if !ok {
+		doc = &document{}
+		s.documents[uri] = doc
+	}
+	doc.text = text
+	program, err := source.Parse(strings.NewReader(text), s.set)
+	if err == nil {
+		doc.program = program
+	}
+	doc.err = err
+	return doc
+}
+
+func (s *Server) didOpen(params json.RawMessage) (interface{}, error) {
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	s.publishDiagnostics(p.TextDocument.URI, s.update(p.TextDocument.URI, p.TextDocument.Text))
+	return nil, nil
+}
+
+func (s *Server) didChange(params json.RawMessage) (interface{}, error) {
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	if n := len(p.ContentChanges); n > 0 {
+		s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+	}
+	return nil, nil
+}
+
+func (s *Server) didSave(params json.RawMessage) (interface{}, error) {
+	var p DidSaveTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, ok := s.documents[p.TextDocument.URI]
+	if p.Text != nil {
+		doc = s.update(p.TextDocument.URI, *p.Text)
+	} else if !ok {
+		return nil, nil
+	}
+	s.publishDiagnostics(p.TextDocument.URI, doc)
+	return nil, nil
+}
+
+func (s *Server) didClose(params json.RawMessage) (interface{}, error) {
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.documents, p.TextDocument.URI)
+	s.send(notification("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}}))
+	return nil, nil
+}
+
+func (s *Server) publishDiagnostics(uri string, doc *document) {
+	s.send(notification("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: s.diagnostics(doc)}))
+}
+
+// diagnostics returns the line of the text of doc that can't be parsed, or
+// else the instructions that can't be encoded and the problems lint finds.
+func (s *Server) diagnostics(doc *document) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	if doc.err != nil {
+		line, message := 1, doc.err.Error()
+		var parseErr *source.Error
+		if errors.As(doc.err, &parseErr) {
+			line, message = parseErr.Line, parseErr.Err.Error()
+		}
+		length := 0
+		if lines := strings.Split(doc.text, "\n"); line <= len(lines) {
+			length = len(strings.TrimRight(lines[line-1], "\r"))
+		}
+		r := Range{Start: Position{Line: line - 1}, End: Position{Line: line - 1, Character: length}}
+		return append(diagnostics, Diagnostic{Range: r, Severity: severityError, Source: "asm", Message: message})
+	}
+
+	for _, in := range doc.program.Instructions {
+		if in.IsLabel() {
+			continue
+		}
+		if _, err := doc.program.Encode(in, s.set); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{Range: instructionRange(in), Severity: severityError, Source: "asm", Message: err.Error()})
+		}
+	}
+	problems, err := lint.Lint(strings.NewReader(doc.text), s.set, lint.Rules)
+	if err != nil {
+		return diagnostics
+	}
+	for _, problem := range problems {
+		in, _ := doc.program.At(problem.Pos.Line)
+		r := instructionRange(in)
+		r.Start.Character = problem.Pos.Column - 1
+		diagnostics = append(diagnostics, Diagnostic{Range: r, Severity: severityWarning, Code: problem.Rule, Source: "asmlint", Message: problem.Message})
+	}
+	return diagnostics
+}
+
+func instructionRange(in source.Instruction) Range {
+	start := Position{Line: in.Pos.Line - 1, Character: in.Pos.Column - 1}
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + len(in.Text)}}
+}
+
+func symbolRange(in source.Instruction) Range {
+	pos := in.SymbolPos()
+	start := Position{Line: pos.Line - 1, Character: pos.Column - 1}
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + len(in.Symbol())}}
+}
+
+// program returns the program of the document at uri, or nil if there is none.
+func (s *Server) program(uri string) *source.Program {
+	if doc, ok := s.documents[uri]; ok {
+		return doc.program
+	}
+	return nil
+}
+
+// symbolAt returns the A-instruction or label whose symbol is at pos.
+func symbolAt(p *source.Program, pos Position) (source.Instruction, bool) {
+	if p == nil {
+		return source.Instruction{}, false
+	}
+	in, ok := p.At(pos.Line + 1)
+	if !ok || in.IsC() {
+		return source.Instruction{}, false
+	}
+	r := symbolRange(in)
+	return in, r.Start.Character <= pos.Character && pos.Character <= r.End.Character
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	program := s.program(p.TextDocument.URI)
+	in, ok := symbolAt(program, p.Position)
+	if !ok {
+		return nil, nil
+	}
+	if label, ok := program.Labels[in.Symbol()]; ok {
+		return Location{URI: p.TextDocument.URI, Range: symbolRange(label)}, nil
+	}
+	if program.IsVariable(in.Symbol()) {
+		for _, use := range program.Instructions {
+			if use.IsA() && use.Symbol() == in.Symbol() {
+				return Location{URI: p.TextDocument.URI, Range: symbolRange(use)}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) references(params json.RawMessage) (interface{}, error) {
+	var p ReferenceParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	program := s.program(p.TextDocument.URI)
+	in, ok := symbolAt(program, p.Position)
+	if !ok || source.IsConstant(in.Symbol()) {
+		return nil, nil
+	}
+	locations := []Location{}
+	for _, ref := range program.Instructions {
+		if ref.Symbol() == in.Symbol() && (ref.IsA() || ref.IsLabel() && p.Context.IncludeDeclaration) {
+			locations = append(locations, Location{URI: p.TextDocument.URI, Range: symbolRange(ref)})
+		}
+	}
+	return locations, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	program := s.program(p.TextDocument.URI)
+	if program == nil {
+		return nil, nil
+	}
+	in, ok := program.At(p.Position.Line + 1)
+	if !ok {
+		return nil, nil
+	}
+
+	var text strings.Builder
+	if in.IsA() || in.IsLabel() {
+		text.WriteString(describeSymbol(program, in.Symbol()) + "\n\n")
+	}
+	if in.IsLabel() {
+		fmt.Fprintf(&text, "`%s` is not an instruction, it names ROM[%d]", in.Text, in.Address)
+	} else if word, err := program.Encode(in, s.set); err != nil {
+		fmt.Fprintf(&text, "`%s` at ROM[%d] can't be encoded: %v", in.Text, in.Address, err)
+	} else {
+		fmt.Fprintf(&text, "`%s` at ROM[%d] is `%s`", in.Text, in.Address, s.set.FormatWord(word))
+	}
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: text.String()}, Range: instructionRange(in)}, nil
+}
+
+// describeSymbol returns what symbol is and the address it resolves to, in
+// Markdown.
+func describeSymbol(p *source.Program, symbol string) string {
+	switch {
+	case source.IsConstant(symbol):
+		return fmt.Sprintf("**constant** `%s`", symbol)
+	case source.IsPredefined(symbol):
+		return fmt.Sprintf("**predefined** `%s`: RAM[%d]", symbol, p.Symbols.GetAddress(symbol))
+	case p.IsVariable(symbol):
+		return fmt.Sprintf("**variable** `%s`: RAM[%d]", symbol, p.Symbols.GetAddress(symbol))
+	}
+	return fmt.Sprintf("**label** `%s`: ROM[%d]", symbol, p.Symbols.GetAddress(symbol))
+}
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, ok := s.documents[p.TextDocument.URI]
+	if !ok {
+		return []CompletionItem{}, nil
+	}
+	prefix := ""
+	if lines := strings.Split(doc.text, "\n"); p.Position.Line < len(lines) {
+		line := lines[p.Position.Line]
+		if p.Position.Character < len(line) {
+			line = line[:p.Position.Character]
+		}
+		prefix = strings.TrimSpace(line)
+	}
+
+	items := []CompletionItem{}
+	switch {
+	case strings.HasPrefix(prefix, "@"):
+		symbols := symboltable.NewSymbolTable()
+		if doc.program != nil {
+			symbols = doc.program.Symbols
+			for _, label := range sortedKeys(doc.program.Labels) {
+				items = append(items, CompletionItem{Label: label, Kind: completionReference, Detail: fmt.Sprintf("label: ROM[%d]", symbols.GetAddress(label))})
+			}
+			for _, variable := range doc.program.Variables {
+				items = append(items, CompletionItem{Label: variable, Kind: completionVariable, Detail: fmt.Sprintf("variable: RAM[%d]", symbols.GetAddress(variable))})
+			}
+		}
+		for _, symbol := range symboltable.Predefined() {
+			items = append(items, CompletionItem{Label: symbol, Kind: completionConstant, Detail: fmt.Sprintf("predefined: RAM[%d]", symbols.GetAddress(symbol))})
+		}
+	case strings.Contains(prefix, ";"):
+		items = mnemonics(items, s.set.Jump, "", "jump")
+	case strings.Contains(prefix, "="):
+		items = mnemonics(items, s.set.Comp, "", "comp")
+	default:
+		items = mnemonics(items, s.set.Dest, "=", "dest")
+		items = mnemonics(items, s.set.Comp, "", "comp")
+	}
+	return items, nil
+}
+
+// mnemonics appends the mnemonics of table, followed by suffix, to items.
+func mnemonics(items []CompletionItem, table map[string]uint32, suffix, detail string) []CompletionItem {
+	names := []string{}
+	for mnemonic := range table {
+		names = append(names, mnemonic)
+	}
+	sort.Strings(names)
+	for _, mnemonic := range names {
+		items = append(items, CompletionItem{Label: mnemonic + suffix, Kind: completionKeyword, Detail: detail})
+	}
+	return items
+}
+
+func sortedKeys(m map[string]source.Instruction) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p DocumentSymbolParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	symbols := []DocumentSymbol{}
+	if program := s.program(p.TextDocument.URI); program != nil {
+		for _, in := range program.Instructions {
+			if in.IsLabel() {
+				symbols = append(symbols, DocumentSymbol{
+					Name:           in.Symbol(),
+					Detail:         fmt.Sprintf("ROM[%d]", in.Address),
+					Kind:           symbolFunction,
+					Range:          instructionRange(in),
+					SelectionRange: symbolRange(in),
+				})
+			}
+		}
+	}
+	return symbols, nil
+}
@@ -0,0 +1,282 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+const uri = "file:///sum.asm"
+
+// program adds R0 to itself R1 times. i is misspelled once.
+const program = `// sum
+	@i
+	M=1
+(LOOP)
+	@i
+	D=M
+	@R1
+	D=D-M
+	@END
+	D;JGT
+	@R0
+	D=M
+	@ii
+	M=D+M
+	@LOOP
+	0;JMP
+(END)
+	@END
+	0;JMP
+`
+
+// session sends the requests to a new server, one per line as method and
+// params, and returns what the server sent back. Requests whose method starts
+// with ! are sent as notifications.
+func session(t *testing.T, requests ...string) []map[string]json.RawMessage {
+	var in bytes.Buffer
+	for i, request := range requests {
+		method, params := request, "{}"
+		if j := strings.Index(request, " "); j != -1 {
+			method, params = request[:j], request[j+1:]
+		}
+		m := map[string]interface{}{"jsonrpc": "2.0", "method": strings.TrimPrefix(method, "!"), "params": json.RawMessage(params)}
+		if !strings.HasPrefix(method, "!") {
+			m["id"] = i
+		}
+		if err := writeMessage(&in, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := NewServer(isa.Hack).Serve(&in, &out); err != nil && err != ErrNoShutdown {
+		t.Fatal(err)
+	}
+	messages := []map[string]json.RawMessage{}
+	r := bufio.NewReader(&out)
+	for {
+		m, err := readRaw(r)
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+}
+
+func readRaw(r *bufio.Reader) (map[string]json.RawMessage, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	var length int
+	if _, err := fmt.Sscanf(header, "Content-Length: %d", &length); err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := map[string]json.RawMessage{}
+	return m, json.Unmarshal(body, &m)
+}
+
+func open(text string) string {
+	encoded, _ := json.Marshal(text)
+	return fmt.Sprintf(`!textDocument/didOpen {"textDocument": {"uri": %q, "languageId": "hack", "version": 1, "text": %s}}`, uri, encoded)
+}
+
+func at(method string, line, character int) string {
+	return fmt.Sprintf(`textDocument/%s {"textDocument": {"uri": %q}, "position": {"line": %d, "character": %d}, "context": {"includeDeclaration": true}}`, method, uri, line, character)
+}
+
+// result decodes the result of the last message into v.
+func result(t *testing.T, messages []map[string]json.RawMessage, v interface{}) {
+	t.Helper()
+	last := messages[len(messages)-1]
+	if e, ok := last["error"]; ok {
+		t.Fatalf("expected a result got error %s", e)
+	}
+	if err := json.Unmarshal(last["result"], v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	messages := session(t, "initialize", open(program))
+	if len(messages) != 2 {
+		t.Fatalf("expected the initialize response and diagnostics got %d messages", len(messages))
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(messages[1]["params"], &params); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Diagnostic{
+		{Range: Range{Start: Position{Line: 12, Character: 1}, End: Position{Line: 12, Character: 4}}, Severity: severityWarning, Code: "single-use", Source: "asmlint", Message: "variable ii is only used once, and gets RAM[17] of its own; did you mean i?"},
+	}
+	if params.URI != uri || !reflect.DeepEqual(params.Diagnostics, expected) {
+		t.Errorf("expected %+v got %+v", expected, params.Diagnostics)
+	}
+
+	save := func(text string) string {
+		encoded, _ := json.Marshal(text)
+		return fmt.Sprintf(`!textDocument/didSave {"textDocument": {"uri": %q}, "text": %s}`, uri, encoded)
+	}
+	messages = session(t, open(program), save("@1\nD=Q\n@\n"), save("@1\nD=Q\n"))
+	if len(messages) != 3 {
+		t.Fatalf("expected diagnostics on open and both saves got %d messages", len(messages))
+	}
+	for i, expected := range []string{
+		`[{"range":{"start":{"line":2,"character":0},"end":{"line":2,"character":1}},"severity":1,"source":"asm","message":"expected CONSTANT or SYMBOL token while parsing A_COMMAND got EOF"}]`,
+		`[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":3}},"severity":1,"source":"asm","message":"unknown comp \"Q\""}]`,
+	} {
+		params = PublishDiagnosticsParams{}
+		if err := json.Unmarshal(messages[i+1]["params"], &params); err != nil {
+			t.Fatal(err)
+		}
+		actual, _ := json.Marshal(params.Diagnostics)
+		if string(actual) != expected {
+			t.Errorf("save %d: expected %s got %s", i+1, expected, actual)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		name      string
+		line, col int
+		expected  *Location
+	}{
+		{"label", 8, 2, &Location{URI: uri, Range: Range{Start: Position{Line: 16, Character: 1}, End: Position{Line: 16, Character: 4}}}},
+		{"end of a label", 14, 6, &Location{URI: uri, Range: Range{Start: Position{Line: 3, Character: 1}, End: Position{Line: 3, Character: 5}}}},
+		{"variable", 4, 2, &Location{URI: uri, Range: Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 1, Character: 3}}}},
+		{"predefined", 6, 2, nil},
+		{"C-instruction", 5, 2, nil},
+		{"comment", 0, 3, nil},
+	}
+	for _, test := range tests {
+		var location *Location
+		result(t, session(t, open(program), at("definition", test.line, test.col)), &location)
+		if !reflect.DeepEqual(location, test.expected) {
+			t.Errorf("%s: expected %+v got %+v", test.name, test.expected, location)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	var locations []Location
+	result(t, session(t, open(program), at("references", 1, 2)), &locations)
+	lines := []int{}
+	for _, location := range locations {
+		lines = append(lines, location.Range.Start.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{1, 4}) {
+		t.Errorf("expected i on lines 1 and 4 got %v", lines)
+	}
+
+	result(t, session(t, open(program), at("references", 17, 3)), &locations)
+	lines = []int{}
+	for _, location := range locations {
+		lines = append(lines, location.Range.Start.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{8, 16, 17}) {
+		t.Errorf("expected END on lines 8, 16 and 17 got %v", lines)
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{1, "**variable** `i`: RAM[16]\n\n`@i` at ROM[0] is `0000000000010000`"},
+		{3, "**label** `LOOP`: ROM[2]\n\n`(LOOP)` is not an instruction, it names ROM[2]"},
+		{6, "**predefined** `R1`: RAM[1]\n\n`@R1` at ROM[4] is `0000000000000001`"},
+		{9, "`D;JGT` at ROM[7] is `1110001100000001`"},
+	}
+	for _, test := range tests {
+		var hover Hover
+		result(t, session(t, open(program), at("hover", test.line, 2)), &hover)
+		if hover.Contents.Value != test.expected {
+			t.Errorf("line %d: expected %q got %q", test.line, test.expected, hover.Contents.Value)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	labels := func(line, character int) []string {
+		var items []CompletionItem
+		// the text being typed can't be parsed, so symbols come from the
+		// version that was opened
+		typed, _ := json.Marshal(program + "\t@\n\tD=\n\tD;\n\t\n")
+		change := fmt.Sprintf(`!textDocument/didChange {"textDocument": {"uri": %q}, "contentChanges": [{"text": %s}]}`, uri, typed)
+		result(t, session(t, open(program), change, at("completion", line, character)), &items)
+		names := []string{}
+		for _, item := range items {
+			names = append(names, item.Label)
+		}
+		return names
+	}
+
+	symbols := labels(19, 2)
+	if len(symbols) != 2+2+23 || symbols[0] != "END" || symbols[2] != "i" || symbols[3] != "ii" {
+		t.Errorf("expected the labels, variables and predefined symbols got %v", symbols)
+	}
+	if comps := labels(20, 3); len(comps) != len(isa.Hack.Comp) || comps[0] != "!A" {
+		t.Errorf("expected the comps got %v", comps)
+	}
+	if jumps := labels(21, 3); len(jumps) != 7 || jumps[0] != "JEQ" {
+		t.Errorf("expected the jumps got %v", jumps)
+	}
+	if all := labels(22, 1); len(all) != len(isa.Hack.Dest)+len(isa.Hack.Comp) || all[0] != "A=" {
+		t.Errorf("expected the dests and comps got %v", all)
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	var symbols []DocumentSymbol
+	result(t, session(t, open(program), `textDocument/documentSymbol {"textDocument": {"uri": "`+uri+`"}}`), &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "LOOP" || symbols[0].Detail != "ROM[2]" || symbols[1].Name != "END" || symbols[1].SelectionRange.Start.Line != 16 {
+		t.Errorf("expected LOOP and END got %+v", symbols)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	messages := session(t, "initialize", "!initialized", "no/such/method", "shutdown", "textDocument/hover", "!exit", "initialize")
+	if len(messages) != 4 {
+		t.Fatalf("expected responses up to the exit notification, got %d", len(messages))
+	}
+	if _, ok := messages[0]["result"]; !ok {
+		t.Error("expected initialize to succeed")
+	}
+	if !strings.Contains(string(messages[1]["error"]), "-32601") {
+		t.Errorf("expected an unknown method to fail got %s", messages[1]["error"])
+	}
+	if string(messages[2]["result"]) != "null" {
+		t.Errorf("expected shutdown to return null got %s", messages[2]["result"])
+	}
+	if _, ok := messages[3]["error"]; !ok {
+		t.Error("expected requests after shutdown to fail")
+	}
+
+	var out bytes.Buffer
+	if err := NewServer(isa.Hack).Serve(strings.NewReader(""), &out); err != ErrNoShutdown {
+		t.Errorf("expected ErrNoShutdown got %v", err)
+	}
+	if err := NewServer(isa.Hack).Serve(strings.NewReader("Content-Length: 99999999999\r\n\r\n"), &out); err == nil {
+		t.Error("expected a huge message to be refused")
+	}
+}
@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzParser(f *testing.F) {
+	paths, _ := filepath.Glob("../testdata/*.asm")
+	if len(paths) == 0 {
+		f.Fatal("no assembly samples in ../testdata")
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, input := range []string{"", "(LOOP", "(LOOP)\n@LOOP\n0;JMP", "/", "@", "@\nD=M\n", "D=M#1", "=", ";JMP", "D;", "\x00", "@é"} {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := NewParser(strings.NewReader(input))
+		commands, err := p.ParseAll()
+		if err != nil && !strings.HasPrefix(err.Error(), "line ") {
+			t.Errorf("expected the error to give its line, got %v", err)
+		}
+		if len(commands) > len(input) {
+			t.Errorf("parsed %d commands from %d bytes", len(commands), len(input))
+		}
+	})
+}
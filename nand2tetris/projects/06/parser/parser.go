@@ -4,10 +4,11 @@
 package parser
 
 import (
+	"assembler/isa"
 	"assembler/lexer"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"path/filepath"
 )
 
 type Lexeme struct {
@@ -98,18 +99,31 @@ func (c C_COMMAND) Jump() string {
 }
 
 type Parser struct {
-	file     *os.File
+	r        io.Reader
+	name     string  // base name of the input, if it has one
 	command  Command // The current command pointed to by the parser.
 	lxr      *lexer.Lexer
 	lexeme   *Lexeme
 	tokenNum int
+	line     int // of the current command
 }
 
-func NewParser(f *os.File) *Parser {
+// NewParser returns a Parser that reads Hack assembly from r. If r has a
+// Name method, like an *os.File, the name is used to describe the parser.
+func NewParser(r io.Reader) *Parser {
+	return NewParserWithISA(r, isa.Hack)
+}
+
+// NewParserWithISA returns a Parser that reads assembly for the instruction set
+// set from r. Mnemonics aren't checked until they are encoded.
+func NewParserWithISA(r io.Reader, set *isa.ISA) *Parser {
 	p := new(Parser)
-	p.file = f
+	p.r = r
+	if named, ok := r.(interface{ Name() string }); ok {
+		p.name = filepath.Base(named.Name())
+	}
 	p.command = nil // Initially there is no command.
-	p.lxr = lexer.NewLexer(f)
+	p.lxr = lexer.NewLexerWithISA(r, set)
 
 	t, v := p.lxr.NextToken()
 	p.lexeme = &Lexeme{token: t, value: v}
@@ -123,8 +137,11 @@ func (p *Parser) nextToken() (lexer.Token, string) {
 	return tok, val
 }
 
+// HasMoreCommands reports whether any input is left. An error reading it counts
+// as more, so that Advance returns it.
 func (p *Parser) HasMoreCommands() bool {
-	return p.lxr.HasMoreTokens()
+	more, err := p.lxr.HasMoreTokens()
+	return more || err != nil
 }
 
 func (p *Parser) parseA_Command() (A_COMMAND, error) {
@@ -137,7 +154,7 @@ func (p *Parser) parseA_Command() (A_COMMAND, error) {
 }
 
 func (p *Parser) parseC_Command() (C_COMMAND, error) {
-	if p.lexeme.token == lexer.DEST { // dest=comp
+	if p.lexeme.token == lexer.DEST { // dest=comp or dest=comp;jump
 		dest := p.lexeme.value
 		if t, _ := p.nextToken(); t != lexer.EQUALS { // consume '='
 			return C_COMMAND{}, fmt.Errorf("expected EQUALS token got %s", t.String())
@@ -148,7 +165,18 @@ func (p *Parser) parseC_Command() (C_COMMAND, error) {
 			return C_COMMAND{}, fmt.Errorf("expected COMP token got %s", token)
 		}
 
-		return C_COMMAND{dest: dest, comp: comp, jump: "null"}, nil
+		// The lexer ends a comp at ';' and leaves it to be read next.
+		if next, _ := p.lxr.Peek(1); len(next) == 0 || next[0] != ';' {
+			return C_COMMAND{dest: dest, comp: comp, jump: "null"}, nil
+		}
+		p.nextToken()                // consume ';'
+		token, jump := p.nextToken() // consume jump
+
+		if token != lexer.JUMP {
+			return C_COMMAND{}, fmt.Errorf("expected JUMP token got %s", token.String())
+		}
+
+		return C_COMMAND{dest: dest, comp: comp, jump: jump}, nil
 	}
 	if p.lexeme.token == lexer.COMP { // comp;jump
 		comp := p.lexeme.value
@@ -183,12 +211,29 @@ func (p *Parser) Advance() error {
 	// Reads the next command from the input and makes it the current command.
 	// Should be called only if hasMoreCommands() is true.
 	// Initially there is no current command.
-	if !p.HasMoreCommands() {
+	more, err := p.lxr.HasMoreTokens()
+	if err != nil {
+		return err
+	}
+	if !more {
 		return fmt.Errorf("attempted to advance a Parser with no more commands")
 	}
+	return p.readError(p.advance())
+}
+
+// readError returns the error reading the input in place of err if there was
+// one, since the command then failed because its input ended early.
+func (p *Parser) readError(err error) error {
+	if _, readErr := p.lxr.HasMoreTokens(); err != nil && readErr != nil {
+		return readErr
+	}
+	return err
+}
 
+func (p *Parser) advance() error {
 	var command Command
 	var err error
+	p.line = p.lxr.Line() // of the lexeme the command starts with
 
 	switch p.lexeme.token {
 	case lexer.EOF:
@@ -207,12 +252,9 @@ func (p *Parser) Advance() error {
 			command, err = p.parseL_Command()
 		}
 	default:
-		return fmt.Errorf("failed to parse token: %s as command", p.lexeme.token.String())
+		err = fmt.Errorf("failed to parse token: %s %q as command", p.lexeme.token.String(), p.lexeme.value)
 	}
 
-	if err != nil {
-		log.Printf("parsing failed at token %d (%v,%q) with error %v", p.tokenNum, p.lexeme.token, p.lexeme.value, err)
-	}
 	p.command = command
 
 	// Update the parser with the next lexeme
@@ -230,6 +272,11 @@ func (p *Parser) CommandType() Command {
 	return p.command.Type()
 }
 
+// Line returns the line, counted from 1, the current command starts on.
+func (p *Parser) Line() int {
+	return p.line
+}
+
 func (p *Parser) Symbol() (string, error) {
 	ct := p.CommandType()
 	if ct != (A_COMMAND{}) && ct != (L_COMMAND{}) {
@@ -261,19 +308,31 @@ func (p *Parser) Jump() (string, error) {
 }
 
 func (p *Parser) String() string {
-	return "Parser for " + p.file.Name()
-}
-
-func (p *Parser) Reset() error {
-	_, err := p.file.Seek(0, 0)
-	if err != nil {
-		panic(err)
+	if p.name == "" {
+		return "Parser"
 	}
-	p.command = nil
-
-	t, v := p.lxr.NextToken()
-	p.lexeme = &Lexeme{token: t, value: v}
-	p.tokenNum = 0
-
-	return nil
+	return "Parser for " + p.name
+}
+
+// ParseAll reads every remaining command of the input. The assembler makes
+// both of its passes over the returned commands, so the input is only read
+// once and can be a pipe. It stops at the first command that can't be parsed,
+// and reports its line.
+func (p *Parser) ParseAll() ([]Command, error) {
+	commands := []Command{}
+	// The last command may be followed by nothing at all, so stop at the EOF
+	// token rather than when the input runs out.
+	for p.lexeme.token != lexer.EOF {
+		if err := p.advance(); err != nil {
+			if readErr := p.readError(err); readErr != err {
+				return commands, readErr
+			}
+			return commands, fmt.Errorf("line %d, command %d: %w", p.line, len(commands)+1, err)
+		}
+		commands = append(commands, p.command)
+	}
+	if _, err := p.lxr.HasMoreTokens(); err != nil {
+		return commands, err
+	}
+	return commands, nil
 }
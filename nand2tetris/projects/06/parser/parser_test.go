@@ -2,8 +2,12 @@ package parser
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func setup(t *testing.T) (*os.File, func()) {
@@ -36,7 +40,7 @@ func TestParserInitialization(t *testing.T) {
 
 	testFile.Seek(0, 0)
 	p := NewParser(testFile)
-	if p.file != testFile {
+	if p.r != testFile {
 		t.Errorf("parser initialized without setting file to test file.")
 	}
 	if p.command != nil {
@@ -194,8 +198,10 @@ func TestAdvanceParseA_COMMAND(t *testing.T) {
 func TestAdvanceParseC_COMMAND(t *testing.T) {
 	// Map c instructions to their expected command parses
 	testCommands := map[string]Command{
-		"0;JMP\n": C_COMMAND{dest: "null", comp: "0", jump: "JMP"},
-		"D=A\n":   C_COMMAND{dest: "D", comp: "A", jump: "null"},
+		"0;JMP\n":       C_COMMAND{dest: "null", comp: "0", jump: "JMP"},
+		"D=A\n":         C_COMMAND{dest: "D", comp: "A", jump: "null"},
+		"MD=A+1;JLE\n":  C_COMMAND{dest: "MD", comp: "A+1", jump: "JLE"},
+		"AM=M-1\n@SP\n": C_COMMAND{dest: "AM", comp: "M-1", jump: "null"},
 	}
 
 	// Create a file for each test instruction. Then create a parser and attempt to parse the instruction
@@ -225,6 +231,25 @@ func TestAdvanceParseC_COMMAND(t *testing.T) {
 	}
 }
 
+func TestLine(t *testing.T) {
+	testFile, tearDown := setup(t)
+	defer tearDown()
+	if _, err := testFile.WriteString("// comment\n\n@2 // two\n(LOOP)\n\n  D=A\n"); err != nil {
+		t.Fatalf("could not write to test file %v", err)
+	}
+
+	testFile.Seek(0, 0)
+	p := NewParser(testFile)
+	for _, expected := range []int{3, 4, 6} {
+		if err := p.Advance(); err != nil {
+			t.Fatalf("could not advance parser %v", err)
+		}
+		if p.Line() != expected {
+			t.Errorf("expected %v on line %d but got %d", p.command, expected, p.Line())
+		}
+	}
+}
+
 func TestAdvanceParseL_COMMAND(t *testing.T) {
 	testCommands := map[string]Command{
 		"(LOOP)\n":        L_COMMAND{symbol: "LOOP"},
@@ -456,3 +481,56 @@ func TestJump(t *testing.T) {
 		t.Errorf("Expected null got %s %v", jump, err)
 	}
 }
+
+func TestParseAll(t *testing.T) {
+	input := "// comment\n(LOOP)\n@i\nM=1 // set i\n@LOOP\n0;JMP"
+	expected := []Command{
+		L_COMMAND{symbol: "LOOP"},
+		A_COMMAND{symbol: "i"},
+		C_COMMAND{dest: "M", comp: "1", jump: "null"},
+		A_COMMAND{symbol: "LOOP"},
+		C_COMMAND{dest: "null", comp: "0", jump: "JMP"},
+	}
+
+	commands, err := NewParser(strings.NewReader(input)).ParseAll()
+	if err != nil {
+		t.Fatalf("could not parse %q: %v", input, err)
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("expected %d commands but got %d: %v", len(expected), len(commands), commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Errorf("command %d: expected %v but got %v", i, expected[i], commands[i])
+		}
+	}
+
+	if _, err := NewParser(strings.NewReader("@1\n@\n")).ParseAll(); err == nil {
+		t.Errorf("expected an error parsing an A-instruction without a value")
+	}
+}
+
+func TestReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	input := func() io.Reader {
+		return io.MultiReader(strings.NewReader("@1\nD=A\n(LO"), iotest.ErrReader(errRead))
+	}
+
+	commands, err := NewParser(input()).ParseAll()
+	if !errors.Is(err, errRead) {
+		t.Errorf("expected ParseAll to return the read error got %v", err)
+	}
+	if len(commands) != 2 {
+		t.Errorf("expected the 2 commands before the error got %v", commands)
+	}
+
+	p := NewParser(input())
+	for i := 0; p.HasMoreCommands(); i++ {
+		if err = p.Advance(); err != nil || i > 3 {
+			break
+		}
+	}
+	if !errors.Is(err, errRead) {
+		t.Errorf("expected Advance to return the read error got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package source reads Hack assembly for tools that work with its text, like
+// the linter and the language server: every instruction keeps its position
+// and comments, and symbols are resolved the way the assembler resolves them.
+package source
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"assembler/isa"
+	"assembler/parser"
+	"assembler/symboltable"
+)
+
+// Pos is the position of an instruction. Lines and columns start at 1, and
+// columns count bytes.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Error is a line that can't be parsed.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Instruction is a command of a program and where it is.
+type Instruction struct {
+	parser.Command
+	Pos  Pos
+	Text string // the command as written, without spaces around it
+	// Comments holds the text after // of the comment lines since the previous
+	// instruction and of the line of the instruction, in order.
+	Comments []string
+	// Address is the ROM address of the instruction, or of the instruction
+	// after it for a label.
+	Address int
+}
+
+// IsA reports whether the instruction is an A-instruction.
+func (in Instruction) IsA() bool {
+	return in.Type() == parser.A_COMMAND{}
+}
+
+// IsLabel reports whether the instruction is a label.
+func (in Instruction) IsLabel() bool {
+	return in.Type() == parser.L_COMMAND{}
+}
+
+// IsC reports whether the instruction is a C-instruction.
+func (in Instruction) IsC() bool {
+	return in.Type() == parser.C_COMMAND{}
+}
+
+// SymbolPos returns the position of the symbol of an A-instruction or label.
+func (in Instruction) SymbolPos() Pos {
+	return Pos{Line: in.Pos.Line, Column: in.Pos.Column + strings.Index(in.Text, in.Symbol())}
+}
+
+// Program is a parsed program with its symbols resolved.
+type Program struct {
+	Instructions []Instruction
+	Symbols      *symboltable.SymbolTable
+	Labels       map[string]Instruction // the labels defined in the program
+	Variables    []string               // the variables in the order they get addresses
+}
+
+// predefined holds the predefined symbols. It's only read.
+var predefined = symboltable.NewSymbolTable()
+
+// IsPredefined reports whether symbol is one of the symbols every program
+// starts with, like SP or R13.
+func IsPredefined(symbol string) bool {
+	return predefined.Contains(symbol)
+}
+
+// IsConstant reports whether symbol is a number rather than a name.
+func IsConstant(symbol string) bool {
+	return symbol != "" && symbol[0] >= '0' && symbol[0] <= '9'
+}
+
+// IsVariable reports whether symbol is a variable, which is any symbol that is
+// neither a constant, predefined nor a label.
+func (p *Program) IsVariable(symbol string) bool {
+	_, isLabel := p.Labels[symbol]
+	return !IsConstant(symbol) && !isLabel && !IsPredefined(symbol)
+}
+
+// Parse reads the program for the instruction set set from r, one instruction
+// per line. Lines that can't be parsed are reported as an *Error.
+func Parse(r io.Reader, set *isa.ISA) (*Program, error) {
+	p := &Program{Symbols: symboltable.NewSymbolTable(), Labels: map[string]Instruction{}}
+	scanner := bufio.NewScanner(r)
+	comments := []string{}
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		code := text
+		if i := strings.Index(text, "//"); i != -1 {
+			code = text[:i]
+			comments = append(comments, text[i+2:])
+		}
+		trimmed := strings.TrimSpace(code)
+		if trimmed == "" {
+			continue
+		}
+
+		commands, err := parser.NewParserWithISA(strings.NewReader(trimmed), set).ParseAll()
+		if err == nil && len(commands) != 1 {
+			err = fmt.Errorf("expected one instruction got %d", len(commands))
+		}
+		if err != nil {
+			if cause := errors.Unwrap(err); cause != nil {
+				err = cause // the line and command number the parser adds are known here
+			}
+			return nil, &Error{Line: line, Err: err}
+		}
+		pos := Pos{Line: line, Column: strings.Index(code, trimmed) + 1}
+		p.Instructions = append(p.Instructions, Instruction{Command: commands[0], Pos: pos, Text: trimmed, Comments: comments})
+		comments = []string{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	address := 0
+	for i, in := range p.Instructions {
+		p.Instructions[i].Address = address
+		if !in.IsLabel() {
+			address++
+			continue
+		}
+		if err := p.Symbols.AddEntry(in.Symbol(), address); err != nil {
+			return nil, &Error{Line: in.Pos.Line, Err: err}
+		}
+		p.Labels[in.Symbol()] = p.Instructions[i]
+	}
+	next := 16
+	for _, in := range p.Instructions {
+		if in.IsA() && !IsConstant(in.Symbol()) && !p.Symbols.Contains(in.Symbol()) {
+			p.Symbols.AddEntry(in.Symbol(), next)
+			p.Variables = append(p.Variables, in.Symbol())
+			next++
+		}
+	}
+	return p, nil
+}
+
+// At returns the instruction on line, and whether there is one.
+func (p *Program) At(line int) (Instruction, bool) {
+	i := sort.Search(len(p.Instructions), func(i int) bool { return p.Instructions[i].Pos.Line >= line })
+	if i == len(p.Instructions) || p.Instructions[i].Pos.Line != line {
+		return Instruction{}, false
+	}
+	return p.Instructions[i], true
+}
+
+// Encode returns the machine code of the instruction in, which mustn't be a
+// label, with its symbol resolved in p.
+func (p *Program) Encode(in Instruction, set *isa.ISA) (uint32, error) {
+	if !in.IsA() {
+		return set.Encode(in.Dest(), in.Comp(), in.Jump())
+	}
+	if !IsConstant(in.Symbol()) {
+		return set.EncodeAddress(uint32(p.Symbols.GetAddress(in.Symbol())))
+	}
+	value, err := strconv.ParseUint(in.Symbol(), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid constant %s", in.Symbol())
+	}
+	return set.EncodeAddress(uint32(value))
+}
@@ -0,0 +1,81 @@
+package source
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"assembler/isa"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader("// count\n  @i // first\n\tM=1\n\n(LOOP)\n@ i\n  D=M\n@LOOP\n0;JMP\n"), isa.Hack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Instructions) != 7 {
+		t.Fatalf("expected 7 instructions got %d", len(p.Instructions))
+	}
+
+	first := p.Instructions[0]
+	if first.Pos != (Pos{Line: 2, Column: 3}) || first.Text != "@i" || first.Address != 0 {
+		t.Errorf("expected @i at 2:3 in ROM[0] got %q at %v in ROM[%d]", first.Text, first.Pos, first.Address)
+	}
+	if strings.Join(first.Comments, "|") != " count| first" {
+		t.Errorf("expected the comments above and on the line got %q", first.Comments)
+	}
+	if spaced := p.Instructions[3]; spaced.SymbolPos() != (Pos{Line: 6, Column: 3}) {
+		t.Errorf("expected the symbol of @ i at 6:3 got %v", spaced.SymbolPos())
+	}
+
+	loop, ok := p.Labels["LOOP"]
+	if !ok || loop.Address != 2 || loop.Pos.Line != 5 || p.Symbols.GetAddress("LOOP") != 2 {
+		t.Errorf("expected LOOP on line 5 naming ROM[2] got %+v", loop)
+	}
+	if len(p.Variables) != 1 || p.Variables[0] != "i" || p.Symbols.GetAddress("i") != 16 {
+		t.Errorf("expected the variable i in RAM[16] got %v", p.Variables)
+	}
+	if !p.IsVariable("i") || p.IsVariable("LOOP") || p.IsVariable("R13") || p.IsVariable("12") {
+		t.Error("expected only i to be a variable")
+	}
+
+	if in, ok := p.At(7); !ok || in.Text != "D=M" {
+		t.Errorf("expected D=M on line 7 got %q", in.Text)
+	}
+	if _, ok := p.At(4); ok {
+		t.Error("expected no instruction on the blank line 4")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	p, err := Parse(strings.NewReader("@i\n@LOOP\n(LOOP)\n@KBD\n@7\nD=D+A\n@40000\nD=Q\n"), isa.Hack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{16, 2, 0, 24576, 7, 0b1110000010010000}
+	for i, word := range expected {
+		in := p.Instructions[i]
+		if in.IsLabel() {
+			continue
+		}
+		if actual, err := p.Encode(in, isa.Hack); err != nil || actual != word {
+			t.Errorf("%s: expected %016b got %016b (%v)", in.Text, word, actual, err)
+		}
+	}
+	for _, in := range p.Instructions[6:] {
+		if _, err := p.Encode(in, isa.Hack); err == nil {
+			t.Errorf("%s: expected an error", in.Text)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse(strings.NewReader("@1\n\n@\n"), isa.Hack)
+	var parseErr *Error
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 || strings.Contains(err.Error(), "command") {
+		t.Errorf("expected an error on line 3 got %v", err)
+	}
+	if _, err := Parse(strings.NewReader("@1 @2\n"), isa.Hack); err == nil {
+		t.Error("expected an error for two instructions on a line")
+	}
+}
@@ -3,6 +3,7 @@ package symboltable
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -10,6 +11,8 @@ type SymbolTable struct {
 	t map[string]int
 }
 
+// predefined holds the symbols every table starts with. It is never modified,
+// tables get a copy of it.
 var predefined = map[string]int{
 	"SP":     0,
 	"LCL":    1,
@@ -36,12 +39,27 @@ var predefined = map[string]int{
 	"KBD":    24576,
 }
 
+// NewSymbolTable returns a table holding only the predefined symbols. Tables
+// share nothing, so every assembly can own one, concurrently with others.
 func NewSymbolTable() *SymbolTable {
 	st := new(SymbolTable)
-	st.t = predefined
+	st.t = make(map[string]int, len(predefined))
+	for symbol, address := range predefined {
+		st.t[symbol] = address
+	}
 	return st
 }
 
+// Predefined returns the names of the predefined symbols, sorted.
+func Predefined() []string {
+	names := make([]string, 0, len(predefined))
+	for symbol := range predefined {
+		names = append(names, symbol)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // "Adds the pair (symbol, address) to the table."
 func (st *SymbolTable) AddEntry(symbol string, address int) error {
 	const sixteenBit = 16
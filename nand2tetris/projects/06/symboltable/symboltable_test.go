@@ -1,6 +1,9 @@
 package symboltable
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestSymbolTableInitialization(t *testing.T) {
 	predefined := map[string]int{
@@ -77,3 +80,61 @@ func TestContains(t *testing.T) {
 	}
 
 }
+
+func TestSymbolTablesAreIsolated(t *testing.T) {
+	first, second := NewSymbolTable(), NewSymbolTable()
+	if err := first.AddEntry("LOOP", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.AddEntry("SP", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if second.Contains("LOOP") {
+		t.Errorf("a label added to one SymbolTable leaked into another")
+	}
+	if address := second.GetAddress("SP"); address != 0 {
+		t.Errorf("expected SP to stay at 0 in another SymbolTable but got %d", address)
+	}
+	if third := NewSymbolTable(); third.Contains("LOOP") || third.GetAddress("SP") != 0 {
+		t.Errorf("a new SymbolTable should only hold the predefined symbols")
+	}
+}
+
+func TestSymbolTablesConcurrently(t *testing.T) {
+	// run with -race: tables used on different goroutines must not share memory
+	const goroutines = 8
+	done := make(chan bool)
+	for g := 0; g < goroutines; g++ {
+		g := g
+		go func() {
+			st := NewSymbolTable()
+			ok := true
+			for i := 0; i < 100; i++ {
+				st.AddEntry(fmt.Sprintf("LABEL%d", i), g*1000+i)
+			}
+			for i := 0; i < 100; i++ {
+				ok = ok && st.GetAddress(fmt.Sprintf("LABEL%d", i)) == g*1000+i
+			}
+			done <- ok
+		}()
+	}
+	for g := 0; g < goroutines; g++ {
+		if !<-done {
+			t.Errorf("a SymbolTable saw the labels of another goroutine")
+		}
+	}
+}
+
+func TestPredefined(t *testing.T) {
+	names := Predefined()
+	if len(names) != 23 || names[0] != "ARG" || names[len(names)-1] != "THIS" {
+		t.Errorf("expected the 23 predefined symbols in order got %v", names)
+	}
+	st := NewSymbolTable()
+	for _, name := range names {
+		if !st.Contains(name) {
+			t.Errorf("expected a new table to contain %s", name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Blackens the screen while a key is pressed and clears it otherwise.
+
+(LISTEN)
+    @KBD
+    D=M
+    @color
+    M=0
+    @PAINT
+    D;JEQ
+    @color
+    M=-1        // a key is pressed: black
+(PAINT)
+    @SCREEN
+    D=A
+    @pixels
+    M=D         // the word of the screen to paint next
+(NEXT)
+    @color
+    D=M
+    @pixels
+    A=M
+    M=D
+    @pixels
+    MD=M+1
+    @KBD
+    D=D-A
+    @NEXT
+    D;JLT       // until the keyboard's memory map
+    @LISTEN
+    0;JMP
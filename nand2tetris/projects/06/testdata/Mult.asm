@@ -0,0 +1,26 @@
+// Multiplies R0 and R1 and stores the result in R2.
+// (R0, R1, R2 refer to RAM[0], RAM[1], and RAM[2], respectively.)
+
+// R2 = 0, i = R1, then add R0 to R2 i times
+    @R2
+    M=0
+    @R1
+    D=M
+    @i
+    M=D
+(LOOP)
+    @i
+    D=M
+    @END
+    D;JLE       // while i > 0
+    @R0
+    D=M
+    @R2
+    M=D+M       // R2 += R0
+    @i
+    M=M-1
+    @LOOP
+    0;JMP
+(END)
+    @END
+    0;JMP
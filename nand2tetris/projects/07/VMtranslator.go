@@ -8,13 +8,25 @@ import (
 	"strings"
 )
 
+// stdio is the source path that translates stdin to stdout, so the translator
+// can be chained with other tools, e.g. `VMtranslator - | assembler - > out.hack`.
+const stdio = "-"
+
 func main() {
 	if len(os.Args) != 2 {
-		fmt.Println("VMTranslator expects a .vm file or dir containing .vm files")
+		fmt.Println("VMTranslator expects a .vm file or dir containing .vm files, or - to translate stdin to stdout")
 		os.Exit(1)
 	}
 	srcPath := os.Args[1]
 
+	if srcPath == stdio {
+		if err := translateStdio(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not translate stdin: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Printf("Translating %s ...\n", srcPath)
 	err := translate(srcPath)
 	if err != nil {
@@ -29,7 +41,6 @@ func translate(path string) error {
 	}
 	defer srcFile.Close()
 
-	p := parser.NewParser(srcFile)
 	outputFilename := strings.Split(path, ".")[0] + ".asm"
 
 	outputFile, err := os.Create(outputFilename)
@@ -38,7 +49,27 @@ func translate(path string) error {
 	}
 
 	codeWriter := codewriter.NewCodeWriter(outputFile)
+	writeCommands(parser.NewParser(srcFile), codeWriter)
 
+	if err := codeWriter.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Created output file: %s", outputFilename)
+
+	return nil
+}
+
+// translateStdio translates the VM commands read from stdin and writes the
+// assembly to stdout. Their static variables are named after "stdin".
+func translateStdio() error {
+	codeWriter := codewriter.NewCodeWriter(os.Stdout)
+	codeWriter.SetFileName("stdin")
+	writeCommands(parser.NewParser(os.Stdin), codeWriter)
+	return codeWriter.Close()
+}
+
+// writeCommands translates every command p reads with codeWriter.
+func writeCommands(p *parser.Parser, codeWriter *codewriter.CodeWriter) {
 	for p.HasMoreCommands() {
 		p.Advance()
 
@@ -50,11 +81,4 @@ func translate(path string) error {
 			codeWriter.WritePushPop(p.CommandType(), p.Arg1(), p.Arg2())
 		}
 	}
-
-	if err := codeWriter.Close(); err != nil {
-		return err
-	}
-	fmt.Printf("Created output file: %s", outputFilename)
-
-	return nil
 }
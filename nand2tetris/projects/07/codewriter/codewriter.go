@@ -26,8 +26,12 @@ func NewCodeWriter(outputFile *os.File) *CodeWriter {
 	return cw
 }
 
+// SetFileName tells the CodeWriter that the commands that follow come from
+// fileName, which names their static variables.
 func (cw *CodeWriter) SetFileName(fileName string) {
 	cw.fileName = fileName
+	_, f := filepath.Split(cw.fileName)
+	cw.label = strings.Split(f, ".")[0]
 }
 
 const unsupportedCmdString = "Unsupported Command: "
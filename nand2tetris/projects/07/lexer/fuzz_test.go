@@ -0,0 +1,40 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzLexer(f *testing.F) {
+	paths, _ := filepath.Glob("../../*/*/*/*.vm")
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, input := range []string{"", "add", "push constant 7", "/", "/ add", "// end", "@ add", "\x00", "\xff", strings.Repeat("\n", 5000)} {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := NewLexer(strings.NewReader(input))
+		line := 1
+		for n := 0; ; n++ {
+			lexeme := l.NextToken()
+			if l.Line() < line {
+				t.Fatalf("%s %q on line %d after a token on line %d", lexeme.Token, lexeme.Value, l.Line(), line)
+			}
+			line = l.Line()
+			if lexeme.Token == EOF {
+				break
+			}
+			if n > len(input) {
+				t.Fatalf("read %d tokens from %d bytes, the last %s %q", n, len(input), lexeme.Token, lexeme.Value)
+			}
+		}
+	})
+}
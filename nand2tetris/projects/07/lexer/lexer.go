@@ -2,9 +2,8 @@ package lexer
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"log"
-	"os"
 	"strconv"
 )
 
@@ -30,35 +29,52 @@ var tokens = []string{
 }
 
 func (t Token) String() string {
+	if t < 0 || int(t) >= len(tokens) {
+		return fmt.Sprintf("Token(%d)", int(t))
+	}
 	return tokens[t]
 }
 
 type Lexer struct {
-	r    *bufio.Reader
-	prev Token
+	r     *bufio.Reader
+	prev  Token
+	line  int  // the line being read
+	atEOF bool // the last character read was the end of the input
 }
 
-func NewLexer(f *os.File) *Lexer {
-	return &Lexer{r: bufio.NewReader(f), prev: NEWLINE}
+// NewLexer returns a Lexer that reads VM commands from r.
+func NewLexer(r io.Reader) *Lexer {
+	return &Lexer{r: bufio.NewReader(r), prev: NEWLINE, line: 1}
 }
 
-const eofRune = rune(0)
+// eofRune stands for the end of the input. ReadRune never returns it, so a NUL
+// in the input is just an illegal character.
+const eofRune = rune(-1)
 const newlineRune = rune(10)
 
 func (l *Lexer) getChar() rune {
 	ch, _, err := l.r.ReadRune()
 	if err != nil {
 		if err == io.EOF {
+			l.atEOF = true
 			return eofRune
 		} else {
 			panic(err)
 		}
 	}
+	l.atEOF = false
 	return ch
 }
 
-func (l *Lexer) unread() error {
-	return l.r.UnreadRune()
+// unread puts the last character read back. There's nothing to put back at
+// the end of the input.
+func (l *Lexer) unread() {
+	if l.atEOF {
+		return
+	}
+	if err := l.r.UnreadRune(); err != nil {
+		panic(err)
+	}
 }
 
 func isWhitespace(ch rune) bool {
@@ -78,7 +94,24 @@ type Lexeme struct {
 	Value string
 }
 
+// Line returns the line, counted from 1, of the last token NextToken returned.
+func (l *Lexer) Line() int {
+	return l.line
+}
+
+// NextToken returns the next token. Characters that start no token are
+// returned one at a time as ILLEGAL tokens.
 func (l *Lexer) NextToken() *Lexeme {
+	for {
+		if lexeme := l.next(); lexeme != nil {
+			return lexeme
+		}
+	}
+}
+
+// next returns the next token, or nil if it only skipped a line ending or a
+// comment.
+func (l *Lexer) next() *Lexeme {
 	var currChar = ' '
 
 	for isWhitespace(currChar) {
@@ -92,19 +125,19 @@ func (l *Lexer) NextToken() *Lexeme {
 
 	if currChar == newlineRune {
 		l.prev = NEWLINE
-		return l.NextToken()
+		l.line++
+		return nil
 	}
 
 	if isLetter(currChar) || isDigit(currChar) {
 		charSeq := []rune{currChar}
-		for currChar = l.getChar(); !isWhitespace(currChar); {
+		for currChar = l.getChar(); !isWhitespace(currChar) && currChar != newlineRune && currChar != eofRune; {
 			charSeq = append(charSeq, currChar)
 			currChar = l.getChar()
 		}
 
-		if err := l.unread(); err != nil {
-			panic(err)
-		}
+		// Leave the character that ended the word in the input.
+		l.unread()
 
 		if _, err := strconv.ParseInt(string(charSeq), 10, 16); err == nil {
 			l.prev = ARG
@@ -116,27 +149,26 @@ func (l *Lexer) NextToken() *Lexeme {
 			return &Lexeme{COMMAND, string(charSeq)}
 		}
 
-		if l.prev == COMMAND || l.prev == ARG {
-			l.prev = ARG
-			return &Lexeme{ARG, string(charSeq)}
-		}
+		// Anything else on the line, even after an illegal character, is an
+		// argument.
+		l.prev = ARG
+		return &Lexeme{ARG, string(charSeq)}
 	}
 
 	if currChar == '/' {
-		for currChar != eofRune && currChar != newlineRune && currChar != '\r' {
-			currChar = l.getChar()
-		}
-
-		if currChar != eofRune {
-			return l.NextToken()
+		if next := l.getChar(); next == '/' {
+			for currChar != eofRune && currChar != newlineRune {
+				currChar = l.getChar()
+			}
+			// Leave the line ending in the input so the line is counted.
+			l.unread()
+			return nil
 		}
+		l.unread()
 	}
 
-	log.Printf("could not derive token from char: %q with prev token %s", currChar, l.prev.String())
-	thisChar := currChar
 	l.prev = ILLEGAL
-	_ = l.getChar()
-	return &Lexeme{ILLEGAL, string(thisChar)}
+	return &Lexeme{ILLEGAL, string(currChar)}
 }
 
 func (l *Lexer) HasMoreTokens() bool {
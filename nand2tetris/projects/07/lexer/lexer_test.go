@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -152,3 +153,26 @@ func TestStackTest(t *testing.T) {
 		}
 	}
 }
+
+func TestReader(t *testing.T) {
+	// LF line endings, a comment and no newline after the last command
+	lxr := NewLexer(strings.NewReader("push constant 7 // seven\npush constant 8\nadd"))
+	expected := []Lexeme{
+		{COMMAND, "push"},
+		{ARG, "constant"},
+		{ARG, "7"},
+		{COMMAND, "push"},
+		{ARG, "constant"},
+		{ARG, "8"},
+		{COMMAND, "add"},
+		{EOF, "EOF"},
+	}
+
+	for i, expctd := range expected {
+		actual := lxr.NextToken()
+
+		if !expctd.Equals(actual) {
+			t.Errorf("comparison failed at token %d. expected %#v got %#v", i, expctd, actual)
+		}
+	}
+}
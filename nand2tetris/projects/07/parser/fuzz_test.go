@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzParser(f *testing.F) {
+	paths, _ := filepath.Glob("../../*/*/*/*.vm")
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, input := range []string{"", "push", "push constant", "push constant x", "pop local\nadd", "lathe LOOP\n", "@ add", "/", "push constant 1\nadd"} {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := NewParser(strings.NewReader(input))
+		for n := 0; p.HasMoreCommands(); n++ {
+			if n > len(input) {
+				t.Fatalf("parsed %d commands from %d bytes", n, len(input))
+			}
+			var perr *ParserCouldNotParseError
+			if err := p.Advance(); err != nil && !errors.As(err, &perr) {
+				t.Errorf("expected a *ParserCouldNotParseError, got %v", err)
+			}
+		}
+	})
+}
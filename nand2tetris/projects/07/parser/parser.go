@@ -2,10 +2,9 @@ package parser
 
 import (
 	"VMtranslator/lexer"
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
+	"io"
 	"strconv"
 )
 
@@ -36,6 +35,9 @@ var commandTypes = []string{
 }
 
 func (ct CommandType) String() string {
+	if ct < 0 || int(ct) >= len(commandTypes) {
+		return fmt.Sprintf("CommandType(%d)", int(ct))
+	}
 	return commandTypes[ct]
 }
 
@@ -48,34 +50,35 @@ type Command struct {
 var emptyArg2 = -1
 
 type Parser struct {
-	f      *os.File
-	rdr    *bufio.Reader
+	r      io.Reader
 	lxr    *lexer.Lexer
 	lexeme *lexer.Lexeme
 	cmd    *Command
 }
 
-func NewParser(file *os.File) *Parser {
-	if file == nil {
+// NewParser returns a Parser that reads VM commands from r, which can be a
+// file, stdin or any other stream.
+func NewParser(r io.Reader) *Parser {
+	if r == nil {
 		return &Parser{}
 	}
 
-	reader := bufio.NewReader(file)
-	lexer := lexer.NewLexer(file)
+	lexer := lexer.NewLexer(r)
 
 	// Load the first token from the input
 	initialLex := lexer.NextToken()
 	return &Parser{
-		f:      file,
-		rdr:    reader,
+		r:      r,
 		lxr:    lexer,
 		lexeme: initialLex,
 		cmd:    nil,
 	}
 }
 
+// HasMoreCommands reports whether a command is left to Advance to. The last
+// command of a stream needn't be followed by a newline.
 func (p *Parser) HasMoreCommands() bool {
-	return p.lxr.HasMoreTokens()
+	return p.lexeme != nil && p.lexeme.Token != lexer.EOF
 }
 
 func isArithmeticCommand(command string) bool {
@@ -103,29 +106,39 @@ func (p *Parser) parsePushPopCommand() (*Command, error) {
 	segment := p.lxr.NextToken() // consume segment
 	if segment.Token != lexer.ARG {
 		return &Command{
-				ct: currCmdType, arg1: segment.Value,
-				arg2: emptyArg2,
-			}, &ParserCouldNotParseError{
-				lxm: segment,
-				msg: fmt.Sprintf("expected ARG token while parsing \"push\" command got %s instead\n", segment.Token),
-			}
+			ct: currCmdType, arg1: segment.Value,
+			arg2: emptyArg2,
+		}, &ParserCouldNotParseError{
+			line: p.lxr.Line(),
+			lxm:  segment,
+			msg:  fmt.Sprintf("expected ARG token while parsing \"push\" command got %s instead", segment.Token),
+		}
 	}
 
 	index := p.lxr.NextToken() // consume index
 	if index.Token != lexer.ARG {
-		fmt.Printf("expected ARG token while parsing \"push\" command got %s instead\n", index.Token)
+		return &Command{
+			ct:   currCmdType,
+			arg1: segment.Value,
+			arg2: emptyArg2,
+		}, &ParserCouldNotParseError{
+			line: p.lxr.Line(),
+			lxm:  index,
+			msg:  fmt.Sprintf("expected ARG token while parsing %q command got %s instead", p.lexeme.Value, index.Token),
+		}
 	}
 
 	indexInt, err := strconv.Atoi(index.Value)
 	if err != nil {
 		return &Command{
-				ct:   currCmdType,
-				arg1: segment.Value,
-				arg2: emptyArg2,
-			}, &ParserCouldNotParseError{
-				lxm: index,
-				msg: fmt.Sprintf("could not convert %q to int while parsing push command (%s, %q)", index, index.Token.String(), index),
-			}
+			ct:   currCmdType,
+			arg1: segment.Value,
+			arg2: emptyArg2,
+		}, &ParserCouldNotParseError{
+			line: p.lxr.Line(),
+			lxm:  index,
+			msg:  fmt.Sprintf("could not convert %q to int while parsing push command (%s, %q)", index, index.Token.String(), index),
+		}
 	}
 
 	return &Command{ct: currCmdType, arg1: segment.Value, arg2: indexInt}, nil
@@ -134,12 +147,13 @@ func (p *Parser) parsePushPopCommand() (*Command, error) {
 var ErrParserNoMoreCommands = errors.New("parser has no more commands")
 
 type ParserCouldNotParseError struct {
-	lxm *lexer.Lexeme
-	msg string
+	line int // the line of lxm
+	lxm  *lexer.Lexeme
+	msg  string
 }
 
 func (e *ParserCouldNotParseError) Error() string {
-	return e.msg
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
 }
 
 func (p *Parser) Advance() error {
@@ -159,7 +173,13 @@ func (p *Parser) Advance() error {
 			if p.lexeme.Value == "push" || p.lexeme.Value == "pop" {
 				parsedCmd, err = p.parsePushPopCommand()
 			}
+
+			if parsedCmd == nil {
+				err = &ParserCouldNotParseError{line: p.lxr.Line(), lxm: p.lexeme, msg: fmt.Sprintf("unsupported command %q", p.lexeme.Value)}
+			}
 		}
+	default:
+		err = &ParserCouldNotParseError{line: p.lxr.Line(), lxm: p.lexeme, msg: fmt.Sprintf("expected a command got (%s, %q)", p.lexeme.Token, p.lexeme.Value)}
 	}
 	p.cmd = parsedCmd
 
@@ -179,6 +199,9 @@ func (p *Parser) CommandType() CommandType {
 }
 
 func (p *Parser) Arg1() string {
+	if p.cmd == nil {
+		return ""
+	}
 	if p.CommandType() == C_RETURN {
 		fmt.Println("attempted to call Arg1 on a C_RETURN command")
 		return ""
@@ -61,7 +61,7 @@ func TestAdvanceSimpleAdd(t *testing.T) {
 }
 
 func TestAdvanceStackTest(t *testing.T) {
-	f, err := os.Open("../StackArithmetic/StackTest/Stacktest.vm")
+	f, err := os.Open("../StackArithmetic/StackTest/StackTest.vm")
 	if err != nil {
 		panic(err)
 	}
@@ -1,165 +1,659 @@
 package main
 
 import (
+	"VMtranslator/analysis"
+	"VMtranslator/backend"
+	"VMtranslator/buildcache"
 	"VMtranslator/codewriter"
+	"VMtranslator/coverage"
+	"VMtranslator/gowriter"
+	"VMtranslator/hack"
 	"VMtranslator/parser"
+	"VMtranslator/profile"
+	"VMtranslator/trace"
+	"VMtranslator/vmopt"
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
+// options controls how translate generates code.
+type options struct {
+	optimize      bool // optimize the VM commands, and the generated assembly for the hack target
+	sharedRuntime bool // emit call, return and comparisons as shared routines
+	sourceMap     bool // write a .map file tying every instruction to its VM command
+	target        string
+	verify        bool   // check the program with the analysis package before translating it
+	dce           bool   // leave out functions that can't be reached from Sys.init or Main.main
+	callGraph     string // file to write the call graph to, as DOT or JSON depending on its extension
+	checked       bool   // guard the stack, frames and I/O memory at run time
+	stackLimit    int    // largest SP checked code allows
+	profile       string // file to write a pprof profile of running the program to
+	profileCycles uint64 // instructions to profile at most
+	trace         string // file to write a trace of running the program to
+	traceFormat   string // text or binary
+	tracePC       string // ranges of ROM addresses and labels to trace, all if empty
+	traceCycles   uint64 // instructions to trace at most
+	cover         string // file to write the coverage of running the program to
+	coverCycles   uint64 // instructions to run at most for coverage
+	jobs          int    // files of the hack target translated at the same time
+
+	cache *buildcache.Cache // Hack assembly of files translated before, and programs assembled before; nil builds everything
+}
+
+// targets maps the supported values of -target to the extension of their output.
+var targets = map[string]string{
+	"hack": ".asm",
+	"go":   ".go",
+}
+
+// stdio is the source path that translates stdin to stdout, so the translator
+// can be chained with other tools, e.g. `VMtranslator - | assembler - > out.hack`.
+const stdio = "-"
+
+// messages receives the progress and problems the translator reports. It's
+// stderr when the translated program itself is written to stdout.
+var messages io.Writer = os.Stdout
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("VMTranslator expects a .vm file or dir containing .vm files")
+	var opts options
+	flag.BoolVar(&opts.optimize, "O", false, "optimize the VM commands before translating them, and the generated Hack assembly")
+	flag.BoolVar(&opts.sharedRuntime, "shared-runtime", false, "emit call, return and comparisons as shared routines to save ROM")
+	flag.BoolVar(&opts.sourceMap, "sourcemap", false, "write a source map from ROM addresses to VM commands next to the output file")
+	flag.StringVar(&opts.target, "target", "hack", "output to generate: hack for Hack assembly or go for a Go program that runs natively")
+	flag.BoolVar(&opts.verify, "verify", false, "check calls, jumps and the stack depth of every function before translating")
+	flag.BoolVar(&opts.dce, "dce", false, "leave out functions that can't be reached from Sys.init, or Main.main without a bootstrap")
+	flag.StringVar(&opts.callGraph, "callgraph", "", "write the call graph to a .dot or .json file")
+	flag.BoolVar(&opts.checked, "checked", false, "halt with an error code when the stack overflows, a frame is corrupted or the screen is written by accident")
+	flag.IntVar(&opts.stackLimit, "stack-limit", codewriter.DefaultStackLimit, "largest stack pointer allowed by -checked")
+	flag.StringVar(&opts.profile, "profile", "", "run the program, print where it spends its instructions and write a pprof profile to this file")
+	flag.Uint64Var(&opts.profileCycles, "profile-cycles", 10000000, "instructions to run at most with -profile")
+	flag.StringVar(&opts.trace, "trace", "", "run the program and write every instruction it executes to this file")
+	flag.StringVar(&opts.traceFormat, "trace-format", "text", "format of -trace: text, or binary for tracesum")
+	flag.StringVar(&opts.tracePC, "trace-pc", "", "trace only these comma separated ROM addresses, ranges like 100-200 and labels")
+	flag.Uint64Var(&opts.traceCycles, "trace-cycles", 1000000, "instructions to run at most with -trace")
+	flag.StringVar(&opts.cover, "cover", "", "run the program and write which lines ran to a .html, .txt or Go coverage profile file")
+	flag.Uint64Var(&opts.coverCycles, "cover-cycles", 10000000, "instructions to run at most with -cover")
+	flag.IntVar(&opts.jobs, "j", runtime.NumCPU(), "number of files to translate to Hack assembly at the same time")
+	cacheDir := flag.String("cache", "", "keep the Hack assembly of every file, and the program assembled for -profile, -trace and -cover, in this directory and only translate and assemble what changed since; hack target only")
+	watchSources := flag.Bool("watch", false, "translate again whenever a .vm file changes, until interrupted")
+	watchInterval := flag.Duration("watch-interval", 500*time.Millisecond, "how often -watch checks the .vm files for changes")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("VMTranslator expects a .vm file or dir containing .vm files, or - to translate stdin to stdout")
 		os.Exit(1)
 	}
-	srcPath := os.Args[1]
+	srcPath := flag.Arg(0)
+	if srcPath == stdio {
+		messages = os.Stderr
+	}
 
-	fmt.Printf("Translating %s ...\n", srcPath)
-	err := translate(srcPath)
+	if *cacheDir != "" || (*watchSources && opts.target == "hack") {
+		var err error
+		// without a directory, -watch still reuses files between its own builds
+		if opts.cache, err = buildcache.Open(*cacheDir); err != nil {
+			panic(err)
+		}
+	}
+	if *watchSources {
+		if srcPath == stdio {
+			fmt.Fprintf(messages, "-watch can't be used with %s\n", stdio)
+			os.Exit(1)
+		}
+		if err := watch(srcPath, opts, *watchInterval, nil); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	fmt.Fprintf(messages, "Translating %s ...\n", srcPath)
+	err := translate(srcPath, opts)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func translate(path string) error {
-	fi, err := os.Stat(path)
+// watch translates path, then translates it again whenever a .vm file of path
+// changes, appears or disappears, looking for changes every interval until stop
+// is closed. Builds that fail are reported, and watching goes on.
+func watch(path string, opts options, interval time.Duration, stop <-chan struct{}) error {
+	last, err := fingerprint(path)
 	if err != nil {
 		return err
 	}
+	build := func() {
+		fmt.Fprintf(messages, "Translating %s ...\n", path)
+		if err := translate(path, opts); err != nil {
+			fmt.Fprintf(messages, "Translation failed: %v\n", err)
+		}
+		fmt.Fprintf(messages, "Watching %s for changes ...\n", path)
+	}
+	build()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		current, err := fingerprint(path)
+		if err != nil {
+			// an editor may be halfway through replacing a file
+			fmt.Fprintln(messages, err)
+			continue
+		}
+		if current != last {
+			last = current
+			build()
+		}
+	}
+}
 
-	if !fi.IsDir() {
-		srcFile, err := os.Open(path)
+// fingerprint returns a hash of the names and contents of the .vm files of path.
+func fingerprint(path string) (buildcache.Key, error) {
+	srcPaths, _, err := sourcePaths(path)
+	if err != nil {
+		return buildcache.Key{}, err
+	}
+	h := buildcache.NewHasher()
+	for _, srcPath := range srcPaths {
+		content, err := os.ReadFile(srcPath)
 		if err != nil {
-			return err
+			return buildcache.Key{}, err
 		}
-		defer srcFile.Close()
+		h.Add(srcPath, len(content))
+		h.Write(content)
+	}
+	return h.Key(), nil
+}
 
-		p := parser.NewParser(srcFile)
+// newCodeWriter creates a CodeWriter for outputFile configured by opts.
+func newCodeWriter(outputFile *os.File, opts options) *codewriter.CodeWriter {
+	cw := codewriter.NewCodeWriter(outputFile)
+	if opts.optimize {
+		cw.EnableOptimization()
+	}
+	if opts.sharedRuntime {
+		cw.EnableSharedRuntime()
+	}
+	if opts.sourceMap || opts.profile != "" || opts.cover != "" {
+		cw.EnableSourceMap()
+	}
+	if opts.checked {
+		cw.EnableChecks(opts.stackLimit)
+	}
+	if opts.cache != nil {
+		cw.EnableCache(opts.cache)
+	}
+	cw.SetJobs(opts.jobs)
+	return cw
+}
 
-		outputFilename := strings.Split(path, ".")[0] + ".asm"
-		outputFile, err := os.Create(outputFilename)
+// writeSourceMap writes the source map of the closed cw next to outputFilename.
+func writeSourceMap(cw *codewriter.CodeWriter, outputFilename string, opts options) error {
+	if !opts.sourceMap {
+		return nil
+	}
+	mapFilename := strings.TrimSuffix(outputFilename, ".asm") + ".map"
+	mapFile, err := os.Create(mapFilename)
+	if err != nil {
+		return err
+	}
+	if err := codewriter.WriteSourceMap(mapFile, cw.SourceMap()); err != nil {
+		mapFile.Close()
+		return err
+	}
+	fmt.Fprintf(messages, "Created source map: %s\n", mapFilename)
+	return mapFile.Close()
+}
+
+// printStats reports how many instructions the optimizer saved in each file.
+func printStats(cw *codewriter.CodeWriter) {
+	for _, s := range cw.Stats() {
+		fmt.Fprintf(messages, "Optimized %s: %d -> %d instructions (saved %d)\n", s.FileName, s.Before, s.After, s.Saved())
+	}
+}
+
+// parseSources parses every file in srcPaths.
+func parseSources(srcPaths []string, isDir bool) ([]backend.File, error) {
+	sources := []backend.File{}
+	for _, srcPath := range srcPaths {
+		fname := filepath.Base(srcPath)
+		if isDir {
+			fmt.Fprintf(messages, "Found vm file %s. Translating...\n", fname)
+		}
+		f, err := os.Open(srcPath)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		cmds, err := parser.NewParser(f).ParseAll()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
 		}
-		fmt.Printf("%s is a file.\nCreating output: %s\n", path, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fname, err)
+		}
+		sources = append(sources, backend.File{Name: fname, Commands: cmds})
+	}
+	return sources, nil
+}
 
-		codeWriter := codewriter.NewCodeWriter(outputFile)
+// verify prints the problems the analysis finds in the whole program and fails
+// if there are any.
+func verify(sources []backend.File) error {
+	cmds := program(sources)
+	problems := append(analysis.CheckLinks(cmds), analysis.VerifyStackDepth(cmds)...)
+	for _, p := range problems {
+		fmt.Fprintln(messages, p)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("verification found %d problems", len(problems))
+	}
+	return nil
+}
 
-		for p.HasMoreCommands() {
-			err := p.Advance()
-			if err != nil {
-				fmt.Println(err)
-			}
+// checkStatics prints an error if the program has more static variables than
+// fit below the stack of opts.target.
+func checkStatics(sources []backend.File, opts options) error {
+	capacity := codewriter.StaticCapacity
+	if opts.target == "go" {
+		capacity = gowriter.StaticCapacity
+	}
+	problems := analysis.CheckStatics(program(sources), capacity)
+	for _, p := range problems {
+		fmt.Fprintln(messages, p)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("too many static variables")
+	}
+	return nil
+}
 
-			ct := p.CommandType()
-			switch ct {
-			case parser.C_ARITHMETIC:
-				codeWriter.WriteArithmetic(p.Arg1())
+// program returns the commands of every source as one program.
+func program(sources []backend.File) []parser.Command {
+	cmds := []parser.Command{}
+	for _, src := range sources {
+		cmds = append(cmds, src.Commands...)
+	}
+	return cmds
+}
 
-			case parser.C_PUSH, parser.C_POP:
-				codeWriter.WritePushPop(p.CommandType(), p.Arg1(), p.Arg2())
+// eliminateDeadFunctions builds the call graph of the program, writes it out if
+// requested and removes the unreachable functions from sources if requested.
+func eliminateDeadFunctions(sources []backend.File, opts options) error {
+	g := analysis.BuildCallGraph(program(sources))
+	if opts.target == "go" {
+		g.AddRoots(gowriter.Invoked...)
+	}
+	if opts.callGraph != "" {
+		if err := writeCallGraph(g, opts.callGraph); err != nil {
+			return err
+		}
+	}
+	if !opts.dce {
+		return nil
+	}
 
-			case parser.C_IF:
-				codeWriter.WriteIf(p.Arg1())
+	for i := range sources {
+		sources[i].Commands = analysis.EliminateDeadFunctions(sources[i].Commands, g)
+	}
+	if unreachable := g.Unreachable(); len(unreachable) != 0 {
+		fmt.Fprintf(messages, "Removed %d unreachable functions: %s\n", len(unreachable), strings.Join(unreachable, ", "))
+	}
+	return nil
+}
 
-			case parser.C_LABEL:
-				codeWriter.WriteLabel(p.Arg1())
+func writeCallGraph(g *analysis.CallGraph, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = g.WriteJSON(f)
+	case ".dot", ".gv":
+		err = g.WriteDOT(f)
+	default:
+		err = fmt.Errorf("call graph %s: expected a .dot or .json file", path)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		fmt.Fprintf(messages, "Created call graph: %s\n", path)
+	}
+	return err
+}
+
+// sourcePaths returns the .vm files of path: path itself, or the .vm files in
+// the directory path.
+func sourcePaths(path string) (srcPaths []string, isDir bool, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, false, nil
+	}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".vm" {
+			srcPaths = append(srcPaths, path+file.Name())
+		}
+	}
+	return srcPaths, true, nil
+}
 
-			case parser.C_GOTO:
-				codeWriter.WriteGoto(p.Arg1())
+// readPath parses path, a .vm file or a directory of .vm files, and returns
+// the name of the output file for the extension ext.
+func readPath(path, ext string) (sources []backend.File, outputFilename string, isDir bool, err error) {
+	srcPaths, isDir, err := sourcePaths(path)
+	if err != nil {
+		return nil, "", false, err
+	}
 
-			case parser.C_CALL:
-				codeWriter.WriteCall(p.Arg1(), p.Arg2())
+	if isDir {
+		fmt.Fprintf(messages, "%s is a directory\n", path)
+		outputFilename = path + filepath.Base(path) + ext
+	} else {
+		fmt.Fprintf(messages, "%s is a file.\nCreating output: %s\n", path, path)
+		outputFilename = strings.Split(path, ".")[0] + ext
+	}
 
-			case parser.C_FUNCTION:
-				codeWriter.WriteFunction(p.Arg1(), p.Arg2())
+	sources, err = parseSources(srcPaths, isDir)
+	return sources, outputFilename, isDir, err
+}
 
-			case parser.C_RETURN:
-				codeWriter.WriteReturn()
-			}
-		}
+// translate translates path, a .vm file or a directory of .vm files, to a
+// single output file for opts.target. Hack assembly for a directory starts
+// with a bootstrap that calls Sys.init. A path of - translates stdin to stdout.
+func translate(path string, opts options) error {
+	if opts.target == "" {
+		opts.target = "hack"
+	}
+	ext, ok := targets[opts.target]
+	if !ok {
+		return fmt.Errorf("unknown target %q", opts.target)
+	}
+	if opts.target != "hack" && (opts.sharedRuntime || opts.sourceMap || opts.checked || opts.profile != "" || opts.trace != "" || opts.cover != "" || opts.cache != nil) {
+		return fmt.Errorf("-shared-runtime, -sourcemap, -checked, -profile, -trace, -cover and -cache only apply to the hack target")
+	}
+	if _, err := trace.ParseFormat(opts.traceFormat); opts.trace != "" && err != nil {
+		return err
+	}
+	if opts.checked && (opts.stackLimit <= 256 || opts.stackLimit > codewriter.MaxStackLimit) {
+		return fmt.Errorf("-stack-limit must be between 257 and %d, below the error record of -checked, got %d", codewriter.MaxStackLimit, opts.stackLimit)
+	}
 
-		if err := codeWriter.Close(); err != nil {
+	var sources []backend.File
+	var outputFilename string // empty when the output goes to stdout
+	var isDir bool
+	if path == stdio {
+		if opts.sourceMap || opts.profile != "" || opts.trace != "" || opts.cover != "" {
+			return fmt.Errorf("-sourcemap, -profile, -trace and -cover need an output file and can't be used with %s", stdio)
+		}
+		cmds, err := parser.NewParser(os.Stdin).ParseAll()
+		if err != nil {
+			return fmt.Errorf("stdin: %w", err)
+		}
+		sources = []backend.File{{Name: "stdin", Commands: cmds}}
+	} else {
+		var err error
+		if sources, outputFilename, isDir, err = readPath(path, ext); err != nil {
 			return err
 		}
-		fmt.Printf("Created output file: %s\n", outputFilename)
 	}
 
-	if fi.IsDir() {
-		outputFilename := path + filepath.Base(path) + ".asm"
-		outputFile, err := os.Create(outputFilename)
-		if err != nil {
+	if opts.verify {
+		if err := verify(sources); err != nil {
+			return err
+		}
+	}
+	if opts.dce || opts.callGraph != "" {
+		if err := eliminateDeadFunctions(sources, opts); err != nil {
 			return err
 		}
-		cw := codewriter.NewCodeWriter(outputFile)
+	}
+	if opts.optimize {
+		for i, src := range sources {
+			sources[i].Commands = vmopt.Optimize(src.Commands)
+			fmt.Fprintf(messages, "Optimized %s: %d -> %d VM commands\n", src.Name, len(src.Commands), len(sources[i].Commands))
+		}
+	}
+
+	if err := checkStatics(sources, opts); err != nil {
+		return err
+	}
 
-		files, err := os.ReadDir(path)
+	// the backends leave the output to be closed here, and stdout open
+	outputFile := os.Stdout
+	if outputFilename != "" {
+		f, err := os.Create(outputFilename)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%s is a directory\n", path)
+		defer f.Close()
+		outputFile = f
+	}
+	var b backend.Backend
+	var cw *codewriter.CodeWriter
+	if opts.target == "go" {
+		b = gowriter.NewGoWriter(outputFile)
+	} else {
+		cw = newCodeWriter(outputFile, opts)
+		if isDir {
+			if err := cw.WriteInit(); err != nil {
+				return err
+			}
+		}
+		b = cw
+	}
 
-		for _, file := range files {
-			fname := file.Name()
-			if filepath.Ext(fname) == ".vm" {
-				fmt.Printf("Found vm file %s. Translating...\n", fname)
-				cw.SetFileName(fname)
+	var hits int
+	if opts.cache != nil {
+		hits, _ = opts.cache.Stats()
+	}
+	if err := backend.Translate(b, sources); err != nil {
+		return err
+	}
+	if opts.cache != nil {
+		reused, _ := opts.cache.Stats()
+		fmt.Fprintf(messages, "Reused %d of %d files from the cache\n", reused-hits, len(sources))
+	}
 
-				f, err := os.Open(path + fname)
-				if err != nil {
-					return err
-				}
-				cw.WriteInit()
+	if err := b.Close(); err != nil {
+		return err
+	}
+	if outputFile != os.Stdout {
+		if err := outputFile.Close(); err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		printStats(cw)
+		if err := writeSourceMap(cw, outputFilename, opts); err != nil {
+			return err
+		}
+	}
+	if outputFilename != "" {
+		fmt.Fprintf(messages, "Created output file: %s\n", outputFilename)
+	}
+	if opts.profile != "" {
+		if err := runProfile(cw, outputFilename, program(sources), opts); err != nil {
+			return err
+		}
+	}
+	if opts.trace != "" {
+		if err := runTrace(outputFilename, opts); err != nil {
+			return err
+		}
+	}
+	if opts.cover != "" {
+		srcDir := filepath.Dir(path)
+		if isDir {
+			srcDir = path
+		}
+		return runCoverage(cw, outputFilename, srcDir, opts)
+	}
+	return nil
+}
 
-				p := parser.NewParser(f)
-				for p.HasMoreCommands() {
-					err := p.Advance()
-					if err != nil {
-						fmt.Println(err)
-					}
+// programVersion is part of the key of every cached program. Change it with
+// the hack assembler, so programs assembled by older builds aren't reused.
+const programVersion = 1
 
-					ct := p.CommandType()
-					switch ct {
-					case parser.C_ARITHMETIC:
-						cw.WriteArithmetic(p.Arg1())
+// assembleFile assembles the Hack assembly file asmFilename. With a cache, a
+// file that has been assembled before is reused rather than assembled again.
+func assembleFile(asmFilename string, cache *buildcache.Cache) (*hack.Program, error) {
+	asm, err := os.ReadFile(asmFilename)
+	if err != nil {
+		return nil, err
+	}
+	h := buildcache.NewHasher()
+	h.Add("program", programVersion)
+	h.Write(asm)
+	key := h.Key()
+	prog := &hack.Program{}
+	if cache != nil && cache.Get(key, prog) {
+		return prog, nil
+	}
 
-					case parser.C_PUSH, parser.C_POP:
-						cw.WritePushPop(p.CommandType(), p.Arg1(), p.Arg2())
+	if prog, err = hack.Assemble(bytes.NewReader(asm)); err != nil {
+		return nil, fmt.Errorf("%s: %w", asmFilename, err)
+	}
+	if cache != nil {
+		if err := cache.Put(key, prog); err != nil {
+			return nil, fmt.Errorf("caching the assembled program: %w", err)
+		}
+	}
+	return prog, nil
+}
 
-					case parser.C_IF:
-						cw.WriteIf(p.Arg1())
+// runProfile runs the program in the assembly file asmFilename, prints where
+// it spent its instructions and writes the pprof profile to opts.profile.
+func runProfile(cw *codewriter.CodeWriter, asmFilename string, cmds []parser.Command, opts options) error {
+	prog, err := assembleFile(asmFilename, opts.cache)
+	if err != nil {
+		return err
+	}
 
-					case parser.C_LABEL:
-						cw.WriteLabel(p.Arg1())
+	p := profile.New(prog, cw.SourceMap(), cmds)
+	cpu := hack.NewCPU(prog.ROM)
+	p.Run(cpu, opts.profileCycles)
+	if !cpu.Halted() {
+		fmt.Fprintf(messages, "Stopped profiling after %d instructions\n", opts.profileCycles)
+	}
+	if err := p.WriteReport(messages); err != nil {
+		return err
+	}
 
-					case parser.C_GOTO:
-						cw.WriteGoto(p.Arg1())
+	f, err := os.Create(opts.profile)
+	if err != nil {
+		return err
+	}
+	if err := p.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(messages, "Created profile: %s\n", opts.profile)
+	return f.Close()
+}
 
-					case parser.C_FUNCTION:
-						cw.WriteFunction(p.Arg1(), p.Arg2())
+// runTrace runs the program in the assembly file asmFilename and writes the
+// instructions it executes that opts.tracePC selects to opts.trace.
+func runTrace(asmFilename string, opts options) error {
+	format, err := trace.ParseFormat(opts.traceFormat)
+	if err != nil {
+		return err
+	}
+	prog, err := assembleFile(asmFilename, opts.cache)
+	if err != nil {
+		return err
+	}
+	filter, err := trace.ParseFilter(opts.tracePC, prog)
+	if err != nil {
+		return fmt.Errorf("-trace-pc: %w", err)
+	}
 
-					case parser.C_CALL:
-						cw.WriteCall(p.Arg1(), p.Arg2())
+	f, err := os.Create(opts.trace)
+	if err != nil {
+		return err
+	}
+	tw := trace.NewWriter(f, format, filter)
+	cpu := hack.NewCPU(prog.ROM)
+	cpu.Trace = tw.Trace
+	cpu.Run(opts.traceCycles)
+	if !cpu.Halted() {
+		fmt.Fprintf(messages, "Stopped tracing after %d instructions\n", opts.traceCycles)
+	}
+	if err := tw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(messages, "Created trace: %s\n", opts.trace)
+	return f.Close()
+}
 
-					case parser.C_RETURN:
-						cw.WriteReturn()
-					}
-				}
+// runCoverage runs the program in the assembly file asmFilename, translated
+// from the .vm files in srcDir, prints the share of every file that ran and
+// writes the coverage to opts.cover in the format its extension asks for.
+func runCoverage(cw *codewriter.CodeWriter, asmFilename, srcDir string, opts options) error {
+	prog, err := assembleFile(asmFilename, opts.cache)
+	if err != nil {
+		return err
+	}
+	cpu := hack.NewCPU(prog.ROM)
+	cpu.Coverage = make([]uint64, len(prog.ROM))
+	cpu.Run(opts.coverCycles)
+	if !cpu.Halted() {
+		fmt.Fprintf(messages, "Stopped collecting coverage after %d instructions\n", opts.coverCycles)
+	} else {
+		// Run stops when PC reaches the loop programs end with, (END) @END
+		// 0;JMP, before running it. The program did get there, so run the
+		// loop's two instructions once to have them covered too. Past the end
+		// of the program, Step does nothing.
+		cpu.Step()
+		cpu.Step()
+	}
+	asmName := filepath.Base(asmFilename)
+	c := coverage.New(asmName, prog, cw.SourceMap(), cpu.Coverage)
+	if err := c.WriteSummary(messages); err != nil {
+		return err
+	}
 
-				if err := f.Close(); err != nil {
-					return err
-				}
+	f, err := os.Create(opts.cover)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(opts.cover) {
+	case ".html":
+		err = c.WriteHTML(f, func(name string) ([]byte, error) {
+			if name == asmName {
+				return os.ReadFile(asmFilename)
 			}
-		}
-
-		if err := cw.Close(); err != nil {
-			return err
-		}
-
-		fmt.Printf("Created output file: %s\n", outputFilename)
+			return os.ReadFile(filepath.Join(srcDir, name))
+		})
+	case ".txt":
+		err = c.WriteText(f)
+	default:
+		err = c.WriteProfile(f)
 	}
-
-	return nil
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(messages, "Created coverage: %s\n", opts.cover)
+	return f.Close()
 }
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"VMtranslator/buildcache"
+	"VMtranslator/codewriter"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -45,7 +50,7 @@ func TestStackArithmetic(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			testScript := strings.Split(test.input, ".")[0] + ".tst"
-			err := translate(test.input)
+			err := translate(test.input, options{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -82,7 +87,7 @@ func TestMemoryAccess(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			testScript := strings.Split(test.input, ".")[0] + ".tst"
-			err := translate(test.input)
+			err := translate(test.input, options{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -117,7 +122,7 @@ func TestProgramFlow(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			testScript := strings.Split(test.input, ".")[0] + ".tst"
-			err := translate(test.input)
+			err := translate(test.input, options{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -162,7 +167,7 @@ func TestFunctionCalls(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err := translate(test.input)
+			err := translate(test.input, options{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -180,3 +185,255 @@ func TestFunctionCalls(t *testing.T) {
 		})
 	}
 }
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	sys := "function Sys.init 0\ncall Main.main 0\nlabel END\ngoto END\n"
+	if err := os.WriteFile(dir+"Sys.vm", []byte(sys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"Main.vm", []byte("function Main.main 0\npush constant 1\nreturn\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := buildcache.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watch(dir, options{cache: cache, jobs: 2}, 10*time.Millisecond, stop)
+	}()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// waitFor waits until the output file contains text
+	output := dir + filepath.Base(dir) + ".asm"
+	waitFor := func(text string) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			asm, _ := os.ReadFile(output)
+			if strings.Contains(string(asm), text) {
+				return
+			}
+		}
+		t.Fatalf("expected the output to contain %q", text)
+	}
+	waitFor("push constant 1")
+
+	if err := os.WriteFile(dir+"Main.vm", []byte("function Main.main 0\npush constant 2\nreturn\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("push constant 2")
+	// Sys.vm is the same file it was in the first build
+	if hits, _ := cache.Stats(); hits == 0 {
+		t.Error("expected the rebuild to reuse Sys.vm from the cache")
+	}
+}
+
+// printProgram prints "Hi" with a String class just big enough for the hooks
+// of the go target. Sys.unused and String.dispose are never called.
+var printProgram = map[string]string{
+	"Sys.vm": `function Sys.init 0
+push constant 2
+call String.new 1
+push constant 72
+call String.appendChar 2
+push constant 105
+call String.appendChar 2
+call Output.printString 1
+pop temp 0
+call Sys.halt 0
+pop temp 0
+label END
+goto END
+function Sys.unused 0
+push constant 0
+return
+`,
+	// a string is its length followed by its characters, allocated from 2048 up
+	"String.vm": `function String.new 0
+push static 0
+push constant 2048
+add
+pop pointer 0
+push constant 0
+pop this 0
+push static 0
+push argument 0
+add
+push constant 1
+add
+pop static 0
+push pointer 0
+return
+function String.appendChar 0
+push argument 0
+pop pointer 0
+push this 0
+push constant 1
+add
+pop this 0
+push argument 0
+push this 0
+add
+pop pointer 1
+push argument 1
+pop that 0
+push argument 0
+return
+function String.length 0
+push argument 0
+pop pointer 1
+push that 0
+return
+function String.charAt 0
+push argument 0
+push argument 1
+add
+push constant 1
+add
+pop pointer 1
+push that 0
+return
+function String.dispose 0
+push constant 0
+return
+`,
+}
+
+func TestDeadFunctionsGoTarget(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	for name, src := range printProgram {
+		if err := os.WriteFile(dir+name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var log strings.Builder
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = &log
+	if err := translate(dir, options{target: "go", dce: true}); err != nil {
+		t.Fatal(err)
+	}
+	// the hook of Output.printString calls String.length and String.charAt
+	if !strings.Contains(log.String(), "Removed 2 unreachable functions: String.dispose, Sys.unused\n") {
+		t.Errorf("expected only String.dispose and Sys.unused to be removed got\n%s", log.String())
+	}
+
+	exe := filepath.Join(t.TempDir(), "program")
+	build := exec.Command("go", "build", "-o", exe, dir+filepath.Base(dir)+".go")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("could not build the generated program: %v\n%s", err, output)
+	}
+	output, err := exec.Command(exe).CombinedOutput()
+	if err != nil || string(output) != "Hi" {
+		t.Errorf("expected the program to print Hi got %q (%v)", output, err)
+	}
+}
+
+func TestStackLimit(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	if err := os.WriteFile(dir+"Main.vm", []byte("push constant 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = io.Discard
+	for _, test := range []struct {
+		limit int
+		ok    bool
+	}{{256, false}, {257, true}, {codewriter.MaxStackLimit, true}, {codewriter.MaxStackLimit + 1, false}, {16384, false}} {
+		err := translate(dir+"Main.vm", options{checked: true, stackLimit: test.limit})
+		if (err == nil) != test.ok {
+			t.Errorf("-stack-limit %d: expected ok %t got %v", test.limit, test.ok, err)
+		}
+	}
+}
+
+func TestLabelsCantClashWithGeneratedOnes(t *testing.T) {
+	// Sys.init's return address is Sys.init$ret$1, the label ret$1 would be too
+	dir := t.TempDir() + string(filepath.Separator)
+	program := "function Sys.init 0\ncall Sys.init 0\nlabel ret$1\ngoto ret$1\n"
+	if err := os.WriteFile(dir+"Sys.vm", []byte(program), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = io.Discard
+	err := translate(dir+"Sys.vm", options{})
+	if err == nil || !strings.Contains(err.Error(), `(Line: 3, Col: 7) - "ret$1" can't contain $`) {
+		t.Errorf("expected label ret$1 to be rejected got %v", err)
+	}
+}
+
+func TestCacheAssembledProgram(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	if err := os.WriteFile(dir+"Main.vm", []byte("push constant 1\npush constant 2\nadd\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = io.Discard
+	cache, err := buildcache.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := options{cache: cache, trace: dir + "trace.txt", traceFormat: "text", traceCycles: 100}
+
+	traces := []string{}
+	for run := 0; run < 2; run++ {
+		before, _ := cache.Stats()
+		if err := translate(dir+"Main.vm", opts); err != nil {
+			t.Fatal(err)
+		}
+		// the second run reuses the file's assembly and the assembled program
+		if hits, _ := cache.Stats(); hits-before != 2*run {
+			t.Errorf("run %d: expected %d hits got %d", run, 2*run, hits-before)
+		}
+		trace, err := os.ReadFile(opts.trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		traces = append(traces, string(trace))
+	}
+	if traces[0] == "" || traces[0] != traces[1] {
+		t.Errorf("expected the cached program to trace the same got\n%s\nand\n%s", traces[0], traces[1])
+	}
+
+	if err := translate(dir+"Main.vm", options{target: "go", cache: cache}); err == nil {
+		t.Error("expected -cache to be rejected for the go target")
+	}
+}
+
+func TestStdoutStaysOpen(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	in, err := os.Create(dir + "Main.vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.WriteString("function Main.main 0\npush constant 1\nreturn\n"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(dir + "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin, os.Stdout = in, out
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = io.Discard
+
+	for _, target := range []string{"hack", "go"} {
+		in.Seek(0, 0)
+		if err := translate(stdio, options{target: target}); err != nil {
+			t.Fatalf("-target %s: %v", target, err)
+		}
+		if _, err := out.WriteString("\n"); err != nil {
+			t.Errorf("-target %s closed stdout: %v", target, err)
+		}
+	}
+}
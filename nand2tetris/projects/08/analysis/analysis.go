@@ -0,0 +1,70 @@
+// Package analysis checks whole VM programs for mistakes that would otherwise
+// only show up as a corrupted machine at run time.
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+	"sort"
+)
+
+// Problem is a mistake found in a VM program.
+type Problem struct {
+	File string // base name of the .vm file
+	Line int
+	Msg  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+}
+
+func problemAt(cmd parser.Command, format string, args ...interface{}) Problem {
+	return Problem{File: cmd.File, Line: cmd.Pos.Line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// sortProblems orders problems by file and line.
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		return problems[i].Line < problems[j].Line
+	})
+}
+
+// function is the body of a VM function, or the code of a file outside any function.
+type function struct {
+	name string // empty for code outside any function
+	decl parser.Command
+	body []parser.Command
+}
+
+// splitFunctions groups cmds into functions. Code before the first function of
+// a file is returned as a function without a name.
+func splitFunctions(cmds []parser.Command) []*function {
+	functions := []*function{}
+	var curr *function
+	for i, cmd := range cmds {
+		switch {
+		case cmd.Type == parser.C_FUNCTION:
+			curr = &function{name: cmd.Arg1, decl: cmd}
+			functions = append(functions, curr)
+		case curr == nil || (i > 0 && cmd.File != cmds[i-1].File):
+			curr = &function{decl: cmd}
+			functions = append(functions, curr)
+			curr.body = append(curr.body, cmd)
+		default:
+			curr.body = append(curr.body, cmd)
+		}
+	}
+	return functions
+}
+
+// describe names f for problem messages.
+func (f *function) describe() string {
+	if f.name == "" {
+		return "code outside functions"
+	}
+	return f.name
+}
@@ -0,0 +1,157 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// CallGraph records which functions of a program call which.
+type CallGraph struct {
+	// Root is where the program starts: Sys.init, or Main.main for programs
+	// without a bootstrap. It's empty if the program defines neither.
+	Root      string
+	Functions []*Node // defined functions in the order they are defined
+	nodes     map[string]*Node
+	topCalls  []string // functions called by code outside any function
+}
+
+// Node is a function of a CallGraph.
+type Node struct {
+	Name      string   `json:"name"`
+	File      string   `json:"file"`
+	Calls     []string `json:"calls"` // callees in order of their first call
+	Reachable bool     `json:"reachable"`
+}
+
+// BuildCallGraph builds the call graph of the program made of cmds and marks the
+// functions that can be reached from its root or from code outside functions.
+func BuildCallGraph(cmds []parser.Command) *CallGraph {
+	g := &CallGraph{nodes: map[string]*Node{}}
+	for _, f := range splitFunctions(cmds) {
+		calls := []string{}
+		seen := map[string]bool{}
+		for _, cmd := range f.body {
+			if cmd.Type == parser.C_CALL && !seen[cmd.Arg1] {
+				seen[cmd.Arg1] = true
+				calls = append(calls, cmd.Arg1)
+			}
+		}
+
+		if f.name == "" {
+			g.topCalls = append(g.topCalls, calls...)
+			continue
+		}
+		if _, ok := g.nodes[f.name]; ok {
+			continue // duplicates are reported by CheckLinks
+		}
+		n := &Node{Name: f.name, File: f.decl.File, Calls: calls}
+		g.nodes[f.name] = n
+		g.Functions = append(g.Functions, n)
+	}
+
+	for _, root := range []string{"Sys.init", "Main.main"} {
+		if _, ok := g.nodes[root]; ok {
+			g.Root = root
+			break
+		}
+	}
+	roots := append([]string{}, g.topCalls...)
+	if g.Root != "" {
+		roots = append(roots, g.Root)
+	}
+	g.mark(roots)
+	return g
+}
+
+// AddRoots marks the functions reachable from names as reachable too, for
+// functions that are called from outside the VM code.
+func (g *CallGraph) AddRoots(names ...string) {
+	g.mark(names)
+}
+
+// mark sets Reachable on every function reachable from names.
+func (g *CallGraph) mark(names []string) {
+	for len(names) != 0 {
+		name := names[len(names)-1]
+		names = names[:len(names)-1]
+		n, ok := g.nodes[name]
+		if !ok || n.Reachable {
+			continue
+		}
+		n.Reachable = true
+		names = append(names, n.Calls...)
+	}
+}
+
+// Unreachable returns the names of the functions that can never be called. It
+// returns none if the program has no root, since then any function may be
+// where execution starts.
+func (g *CallGraph) Unreachable() []string {
+	names := []string{}
+	if g.Root == "" {
+		return names
+	}
+	for _, n := range g.Functions {
+		if !n.Reachable {
+			names = append(names, n.Name)
+		}
+	}
+	return names
+}
+
+// EliminateDeadFunctions returns cmds without the functions g can't reach.
+func EliminateDeadFunctions(cmds []parser.Command, g *CallGraph) []parser.Command {
+	dead := map[string]bool{}
+	for _, name := range g.Unreachable() {
+		dead[name] = true
+	}
+	if len(dead) == 0 {
+		return cmds
+	}
+
+	output := []parser.Command{}
+	for _, f := range splitFunctions(cmds) {
+		if dead[f.name] {
+			continue
+		}
+		if f.name != "" {
+			output = append(output, f.decl)
+		}
+		output = append(output, f.body...)
+	}
+	return output
+}
+
+// WriteDOT writes g in Graphviz format. Unreachable functions are drawn dashed.
+func (g *CallGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph calls {")
+	if g.Root != "" {
+		fmt.Fprintf(bw, "\t%q [shape=doublecircle];\n", g.Root)
+	}
+	for _, n := range g.Functions {
+		if !n.Reachable && g.Root != "" {
+			fmt.Fprintf(bw, "\t%q [style=dashed];\n", n.Name)
+		}
+	}
+	for _, n := range g.Functions {
+		for _, callee := range n.Calls {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", n.Name, callee)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteJSON writes g as a JSON object with the root and every function.
+func (g *CallGraph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Root      string  `json:"root"`
+		Functions []*Node `json:"functions"`
+	}{g.Root, g.Functions})
+}
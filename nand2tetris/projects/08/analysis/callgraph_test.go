@@ -0,0 +1,139 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const deadCodeProgram = `function Sys.init 0
+call Main.main 0
+label HALT
+goto HALT
+function Main.main 0
+call Math.abs 0
+call Main.main 0
+return
+function Main.unused 0
+call Math.max 0
+return
+function Math.abs 0
+push constant 1
+return
+function Math.max 0
+push constant 2
+return
+`
+
+func TestBuildCallGraph(t *testing.T) {
+	t.Parallel()
+	g := BuildCallGraph(parse(t, "Main.vm", deadCodeProgram))
+	if g.Root != "Sys.init" {
+		t.Errorf("expected root Sys.init got %q", g.Root)
+	}
+	if unreachable := strings.Join(g.Unreachable(), " "); unreachable != "Main.unused Math.max" {
+		t.Errorf("expected Main.unused and Math.max to be unreachable got %q", unreachable)
+	}
+	if calls := strings.Join(g.Functions[1].Calls, " "); calls != "Math.abs Main.main" {
+		t.Errorf("expected Main.main to call Math.abs and itself got %q", calls)
+	}
+}
+
+func TestBuildCallGraphRoots(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		src         string
+		root        string
+		unreachable string
+	}{
+		{"Main.main without bootstrap", "function Main.main 0\nreturn\nfunction Main.f 0\nreturn\n", "Main.main", "Main.f"},
+		{"no root keeps everything", "function Main.f 0\nreturn\nfunction Main.g 0\nreturn\n", "", ""},
+		{"code outside functions calls", "call Main.g 0\nfunction Main.main 0\nreturn\nfunction Main.g 0\nreturn\nfunction Main.h 0\nreturn\n", "Main.main", "Main.h"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			g := BuildCallGraph(parse(t, "Main.vm", test.src))
+			if g.Root != test.root {
+				t.Errorf("expected root %q got %q", test.root, g.Root)
+			}
+			if unreachable := strings.Join(g.Unreachable(), " "); unreachable != test.unreachable {
+				t.Errorf("expected %q to be unreachable got %q", test.unreachable, unreachable)
+			}
+		})
+	}
+}
+
+func TestAddRoots(t *testing.T) {
+	t.Parallel()
+	g := BuildCallGraph(parse(t, "Main.vm", deadCodeProgram))
+	g.AddRoots("Main.unused", "Math.missing")
+	if unreachable := g.Unreachable(); len(unreachable) != 0 {
+		t.Errorf("expected everything reachable from Main.unused to be kept got %v", unreachable)
+	}
+}
+
+func TestEliminateDeadFunctions(t *testing.T) {
+	t.Parallel()
+	cmds := parse(t, "Main.vm", deadCodeProgram)
+	output := EliminateDeadFunctions(cmds, BuildCallGraph(cmds))
+
+	functions := []string{}
+	for _, cmd := range output {
+		if cmd.Type == parser.C_FUNCTION {
+			functions = append(functions, cmd.Arg1)
+		}
+	}
+	if strings.Join(functions, " ") != "Sys.init Main.main Math.abs" {
+		t.Errorf("expected only the reachable functions to be left got %v", functions)
+	}
+	if len(output) != len(cmds)-6 {
+		t.Errorf("expected the 6 commands of the unreachable functions to be removed got %d of %d commands", len(output), len(cmds))
+	}
+	if problems := CheckLinks(output); len(problems) != 0 {
+		t.Errorf("expected the remaining program to link got %v", problemStrings(problems))
+	}
+}
+
+func TestWriteCallGraph(t *testing.T) {
+	t.Parallel()
+	g := BuildCallGraph(parse(t, "Main.vm", deadCodeProgram))
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`"Sys.init" [shape=doublecircle];`,
+		`"Main.unused" [style=dashed];`,
+		`"Main.main" -> "Math.abs";`,
+		`"Main.main" -> "Main.main";`,
+	} {
+		if !strings.Contains(dot.String(), line) {
+			t.Errorf("expected DOT output to contain %s got\n%s", line, dot.String())
+		}
+	}
+
+	var output bytes.Buffer
+	if err := g.WriteJSON(&output); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Root      string
+		Functions []Node
+	}
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Root != "Sys.init" || len(decoded.Functions) != 5 {
+		t.Fatalf("unexpected JSON output\n%s", output.String())
+	}
+	if f := decoded.Functions[2]; f.Name != "Main.unused" || f.Reachable || f.File != "Main.vm" || f.Calls[0] != "Math.max" {
+		t.Errorf("unexpected function %+v", f)
+	}
+}
@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+)
+
+// CheckLinks looks at cmds as one program and reports calls to functions that
+// are never defined, functions defined more than once, calls that pass a
+// different number of arguments than the first call to the same function, and
+// jumps to labels that aren't defined in the function they jump from.
+func CheckLinks(cmds []parser.Command) []Problem {
+	problems := []Problem{}
+	functions := splitFunctions(cmds)
+
+	defined := map[string]parser.Command{}
+	for _, f := range functions {
+		if f.name == "" {
+			continue
+		}
+		if first, ok := defined[f.name]; ok {
+			problems = append(problems, problemAt(f.decl, "function %s is already defined at %s", f.name, position(first)))
+			continue
+		}
+		defined[f.name] = f.decl
+	}
+
+	firstCalls := map[string]parser.Command{}
+	for _, f := range functions {
+		labels := map[string]bool{}
+		for _, cmd := range f.body {
+			if cmd.Type == parser.C_LABEL {
+				labels[cmd.Arg1] = true
+			}
+		}
+
+		for _, cmd := range f.body {
+			switch cmd.Type {
+			case parser.C_GOTO, parser.C_IF:
+				if !labels[cmd.Arg1] {
+					problems = append(problems, problemAt(cmd, "%q jumps to %s which is not a label in %s", cmd.String(), cmd.Arg1, f.describe()))
+				}
+			case parser.C_CALL:
+				if _, ok := defined[cmd.Arg1]; !ok {
+					problems = append(problems, problemAt(cmd, "call to undefined function %s", cmd.Arg1))
+				}
+				first, ok := firstCalls[cmd.Arg1]
+				if !ok {
+					firstCalls[cmd.Arg1] = cmd
+				} else if first.Arg2 != cmd.Arg2 {
+					problems = append(problems, problemAt(cmd, "%s called with %d arguments but with %d at %s", cmd.Arg1, cmd.Arg2, first.Arg2, position(first)))
+				}
+			}
+		}
+	}
+
+	sortProblems(problems)
+	return problems
+}
+
+func position(cmd parser.Command) string {
+	return fmt.Sprintf("%s:%d", cmd.File, cmd.Pos.Line)
+}
@@ -0,0 +1,88 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCheckLinksBookPrograms(t *testing.T) {
+	t.Parallel()
+	for name, paths := range bookPrograms {
+		if problems := CheckLinks(parseFiles(t, paths...)); len(problems) != 0 {
+			t.Errorf("%s: expected no problems got %v", name, problemStrings(problems))
+		}
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected []string
+	}{
+		{"calls across files",
+			map[string]string{
+				"Main.vm": "function Main.main 0\npush constant 1\ncall Util.id 1\nreturn\n",
+				"Util.vm": "function Util.id 0\npush argument 0\nreturn\n",
+			},
+			[]string{},
+		},
+		{"undefined function",
+			map[string]string{
+				"Main.vm": "function Main.main 0\ncall Main.missing 0\nreturn\n",
+			},
+			[]string{"Main.vm:2: call to undefined function Main.missing"},
+		},
+		{"duplicate definition",
+			map[string]string{
+				"Main.vm": "function Main.f 0\npush constant 0\nreturn\n",
+				"Util.vm": "function Main.f 0\npush constant 1\nreturn\n",
+			},
+			[]string{"Util.vm:1: function Main.f is already defined at Main.vm:1"},
+		},
+		{"arity mismatch",
+			map[string]string{
+				"Main.vm": "function Main.main 0\npush constant 1\ncall Util.id 1\npush constant 1\npush constant 2\ncall Util.id 2\nreturn\n",
+				"Util.vm": "function Util.id 0\npush argument 0\nreturn\n",
+			},
+			[]string{"Main.vm:6: Util.id called with 2 arguments but with 1 at Main.vm:3"},
+		},
+		{"jump to a label of another function",
+			map[string]string{
+				"Main.vm": "function Main.f 0\nlabel LOOP\ngoto LOOP\nfunction Main.g 0\npush constant 0\nif-goto LOOP\nreturn\n",
+			},
+			[]string{`Main.vm:6: "if-goto LOOP" jumps to LOOP which is not a label in Main.g`},
+		},
+		{"labels of code outside functions are scoped to the file",
+			map[string]string{
+				"A.vm": "label END\ngoto END\n",
+				"B.vm": "goto END\n",
+			},
+			[]string{`B.vm:1: "goto END" jumps to END which is not a label in code outside functions`},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			// files are parsed in sorted order, like the files of a directory
+			names := []string{}
+			for name := range test.files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			cmds := parse(t, names[0], test.files[names[0]])
+			for _, name := range names[1:] {
+				cmds = append(cmds, parse(t, name, test.files[name])...)
+			}
+
+			actual := problemStrings(CheckLinks(cmds))
+			if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
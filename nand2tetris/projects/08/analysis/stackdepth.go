@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+)
+
+// stackEffect returns how many values cmd needs on the stack and how it changes
+// the depth of the stack.
+func stackEffect(cmd parser.Command) (int, int) {
+	switch cmd.Type {
+	case parser.C_ARITHMETIC:
+		if cmd.Arg1 == "neg" || cmd.Arg1 == "not" {
+			return 1, 0
+		}
+		return 2, -1
+	case parser.C_PUSH:
+		return 0, 1
+	case parser.C_POP, parser.C_IF:
+		return 1, -1
+	case parser.C_CALL:
+		return cmd.Arg2, 1 - cmd.Arg2
+	}
+	// return pops its value too, but any depth other than one is reported on its own
+	return 0, 0
+}
+
+// successors returns the indexes into body of the commands that can run after body[i].
+func successors(body []parser.Command, labels map[string]int, i int) []int {
+	next := []int{}
+	if i+1 < len(body) {
+		next = append(next, i+1)
+	}
+	switch body[i].Type {
+	case parser.C_RETURN:
+		return nil
+	case parser.C_GOTO:
+		next = next[:0]
+		fallthrough
+	case parser.C_IF:
+		if target, ok := labels[body[i].Arg1]; ok {
+			next = append(next, target)
+		}
+	}
+	return next
+}
+
+// VerifyStackDepth follows every path through each function of cmds and
+// reports commands that pop from an empty working stack, returns with a depth
+// other than one, and labels reached with different depths. The working stack
+// of a function starts empty. Jumps to labels that don't exist are ignored.
+func VerifyStackDepth(cmds []parser.Command) []Problem {
+	problems := []Problem{}
+	for _, f := range splitFunctions(cmds) {
+		problems = append(problems, f.verifyStackDepth()...)
+	}
+	sortProblems(problems)
+	return problems
+}
+
+func (f *function) verifyStackDepth() []Problem {
+	problems := []Problem{}
+	labels := map[string]int{}
+	for i, cmd := range f.body {
+		if cmd.Type == parser.C_LABEL {
+			labels[cmd.Arg1] = i
+		}
+	}
+
+	depths := make([]int, len(f.body))
+	reached := make([]bool, len(f.body))
+	reported := make([]bool, len(f.body))
+	if len(f.body) == 0 {
+		return problems
+	}
+
+	type visit struct{ i, depth int }
+	worklist := []visit{{0, 0}}
+	reached[0] = true
+	for len(worklist) != 0 {
+		v := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		cmd := f.body[v.i]
+
+		needs, effect := stackEffect(cmd)
+		depth := v.depth
+		if depth < needs {
+			problems = append(problems, problemAt(cmd, "stack underflow in %s: %q needs %d values but the stack holds %d", f.describe(), cmd.String(), needs, depth))
+			depth = needs
+		}
+		if cmd.Type == parser.C_RETURN && depth != 1 {
+			problems = append(problems, problemAt(cmd, "return from %s with stack depth %d, expected 1", f.describe(), depth))
+		}
+		depth += effect
+
+		for _, next := range successors(f.body, labels, v.i) {
+			switch {
+			case !reached[next]:
+				reached[next] = true
+				depths[next] = depth
+				worklist = append(worklist, visit{next, depth})
+			case depths[next] != depth && !reported[next]:
+				reported[next] = true
+				target := f.body[next]
+				problems = append(problems, problemAt(target, "label %s in %s reached with stack depths %d and %d", target.Arg1, f.describe(), depths[next], depth))
+			}
+		}
+	}
+	return problems
+}
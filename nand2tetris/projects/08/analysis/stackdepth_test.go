@@ -0,0 +1,131 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parse returns the commands of the .vm source src, read as if from file name.
+func parse(t *testing.T, name string, src string) []parser.Command {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return parseFiles(t, path)
+}
+
+func parseFiles(t *testing.T, paths ...string) []parser.Command {
+	cmds := []parser.Command{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileCmds, err := parser.NewParser(f).ParseAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, fileCmds...)
+	}
+	return cmds
+}
+
+func problemStrings(problems []Problem) []string {
+	output := []string{}
+	for _, p := range problems {
+		output = append(output, p.String())
+	}
+	return output
+}
+
+// bookPrograms are the .vm files of every program from the book.
+var bookPrograms = map[string][]string{
+	"SimpleAdd":        {"../StackArithmetic/SimpleAdd/SimpleAdd.vm"},
+	"StackTest":        {"../StackArithmetic/StackTest/StackTest.vm"},
+	"BasicTest":        {"../MemoryAccess/BasicTest/BasicTest.vm"},
+	"PointerTest":      {"../MemoryAccess/PointerTest/PointerTest.vm"},
+	"StaticTest":       {"../MemoryAccess/StaticTest/StaticTest.vm"},
+	"BasicLoop":        {"../ProgramFlow/BasicLoop/BasicLoop.vm"},
+	"FibonacciSeries":  {"../ProgramFlow/FibonacciSeries/FibonacciSeries.vm"},
+	"SimpleFunction":   {"../FunctionCalls/SimpleFunction/SimpleFunction.vm"},
+	"NestedCall":       {"../FunctionCalls/NestedCall/Sys.vm"},
+	"FibonacciElement": {"../FunctionCalls/FibonacciElement/Main.vm", "../FunctionCalls/FibonacciElement/Sys.vm"},
+	"StaticsTest":      {"../FunctionCalls/StaticsTest/Class1.vm", "../FunctionCalls/StaticsTest/Class2.vm", "../FunctionCalls/StaticsTest/Sys.vm"},
+}
+
+func TestVerifyStackDepthBookPrograms(t *testing.T) {
+	t.Parallel()
+	for name, paths := range bookPrograms {
+		if problems := VerifyStackDepth(parseFiles(t, paths...)); len(problems) != 0 {
+			t.Errorf("%s: expected no problems got %v", name, problemStrings(problems))
+		}
+	}
+}
+
+func TestVerifyStackDepth(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"balanced",
+			"function Main.f 0\npush constant 1\npush argument 0\nadd\nreturn\n",
+			[]string{},
+		},
+		{"underflow",
+			"function Main.f 0\npush constant 1\nadd\nreturn\n",
+			[]string{`Main.vm:3: stack underflow in Main.f: "add" needs 2 values but the stack holds 1`},
+		},
+		{"return with extra values",
+			"function Main.f 0\npush constant 1\npush constant 2\nreturn\n",
+			[]string{"Main.vm:4: return from Main.f with stack depth 2, expected 1"},
+		},
+		{"return with empty stack",
+			"function Main.f 0\nreturn\n",
+			[]string{"Main.vm:2: return from Main.f with stack depth 0, expected 1"},
+		},
+		{"call consumes its arguments",
+			"function Main.f 0\npush constant 1\npush constant 2\ncall Main.g 2\nreturn\n",
+			[]string{},
+		},
+		{"inconsistent loop",
+			"function Main.f 0\nlabel LOOP\npush constant 1\npush constant 1\nif-goto LOOP\npush constant 0\nreturn\n",
+			[]string{
+				"Main.vm:2: label LOOP in Main.f reached with stack depths 0 and 1",
+				"Main.vm:7: return from Main.f with stack depth 2, expected 1",
+			},
+		},
+		{"branches join with the same depth",
+			"function Main.f 0\npush argument 0\nif-goto ELSE\npush constant 1\ngoto END\nlabel ELSE\npush constant 2\nlabel END\nreturn\n",
+			[]string{},
+		},
+		{"branches join with different depths",
+			"function Main.f 0\npush argument 0\nif-goto ELSE\npush constant 1\npush constant 1\ngoto END\nlabel ELSE\npush constant 2\nlabel END\nreturn\n",
+			[]string{"Main.vm:9: label END in Main.f reached with stack depths 1 and 2"},
+		},
+		{"unreachable code is not checked",
+			"function Main.f 0\npush constant 0\nreturn\nadd\nreturn\n",
+			[]string{},
+		},
+		{"code outside functions",
+			"pop local 0\n",
+			[]string{`Main.vm:1: stack underflow in code outside functions: "pop local 0" needs 1 values but the stack holds 0`},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			actual := problemStrings(VerifyStackDepth(parse(t, "Main.vm", test.src)))
+			if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
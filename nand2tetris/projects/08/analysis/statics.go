@@ -0,0 +1,35 @@
+package analysis
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+	"strings"
+)
+
+// CheckStatics reports a program with more static variables than the capacity
+// words available for them. Statics are given addresses from 16 in the order
+// they first appear, so the ones past capacity would overwrite the stack at 256.
+// Every file has its own statics, so "static 0" of two files counts twice. The
+// problem is reported at the first static that doesn't fit.
+func CheckStatics(cmds []parser.Command, capacity int) []Problem {
+	seen := map[string]bool{}
+	var first *parser.Command
+	var firstName string
+	for i, cmd := range cmds {
+		if (cmd.Type != parser.C_PUSH && cmd.Type != parser.C_POP) || cmd.Arg1 != "static" {
+			continue
+		}
+		name := fmt.Sprintf("%s.%d", strings.TrimSuffix(cmd.File, ".vm"), cmd.Arg2)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if len(seen) > capacity && first == nil {
+			first, firstName = &cmds[i], name
+		}
+	}
+	if first == nil {
+		return []Problem{}
+	}
+	return []Problem{problemAt(*first, "static variable %s does not fit: the program has %d statics but only %d fit below the stack", firstName, len(seen), capacity)}
+}
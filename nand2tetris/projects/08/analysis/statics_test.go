@@ -0,0 +1,41 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckStatics(t *testing.T) {
+	t.Parallel()
+	// statics of different files are different variables, repeated ones are not
+	a := parse(t, "A.vm", "push static 0\npop static 1\npush static 0\n")
+	b := parse(t, "B.vm", "push static 0\npush static 1\npop static 2\n")
+	cmds := append(a, b...)
+
+	if problems := CheckStatics(cmds, 5); len(problems) != 0 {
+		t.Errorf("expected 5 statics to fit in 5 words got %v", problemStrings(problems))
+	}
+	actual := strings.Join(problemStrings(CheckStatics(cmds, 3)), "\n")
+	expected := "B.vm:2: static variable B.1 does not fit: the program has 5 statics but only 3 fit below the stack"
+	if actual != expected {
+		t.Errorf("expected %q got %q", expected, actual)
+	}
+}
+
+func TestCheckStaticsBookPrograms(t *testing.T) {
+	t.Parallel()
+	for name, paths := range bookPrograms {
+		if problems := CheckStatics(parseFiles(t, paths...), 238); len(problems) != 0 {
+			t.Errorf("%s: expected no problems got %v", name, problemStrings(problems))
+		}
+	}
+
+	var src strings.Builder
+	for i := 0; i < 241; i++ {
+		fmt.Fprintf(&src, "push static %d\n", i)
+	}
+	if problems := CheckStatics(parse(t, "Main.vm", src.String()), 240); len(problems) != 1 || problems[0].Line != 241 {
+		t.Errorf("expected static 240 on line 241 to overflow got %v", problemStrings(problems))
+	}
+}
@@ -0,0 +1,92 @@
+// Package backend connects parsed VM programs to whatever is produced from them.
+// A Backend consumes parser.Commands one at a time, so new targets can be added
+// without touching the parser or the driver loop.
+package backend
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+	"io"
+)
+
+// Backend translates VM commands into some output.
+type Backend interface {
+	// SetFileName is called before the commands of each .vm file are written.
+	SetFileName(fileName string)
+	// Write translates a single command.
+	Write(cmd parser.Command) error
+	// Close finishes the output once every file has been written. The writer
+	// the output goes to belongs to the caller, who closes it.
+	Close() error
+}
+
+// File is a parsed .vm file.
+type File struct {
+	Name     string // base name of the file
+	Commands []parser.Command
+}
+
+// FilesWriter is a Backend that translates the files of a program together,
+// e.g. at the same time, rather than one command at a time.
+type FilesWriter interface {
+	Backend
+	// WriteFiles translates files in order.
+	WriteFiles(files []File) error
+}
+
+// Translate writes files to b in order, naming each file before its commands,
+// or hands them all to b if it's a FilesWriter. It stops at the first command
+// b fails to write.
+func Translate(b Backend, files []File) error {
+	if fw, ok := b.(FilesWriter); ok {
+		return fw.WriteFiles(files)
+	}
+	for _, f := range files {
+		b.SetFileName(f.Name)
+		for _, cmd := range f.Commands {
+			if err := b.Write(cmd); err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Printer is a Backend that writes commands back out as normalized VM code:
+// one command per line, functions separated by a blank line and their bodies
+// indented.
+type Printer struct {
+	w      io.Writer
+	inFunc bool // a function command has been written in the current file
+}
+
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{w: w}
+}
+
+func (pr *Printer) SetFileName(fileName string) {
+	pr.inFunc = false
+}
+
+func (pr *Printer) Write(cmd parser.Command) error {
+	var err error
+	switch {
+	case cmd.Type == parser.C_FUNCTION:
+		if pr.inFunc {
+			if _, err = fmt.Fprintln(pr.w); err != nil {
+				return err
+			}
+		}
+		pr.inFunc = true
+		_, err = fmt.Fprintln(pr.w, cmd)
+	case cmd.Type == parser.C_LABEL || !pr.inFunc:
+		_, err = fmt.Fprintln(pr.w, cmd)
+	default:
+		_, err = fmt.Fprintf(pr.w, "    %s\n", cmd)
+	}
+	return err
+}
+
+func (pr *Printer) Close() error {
+	return nil
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"VMtranslator/lexer"
+	"VMtranslator/parser"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recorder is a Backend that keeps every command it is given.
+type recorder struct {
+	files    []string
+	commands []parser.Command
+	failOn   string // a command Write fails on, none if empty
+}
+
+func (r *recorder) SetFileName(fileName string) { r.files = append(r.files, fileName) }
+
+func (r *recorder) Write(cmd parser.Command) error {
+	if cmd.String() == r.failOn {
+		return errors.New("can't write " + r.failOn)
+	}
+	r.commands = append(r.commands, cmd)
+	return nil
+}
+
+func (r *recorder) Close() error { return nil }
+
+// filesRecorder is a FilesWriter that keeps the files it is given.
+type filesRecorder struct {
+	recorder
+	written []File
+}
+
+func (r *filesRecorder) WriteFiles(files []File) error {
+	r.written = files
+	return nil
+}
+
+// parseFile returns the File of the .vm file at path.
+func parseFile(t *testing.T, path string) File {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmds, err := parser.NewParser(f).ParseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return File{Name: filepath.Base(path), Commands: cmds}
+}
+
+func TestTranslate(t *testing.T) {
+	r := &recorder{}
+	if err := Translate(r, []File{parseFile(t, "../FunctionCalls/SimpleFunction/SimpleFunction.vm")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.files) != 1 || r.files[0] != "SimpleFunction.vm" {
+		t.Errorf("expected the file to be named SimpleFunction.vm got %v", r.files)
+	}
+	if len(r.commands) != 10 {
+		t.Fatalf("expected 10 commands got %d: %v", len(r.commands), r.commands)
+	}
+	first := r.commands[0]
+	expected := parser.Command{
+		Type: parser.C_FUNCTION, Arg1: "SimpleFunction.test", Arg2: 2,
+		File: "SimpleFunction.vm", Pos: lexer.FilePosition{Line: 7, Col: 1},
+	}
+	if first != expected {
+		t.Errorf("expected %+v got %+v", expected, first)
+	}
+	if last := r.commands[len(r.commands)-1]; last.Type != parser.C_RETURN || last.Pos.Line != 16 {
+		t.Errorf("expected return on line 16 got %+v", last)
+	}
+}
+
+func TestTranslateStopsAtWriteErrors(t *testing.T) {
+	files := []File{
+		{Name: "Main.vm", Commands: []parser.Command{
+			{Type: parser.C_PUSH, Arg1: "constant", Arg2: 1},
+			{Type: parser.C_PUSH, Arg1: "constant", Arg2: 2},
+			{Type: parser.C_ARITHMETIC, Arg1: "add", Arg2: -1},
+		}},
+		{Name: "Other.vm", Commands: []parser.Command{{Type: parser.C_RETURN}}},
+	}
+
+	r := &recorder{failOn: "push constant 2"}
+	err := Translate(r, files)
+	if err == nil || err.Error() != "Main.vm: can't write push constant 2" {
+		t.Errorf("expected the error of Main.vm got %v", err)
+	}
+	if len(r.commands) != 1 || len(r.files) != 1 {
+		t.Errorf("expected only the command before the error to be written got %v of %v", r.commands, r.files)
+	}
+}
+
+func TestTranslateFilesWriter(t *testing.T) {
+	files := []File{{Name: "Main.vm"}, {Name: "Other.vm"}}
+	r := &filesRecorder{}
+	if err := Translate(r, files); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.written) != 2 || len(r.files) != 0 {
+		t.Errorf("expected WriteFiles to get both files got %v, and SetFileName %v", r.written, r.files)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	var output strings.Builder
+	pr := NewPrinter(&output)
+	if err := Translate(pr, []File{parseFile(t, "../FunctionCalls/SimpleFunction/SimpleFunction.vm")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"function SimpleFunction.test 2",
+		"    push local 0",
+		"    push local 1",
+		"    add",
+		"    not",
+		"    push argument 0",
+		"    add",
+		"    push argument 1",
+		"    sub",
+		"    return",
+		"",
+	}, "\n")
+	if output.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, output.String())
+	}
+}
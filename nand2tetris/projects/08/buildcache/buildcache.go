@@ -0,0 +1,153 @@
+// Package buildcache keeps build products under the hash of everything they
+// were built from, so an unchanged input can be reused instead of rebuilt. The
+// products live in memory and, when the cache has a directory, on disk as one
+// gob file per key, so they outlast the process.
+package buildcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Key identifies a build product by the hash of its inputs.
+type Key [sha256.Size]byte
+
+func (k Key) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// Hasher accumulates the inputs of a build product into its Key.
+type Hasher struct {
+	h hash.Hash
+}
+
+// NewHasher returns a Hasher that has seen nothing yet.
+func NewHasher() *Hasher {
+	return &Hasher{h: sha256.New()}
+}
+
+// Add hashes the values in their %v format, each followed by a separator so
+// that ("ab", "c") and ("a", "bc") give different keys.
+func (h *Hasher) Add(values ...interface{}) {
+	for _, v := range values {
+		fmt.Fprintf(h.h, "%v\x00", v)
+	}
+}
+
+// Write hashes p as it is, e.g. the content of an input file.
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+// Key returns the key of everything added so far.
+func (h *Hasher) Key() Key {
+	var k Key
+	copy(k[:], h.h.Sum(nil))
+	return k
+}
+
+// Cache stores gob encoded build products by Key. It is safe for concurrent use.
+type Cache struct {
+	dir string // directory holding a file per key, or "" to keep products in memory only
+
+	mu     sync.Mutex
+	memory map[Key][]byte
+	hits   int
+	misses int
+}
+
+// Open returns a Cache that keeps its products in dir, creating the directory
+// if needed. A dir of "" returns a Cache that only lives as long as the process.
+func Open(dir string) (*Cache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{dir: dir, memory: map[Key][]byte{}}, nil
+}
+
+// Dir returns the directory of the cache, or "" if it is kept in memory only.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Get decodes the product stored under key into v, which must be a pointer, and
+// reports whether there was one. A product that can't be read or decoded, e.g.
+// because it was written by an older build, counts as missing.
+func (c *Cache) Get(key Key, v interface{}) bool {
+	c.mu.Lock()
+	data, ok := c.memory[key]
+	c.mu.Unlock()
+	if !ok && c.dir != "" {
+		var err error
+		data, err = os.ReadFile(c.path(key))
+		ok = err == nil
+	}
+	if ok && gob.NewDecoder(bytes.NewReader(data)).Decode(v) != nil {
+		ok = false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		c.misses += 1
+		return false
+	}
+	c.memory[key] = data
+	c.hits += 1
+	return true
+}
+
+// Put stores v under key, replacing any product stored before.
+func (c *Cache) Put(key Key, v interface{}) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(v); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.memory[key] = data.Bytes()
+	c.mu.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+
+	// write to a temporary file first, so that a build that is interrupted or
+	// runs at the same time never sees half a product
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Stats returns how many lookups found a product and how many didn't.
+func (c *Cache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *Cache) path(key Key) string {
+	return filepath.Join(c.dir, key.String())
+}
@@ -0,0 +1,99 @@
+package buildcache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type product struct {
+	Name  string
+	Lines []string
+}
+
+func key(values ...interface{}) Key {
+	h := NewHasher()
+	h.Add(values...)
+	return h.Key()
+}
+
+func TestHasher(t *testing.T) {
+	t.Parallel()
+	if key("ab", "c") == key("a", "bc") {
+		t.Error("expected the separation of values to change the key")
+	}
+	if key("a", 1) != key("a", 1) {
+		t.Error("expected the same values to give the same key")
+	}
+	if key("a", 1) == key("a", 2) {
+		t.Error("expected different values to give different keys")
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	for _, dir := range []string{"", t.TempDir()} {
+		c, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := product{Name: "Main.vm", Lines: []string{"@SP", "M=M+1"}}
+		var p product
+		if c.Get(key("Main.vm"), &p) {
+			t.Errorf("dir %q: expected a miss in an empty cache", dir)
+		}
+		if err := c.Put(key("Main.vm"), stored); err != nil {
+			t.Fatal(err)
+		}
+		if !c.Get(key("Main.vm"), &p) || !reflect.DeepEqual(p, stored) {
+			t.Errorf("dir %q: expected %v got %v", dir, stored, p)
+		}
+		if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+			t.Errorf("dir %q: expected 1 hit and 1 miss got %d and %d", dir, hits, misses)
+		}
+	}
+}
+
+func TestCacheOnDisk(t *testing.T) {
+	t.Parallel()
+	dir := filepath.Join(t.TempDir(), "cache")
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := product{Name: "Sys.vm", Lines: []string{"(Sys.init)"}}
+	if err := c.Put(key("Sys.vm"), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new process finds what the last one stored
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p product
+	if !reopened.Get(key("Sys.vm"), &p) || !reflect.DeepEqual(p, stored) {
+		t.Errorf("expected %v got %v", stored, p)
+	}
+
+	// a damaged product is rebuilt, not trusted
+	if err := os.WriteFile(filepath.Join(dir, key("Sys.vm").String()), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Get(key("Sys.vm"), &p) {
+		t.Error("expected a damaged product to be a miss")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected one file in the cache, temporary files included, got %d", len(files))
+	}
+}
@@ -0,0 +1,192 @@
+// Command hackrun runs a Hack assembly program on the built in emulator, and
+// saves and loads snapshots of the whole machine, so a run can go on from a
+// state that took millions of cycles to reach.
+//
+// Usage:
+//
+//	hackrun [flags] Prog.asm
+//
+// It prints the registers and the number of instructions run when the program
+// stops: when it halts, reaches -stop or has run -cycles instructions. The
+// flags are:
+//
+//	-load	a snapshot of Prog.asm to start from instead of a cleared machine
+//	-save	where to write a snapshot of the machine when it stops
+//	-cycles	the most instructions to run; 0 only loads and saves
+//	-stop	a label or ROM address to stop at before running it; a run that
+//		starts there stops the next time it gets there
+//	-key	the key code to hold down on the keyboard, e.g. 130 for left
+//	-print	a RAM address or range like 256-260 to print when it stops; may be
+//		repeated
+//
+// A snapshot holds the registers, the RAM, the keyboard, the cycle count and a
+// hash of the ROM, so it only loads into the program it was saved from.
+// hackrun exits with status 2 if the program or a snapshot can't be read or
+// written.
+package main
+
+import (
+	"VMtranslator/hack"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// ranges is the flag of RAM to print.
+type ranges [][2]int
+
+func (r *ranges) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *ranges) Set(s string) error {
+	from, to, err := parseRange(s)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, [2]int{from, to})
+	return nil
+}
+
+// parseRange parses an address or a range of them like 256-260.
+func parseRange(s string) (from, to int, err error) {
+	first, last := s, s
+	if i := strings.Index(s, "-"); i != -1 {
+		first, last = s[:i], s[i+1:]
+	}
+	if from, err = strconv.Atoi(first); err == nil {
+		to, err = strconv.Atoi(last)
+	}
+	if err != nil || from < 0 || to < from || to >= hack.RAMSize {
+		return 0, 0, fmt.Errorf("%q is not a RAM address or range", s)
+	}
+	return from, to, nil
+}
+
+// run runs hackrun with the command line arguments args and returns its exit
+// status.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("hackrun", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	load := flags.String("load", "", "snapshot to start from")
+	save := flags.String("save", "", "file to write a snapshot to when the program stops")
+	cycles := flags.Uint64("cycles", 1000000, "most instructions to run")
+	stop := flags.String("stop", "", "label or ROM address to stop at")
+	key := flags.Int("key", -1, "key code to hold down, instead of the keyboard of the snapshot")
+	var printed ranges
+	flags.Var(&printed, "print", "RAM address or range like 256-260 to print when the program stops")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+
+	asmPath := flags.Arg(0)
+	f, err := os.Open(asmPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	prog, err := hack.Assemble(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", asmPath, err)
+		return 2
+	}
+
+	stopAt := -1
+	if *stop != "" {
+		if stopAt, err = romAddress(*stop, prog); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	cpu := hack.NewCPU(prog.ROM)
+	if *load != "" {
+		if err := loadSnapshot(cpu, *load); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+	if *key >= 0 {
+		cpu.RAM[hack.KBD] = int16(*key)
+	}
+
+	start := cpu.Cycles
+	// a run that starts at -stop, like one loaded from a snapshot saved there,
+	// goes on to the next time it gets there
+	stopped := func() bool { return int(cpu.PC) == stopAt && cpu.Cycles != start }
+	for cpu.Cycles-start < *cycles && !cpu.Halted() && !stopped() {
+		cpu.Step()
+	}
+
+	state := "stopped"
+	switch {
+	case cpu.Halted():
+		state = "halted"
+	case stopped():
+		state = "stopped at " + *stop
+	}
+	fmt.Fprintf(stdout, "%s after %d instructions, %d in all\n", state, cpu.Cycles-start, cpu.Cycles)
+	fmt.Fprintf(stdout, "PC=%d A=%d D=%d KBD=%d\n", cpu.PC, cpu.A, cpu.D, cpu.RAM[hack.KBD])
+	for _, r := range printed {
+		for address := r[0]; address <= r[1]; address++ {
+			fmt.Fprintf(stdout, "RAM[%d] = %d\n", address, cpu.RAM[address])
+		}
+	}
+
+	if *save != "" {
+		if err := saveSnapshot(cpu, *save); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+	return 0
+}
+
+// romAddress returns the address of the label s of prog, or s as an address
+// of its ROM.
+func romAddress(s string, prog *hack.Program) (int, error) {
+	if address, ok := prog.Labels[s]; ok {
+		return address, nil
+	}
+	address, err := strconv.Atoi(s)
+	if err != nil || address < 0 || address >= len(prog.ROM) {
+		return 0, fmt.Errorf("%q is not a label or ROM address", s)
+	}
+	return address, nil
+}
+
+func loadSnapshot(cpu *hack.CPU, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s, err := hack.ReadSnapshot(f)
+	if err == nil {
+		err = cpu.Restore(s)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func saveSnapshot(cpu *hack.CPU, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = cpu.Snapshot().Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// program adds the key held down to R0 forever.
+const program = "(LOOP)\n@KBD\nD=M\n@R0\nM=D+M\n(NEXT)\n@LOOP\n0;JMP\n"
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	asmPath := filepath.Join(dir, "Keys.asm")
+	if err := os.WriteFile(asmPath, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(dir, "Other.asm")
+	if err := os.WriteFile(otherPath, []byte("@R0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(dir, "keys.snap")
+
+	tests := []struct {
+		name     string
+		args     []string
+		status   int
+		contains string
+	}{
+		{"save", []string{"-cycles", "12", "-key", "3", "-print", "0", "-save", snapshot, asmPath}, 0, "stopped after 12 instructions, 12 in all\nPC=0 A=0 D=3 KBD=3\nRAM[0] = 6\n"},
+		{"load", []string{"-load", snapshot, "-cycles", "6", "-print", "0-1", asmPath}, 0, "stopped after 6 instructions, 18 in all\nPC=0 A=0 D=3 KBD=3\nRAM[0] = 9\nRAM[1] = 0\n"},
+		{"key", []string{"-load", snapshot, "-key", "10", "-cycles", "6", "-print", "0", asmPath}, 0, "RAM[0] = 16\n"},
+		{"stop", []string{"-load", snapshot, "-stop", "NEXT", asmPath}, 0, "stopped at NEXT after 4 instructions, 16 in all\nPC=4"},
+		{"stop at address", []string{"-stop", "4", "-save", snapshot, asmPath}, 0, "stopped at 4 after 4 instructions"},
+		{"stop again", []string{"-load", snapshot, "-stop", "4", asmPath}, 0, "stopped at 4 after 6 instructions, 10 in all\n"},
+		{"halts", []string{otherPath}, 0, "halted after 1 instructions, 1 in all\n"},
+		{"other program", []string{"-load", snapshot, otherPath}, 2, ""},
+		{"not a snapshot", []string{"-load", asmPath, asmPath}, 2, ""},
+		{"missing program", []string{filepath.Join(dir, "none.asm")}, 2, ""},
+		{"bad label", []string{"-stop", "NOPE", asmPath}, 2, ""},
+		{"bad range", []string{"-print", "5-2", asmPath}, 2, ""},
+		{"no program", nil, 2, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if status := run(test.args, &stdout, &stderr); status != test.status {
+			t.Errorf("%s: expected status %d got %d: %s", test.name, test.status, status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), test.contains) {
+			t.Errorf("%s: expected the output to contain %q got\n%s", test.name, test.contains, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Command tracesum summarizes a binary trace written by VMtranslator -trace
+// with -trace-format binary.
+//
+// Usage:
+//
+//	tracesum [flags] [trace]
+//
+// Without a trace file, it reads stdin. It prints the instructions that ran
+// most often, a histogram of the writes to every region of RAM and the
+// addresses written most often. The flags are:
+//
+//	-top	the number of instructions and addresses to list
+//	-asm	the Hack assembly the trace was run from, to locate instructions by
+//		their labels
+//
+// tracesum exits with status 2 if the trace can't be read.
+package main
+
+import (
+	"VMtranslator/hack"
+	"VMtranslator/trace"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs tracesum with the command line arguments args and returns its exit
+// status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tracesum", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	top := flags.Int("top", 20, "number of instructions and RAM addresses to list")
+	asmPath := flags.String("asm", "", "Hack assembly the trace was run from, to name instructions after its labels")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return 2
+	}
+
+	var labels map[string]int
+	if *asmPath != "" {
+		f, err := os.Open(*asmPath)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		prog, err := hack.Assemble(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", *asmPath, err)
+			return 2
+		}
+		labels = prog.Labels
+	}
+
+	name, r := "<standard input>", stdin
+	if flags.NArg() == 1 {
+		name = flags.Arg(0)
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+	tr, err := trace.NewReader(r)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 2
+	}
+	summary, err := trace.Summarize(tr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 2
+	}
+	if err := summary.WriteReport(stdout, *top, labels); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	return 0
+}
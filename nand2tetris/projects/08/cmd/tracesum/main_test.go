@@ -0,0 +1,61 @@
+package main
+
+import (
+	"VMtranslator/hack"
+	"VMtranslator/trace"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = "(LOOP)\n@R0\nM=M+1\n@LOOP\n0;JMP\n"
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	asmPath := filepath.Join(dir, "Loop.asm")
+	if err := os.WriteFile(asmPath, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := hack.Assemble(strings.NewReader(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := trace.NewWriter(&buf, trace.Binary, nil)
+	cpu := hack.NewCPU(prog.ROM)
+	cpu.Trace = w.Trace
+	cpu.Run(40)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tracePath := filepath.Join(dir, "loop.trace")
+	if err := os.WriteFile(tracePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		status   int
+		contains string
+	}{
+		{"file", []string{"-top", "1", tracePath}, "", 0, "          10  25.0%      0  @0\n"},
+		{"labels", []string{"-asm", asmPath, tracePath}, "", 0, "          10  25.0%      1  M=M+1          LOOP+1\n"},
+		{"stdin", nil, buf.String(), 0, "          10 100.0%      0  pointers\n"},
+		{"text trace", nil, "      10     0  @0", 2, ""},
+		{"missing file", []string{filepath.Join(dir, "none.trace")}, "", 2, ""},
+		{"bad assembly", []string{"-asm", tracePath, tracePath}, "", 2, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if status := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr); status != test.status {
+			t.Errorf("%s: expected status %d got %d: %s", test.name, test.status, status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), test.contains) {
+			t.Errorf("%s: expected the output to contain %q got\n%s", test.name, test.contains, stdout.String())
+		}
+	}
+}
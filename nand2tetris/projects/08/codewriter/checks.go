@@ -0,0 +1,174 @@
+package codewriter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Checked code records why it stopped in the words just below the heap, which
+// the stack never reaches: the stack limit can't be past them.
+const (
+	ErrorCodeAddress  = 2032 // error code of the failed check, 0 while the program runs
+	ErrorNameAddress  = 2033 // name of the failing function, one character per word
+	ErrorNameLength   = 15   // characters of the name that are kept, followed by a 0 if shorter
+	MaxStackLimit     = ErrorCodeAddress
+	DefaultStackLimit = MaxStackLimit
+)
+
+// Error codes written to ErrorCodeAddress.
+const (
+	ErrStackOverflow = 1 // SP went past the stack limit
+	ErrFrame         = 2 // a function returned with fewer values on the stack than its locals and a return value
+	ErrIOWrite       = 3 // a pop to this or that wrote into the screen or keyboard memory map
+)
+
+// screenAddress and keyboardAddress start the memory map of the I/O devices.
+const (
+	screenAddress   = 16384
+	keyboardAddress = 24576
+)
+
+// EnableChecks makes the CodeWriter guard the generated code against the common
+// ways a VM program corrupts memory:
+//   - a push, call or function entry that takes SP past stackLimit
+//   - a return whose stack is smaller than the function's locals plus a return value
+//   - a pop to this or that that writes into the screen or keyboard. Functions of
+//     the Screen and Memory classes may write to the screen, since that's how the
+//     OS draws, but not to the keyboard.
+//
+// A failed check stores its error code at ErrorCodeAddress and the name of the
+// failing function from ErrorNameAddress, then halts in an endless loop.
+// stackLimit must be at most MaxStackLimit.
+func (cw *CodeWriter) EnableChecks(stackLimit int) {
+	cw.checked = true
+	cw.stackLimit = stackLimit
+}
+
+// checkRuntimeString records the error code in D for code outside any function,
+// which has no name to record, and holds the loop every failed check ends in.
+var checkRuntimeString = "\t" + strings.Join([]string{
+	"// runtime checks",
+	"@$CHECK.END",
+	"0;JMP",
+	"($CHECK.OVERFLOW)",
+	fmt.Sprintf("@%d", ErrStackOverflow),
+	"D=A",
+	"@$CHECK.HALT",
+	"0;JMP",
+	"($CHECK.FRAME)",
+	fmt.Sprintf("@%d", ErrFrame),
+	"D=A",
+	"@$CHECK.HALT",
+	"0;JMP",
+	"($CHECK.IO)",
+	fmt.Sprintf("@%d", ErrIOWrite),
+	"D=A",
+	"($CHECK.HALT)",
+	fmt.Sprintf("@%d", ErrorCodeAddress),
+	"M=D",
+	fmt.Sprintf("@%d", ErrorNameAddress),
+	"M=0",
+	"($CHECK.STOP)",
+	"@$CHECK.STOP",
+	"0;JMP",
+	"($CHECK.END)",
+}, "\n\t") + "\n"
+
+// checkLabel returns the label a failed check of the given kind jumps to in the
+// current function. kind is one of "overflow", "frame" and "io".
+func (cw *CodeWriter) checkLabel(kind string) string {
+	if cw.currFnName == "" {
+		return "$CHECK." + strings.ToUpper(kind)
+	}
+	return fmt.Sprintf("%s$check$%s", cw.currFnName, kind)
+}
+
+// checkStubString returns the entry points of the failed checks of a function,
+// which record the error code and the name of the function and halt. Control
+// never falls into them from the code before the function.
+func checkStubString(functionName string) string {
+	halt := functionName + "$check$halt"
+	lines := []string{
+		fmt.Sprintf("// checks of %s", functionName),
+		"@" + functionName,
+		"0;JMP",
+	}
+	for _, entry := range []struct {
+		kind string
+		code int
+	}{{"overflow", ErrStackOverflow}, {"frame", ErrFrame}, {"io", ErrIOWrite}} {
+		lines = append(lines,
+			fmt.Sprintf("(%s$check$%s)", functionName, entry.kind),
+			fmt.Sprintf("@%d", entry.code),
+			"D=A",
+			"@"+halt,
+			"0;JMP",
+		)
+	}
+	lines = append(lines,
+		fmt.Sprintf("(%s)", halt),
+		fmt.Sprintf("@%d", ErrorCodeAddress),
+		"M=D",
+	)
+
+	name := functionName
+	if len(name) > ErrorNameLength {
+		name = name[:ErrorNameLength]
+	}
+	for i, c := range name {
+		lines = append(lines, fmt.Sprintf("@%d", c), "D=A", fmt.Sprintf("@%d", ErrorNameAddress+i), "M=D")
+	}
+	if len(name) < ErrorNameLength {
+		lines = append(lines, fmt.Sprintf("@%d", ErrorNameAddress+len(name)), "M=0")
+	}
+	lines = append(lines, "@$CHECK.STOP", "0;JMP")
+	return "\t" + strings.Join(lines, "\n\t") + "\n"
+}
+
+// stackCheckString returns the assembly that fails if SP+grow is past the stack limit.
+func (cw *CodeWriter) stackCheckString(grow int) string {
+	return strings.Join([]string{
+		"// check stack limit",
+		"@SP",
+		"D=M",
+		fmt.Sprintf("@%d", cw.stackLimit-grow),
+		"D=D-A",
+		"@" + cw.checkLabel("overflow"),
+		"D;JGT",
+	}, "\n\t")
+}
+
+// frameCheckString returns the assembly that fails if the stack of the current
+// function holds less than its locals and a return value.
+func (cw *CodeWriter) frameCheckString() string {
+	return strings.Join([]string{
+		"// check frame",
+		"@SP",
+		"D=M",
+		"@LCL",
+		"D=D-M",
+		fmt.Sprintf("@%d", cw.currNumLocals),
+		"D=D-A",
+		"@" + cw.checkLabel("frame"),
+		"D;JLE",
+	}, "\n\t")
+}
+
+// ioCheckString returns the assembly that fails if the address in D is in the
+// memory map of a device the current function may not write to. Addresses
+// past the keyboard are negative as 16 bit values and fail too.
+func (cw *CodeWriter) ioCheckString() string {
+	limit := screenAddress
+	if strings.HasPrefix(cw.currFnName, "Screen.") || strings.HasPrefix(cw.currFnName, "Memory.") {
+		limit = keyboardAddress
+	}
+	return strings.Join([]string{
+		"// check I/O write",
+		"@" + cw.checkLabel("io"),
+		"D;JLT",
+		fmt.Sprintf("@%d", limit),
+		"D=D-A",
+		"@" + cw.checkLabel("io"),
+		"D;JGE",
+	}, "\n\t")
+}
@@ -1,25 +1,72 @@
 package codewriter
 
 import (
+	"VMtranslator/buildcache"
 	"VMtranslator/parser"
+	"VMtranslator/peephole"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 type CodeWriter struct {
-	outputFile *os.File
 	eqCounter  int    // used to make unique label assembly commands for each vm equality command
 	fileName   string // name of the file currently being translated
 	label      string // used as a prefix in the naming of static variables encountered in the file
 	retCounter int
 	currFnName string
+
+	optimize bool                // run the peephole optimizer over each file's assembly
+	sections []*section          // assembly held back until Close, one section per translated file
+	stats    []OptimizationStats // instruction counts of the sections optimized so far
+
+	sharedRuntime  bool // call, return and comparisons jump to shared routines instead of being inlined
+	runtimeWritten bool // the shared routines have been emitted
+
+	sourceMap   bool      // record the VM command every instruction was generated from
+	origins     []Origin  // origins of the held back lines, indexed by peephole.Line.Origin
+	origin      int       // index into origins of the command currently being translated
+	mappings    []Mapping // source map of the instructions written so far
+	mappedLines int       // lines of the output file covered by mappings
+
+	checked       bool // guard the stack, frames and I/O memory at run time
+	stackLimit    int  // largest value SP may take in checked code
+	checksWritten bool // the shared check routines have been emitted
+	currNumLocals int  // number of locals of currFnName
+
+	output io.Writer        // where assembly is written: the output file, or buffer for a unit
+	unit   bool             // the CodeWriter translates one file for another CodeWriter, see NewUnit
+	buffer *strings.Builder // output of a unit that isn't held back
+
+	cache *buildcache.Cache // files translated before, reused by WriteFiles; nil translates every file
+	jobs  int               // files WriteFiles translates at the same time
+}
+
+// section is the assembly generated for one file while output is held back for
+// optimization or source mapping.
+type section struct {
+	fileName  string
+	lines     []peephole.Line
+	optimized bool // lines have been through the peephole optimizer
+	before    int  // instructions generated before optimization
+}
+
+// OptimizationStats reports the effect of the peephole optimizer on one translated file.
+type OptimizationStats struct {
+	FileName string
+	Before   int // instructions generated before optimization
+	After    int // instructions left after optimization
+}
+
+func (s OptimizationStats) Saved() int {
+	return s.Before - s.After
 }
 
 func NewCodeWriter(outputFile *os.File) *CodeWriter {
 	var cw = new(CodeWriter)
-	cw.outputFile = outputFile
+	cw.output = outputFile
 	cw.eqCounter = 1
 	cw.retCounter = 1
 	cw.fileName = outputFile.Name()
@@ -29,17 +76,70 @@ func NewCodeWriter(outputFile *os.File) *CodeWriter {
 	return cw
 }
 
+// EnableOptimization makes the CodeWriter hold back the assembly of each file and
+// run the peephole optimizer over it before it is written to the output file.
+func (cw *CodeWriter) EnableOptimization() {
+	cw.optimize = true
+	cw.holdBack()
+}
+
+// holdBack makes emit collect generated assembly in sections instead of writing it out.
+func (cw *CodeWriter) holdBack() {
+	if len(cw.sections) == 0 {
+		cw.sections = append(cw.sections, &section{fileName: filepath.Base(cw.fileName)})
+		cw.origins = []Origin{{}}
+	}
+}
+
+func (cw *CodeWriter) heldBack() bool {
+	return cw.optimize || cw.sourceMap
+}
+
+// Stats returns the optimization results for every file written so far. It is
+// complete once Close has been called.
+func (cw *CodeWriter) Stats() []OptimizationStats {
+	return cw.stats
+}
+
 func (cw *CodeWriter) SetFileName(fileName string) {
 	cw.fileName = fileName
+	if cw.heldBack() {
+		cw.sections = append(cw.sections, &section{fileName: fileName})
+		cw.origin = 0
+	}
 	cw.currFnName = ""
+	cw.currNumLocals = 0
 	cw.eqCounter = 1
 	cw.retCounter = 1
 	_, f := filepath.Split(cw.fileName)
 	cw.label = strings.Split(f, ".")[0]
 }
 
+// scope returns the prefix of the labels generated for the current command: the
+// current function, or the file outside functions. Counters restart in every
+// scope, so the labels of different files and functions never clash.
+func (cw *CodeWriter) scope() string {
+	if cw.currFnName != "" {
+		return cw.currFnName
+	}
+	return cw.label
+}
+
+// internalLabel returns the nth label of the given kind the translator generates
+// in the current scope, e.g. Main.fibonacci$ret$2. The labels of the program are
+// written scope$label, and the parser rejects labels and function names that
+// contain $, so the two never clash.
+func (cw *CodeWriter) internalLabel(kind string, n int) string {
+	return fmt.Sprintf("%s$%s$%d", cw.scope(), kind, n)
+}
+
 const unsupportedCmdString = "Unsupported Command: "
 
+// StaticCapacity is the number of static variables that fit between RAM[16] and
+// the stack at 256. The assembler also places the FRAME and RET variables used by
+// return there.
+const StaticCapacity = 256 - 16 - 2
+
 // maps VM command names to their assembly equivalent
 var cmdsWithAsm = map[string]string{
 	"add": "D=D+M",
@@ -75,7 +175,91 @@ var incrementSPString = "@SP" + "\n\t" + "M=M+1"
 // assembly to decrement stack pointer
 var decrementSPString = "@SP" + "\n\t" + "AM=M-1"
 
+// assembly to return from a function to the return address saved in its frame
+var returnString = strings.Join([]string{
+	"@LCL", // FRAME = LCL
+	"D=M",
+	"@FRAME",
+	"M=D",
+	"@5", // RET = *(FRAME-5)
+	"D=D-A",
+	"A=D",
+	"D=M",
+	"@RET",
+	"M=D",
+	stackPopString, // *ARG = pop()
+	"@ARG",
+	"A=M",
+	"M=D",
+	"@ARG", // SP = ARG + 1
+	"D=M+1",
+	"@SP",
+	"M=D",
+	"@FRAME", // THAT = *(FRAME-1)
+	"D=M-1",
+	"A=D",
+	"D=M",
+	"@THAT",
+	"M=D",
+	"@2", // THIS = *(FRAME-2)
+	"D=A",
+	"@FRAME",
+	"D=M-D",
+	"A=D",
+	"D=M",
+	"@THIS",
+	"M=D",
+	"@3", // ARG = *(FRAME-3)
+	"D=A",
+	"@FRAME",
+	"D=M-D",
+	"A=D",
+	"D=M",
+	"@ARG",
+	"M=D",
+	"@4", // LCL = *(FRAME-4)
+	"D=A",
+	"@FRAME",
+	"D=M-D",
+	"A=D",
+	"D=M",
+	"@LCL",
+	"M=D",
+	"@RET", // goto RET
+	"A=M",
+	"0;JMP",
+}, "\n\t")
+
+// Write translates cmd with the Write method for its type, which makes the
+// CodeWriter the Hack assembly backend.
+func (cw *CodeWriter) Write(cmd parser.Command) error {
+	cw.SetOrigin(cmd.Pos.Line, cmd.String())
+	switch cmd.Type {
+	case parser.C_ARITHMETIC:
+		return cw.WriteArithmetic(cmd.Arg1)
+	case parser.C_PUSH, parser.C_POP:
+		return cw.WritePushPop(cmd.Type, cmd.Arg1, cmd.Arg2)
+	case parser.C_LABEL:
+		return cw.WriteLabel(cmd.Arg1)
+	case parser.C_GOTO:
+		return cw.WriteGoto(cmd.Arg1)
+	case parser.C_IF:
+		return cw.WriteIf(cmd.Arg1)
+	case parser.C_FUNCTION:
+		return cw.WriteFunction(cmd.Arg1, cmd.Arg2)
+	case parser.C_CALL:
+		return cw.WriteCall(cmd.Arg1, cmd.Arg2)
+	case parser.C_RETURN:
+		return cw.WriteReturn()
+	}
+	return fmt.Errorf("%scommand type %d", unsupportedCmdString, int(cmd.Type))
+}
+
 func (cw *CodeWriter) WriteArithmetic(command string) error {
+	if cw.sharedRuntime && (command == "eq" || command == "gt" || command == "lt") {
+		return cw.writeCompareStub(command)
+	}
+
 	var output strings.Builder
 	outputList := []string{}
 	commandUnsupported := false
@@ -110,7 +294,7 @@ func (cw *CodeWriter) WriteArithmetic(command string) error {
 		}
 	}
 
-	_, err := cw.outputFile.WriteString(output.String())
+	err := cw.emit(output.String())
 	if err != nil {
 		return err
 	}
@@ -126,11 +310,17 @@ func (cw *CodeWriter) WritePushPop(command parser.CommandType, segment string, i
 	if !(command == parser.C_PUSH || command == parser.C_POP) {
 		return fmt.Errorf("attempted to write %s as push or pop command. expected C_PUSH or C_POP", command.String())
 	}
+	if err := parser.CheckPushPop(parser.Command{Type: command, Arg1: segment, Arg2: index}); err != nil {
+		return err
+	}
 
 	var output strings.Builder
 	outputList := []string{}
 
 	outputList = append(outputList, fmt.Sprintf("// %s %s %d", cmdMnemonics[command], segment, index))
+	if cw.checked && command == parser.C_PUSH {
+		outputList = append(outputList, cw.stackCheckString(1))
+	}
 
 	// The assembly to load a constant into data memory is shared by every push/pop command
 	loadIndex := strings.Join([]string{
@@ -218,7 +408,11 @@ func (cw *CodeWriter) WritePushPop(command parser.CommandType, segment string, i
 					"A=M",
 					"M=D",
 				}, "\n\t")
-				outputList = append(outputList, loadIndex, loadIndexOfSegment, storeAddress, popFromStack, push)
+				outputList = append(outputList, loadIndex, loadIndexOfSegment, storeAddress)
+				if cw.checked && (segment == "this" || segment == "that") {
+					outputList = append(outputList, cw.ioCheckString())
+				}
+				outputList = append(outputList, popFromStack, push)
 			}
 		case "temp":
 			{
@@ -265,8 +459,6 @@ func (cw *CodeWriter) WritePushPop(command parser.CommandType, segment string, i
 				}, "\n\t")
 				outputList = append(outputList, popFromStack, push)
 			}
-		case "constant":
-			return fmt.Errorf("attempted to write pop command with %q as segment and %d as index", segment, index)
 		}
 	}
 
@@ -276,7 +468,7 @@ func (cw *CodeWriter) WritePushPop(command parser.CommandType, segment string, i
 		}
 	}
 
-	_, err := cw.outputFile.WriteString(output.String())
+	err := cw.emit(output.String())
 	if err != nil {
 		return err
 	}
@@ -298,7 +490,7 @@ func (cw *CodeWriter) WriteLabel(label string) error {
 		labelGen,
 	}, "\n\t")
 
-	if _, err := cw.outputFile.WriteString(output); err != nil {
+	if err := cw.emit(output); err != nil {
 		return err
 	}
 	return nil
@@ -316,7 +508,7 @@ func (cw *CodeWriter) WriteGoto(label string) error {
 		loadLabel,
 		"0;JMP",
 		""}, "\n\t")
-	_, err := cw.outputFile.WriteString(output)
+	err := cw.emit(output)
 	if err != nil {
 		return err
 	}
@@ -338,7 +530,7 @@ func (cw *CodeWriter) WriteIf(label string) error {
 		"D;JNE",
 	}, "\n\t")
 
-	_, err := cw.outputFile.WriteString("\t" + output + "\n")
+	err := cw.emit("\t" + output + "\n")
 	if err != nil {
 		return err
 	}
@@ -346,6 +538,7 @@ func (cw *CodeWriter) WriteIf(label string) error {
 }
 
 func (cw *CodeWriter) WriteInit() error {
+	cw.setOrigin(Origin{Command: "bootstrap"})
 	initSP := strings.Join([]string{
 		"// Initialize the stack pointer to 0x0100",
 		"@256",
@@ -354,20 +547,29 @@ func (cw *CodeWriter) WriteInit() error {
 		"M=D",
 		"// Start executing the translated code of Sys.init",
 	}, "\n\t")
-	if _, err := cw.outputFile.WriteString("\t" + initSP + "\n"); err != nil {
+	if err := cw.emit("\t" + initSP + "\n"); err != nil {
 		return err
 	}
+	// The call is made as if from Sys.init, so checked code fails in Sys.init if
+	// the stack can't hold the frame. Calls in Sys.init count from 1, so the
+	// return address Sys.init$ret$0 doesn't clash with theirs.
 	cw.currFnName = "Sys.init"
+	cw.retCounter = 0
 	return cw.WriteCall("Sys.init", 0)
 }
 
 func (cw *CodeWriter) WriteCall(functionName string, numArgs int) error {
-	var retAddrLabel string
-	if cw.currFnName != "" {
-		retAddrLabel = fmt.Sprintf("%s$ret.%d", cw.currFnName, cw.retCounter)
-	} else {
-		retAddrLabel = fmt.Sprintf("ret.%d", cw.retCounter)
+	retAddrLabel := cw.internalLabel("ret", cw.retCounter)
+	if cw.checked {
+		// the frame takes 5 words
+		if err := cw.emit("\t" + cw.stackCheckString(5) + "\n"); err != nil {
+			return err
+		}
 	}
+	if cw.sharedRuntime {
+		return cw.writeCallStub(functionName, numArgs, retAddrLabel)
+	}
+
 	output := strings.Join([]string{
 		fmt.Sprintf("// call %s %d", functionName, numArgs),
 		// push return-address
@@ -424,7 +626,7 @@ func (cw *CodeWriter) WriteCall(functionName string, numArgs int) error {
 	}, "\n\t")
 	cw.retCounter += 1
 
-	_, err := cw.outputFile.WriteString("\t" + output + "\n")
+	err := cw.emit("\t" + output + "\n")
 	if err != nil {
 		return err
 	}
@@ -432,62 +634,16 @@ func (cw *CodeWriter) WriteCall(functionName string, numArgs int) error {
 }
 
 func (cw *CodeWriter) WriteReturn() error {
-	output := strings.Join([]string{
-		"// return",
-		"@LCL", // FRAME = LCL
-		"D=M",
-		"@FRAME",
-		"M=D",
-		"@5", // RET = *(FRAME-5)
-		"D=D-A",
-		"A=D",
-		"D=M",
-		"@RET",
-		"M=D",
-		stackPopString, // *ARG = pop()
-		"@ARG",
-		"A=M",
-		"M=D",
-		"@ARG", // SP = ARG + 1
-		"D=M+1",
-		"@SP",
-		"M=D",
-		"@FRAME", // THAT = *(FRAME-1)
-		"D=M-1",
-		"A=D",
-		"D=M",
-		"@THAT",
-		"M=D",
-		"@2", // THIS = *(FRAME-2)
-		"D=A",
-		"@FRAME",
-		"D=M-D",
-		"A=D",
-		"D=M",
-		"@THIS",
-		"M=D",
-		"@3", // ARG = *(FRAME-3)
-		"D=A",
-		"@FRAME",
-		"D=M-D",
-		"A=D",
-		"D=M",
-		"@ARG",
-		"M=D",
-		"@4", // LCL = *(FRAME-4)
-		"D=A",
-		"@FRAME",
-		"D=M-D",
-		"A=D",
-		"D=M",
-		"@LCL",
-		"M=D",
-		"@RET", // goto RET
-		"A=M",
-		"0;JMP",
-	}, "\n\t")
+	if cw.checked {
+		if err := cw.emit("\t" + cw.frameCheckString() + "\n"); err != nil {
+			return err
+		}
+	}
+	if cw.sharedRuntime {
+		return cw.emit("\t" + strings.Join([]string{"// return", "@$RETURN", "0;JMP"}, "\n\t") + "\n")
+	}
 
-	_, err := cw.outputFile.WriteString("\t" + output + "\n")
+	err := cw.emit("\t// return\n\t" + returnString + "\n")
 	if err != nil {
 		return err
 	}
@@ -495,17 +651,28 @@ func (cw *CodeWriter) WriteReturn() error {
 }
 
 func (cw *CodeWriter) WriteFunction(functionName string, numLocals int) error {
-	if _, err := cw.outputFile.WriteString(fmt.Sprintf("// function %s %d\n", functionName, numLocals)); err != nil {
+	if err := cw.emit(fmt.Sprintf("// function %s %d\n", functionName, numLocals)); err != nil {
 		return err
 	}
-	if _, err := cw.outputFile.WriteString(fmt.Sprintf("(%s)\n", functionName)); err != nil {
+	if cw.checked {
+		if err := cw.emit(checkStubString(functionName)); err != nil {
+			return err
+		}
+	}
+	if err := cw.emit(fmt.Sprintf("(%s)\n", functionName)); err != nil {
 		return err
 	}
 	cw.currFnName = functionName
+	cw.currNumLocals = numLocals
 	cw.retCounter = 1
 	cw.eqCounter = 1
 
 	var output strings.Builder
+	if cw.checked && numLocals > 0 {
+		if _, err := output.WriteString("\t" + cw.stackCheckString(numLocals) + "\n"); err != nil {
+			return err
+		}
+	}
 
 	// initialize local variables to 0
 	for i := 0; i < numLocals; i++ {
@@ -520,15 +687,105 @@ func (cw *CodeWriter) WriteFunction(functionName string, numLocals int) error {
 		}
 	}
 
-	if _, err := cw.outputFile.WriteString(output.String()); err != nil {
+	if err := cw.emit(output.String()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// emit writes generated assembly to the output file, or holds it back for the
+// peephole optimizer when optimization is enabled.
+func (cw *CodeWriter) emit(asm string) error {
+	if err := cw.preamble(); err != nil {
+		return err
+	}
+	if cw.heldBack() {
+		sec := cw.sections[len(cw.sections)-1]
+		for _, line := range peephole.Parse(asm) {
+			line.Origin = cw.origin
+			sec.lines = append(sec.lines, line)
+		}
+		return nil
+	}
+	_, err := io.WriteString(cw.output, asm)
+	return err
+}
+
+// preamble emits the shared routines that go before the code of every file,
+// once, the first time anything is emitted.
+func (cw *CodeWriter) preamble() error {
+	if cw.sharedRuntime && !cw.runtimeWritten {
+		cw.runtimeWritten = true
+		origin := cw.origin
+		cw.setOrigin(Origin{Command: "runtime"})
+		if err := cw.emit(runtimeString); err != nil {
+			return err
+		}
+		cw.origin = origin
+	}
+	if cw.checked && !cw.checksWritten {
+		cw.checksWritten = true
+		origin := cw.origin
+		cw.setOrigin(Origin{Command: "runtime checks"})
+		if err := cw.emit(checkRuntimeString); err != nil {
+			return err
+		}
+		cw.origin = origin
+	}
+	return nil
+}
+
+// flush optimizes and maps the held back sections and writes them to the output file.
+func (cw *CodeWriter) flush() error {
+	for _, sec := range cw.sections {
+		lines := sec.lines
+		if len(lines) == 0 {
+			continue
+		}
+		if cw.optimize {
+			optimizeSection(sec)
+			lines = sec.lines
+			cw.stats = append(cw.stats, OptimizationStats{
+				FileName: sec.fileName,
+				Before:   sec.before,
+				After:    peephole.Count(lines),
+			})
+		}
+		if cw.sourceMap {
+			cw.mapLines(lines)
+		}
+		if _, err := io.WriteString(cw.output, peephole.Format(lines)); err != nil {
+			return err
+		}
+	}
+	cw.sections = nil
+	return nil
+}
+
+// optimizeSection runs the peephole optimizer over sec unless it has been already.
+func optimizeSection(sec *section) {
+	if sec.optimized {
+		return
+	}
+	sec.before = peephole.Count(sec.lines)
+	sec.lines = peephole.Optimize(sec.lines)
+	sec.optimized = true
+}
+
+// Close writes out the held back assembly. The output file is left for the
+// caller to close. Closing a unit only optimizes its assembly, which is left
+// for AppendUnit.
 func (cw *CodeWriter) Close() error {
-	return cw.outputFile.Close()
+	if cw.unit {
+		if cw.optimize {
+			for _, sec := range cw.sections {
+				optimizeSection(sec)
+			}
+		}
+		return nil
+	}
+	return cw.flush()
 }
 
 func (cw *CodeWriter) getBinaryCmdOutput(cmd string) string {
@@ -570,15 +827,15 @@ func (cw *CodeWriter) getBinaryCmdOutput(cmd string) string {
 				"D=M-D",
 				// Create a unique label branch for each instance of equality command encountered in
 				// the file(s)
-				fmt.Sprintf("@EQ%d", cw.eqCounter),
+				"@" + cw.internalLabel("EQ", cw.eqCounter),
 				fmt.Sprintf("D;%s", jumpMnemonic),
 				"D=0",
-				fmt.Sprintf("@PUSHEQ%d", cw.eqCounter),
+				"@" + cw.internalLabel("PUSHEQ", cw.eqCounter),
 				"0;JMP",
-				fmt.Sprintf("(EQ%d)", cw.eqCounter),
+				"(" + cw.internalLabel("EQ", cw.eqCounter) + ")",
 				"D=-1",
 				// pushResult
-				fmt.Sprintf("(PUSHEQ%d)", cw.eqCounter),
+				"(" + cw.internalLabel("PUSHEQ", cw.eqCounter) + ")",
 				"@SP",
 				"A=M",
 				"M=D",
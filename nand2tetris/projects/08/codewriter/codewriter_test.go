@@ -1,7 +1,9 @@
 package codewriter
 
 import (
+	"VMtranslator/hack"
 	"VMtranslator/parser"
+	"VMtranslator/peephole"
 	"bytes"
 	"fmt"
 	"os"
@@ -100,6 +102,9 @@ func TestWritePushPop(t *testing.T) {
 		{"pop pointer 0", pushPopInput{parser.C_POP, "pointer", 0}, []string{"@THIS"}},
 		{"pop pointer 1", pushPopInput{parser.C_POP, "pointer", 1}, []string{"@THAT"}},
 		{"error pop constant 6", pushPopInput{parser.C_POP, "constant", 6}, []string{}},
+		{"error push temp 8", pushPopInput{parser.C_PUSH, "temp", 8}, []string{}},
+		{"error pop pointer 2", pushPopInput{parser.C_POP, "pointer", 2}, []string{}},
+		{"error push constant -1", pushPopInput{parser.C_PUSH, "constant", -1}, []string{}},
 	}
 
 	for _, test := range tests {
@@ -138,6 +143,9 @@ func TestWritePushPop(t *testing.T) {
 			if err := cw.Close(); err != nil {
 				t.Fatal(err)
 			}
+			if err := tempFile.Close(); err != nil {
+				t.Fatal(err)
+			}
 
 			if err := os.Remove(tempFile.Name()); err != nil {
 				t.Fatal(err)
@@ -189,6 +197,9 @@ func TestWritePushPopStatic(t *testing.T) {
 			if err := cw.Close(); err != nil {
 				t.Fatal(err)
 			}
+			if err := tempFile.Close(); err != nil {
+				t.Fatal(err)
+			}
 
 			if err := os.Remove(tempFile.Name()); err != nil {
 				t.Fatal(err)
@@ -196,3 +207,421 @@ func TestWritePushPopStatic(t *testing.T) {
 		})
 	}
 }
+
+// translateFiles translates the given .vm files into one assembly program the
+// same way VMtranslator does for a directory. configure is called on the new
+// CodeWriter before anything is written.
+func translateFiles(t *testing.T, paths []string, bootstrap bool, configure func(cw *CodeWriter)) (string, []OptimizationStats) {
+	tempFile, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tempFile.Close()
+	cw := NewCodeWriter(tempFile)
+	configure(cw)
+
+	if bootstrap {
+		cw.WriteInit()
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds, err := parser.NewParser(f).ParseAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// one command at a time, rather than WriteFiles
+		cw.SetFileName(filepath.Base(path))
+		for _, cmd := range cmds {
+			if err := cw.Write(cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := os.ReadFile(tempFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), cw.Stats()
+}
+
+// runHack assembles asm and executes it for at most the given number of cycles,
+// starting with ram, and returns the final contents of RAM.
+func runHack(t *testing.T, asm string, ram []int16, cycles int) []int16 {
+	p, err := hack.Assemble(strings.NewReader(asm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := hack.NewCPU(p.ROM)
+	copy(cpu.RAM[:], ram)
+	cpu.Run(uint64(cycles))
+	return cpu.RAM[:]
+}
+
+// loadTestScript reads the RAM setup and cycle count of a CPUEmulator test script
+// and the RAM values its comparison file expects at the end of the run.
+func loadTestScript(t *testing.T, tstPath string) ([]int16, int, map[int]int16) {
+	script, err := os.ReadFile(tstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ram := make([]int16, 65536)
+	cycles := 0
+	for _, line := range strings.Split(string(script), "\n") {
+		var address, value int
+		if n, _ := fmt.Sscanf(strings.TrimSpace(line), "set RAM[%d] %d", &address, &value); n == 2 {
+			ram[address] = int16(value)
+		}
+		fmt.Sscanf(strings.TrimSpace(line), "repeat %d", &cycles)
+	}
+
+	cmp, err := os.ReadFile(strings.TrimSuffix(tstPath, ".tst") + ".cmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]int16{}
+	rows := strings.Split(strings.TrimSpace(string(cmp)), "\n")
+	for i := 0; i+1 < len(rows); i += 2 {
+		header, values := strings.Split(rows[i], "|"), strings.Split(rows[i+1], "|")
+		for j := range header {
+			var address, value int
+			if _, err := fmt.Sscanf(strings.TrimSpace(header[j]), "RAM[%d]", &address); err != nil {
+				continue
+			}
+			if _, err := fmt.Sscanf(strings.TrimSpace(values[j]), "%d", &value); err != nil {
+				t.Fatalf("could not read value of RAM[%d] from %s: %v", address, tstPath, err)
+			}
+			expected[address] = int16(value)
+		}
+	}
+	return ram, cycles, expected
+}
+
+// bookTests are the programs from the book with their CPUEmulator test scripts.
+var bookTests = []struct {
+	name   string
+	script string
+	input  []string
+}{
+	{"SimpleAdd", "../StackArithmetic/SimpleAdd/SimpleAdd.tst", []string{"../StackArithmetic/SimpleAdd/SimpleAdd.vm"}},
+	{"StackTest", "../StackArithmetic/StackTest/StackTest.tst", []string{"../StackArithmetic/StackTest/StackTest.vm"}},
+	{"BasicTest", "../MemoryAccess/BasicTest/BasicTest.tst", []string{"../MemoryAccess/BasicTest/BasicTest.vm"}},
+	{"PointerTest", "../MemoryAccess/PointerTest/PointerTest.tst", []string{"../MemoryAccess/PointerTest/PointerTest.vm"}},
+	{"StaticTest", "../MemoryAccess/StaticTest/StaticTest.tst", []string{"../MemoryAccess/StaticTest/StaticTest.vm"}},
+	{"BasicLoop", "../ProgramFlow/BasicLoop/BasicLoop.tst", []string{"../ProgramFlow/BasicLoop/BasicLoop.vm"}},
+	{"FibonacciSeries", "../ProgramFlow/FibonacciSeries/FibonacciSeries.tst", []string{"../ProgramFlow/FibonacciSeries/FibonacciSeries.vm"}},
+	{"SimpleFunction", "../FunctionCalls/SimpleFunction/SimpleFunction.tst", []string{"../FunctionCalls/SimpleFunction/SimpleFunction.vm"}},
+	{"NestedCall", "../FunctionCalls/NestedCall/NestedCall.tst", []string{"../FunctionCalls/NestedCall/Sys.vm"}},
+	{"FibonacciElement", "../FunctionCalls/FibonacciElement/FibonacciElement.tst", []string{"../FunctionCalls/FibonacciElement/Main.vm", "../FunctionCalls/FibonacciElement/Sys.vm"}},
+	{"StaticsTest", "../FunctionCalls/StaticsTest/StaticsTest.tst", []string{"../FunctionCalls/StaticsTest/Class1.vm", "../FunctionCalls/StaticsTest/Class2.vm", "../FunctionCalls/StaticsTest/Sys.vm"}},
+}
+
+// runBookTests translates every book program with the CodeWriter configured by
+// configure, runs it and compares the result to the expected RAM values.
+func runBookTests(t *testing.T, configure func(cw *CodeWriter)) {
+	runSlowBookTests(t, configure, 1)
+}
+
+// runSlowBookTests is runBookTests for code that takes up to slowdown times
+// as many cycles as the test scripts allow.
+func runSlowBookTests(t *testing.T, configure func(cw *CodeWriter), slowdown int) {
+	for _, test := range bookTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			// Programs made of several files are translated with the bootstrap code like a directory.
+			bootstrap := len(test.input) > 1 || test.name == "NestedCall"
+
+			asm, _ := translateFiles(t, test.input, bootstrap, configure)
+			ram, cycles, expected := loadTestScript(t, test.script)
+			ram = runHack(t, asm, ram, cycles*slowdown)
+
+			for address, value := range expected {
+				if ram[address] != value {
+					t.Errorf("RAM[%d]: expected %d got %d", address, value, ram[address])
+				}
+			}
+		})
+	}
+}
+
+func TestBookPrograms(t *testing.T) {
+	t.Parallel()
+	runBookTests(t, func(cw *CodeWriter) {})
+}
+
+func TestOptimizationPreservesResults(t *testing.T) {
+	t.Parallel()
+	runBookTests(t, func(cw *CodeWriter) { cw.EnableOptimization() })
+}
+
+func TestOptimizationSavesInstructions(t *testing.T) {
+	t.Parallel()
+	for _, test := range bookTests {
+		_, stats := translateFiles(t, test.input, len(test.input) > 1, func(cw *CodeWriter) { cw.EnableOptimization() })
+		saved := 0
+		for _, s := range stats {
+			saved += s.Saved()
+		}
+		if saved <= 0 {
+			t.Errorf("%s: expected the optimizer to save instructions got %v", test.name, stats)
+		}
+	}
+}
+
+func TestSharedRuntimePreservesResults(t *testing.T) {
+	t.Parallel()
+	t.Run("shared", func(t *testing.T) {
+		t.Parallel()
+		runBookTests(t, func(cw *CodeWriter) { cw.EnableSharedRuntime() })
+	})
+	t.Run("shared and optimized", func(t *testing.T) {
+		t.Parallel()
+		runBookTests(t, func(cw *CodeWriter) {
+			cw.EnableSharedRuntime()
+			cw.EnableOptimization()
+		})
+	})
+}
+
+func TestSharedRuntimeShrinksCalls(t *testing.T) {
+	t.Parallel()
+	input := []string{"../FunctionCalls/StaticsTest/Class1.vm", "../FunctionCalls/StaticsTest/Class2.vm", "../FunctionCalls/StaticsTest/Sys.vm"}
+	inlined, _ := translateFiles(t, input, true, func(cw *CodeWriter) {})
+	shared, _ := translateFiles(t, input, true, func(cw *CodeWriter) { cw.EnableSharedRuntime() })
+
+	count := func(asm string) int {
+		lines := peephole.Parse(asm)
+		return peephole.Count(lines)
+	}
+	if count(shared) >= count(inlined) {
+		t.Errorf("expected shared runtime to produce fewer instructions: %d inlined, %d shared", count(inlined), count(shared))
+	}
+	if strings.Count(shared, "($CALL)") != 1 || strings.Count(shared, "($RETURN)") != 1 {
+		t.Errorf("expected the shared routines to be emitted exactly once")
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	t.Parallel()
+	configurations := []struct {
+		name      string
+		configure func(cw *CodeWriter)
+	}{
+		{"plain", func(cw *CodeWriter) {}},
+		{"optimized", func(cw *CodeWriter) { cw.EnableOptimization() }},
+		{"shared runtime", func(cw *CodeWriter) { cw.EnableSharedRuntime() }},
+		{"checked", func(cw *CodeWriter) { cw.EnableChecks(DefaultStackLimit) }},
+	}
+
+	for _, c := range configurations {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var writer *CodeWriter
+			asm, _ := translateFiles(t, []string{
+				"../FunctionCalls/FibonacciElement/Main.vm",
+				"../FunctionCalls/FibonacciElement/Sys.vm",
+			}, true, func(cw *CodeWriter) {
+				cw.EnableSourceMap()
+				c.configure(cw)
+				writer = cw
+			})
+			mappings := writer.SourceMap()
+
+			// every instruction is mapped, in ROM order, to the line it is on
+			lines := strings.Split(asm, "\n")
+			rom := 0
+			for i, line := range lines {
+				if j := strings.Index(line, "//"); j != -1 {
+					line = line[:j]
+				}
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "(") {
+					continue
+				}
+				if rom >= len(mappings) {
+					t.Fatalf("instruction %d on line %d is not mapped", rom, i+1)
+				}
+				if m := mappings[rom]; m.ROMAddress != rom || m.AsmLine != i+1 {
+					t.Fatalf("expected instruction %d on line %d got mapping %+v", rom, i+1, m)
+				}
+				rom += 1
+			}
+			if rom != len(mappings) {
+				t.Fatalf("expected %d mappings got %d", rom, len(mappings))
+			}
+
+			// the mapped commands are the ones on the mapped lines of the .vm files
+			sources := map[string][]string{}
+			for _, name := range []string{"Main.vm", "Sys.vm"} {
+				content, err := os.ReadFile("../FunctionCalls/FibonacciElement/" + name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sources[name] = strings.Split(string(content), "\n")
+			}
+			for _, m := range mappings {
+				if m.File == "" {
+					if m.Command == "" {
+						t.Errorf("instruction %d has no origin", m.ROMAddress)
+					}
+					continue
+				}
+				line := strings.TrimSpace(sources[m.File][m.Line-1])
+				if !strings.HasPrefix(line, m.Command) {
+					t.Errorf("instruction %d maps to %s:%d %q but the line is %q", m.ROMAddress, m.File, m.Line, m.Command, line)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := WriteSourceMap(&buf, mappings); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadSourceMap(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(read) != len(mappings) {
+				t.Fatalf("expected %d mappings after reading back got %d", len(mappings), len(read))
+			}
+			for i := range read {
+				if read[i] != mappings[i] {
+					t.Errorf("expected %+v after reading back got %+v", mappings[i], read[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChecksPreserveResults(t *testing.T) {
+	t.Parallel()
+	configurations := []struct {
+		name      string
+		configure func(cw *CodeWriter)
+	}{
+		{"checked", func(cw *CodeWriter) {}},
+		{"checked and optimized", func(cw *CodeWriter) { cw.EnableOptimization() }},
+		{"checked with shared runtime", func(cw *CodeWriter) { cw.EnableSharedRuntime() }},
+	}
+	for _, c := range configurations {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			runSlowBookTests(t, func(cw *CodeWriter) {
+				cw.EnableChecks(DefaultStackLimit)
+				c.configure(cw)
+			}, 3)
+		})
+	}
+}
+
+// errorName reads the function name recorded by a failed check.
+func errorName(ram []int16) string {
+	var name strings.Builder
+	for i := 0; i < ErrorNameLength && ram[ErrorNameAddress+i] != 0; i++ {
+		name.WriteByte(byte(ram[ErrorNameAddress+i]))
+	}
+	return name.String()
+}
+
+func TestChecks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		src      string
+		code     int16
+		function string
+	}{
+		{"unbounded recursion",
+			"function Sys.init 0\ncall Main.recurse 0\nfunction Main.recurse 1\ncall Main.recurse 0\nreturn\n",
+			ErrStackOverflow, "Main.recurse"},
+		{"pushes past the limit",
+			"function Sys.init 0\nlabel LOOP\npush constant 1\ngoto LOOP\n",
+			ErrStackOverflow, "Sys.init"},
+		{"return with an empty stack",
+			"function Sys.init 0\ncall Main.f 0\nlabel HALT\ngoto HALT\nfunction Main.f 2\npop temp 0\nreturn\n",
+			ErrFrame, "Main.f"},
+		{"write to the screen",
+			"function Sys.init 0\npush constant 16384\npop pointer 1\npush constant 1\npop that 0\nlabel HALT\ngoto HALT\n",
+			ErrIOWrite, "Sys.init"},
+		{"write to the keyboard from Screen",
+			"function Sys.init 0\ncall Screen.draw 0\nlabel HALT\ngoto HALT\nfunction Screen.draw 0\npush constant 24576\npop pointer 0\npush constant 1\npop this 0\nreturn\n",
+			ErrIOWrite, "Screen.draw"},
+		{"long names are cut",
+			"function Sys.init 0\ncall Main.aVeryLongFunctionName 0\nfunction Main.aVeryLongFunctionName 0\ncall Main.aVeryLongFunctionName 0\nreturn\n",
+			ErrStackOverflow, "Main.aVeryLongF"},
+		{"Screen may draw",
+			"function Sys.init 0\ncall Screen.draw 0\nlabel HALT\ngoto HALT\nfunction Screen.draw 0\npush constant 16384\npop pointer 1\npush constant 1\npop that 0\npush constant 0\nreturn\n",
+			0, ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		for _, optimize := range []bool{false, true} {
+			optimize := optimize
+			t.Run(fmt.Sprintf("%s optimized=%v", test.name, optimize), func(t *testing.T) {
+				t.Parallel()
+				path := filepath.Join(t.TempDir(), "Main.vm")
+				if err := os.WriteFile(path, []byte(test.src), 0644); err != nil {
+					t.Fatal(err)
+				}
+				asm, _ := translateFiles(t, []string{path}, true, func(cw *CodeWriter) {
+					cw.EnableChecks(DefaultStackLimit)
+					if optimize {
+						cw.EnableOptimization()
+					}
+				})
+				ram := runHack(t, asm, make([]int16, 65536), 100000)
+				if ram[ErrorCodeAddress] != test.code {
+					t.Errorf("expected error code %d got %d", test.code, ram[ErrorCodeAddress])
+				}
+				if name := errorName(ram); name != test.function {
+					t.Errorf("expected failing function %q got %q", test.function, name)
+				}
+				if ram[0] > DefaultStackLimit {
+					t.Errorf("expected SP to stay within the limit got %d", ram[0])
+				}
+			})
+		}
+	}
+}
+
+func TestGeneratedLabelsDontClash(t *testing.T) {
+	t.Parallel()
+	// the labels of the program look like the ones the translator generates
+	src := "function Sys.init 0\npush constant 1\npush constant 1\neq\ncall Sys.init 0\n" +
+		"label EQ1\nlabel PUSHEQ1\nlabel ret.1\nlabel cmp.1\ngoto EQ1\n"
+	path := filepath.Join(t.TempDir(), "Sys.vm")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configurations := map[string]func(cw *CodeWriter){
+		"plain":          func(cw *CodeWriter) {},
+		"shared runtime": func(cw *CodeWriter) { cw.EnableSharedRuntime() },
+	}
+	for name, configure := range configurations {
+		asm, _ := translateFiles(t, []string{path}, false, configure)
+		defined := map[string]bool{}
+		for _, line := range strings.Split(asm, "\n") {
+			if i := strings.Index(line, "//"); i != -1 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "(") {
+				continue
+			}
+			if defined[line] {
+				t.Errorf("%s: label %s is defined twice", name, line)
+			}
+			defined[line] = true
+		}
+	}
+}
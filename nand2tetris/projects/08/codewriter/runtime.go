@@ -0,0 +1,142 @@
+package codewriter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnableSharedRuntime makes the CodeWriter emit call, return and the eq/gt/lt
+// comparisons once as shared routines at the start of the program. Every use is
+// translated to a short stub that jumps to the routine with a return address,
+// trading a few cycles per use for a much smaller program.
+func (cw *CodeWriter) EnableSharedRuntime() {
+	cw.sharedRuntime = true
+}
+
+// assembly to push the current frame of the caller onto the stack
+var saveFrameString = strings.Join([]string{
+	"@LCL",
+	"D=M",
+	stackPushString,
+	incrementSPString,
+	"@ARG",
+	"D=M",
+	stackPushString,
+	incrementSPString,
+	"@THIS",
+	"D=M",
+	stackPushString,
+	incrementSPString,
+	"@THAT",
+	"D=M",
+	stackPushString,
+	incrementSPString,
+}, "\n\t")
+
+// compareEntryString returns the entry point of the shared comparison routine for
+// cmd. It pops both operands and jumps to $COMPARE.TRUE if the comparison holds.
+func compareEntryString(cmd string, jumpMnemonic string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("($COMPARE.%s)", strings.ToUpper(cmd)),
+		"@R15", // save return address
+		"M=D",
+		decrementSPString,
+		"D=M",
+		decrementSPString,
+		"D=M-D",
+		"@$COMPARE.TRUE",
+		fmt.Sprintf("D;%s", jumpMnemonic),
+	}, "\n\t")
+}
+
+// runtimeString holds the shared routines. The program jumps over them on start up.
+//
+// $CALL expects the return address in D, the number of arguments in R13 and the
+// address of the function in R14. $RETURN returns from the current function.
+// $COMPARE.EQ, $COMPARE.GT and $COMPARE.LT expect the return address in D and
+// replace the two values on top of the stack with the result of the comparison.
+var runtimeString = "\t" + strings.Join([]string{
+	"// shared runtime routines",
+	"@$RUNTIME_END",
+	"0;JMP",
+	"($CALL)",
+	stackPushString, // push return-address
+	incrementSPString,
+	saveFrameString,
+	"@R13", // ARG = SP-n-5
+	"D=M",
+	"@5",
+	"D=D+A",
+	"@SP",
+	"D=M-D",
+	"@ARG",
+	"M=D",
+	"@SP", // LCL = SP
+	"D=M",
+	"@LCL",
+	"M=D",
+	"@R14", // goto f
+	"A=M",
+	"0;JMP",
+	"($RETURN)",
+	returnString,
+	compareEntryString("eq", "JEQ"),
+	"@$COMPARE.FALSE",
+	"0;JMP",
+	compareEntryString("gt", "JGT"),
+	"@$COMPARE.FALSE",
+	"0;JMP",
+	compareEntryString("lt", "JLT"),
+	"($COMPARE.FALSE)",
+	"D=0",
+	"@$COMPARE",
+	"0;JMP",
+	"($COMPARE.TRUE)",
+	"D=-1",
+	"($COMPARE)", // push the result and return to the caller
+	stackPushString,
+	incrementSPString,
+	"@R15",
+	"A=M",
+	"0;JMP",
+	"($RUNTIME_END)",
+}, "\n\t") + "\n"
+
+// writeCallStub writes a call that lets the shared $CALL routine build the frame.
+func (cw *CodeWriter) writeCallStub(functionName string, numArgs int, retAddrLabel string) error {
+	output := strings.Join([]string{
+		fmt.Sprintf("// call %s %d", functionName, numArgs),
+		fmt.Sprintf("@%d", numArgs),
+		"D=A",
+		"@R13",
+		"M=D",
+		fmt.Sprintf("@%s", functionName),
+		"D=A",
+		"@R14",
+		"M=D",
+		fmt.Sprintf("@%s", retAddrLabel),
+		"D=A",
+		"@$CALL",
+		"0;JMP",
+		fmt.Sprintf("(%s)", retAddrLabel),
+	}, "\n\t")
+	cw.retCounter += 1
+
+	return cw.emit("\t" + output + "\n")
+}
+
+// writeCompareStub writes an eq, gt or lt command that jumps to the shared comparison routine.
+func (cw *CodeWriter) writeCompareStub(cmd string) error {
+	retAddrLabel := cw.internalLabel("cmp", cw.eqCounter)
+	output := strings.Join([]string{
+		fmt.Sprintf("// %s %d", cmd, cw.eqCounter),
+		fmt.Sprintf("@%s", retAddrLabel),
+		"D=A",
+		fmt.Sprintf("@$COMPARE.%s", strings.ToUpper(cmd)),
+		"0;JMP",
+		fmt.Sprintf("(%s)", retAddrLabel),
+	}, "\n\t")
+	cw.eqCounter += 1
+
+	return cw.emit("\t" + output + "\n")
+}
@@ -0,0 +1,126 @@
+package codewriter
+
+import (
+	"VMtranslator/peephole"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Origin identifies the VM command an instruction was generated from. Code that
+// doesn't come from a .vm file, such as the bootstrap, has an empty File and a
+// Command describing it.
+type Origin struct {
+	File    string // name of the .vm file
+	Line    int    // line of the command in File, starting at 1
+	Command string // the VM command, e.g. "push constant 7"
+}
+
+// Mapping ties one instruction of the generated program to its origin.
+type Mapping struct {
+	ROMAddress int // address of the instruction once assembled
+	AsmLine    int // line of the instruction in the .asm file, starting at 1
+	Origin
+}
+
+// EnableSourceMap makes the CodeWriter record the origin of every instruction it
+// writes. It must be called before anything is written. The map is available
+// from SourceMap once Close has been called.
+func (cw *CodeWriter) EnableSourceMap() {
+	cw.sourceMap = true
+	cw.holdBack()
+}
+
+// SetOrigin tells the CodeWriter which command of the current file the
+// following Write calls translate.
+func (cw *CodeWriter) SetOrigin(line int, command string) {
+	cw.setOrigin(Origin{File: cw.fileName, Line: line, Command: command})
+}
+
+func (cw *CodeWriter) setOrigin(origin Origin) {
+	if !cw.heldBack() {
+		return
+	}
+	cw.origins = append(cw.origins, origin)
+	cw.origin = len(cw.origins) - 1
+}
+
+// SourceMap returns the origin of every instruction written to the output file.
+func (cw *CodeWriter) SourceMap() []Mapping {
+	return cw.mappings
+}
+
+// mapLines records the origins of lines, which are about to be written to the
+// output file after everything mapped so far.
+func (cw *CodeWriter) mapLines(lines []peephole.Line) {
+	for _, line := range lines {
+		cw.mappedLines += 1
+		if line.Code == "" || strings.HasPrefix(line.Code, "(") {
+			continue
+		}
+		cw.mappings = append(cw.mappings, Mapping{
+			ROMAddress: len(cw.mappings),
+			AsmLine:    cw.mappedLines,
+			Origin:     cw.origins[line.Origin],
+		})
+	}
+}
+
+const sourceMapHeader = "// rom\tasm\tfile\tline\tcommand"
+
+// WriteSourceMap writes mappings as tab separated text, one instruction per line
+// in ROM order. Origins outside any .vm file are written with "-" as the file.
+func WriteSourceMap(w io.Writer, mappings []Mapping) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintln(bw, sourceMapHeader); err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		file := m.File
+		if file == "" {
+			file = "-"
+		}
+		if _, err := fmt.Fprintf(bw, "%d\t%d\t%s\t%d\t%s\n", m.ROMAddress, m.AsmLine, file, m.Line, m.Command); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSourceMap reads a source map written by WriteSourceMap.
+func ReadSourceMap(r io.Reader) ([]Mapping, error) {
+	mappings := []Mapping{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("source map line %d: expected 5 tab separated fields got %d", lineNum, len(fields))
+		}
+		var m Mapping
+		var err error
+		if m.ROMAddress, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("source map line %d: invalid ROM address: %v", lineNum, err)
+		}
+		if m.AsmLine, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("source map line %d: invalid asm line: %v", lineNum, err)
+		}
+		if fields[2] != "-" {
+			m.File = fields[2]
+		}
+		if m.Line, err = strconv.Atoi(fields[3]); err != nil {
+			return nil, fmt.Errorf("source map line %d: invalid vm line: %v", lineNum, err)
+		}
+		m.Command = fields[4]
+		mappings = append(mappings, m)
+	}
+	return mappings, scanner.Err()
+}
@@ -0,0 +1,211 @@
+package codewriter
+
+import (
+	"VMtranslator/backend"
+	"VMtranslator/buildcache"
+	"VMtranslator/peephole"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// NewUnit returns a CodeWriter that translates the file fileName on its own,
+// with the options of cw, into a buffer. Every file starts its counters and
+// label scopes afresh, so a unit generates exactly what cw would for the same
+// file, and units share no state with cw or each other. That lets files be
+// translated concurrently: Close a finished unit, then add it to the output of
+// cw with AppendUnit. The shared routines are emitted by cw, never by units.
+func (cw *CodeWriter) NewUnit(fileName string) *CodeWriter {
+	u := &CodeWriter{
+		optimize:       cw.optimize,
+		sharedRuntime:  cw.sharedRuntime,
+		runtimeWritten: true,
+		sourceMap:      cw.sourceMap,
+		checked:        cw.checked,
+		stackLimit:     cw.stackLimit,
+		checksWritten:  true,
+		unit:           true,
+		buffer:         new(strings.Builder),
+	}
+	u.output = u.buffer
+	if u.heldBack() {
+		u.origins = []Origin{{}}
+	}
+	u.SetFileName(fileName)
+	return u
+}
+
+// AppendUnit adds the assembly of the closed unit u to the output of cw, after
+// everything written so far. Units must be appended in the order of their files
+// for the output to be the same as when the files are written to cw directly.
+func (cw *CodeWriter) AppendUnit(u *CodeWriter) error {
+	return cw.AppendFragment(u.Fragment())
+}
+
+// Fragment is the assembly a closed unit generated for its file, in a form that
+// can be kept, e.g. in a build cache, and appended later instead of the unit.
+type Fragment struct {
+	Assembly string            // output of a unit that isn't held back
+	Sections []FragmentSection // output of a unit that is held back
+	Origins  []Origin          // origins of the lines of Sections
+}
+
+// FragmentSection is the assembly held back for one file.
+type FragmentSection struct {
+	FileName  string
+	Lines     []peephole.Line
+	Optimized bool // Lines have been through the peephole optimizer
+	Before    int  // instructions generated before optimization
+}
+
+// Fragment returns the assembly of the closed unit u.
+func (u *CodeWriter) Fragment() Fragment {
+	f := Fragment{Origins: u.origins}
+	if !u.heldBack() {
+		f.Assembly = u.buffer.String()
+	}
+	for _, sec := range u.sections {
+		f.Sections = append(f.Sections, FragmentSection{
+			FileName:  sec.fileName,
+			Lines:     sec.lines,
+			Optimized: sec.optimized,
+			Before:    sec.before,
+		})
+	}
+	return f
+}
+
+// AppendFragment adds the assembly of f to the output of cw, like AppendUnit
+// for the unit f came from. f is left as it is, so it can be appended again.
+func (cw *CodeWriter) AppendFragment(f Fragment) error {
+	if err := cw.preamble(); err != nil {
+		return err
+	}
+	if !cw.heldBack() {
+		return cw.emit(f.Assembly)
+	}
+
+	// the lines of f refer to its own origins
+	offset := len(cw.origins)
+	cw.origins = append(cw.origins, f.Origins...)
+	for _, fs := range f.Sections {
+		sec := &section{
+			fileName:  fs.FileName,
+			lines:     make([]peephole.Line, len(fs.Lines)),
+			optimized: fs.Optimized,
+			before:    fs.Before,
+		}
+		for i, line := range fs.Lines {
+			line.Origin += offset
+			sec.lines[i] = line
+		}
+		cw.sections = append(cw.sections, sec)
+	}
+	return nil
+}
+
+// fragmentVersion is part of the key of every cached fragment. Change it with
+// the code the CodeWriter generates, so fragments of older builds aren't reused.
+const fragmentVersion = 2
+
+// EnableCache makes WriteFiles reuse the fragments of files that have been
+// translated with the same options before, and store the ones it translates.
+func (cw *CodeWriter) EnableCache(c *buildcache.Cache) {
+	cw.cache = c
+}
+
+// fragmentKey returns the key of the fragment cw translates src to: it hashes
+// everything a unit's output depends on, which is the options, the file name
+// that statics and labels are named after, and the commands with their lines.
+func (cw *CodeWriter) fragmentKey(src backend.File) buildcache.Key {
+	h := buildcache.NewHasher()
+	h.Add(fragmentVersion, cw.optimize, cw.sharedRuntime, cw.sourceMap, cw.checked, cw.stackLimit)
+	h.Add(src.Name, len(src.Commands))
+	for _, cmd := range src.Commands {
+		h.Add(cmd.Type, cmd.Arg1, cmd.Arg2, cmd.Pos.Line)
+	}
+	return h.Key()
+}
+
+// SetJobs makes WriteFiles translate up to jobs files at the same time.
+func (cw *CodeWriter) SetJobs(jobs int) {
+	cw.jobs = jobs
+}
+
+// WriteFiles translates sources on up to the goroutines SetJobs asks for, each
+// file as a unit, and appends them to cw in order. The output doesn't depend on
+// the number of jobs or on how the goroutines are scheduled. It stops at the
+// first file that fails. With a cache, files whose fragment is in it aren't
+// translated again. It makes the CodeWriter a backend.FilesWriter.
+func (cw *CodeWriter) WriteFiles(sources []backend.File) error {
+	jobs := cw.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	fragments := make([]Fragment, len(sources))
+	keys := make([]buildcache.Key, len(sources))
+	pending := []int{}
+	for i, src := range sources {
+		if cw.cache != nil {
+			keys[i] = cw.fragmentKey(src)
+			if cw.cache.Get(keys[i], &fragments[i]) {
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+
+	errs := make([]error, len(sources))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs && j < len(pending); j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fragments[i], errs[i] = cw.translateSource(sources[i], keys[i])
+			}
+		}()
+	}
+	for _, i := range pending {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i := range sources {
+		if errs[i] != nil {
+			return fmt.Errorf("%s: %w", sources[i].Name, errs[i])
+		}
+		if err := cw.AppendFragment(fragments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translateSource translates src as a unit and stores its fragment under key
+// when cw has a cache.
+func (cw *CodeWriter) translateSource(src backend.File, key buildcache.Key) (Fragment, error) {
+	u := cw.NewUnit(src.Name)
+	if err := u.writeSource(src); err != nil {
+		return Fragment{}, err
+	}
+	f := u.Fragment()
+	if cw.cache != nil {
+		if err := cw.cache.Put(key, f); err != nil {
+			return Fragment{}, fmt.Errorf("caching the translation: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// writeSource translates every command of src and closes the unit.
+func (cw *CodeWriter) writeSource(src backend.File) error {
+	for _, cmd := range src.Commands {
+		if err := cw.Write(cmd); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
@@ -0,0 +1,272 @@
+package codewriter
+
+import (
+	"VMtranslator/backend"
+	"VMtranslator/buildcache"
+	"VMtranslator/parser"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseFiles parses the .vm files at paths.
+func parseFiles(t testing.TB, paths []string) []backend.File {
+	sources := []backend.File{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds, err := parser.NewParser(f).ParseAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, backend.File{Name: filepath.Base(path), Commands: cmds})
+	}
+	return sources
+}
+
+// syntheticProject returns a program of the given number of files, each with
+// the given number of functions that compare, branch, call and use statics.
+func syntheticProject(t testing.TB, files, functions int) []backend.File {
+	sources := []backend.File{}
+	for i := 0; i < files; i++ {
+		var vm strings.Builder
+		class := fmt.Sprintf("Class%d", i)
+		// code outside functions has labels of its own
+		fmt.Fprintf(&vm, "push constant %d\npush constant 1\neq\npop temp 0\ncall %s.f0 0\npop temp 1\n", i, class)
+		for j := 0; j < functions; j++ {
+			fmt.Fprintf(&vm, "function %s.f%d 2\n", class, j)
+			fmt.Fprintf(&vm, "push argument 0\npush constant %d\nadd\npop local 0\n", j)
+			fmt.Fprintf(&vm, "push local 0\npush constant 3\nlt\nif-goto SKIP\n")
+			fmt.Fprintf(&vm, "push local 0\ncall Class%d.f%d 1\npop local 1\n", (i+1)%files, (j+1)%functions)
+			fmt.Fprintf(&vm, "label SKIP\npush local 0\npush static %d\ngt\npop static %d\n", j%8, j%8)
+			fmt.Fprintf(&vm, "push local 1\nreturn\n")
+		}
+		cmds, err := parser.NewParser(strings.NewReader(vm.String())).ParseAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, backend.File{Name: class + ".vm", Commands: cmds})
+	}
+	return sources
+}
+
+// writeFiles translates sources with WriteFiles on jobs goroutines, with a
+// bootstrap, and returns the output and the source map.
+func writeFiles(t testing.TB, sources []backend.File, jobs int, configure func(cw *CodeWriter)) (string, []Mapping, []OptimizationStats) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "Program.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw := NewCodeWriter(f)
+	configure(cw)
+	if err := cw.WriteInit(); err != nil {
+		t.Fatal(err)
+	}
+	cw.SetJobs(jobs)
+	if err := cw.WriteFiles(sources); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), cw.SourceMap(), cw.Stats()
+}
+
+var unitConfigs = []struct {
+	name      string
+	configure func(cw *CodeWriter)
+}{
+	{"plain", func(cw *CodeWriter) {}},
+	{"optimized", func(cw *CodeWriter) { cw.EnableOptimization() }},
+	{"shared", func(cw *CodeWriter) { cw.EnableSharedRuntime() }},
+	{"sourcemap", func(cw *CodeWriter) { cw.EnableSourceMap() }},
+	{"checked", func(cw *CodeWriter) { cw.EnableChecks(DefaultStackLimit) }},
+	{"all", func(cw *CodeWriter) {
+		cw.EnableOptimization()
+		cw.EnableSharedRuntime()
+		cw.EnableSourceMap()
+		cw.EnableChecks(DefaultStackLimit)
+	}},
+}
+
+func TestWriteFilesMatchesSequential(t *testing.T) {
+	paths := []string{
+		"../FunctionCalls/StaticsTest/Class1.vm",
+		"../FunctionCalls/StaticsTest/Class2.vm",
+		"../FunctionCalls/StaticsTest/Sys.vm",
+	}
+	sources := parseFiles(t, paths)
+	for _, config := range unitConfigs {
+		config := config
+		t.Run(config.name, func(t *testing.T) {
+			t.Parallel()
+			var sequentialCW *CodeWriter
+			sequential, sequentialStats := translateFiles(t, paths, true, func(cw *CodeWriter) {
+				config.configure(cw)
+				sequentialCW = cw
+			})
+			for _, jobs := range []int{1, 2, 8} {
+				asm, mappings, stats := writeFiles(t, sources, jobs, config.configure)
+				if asm != sequential {
+					t.Errorf("%d jobs: output differs from translating the files one after the other", jobs)
+				}
+				if !reflect.DeepEqual(mappings, sequentialCW.SourceMap()) {
+					t.Errorf("%d jobs: source map differs from translating the files one after the other", jobs)
+				}
+				// the bootstrap is counted under the name of the output file
+				if len(stats) != 0 && len(stats) == len(sequentialStats) {
+					stats[0].FileName = sequentialStats[0].FileName
+				}
+				if !reflect.DeepEqual(stats, sequentialStats) {
+					t.Errorf("%d jobs: expected stats %v got %v", jobs, sequentialStats, stats)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFilesDeterministic(t *testing.T) {
+	sources := syntheticProject(t, 16, 8)
+	for _, config := range unitConfigs {
+		config := config
+		t.Run(config.name, func(t *testing.T) {
+			t.Parallel()
+			expected, expectedMap, _ := writeFiles(t, sources, 1, config.configure)
+
+			// every label is defined once, so no jump can land in another file or function
+			defined := map[string]bool{}
+			for _, line := range strings.Split(expected, "\n") {
+				line = strings.TrimSpace(line)
+				if !strings.HasPrefix(line, "(") {
+					continue
+				}
+				if defined[line] {
+					t.Errorf("label %s is defined more than once", line)
+				}
+				defined[line] = true
+			}
+
+			for i := 0; i < 10; i++ {
+				asm, mappings, _ := writeFiles(t, sources, 8, config.configure)
+				if asm != expected {
+					t.Fatalf("run %d: output differs between runs", i)
+				}
+				if !reflect.DeepEqual(mappings, expectedMap) {
+					t.Fatalf("run %d: source map differs between runs", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFilesError(t *testing.T) {
+	sources := syntheticProject(t, 4, 2)
+	sources[2].Commands = append(sources[2].Commands, parser.Command{Type: parser.C_POP, Arg1: "constant", Arg2: 1})
+
+	f, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw := NewCodeWriter(f)
+	cw.SetJobs(4)
+	err = cw.WriteFiles(sources)
+	cw.Close()
+	if err == nil || !strings.HasPrefix(err.Error(), "Class2.vm: ") {
+		t.Errorf("expected an error in Class2.vm got %v", err)
+	}
+}
+
+func TestWriteFilesCache(t *testing.T) {
+	sources := syntheticProject(t, 6, 4)
+	for _, config := range unitConfigs {
+		config := config
+		t.Run(config.name, func(t *testing.T) {
+			t.Parallel()
+			expected, expectedMap, expectedStats := writeFiles(t, sources, 1, config.configure)
+
+			dir := t.TempDir()
+			tests := []struct {
+				name   string
+				hits   int
+				change bool // change the commands of one file before the build
+			}{
+				{"first build", 0, false},
+				{"nothing changed", len(sources), false},
+				{"one file changed", len(sources) - 1, true},
+			}
+			for _, test := range tests {
+				if test.change {
+					sources = append([]backend.File{}, sources...)
+					sources[3].Commands = append(sources[3].Commands, parser.Command{Type: parser.C_ARITHMETIC, Arg1: "neg", Arg2: -1})
+					expected, expectedMap, expectedStats = writeFiles(t, sources, 1, config.configure)
+				}
+				// a fresh cache on the same directory, as in a new process
+				c, err := buildcache.Open(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				asm, mappings, stats := writeFiles(t, sources, 4, func(cw *CodeWriter) {
+					config.configure(cw)
+					cw.EnableCache(c)
+				})
+				if hits, misses := c.Stats(); hits != test.hits || misses != len(sources)-test.hits {
+					t.Errorf("%s: expected %d hits and %d misses got %d and %d", test.name, test.hits, len(sources)-test.hits, hits, misses)
+				}
+				if asm != expected {
+					t.Errorf("%s: output differs from translating without a cache", test.name)
+				}
+				if !reflect.DeepEqual(mappings, expectedMap) {
+					t.Errorf("%s: source map differs from translating without a cache", test.name)
+				}
+				if !reflect.DeepEqual(stats, expectedStats) {
+					t.Errorf("%s: expected stats %v got %v", test.name, expectedStats, stats)
+				}
+			}
+		})
+	}
+}
+
+// TestAppendFragmentTwice checks that appending a fragment leaves it intact,
+// since a cached fragment is appended by every build that reuses it.
+func TestAppendFragmentTwice(t *testing.T) {
+	sources := syntheticProject(t, 2, 2)
+	c, err := buildcache.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configure := func(cw *CodeWriter) {
+		cw.EnableSourceMap()
+		cw.EnableCache(c)
+	}
+	first, firstMap, _ := writeFiles(t, sources, 1, configure)
+	second, secondMap, _ := writeFiles(t, sources, 1, configure)
+	if first != second || !reflect.DeepEqual(firstMap, secondMap) {
+		t.Error("expected reusing the fragments to give the same output as translating the files")
+	}
+}
+
+// BenchmarkWriteFiles translates a program of 64 files with the peephole
+// optimizer on a growing number of goroutines.
+func BenchmarkWriteFiles(b *testing.B) {
+	sources := syntheticProject(b, 64, 20)
+	for _, jobs := range []int{1, 2, 4, 8} {
+		jobs := jobs
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				writeFiles(b, sources, jobs, func(cw *CodeWriter) { cw.EnableOptimization() })
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package coverage reports which lines of a translated program ran. It maps
+// the times every ROM address was executed back to the lines of the .asm file
+// through the assembler, and to the VM commands of the .vm files through the
+// source map of the CodeWriter.
+package coverage
+
+import (
+	"VMtranslator/codewriter"
+	"VMtranslator/hack"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// File is the coverage of one source file.
+type File struct {
+	Name string
+	// Counts holds the times every line of code ran, by line number. A VM
+	// command ran as often as the instruction of its code that ran the most.
+	Counts map[int]uint64
+}
+
+// Lines returns the numbers of the lines of code of f, in order.
+func (f *File) Lines() []int {
+	lines := make([]int, 0, len(f.Counts))
+	for line := range f.Counts {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Covered returns the number of lines of code of f that ran.
+func (f *File) Covered() int {
+	covered := 0
+	for _, count := range f.Counts {
+		if count > 0 {
+			covered++
+		}
+	}
+	return covered
+}
+
+// Percent returns the share of the lines of code of f that ran, from 0 to 100.
+// A file without code is fully covered.
+func (f *File) Percent() float64 {
+	if len(f.Counts) == 0 {
+		return 100
+	}
+	return float64(f.Covered()) * 100 / float64(len(f.Counts))
+}
+
+// Coverage is the coverage of a program: its .asm file first, then its .vm
+// files in the order they were translated.
+type Coverage struct {
+	Files []*File
+}
+
+// New returns the coverage of prog, assembled from the file asmName, after the
+// instruction at every ROM address ran counts times. mappings is the source map
+// of the CodeWriter that wrote asmName; code that doesn't come from a .vm file,
+// like the bootstrap, is only covered in the .asm file.
+func New(asmName string, prog *hack.Program, mappings []codewriter.Mapping, counts []uint64) *Coverage {
+	asm := &File{Name: asmName, Counts: map[int]uint64{}}
+	for address, line := range prog.Lines {
+		asm.Counts[line] += count(counts, address)
+	}
+	c := &Coverage{Files: []*File{asm}}
+
+	vmFiles := map[string]*File{}
+	for _, m := range mappings {
+		if m.File == "" {
+			continue
+		}
+		f, ok := vmFiles[m.File]
+		if !ok {
+			f = &File{Name: m.File, Counts: map[int]uint64{}}
+			vmFiles[m.File] = f
+			c.Files = append(c.Files, f)
+		}
+		if n := count(counts, m.ROMAddress); n >= f.Counts[m.Line] {
+			f.Counts[m.Line] = n
+		}
+	}
+	return c
+}
+
+// count returns the times the instruction at address ran.
+func count(counts []uint64, address int) uint64 {
+	if address < 0 || address >= len(counts) {
+		return 0
+	}
+	return counts[address]
+}
+
+// WriteSummary writes the number of lines of code of every file and the share
+// of them that ran to w.
+func (c *Coverage) WriteSummary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	width := len("file")
+	for _, f := range c.Files {
+		if len(f.Name) > width {
+			width = len(f.Name)
+		}
+	}
+	fmt.Fprintf(bw, "%-*s %8s %8s\n", width, "file", "lines", "covered")
+	for _, f := range c.Files {
+		fmt.Fprintf(bw, "%-*s %8d %7.1f%%\n", width, f.Name, len(f.Counts), f.Percent())
+	}
+	return bw.Flush()
+}
+
+// WriteText writes the summary of c to w, followed by the lines of code of
+// every file that didn't run, if there are any.
+func (c *Coverage) WriteText(w io.Writer) error {
+	if err := c.WriteSummary(w); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	header := "\nNot run:\n"
+	for _, f := range c.Files {
+		if missed := f.notRun(); missed != "" {
+			fmt.Fprintf(bw, "%s%s: %s\n", header, f.Name, missed)
+			header = ""
+		}
+	}
+	return bw.Flush()
+}
+
+// notRun returns the lines of code of f that didn't run as a list of ranges,
+// e.g. "3, 7-9". Lines without code between them don't break a range.
+func (f *File) notRun() string {
+	ranges := []string{}
+	start, end := 0, 0
+	flush := func() {
+		switch {
+		case start == 0:
+		case start == end:
+			ranges = append(ranges, strconv.Itoa(start))
+		default:
+			ranges = append(ranges, fmt.Sprintf("%d-%d", start, end))
+		}
+		start = 0
+	}
+	for _, line := range f.Lines() {
+		if f.Counts[line] > 0 {
+			flush()
+			continue
+		}
+		if start == 0 {
+			start = line
+		}
+		end = line
+	}
+	flush()
+	return strings.Join(ranges, ", ")
+}
+
+// WriteProfile writes c to w in the format of Go coverage profiles, so tools
+// that read them can show it. Every line of code is a block of one statement
+// that spans the whole line.
+func (c *Coverage) WriteProfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mode: count")
+	for _, f := range c.Files {
+		for _, line := range f.Lines() {
+			fmt.Fprintf(bw, "%s:%d.1,%d.1 1 %d\n", f.Name, line, line+1, f.Counts[line])
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,167 @@
+package coverage
+
+import (
+	"VMtranslator/codewriter"
+	"VMtranslator/hack"
+	"VMtranslator/parser"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// source skips the push of 2: lines 3 and 4 never run.
+var source = strings.Join([]string{
+	"push constant 1",
+	"if-goto SKIP",
+	"push constant 2",
+	"pop temp 0",
+	"label SKIP",
+	"",
+	"push constant 3",
+}, "\n") + "\n"
+
+// cover translates source as Test.vm, runs it to its end and returns its
+// coverage and the assembly it was translated to.
+func cover(t *testing.T) (*Coverage, string) {
+	cmds, err := parser.NewParser(strings.NewReader(source)).ParseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "Test.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw := codewriter.NewCodeWriter(f)
+	cw.EnableSourceMap()
+	cw.SetFileName("Test.vm")
+	for _, cmd := range cmds {
+		if err := cw.Write(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	asm, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := hack.Assemble(bytes.NewReader(asm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := hack.NewCPU(prog.ROM)
+	cpu.RAM[0] = 256
+	cpu.Coverage = make([]uint64, len(prog.ROM))
+	cpu.Run(1000)
+	for cpu.Step() {
+	}
+	return New("Test.asm", prog, cw.SourceMap(), cpu.Coverage), string(asm)
+}
+
+func TestNew(t *testing.T) {
+	c, _ := cover(t)
+	if len(c.Files) != 2 || c.Files[0].Name != "Test.asm" || c.Files[1].Name != "Test.vm" {
+		t.Fatalf("expected Test.asm and Test.vm got %v", c.Files)
+	}
+	vm := c.Files[1]
+	expected := map[int]uint64{1: 1, 2: 1, 3: 0, 4: 0, 7: 1}
+	for line, count := range expected {
+		if actual, ok := vm.Counts[line]; !ok || actual != count {
+			t.Errorf("expected line %d to run %d times got %d (%t)", line, count, actual, ok)
+		}
+	}
+	if len(vm.Counts) != len(expected) {
+		t.Errorf("expected %d lines of code got %v", len(expected), vm.Counts)
+	}
+	if vm.Covered() != 3 || vm.Percent() != 60 {
+		t.Errorf("expected 3 lines covered, 60%% got %d, %.1f%%", vm.Covered(), vm.Percent())
+	}
+	asm := c.Files[0]
+	if asm.Covered() == 0 || asm.Covered() == len(asm.Counts) {
+		t.Errorf("expected part of the assembly to run got %d of %d lines", asm.Covered(), len(asm.Counts))
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	c, _ := cover(t)
+	var buf bytes.Buffer
+	if err := c.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"file        lines  covered\n",
+		"Test.vm         5    60.0%\n",
+		"\nNot run:\nTest.asm: ",
+		"\nTest.vm: 3-4\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the report to contain %q\n%s", expected, buf.String())
+		}
+	}
+
+	full := &Coverage{Files: []*File{{Name: "Full.vm", Counts: map[int]uint64{1: 2}}}}
+	buf.Reset()
+	full.WriteText(&buf)
+	if strings.Contains(buf.String(), "Not run") {
+		t.Errorf("expected no lines that didn't run got\n%s", buf.String())
+	}
+}
+
+func TestNotRun(t *testing.T) {
+	f := &File{Counts: map[int]uint64{1: 0, 2: 1, 3: 0, 5: 0, 6: 0, 9: 4, 10: 0}}
+	if actual := f.notRun(); actual != "1, 3-6, 10" {
+		t.Errorf("expected 1, 3-6, 10 got %q", actual)
+	}
+}
+
+func TestWriteProfile(t *testing.T) {
+	c, _ := cover(t)
+	var buf bytes.Buffer
+	if err := c.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "mode: count\n") {
+		t.Errorf("expected the profile to start with its mode got\n%s", buf.String())
+	}
+	expected := "Test.vm:1.1,2.1 1 1\nTest.vm:2.1,3.1 1 1\nTest.vm:3.1,4.1 1 0\nTest.vm:4.1,5.1 1 0\nTest.vm:7.1,8.1 1 1\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("expected the profile to end with\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	c, asm := cover(t)
+	var buf bytes.Buffer
+	err := c.WriteHTML(&buf, func(name string) ([]byte, error) {
+		switch name {
+		case "Test.asm":
+			return []byte(asm), nil
+		case "Test.vm":
+			return []byte(source), nil
+		}
+		return nil, fmt.Errorf("unexpected file %s", name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<option value="file1">Test.vm (60.0%)</option>`,
+		`<span class="cov"><span class="num">1</span><span class="count">1</span>push constant 1</span>`,
+		`<span class="uncov"><span class="num">3</span><span class="count">0</span>push constant 2</span>`,
+		`<span><span class="num">6</span><span class="count"></span></span>`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected the page to contain %q", expected)
+		}
+	}
+
+	err = c.WriteHTML(&buf, func(name string) ([]byte, error) { return nil, fmt.Errorf("no %s", name) })
+	if err == nil || err.Error() != "no Test.asm" {
+		t.Errorf("expected the error reading Test.asm got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlFile is a file as the HTML report shows it.
+type htmlFile struct {
+	Name    string
+	Percent string
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Count  string // empty for lines without code
+	Class  string // cov, uncov or empty for lines without code
+	Text   string
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { background: #fff; color: #222; font-family: sans-serif; margin: 0; }
+#topbar { background: #eee; padding: 8px; position: sticky; top: 0; }
+pre { font-family: monospace; margin: 0; padding: 8px; }
+.num, .count { color: #999; display: inline-block; text-align: right; user-select: none; }
+.num { width: 5em; }
+.count { width: 8em; margin-right: 1em; }
+.cov { background: #d6f5d6; }
+.uncov { background: #f8d4d4; }
+.file { display: none; }
+.file.shown { display: block; }
+</style>
+</head>
+<body>
+<div id="topbar">
+<select id="files">
+{{range $i, $f := .}}<option value="file{{$i}}">{{$f.Name}} ({{$f.Percent}})</option>
+{{end}}</select>
+<span class="cov">&nbsp;ran&nbsp;</span> <span class="uncov">&nbsp;not run&nbsp;</span>
+</div>
+{{range $i, $f := .}}<pre class="file{{if eq $i 0}} shown{{end}}" id="file{{$i}}">
+{{range $f.Lines}}<span{{if .Class}} class="{{.Class}}"{{end}}><span class="num">{{.Number}}</span><span class="count">{{.Count}}</span>{{.Text}}</span>
+{{end}}</pre>
+{{end}}<script>
+document.getElementById("files").addEventListener("change", function(e) {
+	document.querySelector(".file.shown").classList.remove("shown");
+	document.getElementById(e.target.value).classList.add("shown");
+});
+</script>
+</body>
+</html>
+`))
+
+// WriteHTML writes c to w as an HTML page that shows the source of one file
+// at a time, with every line of code marked as run or not and the times it
+// ran. read returns the source of the file called name.
+func (c *Coverage) WriteHTML(w io.Writer, read func(name string) ([]byte, error)) error {
+	files := []htmlFile{}
+	for _, f := range c.Files {
+		src, err := read(f.Name)
+		if err != nil {
+			return err
+		}
+		hf := htmlFile{Name: f.Name, Percent: fmt.Sprintf("%.1f%%", f.Percent())}
+		scanner := bufio.NewScanner(strings.NewReader(string(src)))
+		scanner.Buffer(nil, 1<<20)
+		for number := 1; scanner.Scan(); number++ {
+			line := htmlLine{Number: number, Text: scanner.Text()}
+			if count, ok := f.Counts[number]; ok {
+				line.Count = fmt.Sprint(count)
+				line.Class = "uncov"
+				if count > 0 {
+					line.Class = "cov"
+				}
+			}
+			hf.Lines = append(hf.Lines, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		files = append(files, hf)
+	}
+	return htmlTemplate.Execute(w, files)
+}
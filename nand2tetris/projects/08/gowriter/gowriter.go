@@ -0,0 +1,323 @@
+// Package gowriter is a backend that translates VM programs to a self-contained
+// Go program. The program keeps the Hack memory layout, so the VM code behaves
+// exactly as it does on the CPU emulator, but runs natively. The OS functions
+// that need hardware are replaced by hooks printing to stdout, reading from
+// stdin and drawing into screen memory that can be saved as a PNG.
+package gowriter
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+)
+
+// StaticCapacity is the number of static variables that fit between RAM[16] and
+// the stack at 256.
+const StaticCapacity = 256 - 16
+
+// GoWriter is the Go backend. The program is written out by Close because it
+// can only be completed once every function is known.
+type GoWriter struct {
+	w        io.Writer
+	fileName string // name of the file currently being translated
+	files    []string
+
+	top       *function   // commands outside any function
+	functions []*function // functions in the order they are defined
+	curr      *function   // function the commands are currently added to
+	topFirst  bool        // the program starts with commands outside any function
+
+	ids     map[string]int // Go function index of every VM function defined or called
+	calls   map[string]parser.Command
+	statics map[string]int // RAM address of every static variable, by "file.index"
+}
+
+// function is the Go code generated for one VM function.
+type function struct {
+	name   string
+	body   strings.Builder
+	cases  map[string]int            // switch case of every label defined or jumped to
+	gotos  map[string]parser.Command // first jump to every label
+	labels map[string]bool           // labels defined so far
+	last   string                    // label defined by the previous command, if any
+}
+
+func newFunction(name string) *function {
+	f := &function{
+		name:   name,
+		cases:  map[string]int{},
+		gotos:  map[string]parser.Command{},
+		labels: map[string]bool{},
+	}
+	f.body.WriteString("case 0:\n")
+	return f
+}
+
+func NewGoWriter(w io.Writer) *GoWriter {
+	return &GoWriter{
+		w:       w,
+		ids:     map[string]int{},
+		calls:   map[string]parser.Command{},
+		statics: map[string]int{},
+	}
+}
+
+func (gw *GoWriter) SetFileName(fileName string) {
+	gw.fileName = fileName
+	gw.files = append(gw.files, fileName)
+	gw.curr = nil
+}
+
+// id returns the index of the Go function for the VM function name.
+func (gw *GoWriter) id(name string) int {
+	if id, ok := gw.ids[name]; ok {
+		return id
+	}
+	gw.ids[name] = len(gw.ids)
+	return gw.ids[name]
+}
+
+// label returns the switch case for label in the current function. Labels of
+// code outside functions are scoped to their file.
+func (gw *GoWriter) label(label string) int {
+	if gw.curr == gw.top {
+		label = gw.fileName + "." + label
+	}
+	if n, ok := gw.curr.cases[label]; ok {
+		return n
+	}
+	gw.curr.cases[label] = len(gw.curr.cases) + 1
+	return gw.curr.cases[label]
+}
+
+// segmentAddress returns a Go expression for the RAM address of segment[index].
+func (gw *GoWriter) segmentAddress(segment string, index int) (string, error) {
+	switch segment {
+	case "local":
+		return fmt.Sprintf("uint16(ram[LCL]+%d)", index), nil
+	case "argument":
+		return fmt.Sprintf("uint16(ram[ARG]+%d)", index), nil
+	case "this":
+		return fmt.Sprintf("uint16(ram[THIS]+%d)", index), nil
+	case "that":
+		return fmt.Sprintf("uint16(ram[THAT]+%d)", index), nil
+	case "pointer":
+		return fmt.Sprint(3 + index), nil
+	case "temp":
+		return fmt.Sprint(5 + index), nil
+	case "static":
+		// Statics get addresses from 16 in order of appearance, like the
+		// variables of the Hack assembler.
+		name := fmt.Sprintf("%s.%d", strings.TrimSuffix(gw.fileName, ".vm"), index)
+		if _, ok := gw.statics[name]; !ok {
+			gw.statics[name] = 16 + len(gw.statics)
+		}
+		return fmt.Sprint(gw.statics[name]), nil
+	}
+	return "", fmt.Errorf("unknown segment %q", segment)
+}
+
+func (gw *GoWriter) Write(cmd parser.Command) error {
+	if cmd.Type == parser.C_FUNCTION {
+		if err := gw.endFunction(); err != nil {
+			return err
+		}
+		gw.curr = newFunction(cmd.Arg1)
+		gw.id(cmd.Arg1)
+		gw.functions = append(gw.functions, gw.curr)
+		fmt.Fprintf(&gw.curr.body, "locals(%d)\n", cmd.Arg2)
+		return nil
+	}
+	if gw.curr == nil {
+		if gw.top == nil {
+			gw.top = newFunction("")
+			gw.topFirst = len(gw.functions) == 0
+		}
+		gw.curr = gw.top
+	}
+
+	if err := parser.CheckPushPop(cmd); err != nil {
+		return fmt.Errorf("%s:%d: %v", cmd.File, cmd.Pos.Line, err)
+	}
+
+	f := gw.curr
+	last := f.last
+	f.last = ""
+	switch cmd.Type {
+	case parser.C_ARITHMETIC:
+		switch cmd.Arg1 {
+		case "add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not":
+			fmt.Fprintf(&f.body, "%s()\n", cmd.Arg1)
+		default:
+			return fmt.Errorf("%s:%d: unknown command %q", cmd.File, cmd.Pos.Line, cmd.Arg1)
+		}
+
+	case parser.C_PUSH:
+		if cmd.Arg1 == "constant" {
+			fmt.Fprintf(&f.body, "push(%d)\n", cmd.Arg2)
+			break
+		}
+		address, err := gw.segmentAddress(cmd.Arg1, cmd.Arg2)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", cmd.File, cmd.Pos.Line, err)
+		}
+		fmt.Fprintf(&f.body, "push(ram[%s])\n", address)
+
+	case parser.C_POP:
+		address, err := gw.segmentAddress(cmd.Arg1, cmd.Arg2)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", cmd.File, cmd.Pos.Line, err)
+		}
+		fmt.Fprintf(&f.body, "ram[%s] = pop()\n", address)
+
+	case parser.C_LABEL:
+		key := cmd.Arg1
+		if f == gw.top {
+			key = gw.fileName + "." + key
+		}
+		f.labels[key] = true
+		fmt.Fprintf(&f.body, "fallthrough\ncase %d: // label %s\n", gw.label(cmd.Arg1), cmd.Arg1)
+		f.last = cmd.Arg1
+
+	case parser.C_GOTO:
+		if last == cmd.Arg1 {
+			// a label jumping to itself is how VM programs stop
+			f.body.WriteString("halt()\n")
+			break
+		}
+		gw.jump(cmd)
+		fmt.Fprintf(&f.body, "pc = %d // goto %s\ncontinue\n", gw.label(cmd.Arg1), cmd.Arg1)
+
+	case parser.C_IF:
+		gw.jump(cmd)
+		fmt.Fprintf(&f.body, "if pop() != 0 {\npc = %d // if-goto %s\ncontinue\n}\n", gw.label(cmd.Arg1), cmd.Arg1)
+
+	case parser.C_CALL:
+		if h, ok := hooks[cmd.Arg1]; ok {
+			fmt.Fprintf(&f.body, "callHook(%s, %d)\n", h, cmd.Arg2)
+			break
+		}
+		if _, ok := gw.calls[cmd.Arg1]; !ok {
+			gw.calls[cmd.Arg1] = cmd
+		}
+		fmt.Fprintf(&f.body, "call(f%d, %d) // %s\n", gw.id(cmd.Arg1), cmd.Arg2, cmd.Arg1)
+
+	case parser.C_RETURN:
+		f.body.WriteString("ret()\nreturn\n")
+
+	default:
+		return fmt.Errorf("%s:%d: unsupported command type %d", cmd.File, cmd.Pos.Line, int(cmd.Type))
+	}
+	return nil
+}
+
+// jump records the first jump to every label so endFunction can report the
+// ones that are never defined.
+func (gw *GoWriter) jump(cmd parser.Command) {
+	key := cmd.Arg1
+	if gw.curr == gw.top {
+		key = gw.fileName + "." + key
+	}
+	if _, ok := gw.curr.gotos[key]; !ok {
+		gw.curr.gotos[key] = cmd
+	}
+}
+
+// endFunction checks that every label the current function jumps to exists.
+func (gw *GoWriter) endFunction() error {
+	if gw.curr == nil || gw.curr == gw.top {
+		return nil
+	}
+	return undefinedLabels(gw.curr)
+}
+
+func undefinedLabels(f *function) error {
+	undefined := []string{}
+	for label, cmd := range f.gotos {
+		if !f.labels[label] {
+			undefined = append(undefined, fmt.Sprintf("%s:%d: %q jumps to undefined label %s", cmd.File, cmd.Pos.Line, cmd.String(), cmd.Arg1))
+		}
+	}
+	if len(undefined) != 0 {
+		sort.Strings(undefined)
+		return fmt.Errorf("%s", strings.Join(undefined, "\n"))
+	}
+	return nil
+}
+
+// writeFunction writes f as the Go function named goName. Every label is a case
+// of a switch on pc, so jumps set pc and restart the loop.
+func writeFunction(out *strings.Builder, goName string, f *function) {
+	if f.name != "" {
+		fmt.Fprintf(out, "// %s\n", f.name)
+	}
+	fmt.Fprintf(out, "func %s() {\npc := 0\nfor {\nswitch pc {\n%s}\nreturn\n}\n}\n\n", goName, f.body.String())
+}
+
+// Close writes the program. The writer is left for the caller to close.
+func (gw *GoWriter) Close() error {
+	if err := gw.endFunction(); err != nil {
+		return err
+	}
+	if gw.top != nil {
+		if err := undefinedLabels(gw.top); err != nil {
+			return err
+		}
+	}
+
+	defined := map[string]bool{}
+	for _, f := range gw.functions {
+		defined[f.name] = true
+	}
+	undefined := []string{}
+	for name, cmd := range gw.calls {
+		if !defined[name] {
+			undefined = append(undefined, fmt.Sprintf("%s:%d: call to undefined function %s", cmd.File, cmd.Pos.Line, name))
+		}
+	}
+	if len(undefined) != 0 {
+		sort.Strings(undefined)
+		return fmt.Errorf("%s", strings.Join(undefined, "\n"))
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by VMtranslator from %s. DO NOT EDIT.\n\n", strings.Join(gw.files, ", "))
+	out.WriteString(runtimeSource)
+	out.WriteString("\n")
+
+	for _, f := range gw.functions {
+		writeFunction(&out, fmt.Sprintf("f%d", gw.ids[f.name]), f)
+	}
+	if gw.top != nil {
+		writeFunction(&out, "top", gw.top)
+	}
+
+	// vmFunctions is filled in by init to avoid an initialization cycle through the hooks
+	out.WriteString("func init() {\n")
+	for _, f := range gw.functions {
+		fmt.Fprintf(&out, "vmFunctions[%q] = f%d\n", f.name, gw.ids[f.name])
+	}
+	out.WriteString("}\n\n")
+
+	out.WriteString("func entry() {\n")
+	switch {
+	case defined["Sys.init"]:
+		fmt.Fprintf(&out, "ram[SP] = 256\ncall(f%d, 0) // Sys.init\n", gw.ids["Sys.init"])
+	case gw.topFirst:
+		out.WriteString("top()\n")
+	case len(gw.functions) != 0:
+		// Like the CPU emulator, run the code of the first function without a call.
+		fmt.Fprintf(&out, "f%d() // %s\n", gw.ids[gw.functions[0].name], gw.functions[0].name)
+	}
+	out.WriteString("}\n")
+
+	src, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return fmt.Errorf("formatting generated program: %v", err)
+	}
+	_, err = gw.w.Write(src)
+	return err
+}
@@ -0,0 +1,273 @@
+package gowriter
+
+import (
+	"VMtranslator/backend"
+	"VMtranslator/parser"
+	"fmt"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// translate writes the Go program for the .vm files at paths, builds it and
+// returns the path of the executable.
+func translate(t *testing.T, paths []string) string {
+	dir := t.TempDir()
+	src, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	files := []backend.File{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds, err := parser.NewParser(f).ParseAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, backend.File{Name: filepath.Base(path), Commands: cmds})
+	}
+	gw := NewGoWriter(src)
+	if err := backend.Translate(gw, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(dir, "program")
+	build := exec.Command("go", "build", "-o", exe, src.Name())
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("could not build the generated program: %v\n%s", err, output)
+	}
+	return exe
+}
+
+// loadTestScript returns the -set flags for the RAM initialized by a CPUEmulator
+// test script and the RAM values its .cmp file expects.
+func loadTestScript(t *testing.T, tstPath string) ([]string, map[int]int) {
+	script, err := os.ReadFile(tstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{}
+	for _, line := range strings.Split(string(script), "\n") {
+		var address, value int
+		if n, _ := fmt.Sscanf(strings.TrimSpace(line), "set RAM[%d] %d", &address, &value); n == 2 {
+			args = append(args, "-set", fmt.Sprintf("%d=%d", address, value))
+		}
+	}
+
+	cmp, err := os.ReadFile(strings.TrimSuffix(tstPath, ".tst") + ".cmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]int{}
+	rows := strings.Split(strings.TrimSpace(string(cmp)), "\n")
+	for i := 0; i+1 < len(rows); i += 2 {
+		header, values := strings.Split(rows[i], "|"), strings.Split(rows[i+1], "|")
+		for j := range header {
+			var address, value int
+			if _, err := fmt.Sscanf(strings.TrimSpace(header[j]), "RAM[%d]", &address); err != nil {
+				continue
+			}
+			if _, err := fmt.Sscanf(strings.TrimSpace(values[j]), "%d", &value); err != nil {
+				t.Fatalf("could not read value of RAM[%d] from %s: %v", address, tstPath, err)
+			}
+			expected[address] = value
+		}
+	}
+	return args, expected
+}
+
+func TestBookPrograms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		script string
+		input  []string
+	}{
+		{"SimpleAdd", "../StackArithmetic/SimpleAdd/SimpleAdd.tst", []string{"../StackArithmetic/SimpleAdd/SimpleAdd.vm"}},
+		{"StackTest", "../StackArithmetic/StackTest/StackTest.tst", []string{"../StackArithmetic/StackTest/StackTest.vm"}},
+		{"BasicTest", "../MemoryAccess/BasicTest/BasicTest.tst", []string{"../MemoryAccess/BasicTest/BasicTest.vm"}},
+		{"PointerTest", "../MemoryAccess/PointerTest/PointerTest.tst", []string{"../MemoryAccess/PointerTest/PointerTest.vm"}},
+		{"StaticTest", "../MemoryAccess/StaticTest/StaticTest.tst", []string{"../MemoryAccess/StaticTest/StaticTest.vm"}},
+		{"BasicLoop", "../ProgramFlow/BasicLoop/BasicLoop.tst", []string{"../ProgramFlow/BasicLoop/BasicLoop.vm"}},
+		{"FibonacciSeries", "../ProgramFlow/FibonacciSeries/FibonacciSeries.tst", []string{"../ProgramFlow/FibonacciSeries/FibonacciSeries.vm"}},
+		{"SimpleFunction", "../FunctionCalls/SimpleFunction/SimpleFunction.tst", []string{"../FunctionCalls/SimpleFunction/SimpleFunction.vm"}},
+		{"NestedCall", "../FunctionCalls/NestedCall/NestedCall.tst", []string{"../FunctionCalls/NestedCall/Sys.vm"}},
+		{"FibonacciElement", "../FunctionCalls/FibonacciElement/FibonacciElement.tst", []string{"../FunctionCalls/FibonacciElement/Main.vm", "../FunctionCalls/FibonacciElement/Sys.vm"}},
+		{"StaticsTest", "../FunctionCalls/StaticsTest/StaticsTest.tst", []string{"../FunctionCalls/StaticsTest/Class1.vm", "../FunctionCalls/StaticsTest/Class2.vm", "../FunctionCalls/StaticsTest/Sys.vm"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			exe := translate(t, test.input)
+			args, expected := loadTestScript(t, test.script)
+			for address := range expected {
+				args = append(args, "-print", fmt.Sprint(address))
+			}
+
+			output, err := exec.Command(exe, args...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v\n%s", err, output)
+			}
+			actual := map[int]int{}
+			for _, line := range strings.Fields(string(output)) {
+				var address, value int
+				if _, err := fmt.Sscanf(line, "RAM[%d]=%d", &address, &value); err != nil {
+					t.Fatalf("unexpected output %q", line)
+				}
+				actual[address] = value
+			}
+			for address, value := range expected {
+				if actual[address] != value {
+					t.Errorf("RAM[%d]: expected %d got %d", address, value, actual[address])
+				}
+			}
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	vm := filepath.Join(dir, "Main.vm")
+	program := strings.Join([]string{
+		"function Sys.init 0",
+		"push constant 42",
+		"call Output.printInt 1",
+		"pop temp 0",
+		"call Output.println 0",
+		"pop temp 0",
+		"push constant 72",
+		"call Output.printChar 1",
+		"pop temp 0",
+		"push constant 3",
+		"push constant 2",
+		"call Screen.drawPixel 2",
+		"pop temp 0",
+		"push constant 10",
+		"push constant 10",
+		"push constant 20",
+		"push constant 12",
+		"call Screen.drawRectangle 4",
+		"pop temp 0",
+		"call Sys.halt 0",
+		"pop temp 0",
+		"push constant 1",
+		"call Sys.error 1",
+		"return",
+		"",
+	}, "\n")
+	if err := os.WriteFile(vm, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := translate(t, []string{vm})
+	screen := filepath.Join(dir, "screen.png")
+	output, err := exec.Command(exe, "-png", screen).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if string(output) != "42\nH" {
+		t.Errorf("expected %q on stdout got %q", "42\nH", output)
+	}
+
+	f, err := os.Open(screen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	black := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r == 0
+	}
+	for _, p := range [][2]int{{3, 2}, {10, 10}, {20, 12}, {15, 11}} {
+		if !black(p[0], p[1]) {
+			t.Errorf("expected pixel %v to be drawn", p)
+		}
+	}
+	for _, p := range [][2]int{{0, 0}, {4, 2}, {21, 12}, {15, 13}} {
+		if black(p[0], p[1]) {
+			t.Errorf("expected pixel %v to be blank", p)
+		}
+	}
+}
+
+func TestHookWithManyArgs(t *testing.T) {
+	t.Parallel()
+	vm := filepath.Join(t.TempDir(), "Main.vm")
+	// a hook only uses the arguments it knows, but gets and pops all of them
+	program := "function Sys.init 0\npush constant 7\n"
+	for i := 1; i <= 10; i++ {
+		program += fmt.Sprintf("push constant %d\n", i)
+	}
+	program += "call Output.printInt 10\npop temp 0\ncall Output.printInt 1\npop temp 0\ncall Sys.halt 0\nreturn\n"
+	if err := os.WriteFile(vm, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(translate(t, []string{vm})).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	if string(output) != "17" {
+		t.Errorf("expected %q on stdout got %q", "17", output)
+	}
+}
+
+func TestUndefinedFunctionsAndLabels(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		program string
+		errMsg  string
+	}{
+		{"undefined function", "function Main.main 0\ncall Main.missing 0\nreturn\n", "Main.vm:2: call to undefined function Main.missing"},
+		{"undefined label", "function Main.main 0\ngoto END\nreturn\n", "Main.vm:2: \"goto END\" jumps to undefined label END"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			vm := filepath.Join(t.TempDir(), "Main.vm")
+			if err := os.WriteFile(vm, []byte(test.program), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(vm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			cmds, err := parser.NewParser(f).ParseAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var output strings.Builder
+			gw := NewGoWriter(&output)
+			err = backend.Translate(gw, []backend.File{{Name: "Main.vm", Commands: cmds}})
+			if err == nil {
+				err = gw.Close()
+			}
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("expected error %q got %v", test.errMsg, err)
+			}
+		})
+	}
+}
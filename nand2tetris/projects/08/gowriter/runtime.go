@@ -0,0 +1,419 @@
+package gowriter
+
+// hooks maps the OS functions the generated program implements natively to the
+// Go function implementing them. Calls to them never reach a VM definition, so
+// programs can be linked with or without the OS .vm files.
+var hooks = map[string]string{
+	"Output.init":          "outputInit",
+	"Output.moveCursor":    "outputMoveCursor",
+	"Output.printChar":     "outputPrintChar",
+	"Output.printString":   "outputPrintString",
+	"Output.printInt":      "outputPrintInt",
+	"Output.println":       "outputPrintln",
+	"Output.backSpace":     "outputBackSpace",
+	"Screen.init":          "screenInit",
+	"Screen.clearScreen":   "screenClearScreen",
+	"Screen.setColor":      "screenSetColor",
+	"Screen.drawPixel":     "screenDrawPixel",
+	"Screen.drawLine":      "screenDrawLine",
+	"Screen.drawRectangle": "screenDrawRectangle",
+	"Screen.drawCircle":    "screenDrawCircle",
+	"Keyboard.init":        "keyboardInit",
+	"Keyboard.keyPressed":  "keyboardKeyPressed",
+	"Keyboard.readChar":    "keyboardReadChar",
+	"Keyboard.readLine":    "keyboardReadLine",
+	"Keyboard.readInt":     "keyboardReadInt",
+	"Sys.halt":             "sysHalt",
+	"Sys.error":            "sysError",
+	"Sys.wait":             "sysWait",
+}
+
+// Invoked lists the VM functions the hooks call with invoke: Output.printString
+// reads the string it prints and Keyboard.readLine builds the one it returns.
+// No VM code calls them for the hooks, so whole-program passes must keep them.
+var Invoked = []string{"String.length", "String.charAt", "String.new", "String.appendChar"}
+
+// runtimeSource is the part of every generated program that doesn't depend on
+// the VM code: memory, the stack operations, calls, the OS hooks and main.
+const runtimeSource = `package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+
+	screenBase = 16384
+	screenEnd  = 24576
+)
+
+var ram [65536]int16
+
+func push(v int16) {
+	ram[uint16(ram[SP])] = v
+	ram[SP]++
+}
+
+func pop() int16 {
+	ram[SP]--
+	return ram[uint16(ram[SP])]
+}
+
+func truth(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+func add() { sp := uint16(ram[SP]); ram[sp-2] += ram[sp-1]; ram[SP]-- }
+func sub() { sp := uint16(ram[SP]); ram[sp-2] -= ram[sp-1]; ram[SP]-- }
+func and() { sp := uint16(ram[SP]); ram[sp-2] &= ram[sp-1]; ram[SP]-- }
+func or()  { sp := uint16(ram[SP]); ram[sp-2] |= ram[sp-1]; ram[SP]-- }
+func eq()  { sp := uint16(ram[SP]); ram[sp-2] = truth(ram[sp-2] == ram[sp-1]); ram[SP]-- }
+func gt()  { sp := uint16(ram[SP]); ram[sp-2] = truth(ram[sp-2] > ram[sp-1]); ram[SP]-- }
+func lt()  { sp := uint16(ram[SP]); ram[sp-2] = truth(ram[sp-2] < ram[sp-1]); ram[SP]-- }
+func neg() { sp := uint16(ram[SP]); ram[sp-1] = -ram[sp-1] }
+func not() { sp := uint16(ram[SP]); ram[sp-1] = ^ram[sp-1] }
+
+// call builds the frame of a VM call and runs f. There is no return address to
+// save: the Go call stack returns to the caller, so 0 is pushed in its place.
+func call(f func(), nArgs int16) {
+	push(0)
+	push(ram[LCL])
+	push(ram[ARG])
+	push(ram[THIS])
+	push(ram[THAT])
+	ram[ARG] = ram[SP] - nArgs - 5
+	ram[LCL] = ram[SP]
+	f()
+}
+
+// locals pushes the n local variables of a function.
+func locals(n int) {
+	for i := 0; i < n; i++ {
+		push(0)
+	}
+}
+
+// ret returns from the current function to its caller's frame.
+func ret() {
+	frame := ram[LCL]
+	ram[uint16(ram[ARG])] = pop()
+	ram[SP] = ram[ARG] + 1
+	ram[THAT] = ram[uint16(frame-1)]
+	ram[THIS] = ram[uint16(frame-2)]
+	ram[ARG] = ram[uint16(frame-3)]
+	ram[LCL] = ram[uint16(frame-4)]
+}
+
+// A hook implements an OS function natively. It gets the arguments of the call
+// and returns the value pushed in its place.
+type hook func(args []int16) int16
+
+func callHook(h hook, nArgs int) {
+	args := make([]int16, nArgs)
+	sp := int(uint16(ram[SP]))
+	copy(args, ram[sp-nArgs:sp])
+	ram[SP] -= int16(nArgs)
+	push(h(args))
+}
+
+// vmFunctions holds the functions of the program hooks can call, by VM name.
+var vmFunctions = map[string]func(){}
+
+// invoke calls the VM function name from a hook.
+func invoke(name string, args ...int16) int16 {
+	f, ok := vmFunctions[name]
+	if !ok {
+		fail("%s is not defined, translate the program together with the OS", name)
+	}
+	for _, arg := range args {
+		push(arg)
+	}
+	call(f, int16(len(args)))
+	return pop()
+}
+
+var stdout = bufio.NewWriter(os.Stdout)
+var stdin = bufio.NewReader(os.Stdin)
+
+func printChar(c int16) {
+	switch c {
+	case 128:
+		stdout.WriteByte('\n')
+	case 129:
+		stdout.WriteByte('\b')
+	default:
+		stdout.WriteByte(byte(c))
+	}
+}
+
+func printString(s int16) {
+	n := invoke("String.length", s)
+	for i := int16(0); i < n; i++ {
+		printChar(invoke("String.charAt", s, i))
+	}
+}
+
+func outputInit(args []int16) int16       { return 0 }
+func outputMoveCursor(args []int16) int16 { return 0 }
+func outputPrintChar(args []int16) int16  { printChar(args[0]); return 0 }
+func outputPrintString(args []int16) int16 { printString(args[0]); return 0 }
+func outputPrintInt(args []int16) int16 {
+	stdout.WriteString(strconv.Itoa(int(args[0])))
+	return 0
+}
+func outputPrintln(args []int16) int16   { stdout.WriteByte('\n'); return 0 }
+func outputBackSpace(args []int16) int16 { stdout.WriteByte('\b'); return 0 }
+
+var screenColor = true
+
+func pixel(x, y int) {
+	if x < 0 || x >= 512 || y < 0 || y >= 256 {
+		return
+	}
+	address := screenBase + y*32 + x/16
+	bit := int16(1) << (x % 16)
+	if screenColor {
+		ram[address] |= bit
+	} else {
+		ram[address] &^= bit
+	}
+}
+
+func screenInit(args []int16) int16 { screenColor = true; return 0 }
+func screenClearScreen(args []int16) int16 {
+	for i := screenBase; i < screenEnd; i++ {
+		ram[i] = 0
+	}
+	return 0
+}
+func screenSetColor(args []int16) int16  { screenColor = args[0] != 0; return 0 }
+func screenDrawPixel(args []int16) int16 { pixel(int(args[0]), int(args[1])); return 0 }
+
+func screenDrawLine(args []int16) int16 {
+	x1, y1, x2, y2 := int(args[0]), int(args[1]), int(args[2]), int(args[3])
+	dx, dy := abs(x2-x1), -abs(y2-y1)
+	sx, sy := sign(x2-x1), sign(y2-y1)
+	e := dx + dy
+	for {
+		pixel(x1, y1)
+		if x1 == x2 && y1 == y2 {
+			return 0
+		}
+		if 2*e >= dy {
+			e += dy
+			x1 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y1 += sy
+		}
+	}
+}
+
+func screenDrawRectangle(args []int16) int16 {
+	for y := int(args[1]); y <= int(args[3]); y++ {
+		for x := int(args[0]); x <= int(args[2]); x++ {
+			pixel(x, y)
+		}
+	}
+	return 0
+}
+
+func screenDrawCircle(args []int16) int16 {
+	cx, cy, r := int(args[0]), int(args[1]), int(args[2])
+	for dy := -r; dy <= r; dy++ {
+		half := int(math.Sqrt(float64(r*r - dy*dy)))
+		for x := cx - half; x <= cx+half; x++ {
+			pixel(x, cy+dy)
+		}
+	}
+	return 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// Keyboard input comes from stdin. No key is ever held down.
+func keyboardInit(args []int16) int16       { return 0 }
+func keyboardKeyPressed(args []int16) int16 { return 0 }
+
+func keyboardReadChar(args []int16) int16 {
+	c, err := stdin.ReadByte()
+	if err != nil {
+		fail("reading a character: %v", err)
+	}
+	if c == '\n' {
+		return 128
+	}
+	return int16(c)
+}
+
+func readLine(message int16) string {
+	printString(message)
+	stdout.Flush()
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		fail("reading a line: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func keyboardReadLine(args []int16) int16 {
+	line := readLine(args[0])
+	capacity := len(line)
+	if capacity == 0 {
+		capacity = 1
+	}
+	s := invoke("String.new", int16(capacity))
+	for i := 0; i < len(line); i++ {
+		s = invoke("String.appendChar", s, int16(line[i]))
+	}
+	return s
+}
+
+func keyboardReadInt(args []int16) int16 {
+	n, _ := strconv.Atoi(strings.TrimSpace(readLine(args[0])))
+	return int16(n)
+}
+
+func sysHalt(args []int16) int16 { halt(); return 0 }
+func sysWait(args []int16) int16 { return 0 }
+func sysError(args []int16) int16 {
+	stdout.Flush()
+	fmt.Fprintf(os.Stderr, "ERR%d\n", args[0])
+	finish()
+	os.Exit(1)
+	return 0
+}
+
+var printRanges [][2]int
+var pngPath string
+
+// finish reports the state of the machine once the program stops.
+func finish() {
+	stdout.Flush()
+	for _, r := range printRanges {
+		for address := r[0]; address <= r[1]; address++ {
+			fmt.Printf("RAM[%d]=%d\n", address, ram[address])
+		}
+	}
+	if pngPath != "" {
+		if err := writePNG(pngPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func writePNG(path string) error {
+	img := image.NewGray(image.Rect(0, 0, 512, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 512; x++ {
+			c := color.Gray{Y: 255}
+			if ram[screenBase+y*32+x/16]&(1<<(x%16)) != 0 {
+				c = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// halt stops the program, like the infinite loop ending a Hack program.
+func halt() {
+	finish()
+	os.Exit(0)
+}
+
+func fail(format string, args ...interface{}) {
+	stdout.Flush()
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func parseAddress(s string) (int, error) {
+	address, err := strconv.Atoi(strings.TrimSpace(s))
+	if err == nil && (address < 0 || address >= len(ram)) {
+		err = fmt.Errorf("address %d out of range", address)
+	}
+	return address, err
+}
+
+func main() {
+	flag.Func("set", "set RAM[address] before running, as address=value", func(s string) error {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected address=value got %q", s)
+		}
+		address, err := parseAddress(parts[0])
+		if err != nil {
+			return err
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return err
+		}
+		ram[address] = int16(value)
+		return nil
+	})
+	flag.Func("print", "print RAM[address] or the range RAM[from-to] after running", func(s string) error {
+		parts := strings.SplitN(s, "-", 2)
+		from, err := parseAddress(parts[0])
+		if err != nil {
+			return err
+		}
+		to := from
+		if len(parts) == 2 {
+			if to, err = parseAddress(parts[1]); err != nil {
+				return err
+			}
+		}
+		printRanges = append(printRanges, [2]int{from, to})
+		return nil
+	})
+	flag.StringVar(&pngPath, "png", "", "write the screen to a PNG file after running")
+	flag.Parse()
+
+	entry()
+	finish()
+}
+`
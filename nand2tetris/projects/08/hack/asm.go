@@ -0,0 +1,106 @@
+// Package hack assembles and runs Hack machine language, so translated VM
+// programs can be executed and measured without the CPU emulator.
+package hack
+
+import (
+	"assembler/isa"
+	"assembler/parser"
+	"assembler/symboltable"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Program is an assembled Hack program.
+type Program struct {
+	ROM     []uint16
+	Symbols map[string]int // labels and variables, by name
+	Labels  map[string]int // the labels alone
+	Lines   []int          // line of the source each instruction was assembled from, starting at 1
+}
+
+// predefined holds the symbols every program starts with, like SP and SCREEN.
+// It's only read.
+var predefined = symboltable.NewSymbolTable()
+
+// statement is an instruction of the source before symbols are resolved.
+type statement struct {
+	line             int
+	address          bool // an A-instruction of symbol rather than a C-instruction
+	symbol           string
+	dest, comp, jump string
+}
+
+// Assemble reads Hack assembly from r and assembles it with the parser of
+// project 6. Variables are given addresses from 16 in order of first use, like
+// the book's assembler does.
+func Assemble(r io.Reader) (*Program, error) {
+	return AssembleWithISA(r, isa.Hack)
+}
+
+// AssembleWithISA reads assembly for the instruction set set from r and
+// assembles it like Assemble. The instructions of set must have 16 bits.
+func AssembleWithISA(r io.Reader, set *isa.ISA) (*Program, error) {
+	if set.WordBits != 16 {
+		return nil, fmt.Errorf("%s has %d bit instructions, not 16", set.Name, set.WordBits)
+	}
+	p := &Program{Symbols: map[string]int{}, Labels: map[string]int{}}
+
+	// the first pass records the address of every label
+	statements := []statement{}
+	ps := parser.NewParserWithISA(r, set)
+	for ps.HasMoreCommands() {
+		if err := ps.Advance(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", ps.Line(), err)
+		}
+		s := statement{line: ps.Line()}
+		switch ps.CommandType() {
+		case parser.L_COMMAND{}:
+			label, _ := ps.Symbol()
+			p.Symbols[label] = len(statements)
+			p.Labels[label] = len(statements)
+			continue
+		case parser.A_COMMAND{}:
+			s.address = true
+			s.symbol, _ = ps.Symbol()
+		case parser.C_COMMAND{}:
+			s.dest, _ = ps.Dest()
+			s.comp, _ = ps.Comp()
+			s.jump, _ = ps.Jump()
+		default:
+			continue
+		}
+		statements = append(statements, s)
+	}
+
+	nextVariable := 16
+	for _, s := range statements {
+		var word uint32
+		if s.address {
+			n, err := strconv.Atoi(s.symbol)
+			if err != nil {
+				address, ok := p.Symbols[s.symbol]
+				if !ok && predefined.Contains(s.symbol) {
+					address, ok = predefined.GetAddress(s.symbol), true
+				}
+				if !ok {
+					address = nextVariable
+					p.Symbols[s.symbol] = address
+					nextVariable += 1
+				}
+				n = address
+			}
+			if word, err = set.EncodeAddress(uint32(n)); err != nil {
+				return nil, fmt.Errorf("line %d: %v", s.line, err)
+			}
+		} else {
+			var err error
+			if word, err = set.Encode(s.dest, s.comp, s.jump); err != nil {
+				return nil, fmt.Errorf("line %d: %v", s.line, err)
+			}
+		}
+		p.ROM = append(p.ROM, uint16(word))
+		p.Lines = append(p.Lines, s.line)
+	}
+	return p, nil
+}
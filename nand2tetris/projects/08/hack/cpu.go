@@ -0,0 +1,255 @@
+package hack
+
+import (
+	"assembler/isa"
+	"fmt"
+)
+
+// RAMSize is the number of words of data memory, up to and including the keyboard.
+const RAMSize = 24577
+
+// KBD is the address of the keyboard's memory map.
+const KBD = 24576
+
+// CPU is a Hack computer running a program from its ROM.
+type CPU struct {
+	ROM    []uint16
+	RAM    [RAMSize]int16
+	A, D   int16
+	PC     uint16
+	Cycles uint64 // instructions executed so far
+
+	// Trace, if it isn't nil, is called with every instruction Step executes.
+	Trace func(Event)
+	// Coverage, if it isn't nil, counts the times Step executes the instruction
+	// at every ROM address. It must be as long as the ROM.
+	Coverage []uint64
+
+	decoded []isa.Instruction // rom decoded for the instruction set of NewCPUWithISA; nil for stock Hack
+}
+
+// NewCPU returns a CPU with cleared registers and memory that is about to run rom.
+func NewCPU(rom []uint16) *CPU {
+	return &CPU{ROM: rom}
+}
+
+// NewCPUWithISA returns a CPU like NewCPU that runs rom as instructions of
+// set, which must have 16 bits. It fails if a word of rom isn't an instruction
+// of set.
+func NewCPUWithISA(rom []uint16, set *isa.ISA) (*CPU, error) {
+	if set.WordBits != 16 {
+		return nil, fmt.Errorf("%s has %d bit instructions, not 16", set.Name, set.WordBits)
+	}
+	c := &CPU{ROM: rom, decoded: make([]isa.Instruction, len(rom))}
+	for address, word := range rom {
+		in, err := set.Decode(uint32(word))
+		if err != nil {
+			return nil, fmt.Errorf("ROM[%d]: %w", address, err)
+		}
+		c.decoded[address] = in
+	}
+	return c, nil
+}
+
+// read returns the word at address. Addresses past the keyboard read as 0.
+func (c *CPU) read(address uint16) int16 {
+	if int(address) >= RAMSize {
+		return 0
+	}
+	return c.RAM[address]
+}
+
+// write stores value at address. Writes past the keyboard are ignored.
+func (c *CPU) write(address uint16, value int16) {
+	if int(address) < RAMSize {
+		c.RAM[address] = value
+	}
+}
+
+// alu computes the comp field of a C-instruction: the a-bit and c1..c6.
+func (c *CPU) alu(comp uint16) int16 {
+	x, y := c.D, c.A
+	if comp&0x40 != 0 {
+		y = c.read(uint16(c.A))
+	}
+	if comp&0x20 != 0 { // zx
+		x = 0
+	}
+	if comp&0x10 != 0 { // nx
+		x = ^x
+	}
+	if comp&0x08 != 0 { // zy
+		y = 0
+	}
+	if comp&0x04 != 0 { // ny
+		y = ^y
+	}
+	var out int16
+	if comp&0x02 != 0 { // f
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if comp&0x01 != 0 { // no
+		out = ^out
+	}
+	return out
+}
+
+// Event is what an instruction did, as passed to CPU.Trace.
+type Event struct {
+	Cycle       uint64 // instructions executed before this one
+	PC          uint16
+	Instruction uint16
+	A, D        int16  // the registers after the instruction
+	Address     uint16 // the address of M, which is A before the instruction, if it's used
+	ReadsM      bool
+	WritesM     bool
+	Read        int16 // M before the instruction if it reads M
+	Written     int16 // the value stored in M if it writes M
+}
+
+// Step executes the instruction at PC. It does nothing and returns false if PC
+// is past the end of the program.
+func (c *CPU) Step() bool {
+	if int(c.PC) >= len(c.ROM) {
+		return false
+	}
+	if c.Coverage != nil {
+		c.Coverage[c.PC]++
+	}
+	if c.Trace == nil {
+		c.step()
+		return true
+	}
+
+	e := Event{Cycle: c.Cycles, PC: c.PC, Instruction: c.ROM[c.PC]}
+	e.ReadsM, e.WritesM = c.accessesM(c.PC)
+	if e.ReadsM || e.WritesM {
+		e.Address = uint16(c.A)
+	}
+	if e.ReadsM {
+		e.Read = c.read(e.Address)
+	}
+	out := c.step()
+	if e.WritesM {
+		e.Written = out
+	}
+	e.A, e.D = c.A, c.D
+	c.Trace(e)
+	return true
+}
+
+// accessesM reports whether the instruction at address reads and writes M.
+func (c *CPU) accessesM(address uint16) (reads, writes bool) {
+	if c.decoded != nil {
+		in := c.decoded[address]
+		return !in.Address && in.ReadsM, !in.Address && in.StoreM
+	}
+	ins := c.ROM[address]
+	if ins&0x8000 == 0 {
+		return false, false
+	}
+	return ins&0x1000 != 0, ins&0x08 != 0
+}
+
+// step executes the instruction at PC, which must be in the ROM, and returns
+// the result of its comp, or 0 for an A-instruction.
+func (c *CPU) step() int16 {
+	if c.decoded != nil {
+		return c.stepDecoded()
+	}
+	ins := c.ROM[c.PC]
+	c.Cycles += 1
+	if ins&0x8000 == 0 {
+		c.A = int16(ins)
+		c.PC += 1
+		return 0
+	}
+
+	out := c.alu(ins >> 6 & 0x7f)
+	// M and the jump target are the A register of before the instruction
+	address := uint16(c.A)
+	if ins&0x08 != 0 {
+		c.write(address, out)
+	}
+	if ins&0x20 != 0 {
+		c.A = out
+	}
+	if ins&0x10 != 0 {
+		c.D = out
+	}
+	jump := ins & 0x07
+	if (jump&0x04 != 0 && out < 0) || (jump&0x02 != 0 && out == 0) || (jump&0x01 != 0 && out > 0) {
+		c.PC = address
+	} else {
+		c.PC += 1
+	}
+	return out
+}
+
+// stepDecoded executes the decoded instruction at PC like step.
+func (c *CPU) stepDecoded() int16 {
+	in := &c.decoded[c.PC]
+	c.Cycles += 1
+	if in.Address {
+		c.A = int16(in.Value)
+		c.PC += 1
+		return 0
+	}
+
+	address := uint16(c.A)
+	var m int16
+	if in.ReadsM {
+		m = c.read(address)
+	}
+	out := int16(in.Compute(int32(c.D), int32(c.A), int32(m)))
+	if in.StoreM {
+		c.write(address, out)
+	}
+	if in.StoreA {
+		c.A = out
+	}
+	if in.StoreD {
+		c.D = out
+	}
+	if (in.JumpLT && out < 0) || (in.JumpEQ && out == 0) || (in.JumpGT && out > 0) {
+		c.PC = address
+	} else {
+		c.PC += 1
+	}
+	return out
+}
+
+// Halted reports whether the program has run past its end or is stuck in the
+// loop programs end with, a label that jumps to itself: (END) @END 0;JMP.
+func (c *CPU) Halted() bool {
+	pc := int(c.PC)
+	if pc >= len(c.ROM) {
+		return true
+	}
+	isJump := func(address int) bool {
+		if address >= len(c.ROM) {
+			return false
+		}
+		if c.decoded != nil {
+			in := c.decoded[address]
+			return in.JumpLT && in.JumpEQ && in.JumpGT
+		}
+		return c.ROM[address]&0x8007 == 0x8007
+	}
+	if c.ROM[pc] == uint16(pc) && isJump(pc+1) {
+		return true
+	}
+	return int(c.A) == pc-1 && pc > 0 && c.ROM[pc-1] == uint16(pc-1) && isJump(pc)
+}
+
+// Run executes instructions until the program halts or maxCycles have been
+// executed, and returns the number executed.
+func (c *CPU) Run(maxCycles uint64) uint64 {
+	start := c.Cycles
+	for c.Cycles-start < maxCycles && !c.Halted() {
+		c.Step()
+	}
+	return c.Cycles - start
+}
@@ -0,0 +1,302 @@
+package hack
+
+import (
+	"assembler/isa"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func assemble(t *testing.T, lines ...string) *Program {
+	p, err := Assemble(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAssemble(t *testing.T) {
+	t.Parallel()
+	p := assemble(t,
+		"// Computes R2 = max(R0, R1)",
+		"   @R0",
+		"   D=M              // D = first number",
+		"   @R1",
+		"   D=D-M",
+		"   @OUTPUT_FIRST",
+		"   D;JGT",
+		"   @R1",
+		"   D=M",
+		"   @OUTPUT_D",
+		"   0;JMP",
+		"(OUTPUT_FIRST)",
+		"   @R0",
+		"   D=M",
+		"(OUTPUT_D)",
+		"   @R2",
+		"   M=D",
+		"(INFINITE_LOOP)",
+		"   @INFINITE_LOOP",
+		"   0;JMP",
+		"   @counter",
+		"   AMD=!M",
+		"   @other",
+		"   MD=A+1;JLE",
+	)
+	expected := []string{
+		"0000000000000000", "1111110000010000", "0000000000000001", "1111010011010000",
+		"0000000000001010", "1110001100000001", "0000000000000001", "1111110000010000",
+		"0000000000001100", "1110101010000111", "0000000000000000", "1111110000010000",
+		"0000000000000010", "1110001100001000", "0000000000001110", "1110101010000111",
+		"0000000000010000", "1111110001111000", "0000000000010001", "1110110111011110",
+	}
+	actual := []string{}
+	for _, word := range p.ROM {
+		actual = append(actual, fmt.Sprintf("%016b", word))
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+	if p.Symbols["OUTPUT_D"] != 12 || p.Symbols["counter"] != 16 || p.Symbols["other"] != 17 {
+		t.Errorf("unexpected symbols %v", p.Symbols)
+	}
+	if p.Lines[0] != 2 || p.Lines[len(p.Lines)-1] != 24 {
+		t.Errorf("expected instructions from lines 2 to 24 got %v", p.Lines)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"@1\nD=D*A\n", `line 2: unknown comp "D*A"`},
+		{"X=D\n", `line 1: unknown dest "X"`},
+		{"0;JUMP\n", `line 1: unknown jump "JUMP"`},
+		{"(LOOP\n", `line 1: expected LABEL token while parsing L_COMMAND got: VALUE`},
+		{"@32768\n", `line 1: 32768 doesn't fit in an A-instruction of 16 bits`},
+	}
+	for _, test := range tests {
+		if _, err := Assemble(strings.NewReader(test.src)); err == nil || err.Error() != test.expected {
+			t.Errorf("%q: expected error %q got %v", test.src, test.expected, err)
+		}
+	}
+}
+
+func TestCPU(t *testing.T) {
+	t.Parallel()
+	// R2 = R0 * R1 by repeated addition
+	p := assemble(t,
+		"@R2", "M=0",
+		"(LOOP)",
+		"@R0", "D=M", "@END", "D;JEQ",
+		"@R1", "D=M", "@R2", "M=D+M",
+		"@R0", "M=M-1",
+		"@LOOP", "0;JMP",
+		"(END)",
+		"@END", "0;JMP",
+	)
+	for _, test := range []struct{ x, y int16 }{{0, 5}, {3, 4}, {7, -2}, {200, 300}} {
+		cpu := NewCPU(p.ROM)
+		cpu.RAM[0], cpu.RAM[1] = test.x, test.y
+		cycles := cpu.Run(1000000)
+		if !cpu.Halted() || cycles == 1000000 {
+			t.Errorf("%d*%d: expected the program to halt got PC %d after %d cycles", test.x, test.y, cpu.PC, cycles)
+		}
+		if cpu.RAM[2] != test.x*test.y {
+			t.Errorf("%d*%d: expected %d got %d", test.x, test.y, test.x*test.y, cpu.RAM[2])
+		}
+		if cpu.Cycles != cycles {
+			t.Errorf("expected Cycles to be %d got %d", cycles, cpu.Cycles)
+		}
+	}
+}
+
+func TestCPUHalts(t *testing.T) {
+	t.Parallel()
+	// running off the end
+	cpu := NewCPU(assemble(t, "@5", "D=A").ROM)
+	if cycles := cpu.Run(100); cycles != 2 || cpu.D != 5 || cpu.Step() {
+		t.Errorf("expected the program to stop after 2 cycles got %d", cycles)
+	}
+
+	// a loop that only jumps back to itself halts at either instruction
+	cpu = NewCPU(assemble(t, "(END)", "@END", "0;JMP").ROM)
+	if !cpu.Halted() {
+		t.Errorf("expected a program starting in its halting loop to be halted")
+	}
+	cpu.Step()
+	if !cpu.Halted() {
+		t.Errorf("expected the CPU to be halted between the instructions of the loop")
+	}
+
+	// loops that do work don't halt
+	cpu = NewCPU(assemble(t, "(LOOP)", "@R0", "M=M+1", "@LOOP", "0;JMP").ROM)
+	if cycles := cpu.Run(100); cycles != 100 || cpu.RAM[0] != 25 {
+		t.Errorf("expected 100 cycles and 25 iterations got %d and %d", cycles, cpu.RAM[0])
+	}
+}
+
+func TestCPUWithISA(t *testing.T) {
+	t.Parallel()
+	// the same multiplication as TestCPU, decoded from the stock table
+	p := assemble(t,
+		"@R2", "M=0",
+		"(LOOP)",
+		"@R0", "D=M", "@END", "D;JEQ",
+		"@R1", "D=M", "@R2", "M=D+M",
+		"@R0", "M=M-1",
+		"@LOOP", "0;JMP",
+		"(END)",
+		"@END", "0;JMP",
+	)
+	for _, test := range []struct{ x, y int16 }{{0, 5}, {3, 4}, {7, -2}, {200, 300}} {
+		stock := NewCPU(p.ROM)
+		table, err := NewCPUWithISA(p.ROM, isa.Hack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cpu := range []*CPU{stock, table} {
+			cpu.RAM[0], cpu.RAM[1] = test.x, test.y
+			cpu.Run(1000000)
+		}
+		if table.RAM != stock.RAM || table.Cycles != stock.Cycles || !table.Halted() {
+			t.Errorf("%d*%d: expected %d in %d cycles got %d in %d", test.x, test.y, stock.RAM[2], stock.Cycles, table.RAM[2], table.Cycles)
+		}
+	}
+
+	shift, err := isa.Load(strings.NewReader(`{"name": "shift", "base": "hack", "comp": {"D<<": "1010110000", "M>>": "1011000000"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "@R0\nD=M\nD=D<<\n@R1\nM=D\n@R2\nM=M>>\n"
+	if _, err := Assemble(strings.NewReader(src)); err == nil {
+		t.Error("expected stock Hack to have no shifts")
+	}
+	p, err = AssembleWithISA(strings.NewReader(src), shift)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu, err := NewCPUWithISA(p.ROM, shift)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu.RAM[0], cpu.RAM[2] = 21, -9
+	cpu.Run(100)
+	if cpu.RAM[1] != 42 || cpu.RAM[2] != -5 {
+		t.Errorf("expected R1=42 and R2=-5 got %d and %d", cpu.RAM[1], cpu.RAM[2])
+	}
+	if _, err := NewCPUWithISA(p.ROM, isa.Hack); err == nil {
+		t.Error("expected stock Hack not to decode a shift")
+	}
+}
+
+func TestCPUTrace(t *testing.T) {
+	t.Parallel()
+	p := assemble(t, "@R0", "D=M", "@R1", "M=D+M", "MD=M+1", "@7", "0;JMP")
+	table, err := NewCPUWithISA(p.ROM, isa.Hack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		{Cycle: 0, PC: 0, Instruction: 0, A: 0, D: 0},
+		{Cycle: 1, PC: 1, Instruction: p.ROM[1], A: 0, D: 3, Address: 0, ReadsM: true, Read: 3},
+		{Cycle: 2, PC: 2, Instruction: 1, A: 1, D: 3},
+		{Cycle: 3, PC: 3, Instruction: p.ROM[3], A: 1, D: 3, Address: 1, ReadsM: true, WritesM: true, Read: 4, Written: 7},
+		{Cycle: 4, PC: 4, Instruction: p.ROM[4], A: 1, D: 8, Address: 1, ReadsM: true, WritesM: true, Read: 7, Written: 8},
+		{Cycle: 5, PC: 5, Instruction: 7, A: 7, D: 8},
+		{Cycle: 6, PC: 6, Instruction: p.ROM[6], A: 7, D: 8},
+	}
+	for _, cpu := range []*CPU{NewCPU(p.ROM), table} {
+		events := []Event{}
+		cpu.Trace = func(e Event) {
+			events = append(events, e)
+		}
+		cpu.RAM[0], cpu.RAM[1] = 3, 4
+		cpu.Run(uint64(len(expected)))
+		if fmt.Sprint(events) != fmt.Sprint(expected) {
+			t.Errorf("expected\n%v\ngot\n%v", expected, events)
+		}
+	}
+}
+
+func TestCPUCoverage(t *testing.T) {
+	t.Parallel()
+	prog := assemble(t, "@R0", "D=M", "@END", "D;JEQ", "@R1", "M=1", "(END)", "@END", "0;JMP")
+	cpu := NewCPU(prog.ROM)
+	cpu.Coverage = make([]uint64, len(prog.ROM))
+	cpu.Run(100)
+	cpu.Step()
+	cpu.Step()
+	expected := []uint64{1, 1, 1, 1, 0, 0, 1, 1}
+	for address, count := range expected {
+		if cpu.Coverage[address] != count {
+			t.Errorf("expected the instruction at %d to run %d times got %v", address, count, cpu.Coverage)
+			break
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	p := assemble(t, "(LOOP)", "@KBD", "D=M", "@R0", "M=D+M", "@LOOP", "0;JMP")
+	cpu := NewCPU(p.ROM)
+	cpu.RAM[KBD] = 65
+	cpu.Run(9)
+
+	var buf bytes.Buffer
+	if err := cpu.Snapshot().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewCPU(p.ROM)
+	if err := restored.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if restored.RAM != cpu.RAM || restored.A != cpu.A || restored.D != cpu.D || restored.PC != cpu.PC || restored.Cycles != cpu.Cycles {
+		t.Fatalf("expected the restored CPU to be like the one saved: A=%d D=%d PC=%d cycles %d, got A=%d D=%d PC=%d cycles %d",
+			cpu.A, cpu.D, cpu.PC, cpu.Cycles, restored.A, restored.D, restored.PC, restored.Cycles)
+	}
+	// both run on the same way: M=D+M runs 18 times in 109 cycles
+	cpu.Run(100)
+	restored.Run(100)
+	if restored.RAM[0] != cpu.RAM[0] || restored.Cycles != cpu.Cycles || cpu.RAM[0] != 65*18 {
+		t.Errorf("expected R0 = %d after %d cycles got %d after %d", cpu.RAM[0], cpu.Cycles, restored.RAM[0], restored.Cycles)
+	}
+
+	other := NewCPU(assemble(t, "@KBD", "D=M").ROM)
+	if err := other.Restore(s); err == nil || !strings.Contains(err.Error(), "different program") {
+		t.Errorf("expected a snapshot of another program to be rejected got %v", err)
+	}
+	if other.PC != 0 || other.RAM[KBD] != 0 {
+		t.Errorf("expected a rejected snapshot to leave the CPU as it was")
+	}
+}
+
+func TestReadSnapshotErrors(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	NewCPU(nil).Snapshot().Write(&buf)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, "not a snapshot"},
+		{"other file", []byte("HACKTRACE\x01"), "not a snapshot"},
+		{"version", []byte(snapshotMagic + "\x02"), "version 2"},
+		{"header", buf.Bytes()[:20], io.ErrUnexpectedEOF.Error()},
+		{"ram", buf.Bytes()[:buf.Len()-1], io.ErrUnexpectedEOF.Error()},
+	}
+	for _, test := range tests {
+		if _, err := ReadSnapshot(bytes.NewReader(test.data)); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected an error containing %q got %v", test.name, test.expected, err)
+		}
+	}
+}
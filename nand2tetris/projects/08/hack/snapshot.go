@@ -0,0 +1,145 @@
+package hack
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// snapshotMagic starts every snapshot file. The byte after it is the version
+// of the format, which changes whenever the layout does.
+//
+// Version 1 continues with the header below and then the words of RAM below
+// the keyboard, all little-endian.
+const (
+	snapshotMagic   = "HACKSNAP"
+	snapshotVersion = 1
+)
+
+// snapshotHeader is the fixed size part of a snapshot file after the version.
+type snapshotHeader struct {
+	ROMHash  [sha256.Size]byte
+	ROMSize  uint32
+	A, D     int16
+	PC       uint16
+	Keyboard int16
+	Cycles   uint64
+}
+
+// Snapshot is the full state of a CPU running a program, so it can be saved
+// and run on from where it was.
+type Snapshot struct {
+	ROMHash  [sha256.Size]byte // HashROM of the program it was taken of
+	ROMSize  int               // instructions of the program
+	RAM      [KBD]int16        // the memory below the keyboard
+	Keyboard int16             // the key pressed, as the keyboard's memory map holds it
+	A, D     int16
+	PC       uint16
+	Cycles   uint64
+}
+
+// HashROM returns the SHA-256 of rom with every word little-endian.
+func HashROM(rom []uint16) [sha256.Size]byte {
+	h := sha256.New()
+	var buf [2]byte
+	for _, word := range rom {
+		binary.LittleEndian.PutUint16(buf[:], word)
+		h.Write(buf[:])
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Snapshot returns the state of c.
+func (c *CPU) Snapshot() *Snapshot {
+	s := &Snapshot{
+		ROMHash:  HashROM(c.ROM),
+		ROMSize:  len(c.ROM),
+		Keyboard: c.RAM[KBD],
+		A:        c.A,
+		D:        c.D,
+		PC:       c.PC,
+		Cycles:   c.Cycles,
+	}
+	copy(s.RAM[:], c.RAM[:KBD])
+	return s
+}
+
+// Restore puts c in the state of s. It fails, leaving c as it was, if s was
+// taken of a program other than the one in c's ROM.
+func (c *CPU) Restore(s *Snapshot) error {
+	if hash := HashROM(c.ROM); s.ROMSize != len(c.ROM) || s.ROMHash != hash {
+		return fmt.Errorf("snapshot of a different program: %d instructions with hash %x..., expected %d with hash %x...",
+			s.ROMSize, s.ROMHash[:4], len(c.ROM), hash[:4])
+	}
+	copy(c.RAM[:KBD], s.RAM[:])
+	c.RAM[KBD] = s.Keyboard
+	c.A, c.D, c.PC, c.Cycles = s.A, s.D, s.PC, s.Cycles
+	return nil
+}
+
+// Write writes s to w in the snapshot file format.
+func (s *Snapshot) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	header := snapshotHeader{
+		ROMHash:  s.ROMHash,
+		ROMSize:  uint32(s.ROMSize),
+		A:        s.A,
+		D:        s.D,
+		PC:       s.PC,
+		Keyboard: s.Keyboard,
+		Cycles:   s.Cycles,
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &s.RAM); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by Snapshot.Write from r. It fails if
+// r doesn't start like a snapshot of a version it reads.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	start := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, start); err != nil || string(start[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a snapshot")
+	}
+	if start[len(snapshotMagic)] != snapshotVersion {
+		return nil, fmt.Errorf("snapshot of version %d, expected %d", start[len(snapshotMagic)], snapshotVersion)
+	}
+	var header snapshotHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, truncated(err)
+	}
+	s := &Snapshot{
+		ROMHash:  header.ROMHash,
+		ROMSize:  int(header.ROMSize),
+		Keyboard: header.Keyboard,
+		A:        header.A,
+		D:        header.D,
+		PC:       header.PC,
+		Cycles:   header.Cycles,
+	}
+	if err := binary.Read(br, binary.LittleEndian, &s.RAM); err != nil {
+		return nil, truncated(err)
+	}
+	return s, nil
+}
+
+// truncated turns the EOF of a snapshot that ends early into an
+// io.ErrUnexpectedEOF.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addSamples adds the VM programs of the projects, and inputs that used to
+// crash the lexer, to the corpus of f.
+func addSamples(f *testing.F) {
+	paths, _ := filepath.Glob("../../*/*/*/*.vm")
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, input := range []string{"", "add", "push constant 7", "/", "/ add", "// end", "@ add", "\x00", "\xff", "push\tlocal\r\n", strings.Repeat("\n", 5000)} {
+		f.Add(input)
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		l := NewLexer(strings.NewReader(input))
+		last := FilePosition{Line: 1, Col: 1}
+		for n := 0; ; n++ {
+			pos, lexeme := l.NextToken()
+			if pos.Line < last.Line || pos.Line == last.Line && pos.Col < last.Col || pos.Col < 1 {
+				t.Fatalf("%s %q at %d:%d after a token at %d:%d", lexeme.Token, lexeme.Value, pos.Line, pos.Col, last.Line, last.Col)
+			}
+			last = pos
+			if lexeme.Token == EOF {
+				break
+			}
+			if n > len(input) {
+				t.Fatalf("read %d tokens from %d bytes, the last %s %q", n, len(input), lexeme.Token, lexeme.Value)
+			}
+		}
+	})
+}
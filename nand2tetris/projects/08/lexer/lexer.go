@@ -2,9 +2,8 @@ package lexer
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"log"
-	"os"
 	"strconv"
 )
 
@@ -30,13 +29,17 @@ var tokens = []string{
 }
 
 func (t Token) String() string {
+	if t < 0 || int(t) >= len(tokens) {
+		return fmt.Sprintf("Token(%d)", int(t))
+	}
 	return tokens[t]
 }
 
 type Lexer struct {
-	r    *bufio.Reader
-	prev Token
-	fp   FilePosition
+	r     *bufio.Reader
+	prev  Token
+	fp    FilePosition
+	atEOF bool // the last character read was the end of the input
 }
 
 type FilePosition struct {
@@ -44,31 +47,43 @@ type FilePosition struct {
 	Col  int
 }
 
-func NewLexer(f *os.File) *Lexer {
+// NewLexer returns a Lexer that reads VM commands from r.
+func NewLexer(r io.Reader) *Lexer {
 	return &Lexer{
-		r:    bufio.NewReader(f),
+		r:    bufio.NewReader(r),
 		prev: NEWLINE,
 		fp:   FilePosition{Line: 1, Col: 1},
 	}
 }
 
-const eofRune = rune(0)
+// eofRune stands for the end of the input. ReadRune never returns it, so a NUL
+// in the input is just an illegal character.
+const eofRune = rune(-1)
 const newlineRune = rune(10)
 
 func (l *Lexer) getChar() rune {
 	ch, _, err := l.r.ReadRune()
 	if err != nil {
 		if err == io.EOF {
+			l.atEOF = true
 			return eofRune
 		} else {
 			panic(err)
 		}
 	}
+	l.atEOF = false
 	return ch
 }
 
-func (l *Lexer) unread() error {
-	return l.r.UnreadRune()
+// unread puts the last character read back. There's nothing to put back at
+// the end of the input.
+func (l *Lexer) unread() {
+	if l.atEOF {
+		return
+	}
+	if err := l.r.UnreadRune(); err != nil {
+		panic(err)
+	}
 }
 
 func isWhitespace(ch rune) bool {
@@ -92,11 +107,25 @@ type Lexeme struct {
 	Value string
 }
 
+// NextToken returns the next token and where it starts. Characters that start
+// no token are returned one at a time as ILLEGAL tokens.
 func (l *Lexer) NextToken() (FilePosition, *Lexeme) {
+	for {
+		if pos, lexeme := l.next(); lexeme != nil {
+			return pos, lexeme
+		}
+	}
+}
+
+// next returns the next token, or a nil Lexeme if it only skipped a line
+// ending or a comment.
+func (l *Lexer) next() (FilePosition, *Lexeme) {
 	var currChar = ' '
 	for isWhitespace(currChar) {
 		currChar = l.getChar()
-		l.fp.Col += 1
+		if currChar != eofRune {
+			l.fp.Col += 1
+		}
 	}
 
 	if currChar == eofRune {
@@ -108,21 +137,18 @@ func (l *Lexer) NextToken() (FilePosition, *Lexeme) {
 		l.prev = NEWLINE
 		l.fp.Line += 1
 		l.fp.Col = 1
-		return l.NextToken()
+		return l.fp, nil
 	}
 
+	startingPos := FilePosition{Line: l.fp.Line, Col: l.fp.Col - 1}
 	if isLetter(currChar) || isDigit(currChar) {
-		startingPos := FilePosition{Line: l.fp.Line, Col: l.fp.Col - 1}
 		charSeq := []rune{currChar}
 		for currChar = l.getChar(); isDigit(currChar) || isLetter(currChar) || isSymbolChar(currChar); {
 			charSeq = append(charSeq, currChar)
 			currChar = l.getChar()
 			l.fp.Col += 1
 		}
-
-		if err := l.unread(); err != nil {
-			panic(err)
-		}
+		l.unread()
 
 		if _, err := strconv.ParseInt(string(charSeq), 10, 16); err == nil {
 			l.prev = ARG
@@ -134,28 +160,26 @@ func (l *Lexer) NextToken() (FilePosition, *Lexeme) {
 			return startingPos, &Lexeme{COMMAND, string(charSeq)}
 		}
 
-		if l.prev == COMMAND || l.prev == ARG {
-			l.prev = ARG
-			return startingPos, &Lexeme{ARG, string(charSeq)}
-		}
+		// Anything else on the line, even after an illegal character, is an
+		// argument.
+		l.prev = ARG
+		return startingPos, &Lexeme{ARG, string(charSeq)}
 	}
 
 	if currChar == '/' {
-		for currChar != eofRune && currChar != newlineRune && currChar != '\r' {
-			currChar = l.getChar()
-		}
-
-		if currChar != eofRune {
-			return l.NextToken()
+		if next := l.getChar(); next == '/' {
+			for currChar != eofRune && currChar != newlineRune && currChar != '\r' {
+				currChar = l.getChar()
+			}
+			// Leave the line ending in the input so the next token is lexed at the start of a new line
+			l.unread()
+			return l.fp, nil
 		}
+		l.unread()
 	}
 
-	log.Printf("could not derive token from char: %q with prev token %s", currChar, l.prev.String())
-	thisChar := currChar
 	l.prev = ILLEGAL
-	_ = l.getChar()
-	l.fp.Col += 1
-	return l.fp, &Lexeme{ILLEGAL, string(thisChar)}
+	return startingPos, &Lexeme{ILLEGAL, string(currChar)}
 }
 
 func (l *Lexer) HasMoreTokens() bool {
@@ -4,8 +4,10 @@ import (
 	"VMtranslator/lexer"
 	"errors"
 	"fmt"
-	"os"
+	"io"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type CommandType int
@@ -35,36 +37,53 @@ var commandTypes = []string{
 }
 
 func (ct CommandType) String() string {
+	if ct < 0 || int(ct) >= len(commandTypes) {
+		return fmt.Sprintf("CommandType(%d)", int(ct))
+	}
 	return commandTypes[ct]
 }
 
+// Command is one parsed VM command. It's the representation every backend
+// translates from, so it carries everything needed to report errors about it.
 type Command struct {
-	ct   CommandType // the type of command
-	arg1 string
-	arg2 int
+	Type CommandType // the type of command
+	Arg1 string      // segment, label or function name; the command itself for C_ARITHMETIC
+	Arg2 int         // index, number of locals or number of arguments; -1 when unused
+
+	File string             // base name of the file the command was read from
+	Pos  lexer.FilePosition // where the command starts in File
 }
 
 var emptyArg1 = ""
 var emptyArg2 = -1
 
 type Parser struct {
-	f      *os.File
+	name   string // base name of the input, if it has one
 	lxr    *lexer.Lexer
 	lexeme *lexer.Lexeme
 	cmd    *Command
 	fp     lexer.FilePosition
+	cmdPos lexer.FilePosition // position of the current command
 }
 
-func NewParser(file *os.File) *Parser {
-	if file == nil {
+// NewParser returns a Parser that reads VM commands from r, which can be a
+// file, stdin or any other stream. If r has a Name method, like an *os.File,
+// the base name is the File of every command.
+func NewParser(r io.Reader) *Parser {
+	if r == nil {
 		return &Parser{}
 	}
-	lexer := lexer.NewLexer(file)
+	lexer := lexer.NewLexer(r)
+
+	var name string
+	if named, ok := r.(interface{ Name() string }); ok {
+		name = filepath.Base(named.Name())
+	}
 
 	// Load the first token from the input
 	pos, initialLex := lexer.NextToken()
 	return &Parser{
-		f:      file,
+		name:   name,
 		lxr:    lexer,
 		lexeme: initialLex,
 		cmd:    nil,
@@ -72,12 +91,14 @@ func NewParser(file *os.File) *Parser {
 	}
 }
 
+// HasMoreCommands reports whether a command is left to Advance to. The last
+// command of a stream needn't be followed by a newline.
 func (p *Parser) HasMoreCommands() bool {
-	return p.lxr.HasMoreTokens()
+	return p.lexeme != nil && p.lexeme.Token != lexer.EOF
 }
 
 func (p *Parser) parseArithmeticCommand() (*Command, error) {
-	return &Command{ct: C_ARITHMETIC, arg1: p.lexeme.Value, arg2: emptyArg2}, nil
+	return &Command{Type: C_ARITHMETIC, Arg1: p.lexeme.Value, Arg2: emptyArg2}, nil
 }
 
 func (p *Parser) parsePushPopCommand() (*Command, error) {
@@ -93,37 +114,107 @@ func (p *Parser) parsePushPopCommand() (*Command, error) {
 	p.fp = pos
 	if segment.Token != lexer.ARG {
 		return &Command{
-				ct: currCmdType, arg1: segment.Value,
-				arg2: emptyArg2,
-			}, &ParserError{
-				line: p.fp.Line,
-				col:  p.fp.Col,
-				lxm:  segment,
-				msg:  fmt.Sprintf("expected ARG token while parsing \"push\" command got %s instead\n", segment.Token),
-			}
+			Type: currCmdType, Arg1: segment.Value,
+			Arg2: emptyArg2,
+		}, &ParserError{
+			line: p.fp.Line,
+			col:  p.fp.Col,
+			lxm:  segment,
+			msg:  fmt.Sprintf("expected ARG token while parsing \"push\" command got %s instead\n", segment.Token),
+		}
 	}
 
 	pos, index := p.lxr.NextToken() // consume index
 	p.fp = pos
 	if index.Token != lexer.ARG {
-		fmt.Printf("expected ARG token while parsing \"push\" command got %s instead\n", index.Token)
+		return &Command{
+			Type: currCmdType,
+			Arg1: segment.Value,
+			Arg2: emptyArg2,
+		}, &ParserError{
+			line: p.fp.Line,
+			col:  p.fp.Col,
+			lxm:  index,
+			msg:  fmt.Sprintf("expected ARG token while parsing %q command got %s instead", p.lexeme.Value, index.Token),
+		}
 	}
 
 	indexInt, err := strconv.Atoi(index.Value)
 	if err != nil {
 		return &Command{
-				ct:   currCmdType,
-				arg1: segment.Value,
-				arg2: emptyArg2,
-			}, &ParserError{
-				line: p.fp.Line,
-				col:  p.fp.Col,
-				lxm:  index,
-				msg:  fmt.Sprintf("could not convert %q to int while parsing push command (%s, %q)", index, index.Token.String(), index),
-			}
+			Type: currCmdType,
+			Arg1: segment.Value,
+			Arg2: emptyArg2,
+		}, &ParserError{
+			line: p.fp.Line,
+			col:  p.fp.Col,
+			lxm:  index,
+			msg:  fmt.Sprintf("could not convert %q to int while parsing push command (%s, %q)", index, index.Token.String(), index),
+		}
 	}
 
-	return &Command{ct: currCmdType, arg1: segment.Value, arg2: indexInt}, nil
+	cmd := &Command{Type: currCmdType, Arg1: segment.Value, Arg2: indexInt}
+	if err := CheckPushPop(*cmd); err != nil {
+		return cmd, &ParserError{
+			line: p.fp.Line,
+			col:  p.fp.Col,
+			lxm:  index,
+			msg:  err.Error(),
+		}
+	}
+	return cmd, nil
+}
+
+// segmentSizes holds the number of words of the segments that have a fixed size.
+var segmentSizes = map[string]int{
+	"constant": 32768, // the values an A-instruction can load
+	"pointer":  2,
+	"temp":     8,
+}
+
+// maxIndex is the largest index an A-instruction can add to a segment's base.
+const maxIndex = 32767
+
+// CheckPushPop reports push and pop commands that would read or write outside
+// their segment, like "push temp 8" or "pop constant 3". The number of static
+// variables a program can have depends on the whole program, so it's checked
+// separately.
+func CheckPushPop(cmd Command) error {
+	if cmd.Type != C_PUSH && cmd.Type != C_POP {
+		return nil
+	}
+	switch cmd.Arg1 {
+	case "constant", "local", "argument", "this", "that", "pointer", "temp", "static":
+	default:
+		return fmt.Errorf("%q: unknown segment %q", cmd.String(), cmd.Arg1)
+	}
+	if cmd.Type == C_POP && cmd.Arg1 == "constant" {
+		return fmt.Errorf("%q: can't pop to the constant segment", cmd.String())
+	}
+	size, ok := segmentSizes[cmd.Arg1]
+	if !ok {
+		size = maxIndex + 1
+	}
+	if cmd.Arg2 < 0 || cmd.Arg2 >= size {
+		return fmt.Errorf("%q: index %d is out of range, %s takes 0 to %d", cmd.String(), cmd.Arg2, cmd.Arg1, size-1)
+	}
+	return nil
+}
+
+// nameError reports a label or function name that contains $. The translator
+// writes the labels it makes up, like the return address Main.main$ret$1, as the
+// function's name, a $ and the label, so a name of the program holding one
+// could clash with them.
+func (p *Parser) nameError(name string) error {
+	if !strings.Contains(name, "$") {
+		return nil
+	}
+	return &ParserError{
+		line: p.fp.Line,
+		col:  p.fp.Col,
+		lxm:  p.lexeme,
+		msg:  fmt.Sprintf("%q can't contain $, it's kept for the labels the translator makes", name),
+	}
 }
 
 var ErrParserNoMoreCommands = errors.New("parser has no more commands")
@@ -143,6 +234,7 @@ func (p *Parser) Advance() error {
 	if !p.HasMoreCommands() {
 		return ErrParserNoMoreCommands
 	}
+	p.cmdPos = p.fp
 
 	var parsedCmd *Command
 	var err error
@@ -170,8 +262,10 @@ func (p *Parser) Advance() error {
 							lxm:  p.lexeme,
 							msg:  fmt.Sprintf("expected ARG token while parsing \"label\" command but got %s", label.Token.String()),
 						}
+					} else {
+						err = p.nameError(label.Value)
 					}
-					parsedCmd = &Command{ct: C_LABEL, arg1: label.Value, arg2: emptyArg2}
+					parsedCmd = &Command{Type: C_LABEL, Arg1: label.Value, Arg2: emptyArg2}
 				}
 			case "goto":
 				{
@@ -185,8 +279,10 @@ func (p *Parser) Advance() error {
 							lxm:  p.lexeme,
 							msg:  fmt.Sprintf("expected ARG token while parsing \"goto\" command but got %s", label.Token.String()),
 						}
+					} else {
+						err = p.nameError(label.Value)
 					}
-					parsedCmd = &Command{ct: C_GOTO, arg1: label.Value, arg2: emptyArg2}
+					parsedCmd = &Command{Type: C_GOTO, Arg1: label.Value, Arg2: emptyArg2}
 				}
 			case "if-goto":
 				{
@@ -200,8 +296,10 @@ func (p *Parser) Advance() error {
 							lxm:  p.lexeme,
 							msg:  fmt.Sprintf("expected ARG token while parsing \"if-goto\" command but got %s", label.Token.String()),
 						}
+					} else {
+						err = p.nameError(label.Value)
 					}
-					parsedCmd = &Command{ct: C_IF, arg1: label.Value, arg2: emptyArg2}
+					parsedCmd = &Command{Type: C_IF, Arg1: label.Value, Arg2: emptyArg2}
 				}
 			case "call":
 				{
@@ -210,30 +308,30 @@ func (p *Parser) Advance() error {
 					p.fp = pos
 					if functionName.Token != lexer.ARG {
 						err = &ParserError{line: p.fp.Line, col: p.fp.Col, lxm: p.lexeme, msg: fmt.Sprintf("expected ARG token while parsing %q command but got %q)", p.lexeme.Value, functionName.Token.String())}
+					} else {
+						err = p.nameError(functionName.Value)
 					}
 
 					// consume numArgs
 					pos, numArgs := p.lxr.NextToken()
 					p.fp = pos
-					if functionName.Token != lexer.ARG {
+					if err == nil && numArgs.Token != lexer.ARG {
 						err = &ParserError{line: p.fp.Line, col: p.fp.Col, lxm: p.lexeme, msg: fmt.Sprintf("expected ARG token while parsing %q command but got %q)", p.lexeme.Value, numArgs.Token.String())}
 					}
 
-					numArgsInt, err := strconv.Atoi(numArgs.Value)
-					if err != nil {
-						parsedCmd = &Command{
-							ct:   C_CALL,
-							arg1: functionName.Value,
-							arg2: emptyArg2,
-						}
-						return &ParserError{
-							line: p.fp.Line,
-							col:  p.fp.Col,
-							lxm:  p.lexeme,
-							msg:  fmt.Sprintf("could not convert %q to int while parsing \"function\" %s %s", numArgs.Value, functionName.Value, numArgs.Value),
+					numArgsInt, convErr := strconv.Atoi(numArgs.Value)
+					if convErr != nil {
+						numArgsInt = emptyArg2
+						if err == nil {
+							err = &ParserError{
+								line: p.fp.Line,
+								col:  p.fp.Col,
+								lxm:  p.lexeme,
+								msg:  fmt.Sprintf("could not convert %q to int while parsing %q %s %s", numArgs.Value, p.lexeme.Value, functionName.Value, numArgs.Value),
+							}
 						}
 					}
-					parsedCmd = &Command{ct: C_CALL, arg1: functionName.Value, arg2: numArgsInt}
+					parsedCmd = &Command{Type: C_CALL, Arg1: functionName.Value, Arg2: numArgsInt}
 				}
 			case "function":
 				{
@@ -242,34 +340,34 @@ func (p *Parser) Advance() error {
 					p.fp = pos
 					if functionName.Token != lexer.ARG {
 						err = &ParserError{line: p.fp.Line, col: p.fp.Col, lxm: p.lexeme, msg: fmt.Sprintf("expected ARG token while parsing %q command but got %q)", p.lexeme.Value, functionName.Token.String())}
+					} else {
+						err = p.nameError(functionName.Value)
 					}
 
 					// consume numLocals
 					pos, numLocals := p.lxr.NextToken()
 					p.fp = pos
-					if functionName.Token != lexer.ARG {
+					if err == nil && numLocals.Token != lexer.ARG {
 						err = &ParserError{line: p.fp.Line, col: p.fp.Col, lxm: p.lexeme, msg: fmt.Sprintf("expected ARG token while parsing %q command but got %q)", p.lexeme.Value, numLocals.Token.String())}
 					}
 
-					numLocalsInt, err := strconv.Atoi(numLocals.Value)
-					if err != nil {
-						parsedCmd = &Command{
-							ct:   C_FUNCTION,
-							arg1: functionName.Value,
-							arg2: emptyArg2,
-						}
-						return &ParserError{
-							line: p.fp.Line,
-							col:  p.fp.Col,
-							lxm:  p.lexeme,
-							msg:  fmt.Sprintf("could not convert %q to int while parsing \"function\" %s %s", numLocals.Value, functionName.Value, numLocals.Value),
+					numLocalsInt, convErr := strconv.Atoi(numLocals.Value)
+					if convErr != nil {
+						numLocalsInt = emptyArg2
+						if err == nil {
+							err = &ParserError{
+								line: p.fp.Line,
+								col:  p.fp.Col,
+								lxm:  p.lexeme,
+								msg:  fmt.Sprintf("could not convert %q to int while parsing %q %s %s", numLocals.Value, p.lexeme.Value, functionName.Value, numLocals.Value),
+							}
 						}
 					}
-					parsedCmd = &Command{ct: C_FUNCTION, arg1: functionName.Value, arg2: numLocalsInt}
+					parsedCmd = &Command{Type: C_FUNCTION, Arg1: functionName.Value, Arg2: numLocalsInt}
 				}
 			case "return":
 				{
-					parsedCmd = &Command{ct: C_RETURN, arg1: emptyArg1, arg2: emptyArg2}
+					parsedCmd = &Command{Type: C_RETURN, Arg1: emptyArg1, Arg2: emptyArg2}
 				}
 			default:
 				{
@@ -283,6 +381,10 @@ func (p *Parser) Advance() error {
 			err = &ParserError{line: p.fp.Line, col: p.fp.Col, msg: fmt.Sprintf("attempted to parse non-Command token (%s, %q) as a non-terminal", p.lexeme.Token.String(), p.lexeme.Value)}
 		}
 	}
+	if parsedCmd != nil {
+		parsedCmd.File = p.name
+		parsedCmd.Pos = p.cmdPos
+	}
 	p.cmd = parsedCmd
 
 	// Update parser with next lexeme
@@ -292,20 +394,84 @@ func (p *Parser) Advance() error {
 
 var emptyCommandType = CommandType(-1)
 
+// Position returns where the current command starts in the input.
+func (p *Parser) Position() lexer.FilePosition {
+	return p.cmdPos
+}
+
+// CommandString returns the current command as it would be written in a .vm
+// file, e.g. "push constant 7" or "return".
+func (p *Parser) CommandString() string {
+	if p.cmd == nil {
+		return ""
+	}
+	return p.cmd.String()
+}
+
+// ParseAll returns every remaining command. It stops at the first command that
+// can't be parsed.
+func (p *Parser) ParseAll() ([]Command, error) {
+	cmds := []Command{}
+	for p.HasMoreCommands() {
+		if err := p.Advance(); err != nil {
+			return cmds, err
+		}
+		cmds = append(cmds, p.Command())
+	}
+	return cmds, nil
+}
+
+// Command returns the current command. It's the zero Command with a Type of -1
+// before the first call to Advance or if the last command could not be parsed.
+func (p *Parser) Command() Command {
+	if p.cmd == nil {
+		return Command{Type: emptyCommandType, Arg2: emptyArg2}
+	}
+	return *p.cmd
+}
+
+// String returns the command as it would be written in a .vm file.
+func (c Command) String() string {
+	switch c.Type {
+	case C_ARITHMETIC:
+		return c.Arg1
+	case C_PUSH:
+		return fmt.Sprintf("push %s %d", c.Arg1, c.Arg2)
+	case C_POP:
+		return fmt.Sprintf("pop %s %d", c.Arg1, c.Arg2)
+	case C_LABEL:
+		return "label " + c.Arg1
+	case C_GOTO:
+		return "goto " + c.Arg1
+	case C_IF:
+		return "if-goto " + c.Arg1
+	case C_FUNCTION:
+		return fmt.Sprintf("function %s %d", c.Arg1, c.Arg2)
+	case C_CALL:
+		return fmt.Sprintf("call %s %d", c.Arg1, c.Arg2)
+	case C_RETURN:
+		return "return"
+	}
+	return ""
+}
+
 func (p *Parser) CommandType() CommandType {
 	if p.cmd == nil {
 		return emptyCommandType
 	}
-	return p.cmd.ct
+	return p.cmd.Type
 }
 
 func (p *Parser) Arg1() string {
+	if p.cmd == nil {
+		return emptyArg1
+	}
 	if p.CommandType() == C_RETURN {
 		fmt.Println("attempted to call Arg1 on a C_RETURN command")
 		return ""
 	}
 
-	return p.cmd.arg1
+	return p.cmd.Arg1
 }
 
 func commandHasArg2(ct CommandType) bool {
@@ -317,5 +483,5 @@ func (p *Parser) Arg2() int {
 		return emptyArg2
 	}
 
-	return p.cmd.arg2
+	return p.cmd.Arg2
 }
@@ -3,12 +3,13 @@ package parser
 import (
 	"VMtranslator/lexer"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 func (a *Command) Equals(b *Command) bool {
-	return a.ct == b.ct && a.arg1 == b.arg1 && a.arg2 == b.arg2
+	return a.Type == b.Type && a.Arg1 == b.Arg1 && a.Arg2 == b.Arg2
 }
 
 func TestInitialization(t *testing.T) {
@@ -42,26 +43,26 @@ func TestAdvanceSimpleAdd(t *testing.T) {
 	parser := NewParser(f)
 	parser.Advance()
 
-	expected := &Command{ct: C_PUSH, arg1: "constant", arg2: 7}
+	expected := &Command{Type: C_PUSH, Arg1: "constant", Arg2: 7}
 	if !expected.Equals(parser.cmd) {
 		t.Errorf("expected %v got %v", expected, parser.cmd)
 	}
 
 	parser.Advance()
-	expected = &Command{ct: C_PUSH, arg1: "constant", arg2: 8}
+	expected = &Command{Type: C_PUSH, Arg1: "constant", Arg2: 8}
 	if !expected.Equals(parser.cmd) {
 		t.Errorf("expected %v got %v", expected, parser.cmd)
 	}
 
 	parser.Advance()
-	expected = &Command{ct: C_ARITHMETIC, arg1: "add", arg2: emptyArg2}
+	expected = &Command{Type: C_ARITHMETIC, Arg1: "add", Arg2: emptyArg2}
 	if !expected.Equals(parser.cmd) {
 		t.Errorf("expected %v got %v", expected, parser.cmd)
 	}
 }
 
 func TestAdvanceStackTest(t *testing.T) {
-	f, err := os.Open("../StackArithmetic/StackTest/Stacktest.vm")
+	f, err := os.Open("../StackArithmetic/StackTest/StackTest.vm")
 	if err != nil {
 		panic(err)
 	}
@@ -93,22 +94,22 @@ func TestCommandType(t *testing.T) {
 		input    *Command
 		expected CommandType
 	}{
-		{"add", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"sub", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"neg", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"eq", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"gt", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"lt", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"and", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"or", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"not", &Command{ct: C_ARITHMETIC, arg1: "add"}, C_ARITHMETIC},
-		{"push", &Command{ct: C_PUSH, arg1: "constant", arg2: 7}, C_PUSH},
-		{"pop", &Command{ct: C_POP, arg1: "local", arg2: 1}, C_POP},
-		{"label", &Command{ct: C_LABEL, arg1: "LOOP_START", arg2: emptyArg2}, C_LABEL},
-		{"if-goto", &Command{ct: C_GOTO, arg1: "LOOP_START", arg2: emptyArg2}, C_GOTO},
-		{"call", &Command{ct: C_CALL, arg1: "Sys.init", arg2: 0}, C_CALL},
-		{"function", &Command{ct: C_FUNCTION, arg1: "Sys.init", arg2: 0}, C_FUNCTION},
-		{"return", &Command{ct: C_RETURN, arg1: emptyArg1, arg2: emptyArg2}, C_RETURN},
+		{"add", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"sub", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"neg", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"eq", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"gt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"lt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"and", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"or", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"not", &Command{Type: C_ARITHMETIC, Arg1: "add"}, C_ARITHMETIC},
+		{"push", &Command{Type: C_PUSH, Arg1: "constant", Arg2: 7}, C_PUSH},
+		{"pop", &Command{Type: C_POP, Arg1: "local", Arg2: 1}, C_POP},
+		{"label", &Command{Type: C_LABEL, Arg1: "LOOP_START", Arg2: emptyArg2}, C_LABEL},
+		{"if-goto", &Command{Type: C_GOTO, Arg1: "LOOP_START", Arg2: emptyArg2}, C_GOTO},
+		{"call", &Command{Type: C_CALL, Arg1: "Sys.init", Arg2: 0}, C_CALL},
+		{"function", &Command{Type: C_FUNCTION, Arg1: "Sys.init", Arg2: 0}, C_FUNCTION},
+		{"return", &Command{Type: C_RETURN, Arg1: emptyArg1, Arg2: emptyArg2}, C_RETURN},
 		{"empty", nil, emptyCommandType},
 	}
 
@@ -131,18 +132,18 @@ func TestArg1(t *testing.T) {
 		input    *Command
 		expected string
 	}{
-		{"C_ARITHMETIC add", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC sub", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC neg", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC eq", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC gt", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC lt", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC and", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC or", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_ARITHMETIC not", &Command{ct: C_ARITHMETIC, arg1: "add"}, "add"},
-		{"C_PUSH push constant 7", &Command{ct: C_PUSH, arg1: "constant", arg2: 7}, "constant"},
-		{"C_POP pop local 1", &Command{ct: C_POP, arg1: "local", arg2: 1}, "local"},
-		{"C_RETURN arg1", &Command{ct: C_RETURN, arg1: "return"}, ""},
+		{"C_ARITHMETIC add", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC sub", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC neg", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC eq", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC gt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC lt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC and", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC or", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_ARITHMETIC not", &Command{Type: C_ARITHMETIC, Arg1: "add"}, "add"},
+		{"C_PUSH push constant 7", &Command{Type: C_PUSH, Arg1: "constant", Arg2: 7}, "constant"},
+		{"C_POP pop local 1", &Command{Type: C_POP, Arg1: "local", Arg2: 1}, "local"},
+		{"C_RETURN arg1", &Command{Type: C_RETURN, Arg1: "return"}, ""},
 	}
 
 	for _, test := range passingTests {
@@ -164,17 +165,17 @@ func TestArg2(t *testing.T) {
 		input    *Command
 		expected int
 	}{
-		{"C_ARITHMETIC add", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC sub", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC neg", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC eq", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC gt", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC lt", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC and", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC or", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_ARITHMETIC not", &Command{ct: C_ARITHMETIC, arg1: "add"}, emptyArg2},
-		{"C_PUSH push constant 7", &Command{ct: C_PUSH, arg1: "constant", arg2: 7}, 7},
-		{"C_PUSH pop local 1", &Command{ct: C_POP, arg1: "local", arg2: 1}, 1},
+		{"C_ARITHMETIC add", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC sub", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC neg", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC eq", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC gt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC lt", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC and", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC or", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_ARITHMETIC not", &Command{Type: C_ARITHMETIC, Arg1: "add"}, emptyArg2},
+		{"C_PUSH push constant 7", &Command{Type: C_PUSH, Arg1: "constant", Arg2: 7}, 7},
+		{"C_PUSH pop local 1", &Command{Type: C_POP, Arg1: "local", Arg2: 1}, 1},
 	}
 
 	for _, test := range tests {
@@ -267,3 +268,144 @@ func TestParserReportsLineColNumsOnError(t *testing.T) {
 	}
 
 }
+
+func TestPositionAndCommandString(t *testing.T) {
+	f, err := os.Open("../FunctionCalls/SimpleFunction/SimpleFunction.vm")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	parser := NewParser(f)
+	expected := []struct {
+		line    int
+		command string
+	}{
+		{7, "function SimpleFunction.test 2"},
+		{8, "push local 0"},
+		{9, "push local 1"},
+		{10, "add"},
+	}
+	for _, e := range expected {
+		if err := parser.Advance(); err != nil {
+			t.Fatal(err)
+		}
+		if parser.Position().Line != e.line {
+			t.Errorf("expected %q on line %d got %d", e.command, e.line, parser.Position().Line)
+		}
+		if parser.CommandString() != e.command {
+			t.Errorf("expected %q got %q", e.command, parser.CommandString())
+		}
+	}
+}
+
+func TestPushPopBounds(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected string // empty if the command is valid
+	}{
+		{"push temp 7", ""},
+		{"push temp 8", `Error (Line: 1, Col: 11) - "push temp 8": index 8 is out of range, temp takes 0 to 7`},
+		{"pop temp 12", `Error (Line: 1, Col: 10) - "pop temp 12": index 12 is out of range, temp takes 0 to 7`},
+		{"push pointer 1", ""},
+		{"push pointer 5", `Error (Line: 1, Col: 14) - "push pointer 5": index 5 is out of range, pointer takes 0 to 1`},
+		{"push constant 32767", ""},
+		{"push constant 40000", `Error (Line: 1, Col: 15) - "push constant 40000": index 40000 is out of range, constant takes 0 to 32767`},
+		{"pop constant 3", `Error (Line: 1, Col: 14) - "pop constant 3": can't pop to the constant segment`},
+		{"push local 40000", `Error (Line: 1, Col: 12) - "push local 40000": index 40000 is out of range, local takes 0 to 32767`},
+		{"push heap 0", `Error (Line: 1, Col: 11) - "push heap 0": unknown segment "heap"`},
+		{"pop static 300", ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+			f, err := os.Create(filepath.Join(t.TempDir(), "Main.vm"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString(test.input + "\n"); err != nil {
+				t.Fatal(err)
+			}
+			f.Seek(0, 0)
+
+			var actual string
+			if err := NewParser(f).Advance(); err != nil {
+				actual = err.Error()
+			}
+			if actual != test.expected {
+				t.Errorf("expected %q got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNamesCantContainDollar(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected string // empty if the command is valid
+	}{
+		{"label LOOP_1.a:b", ""},
+		{"label ret$1", `Error (Line: 1, Col: 7) - "ret$1" can't contain $, it's kept for the labels the translator makes`},
+		{"goto ret$1", `Error (Line: 1, Col: 6) - "ret$1" can't contain $, it's kept for the labels the translator makes`},
+		{"if-goto EQ$2", `Error (Line: 1, Col: 9) - "EQ$2" can't contain $, it's kept for the labels the translator makes`},
+		{"function Main.f$ret 0", `Error (Line: 1, Col: 10) - "Main.f$ret" can't contain $, it's kept for the labels the translator makes`},
+		{"call Main.f$ret 0", `Error (Line: 1, Col: 6) - "Main.f$ret" can't contain $, it's kept for the labels the translator makes`},
+		{"call Main.f 0", ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+			var actual string
+			if err := NewParser(strings.NewReader(test.input + "\n")).Advance(); err != nil {
+				actual = err.Error()
+			}
+			if actual != test.expected {
+				t.Errorf("expected %q got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	t.Parallel()
+	input := "// adds two numbers\npush constant 7\npush constant 8\nadd\n"
+
+	cmds, err := NewParser(strings.NewReader(input)).ParseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Command{
+		{Type: C_PUSH, Arg1: "constant", Arg2: 7, Pos: lexer.FilePosition{Line: 2, Col: 1}},
+		{Type: C_PUSH, Arg1: "constant", Arg2: 8, Pos: lexer.FilePosition{Line: 3, Col: 1}},
+		{Type: C_ARITHMETIC, Arg1: "add", Arg2: emptyArg2, Pos: lexer.FilePosition{Line: 4, Col: 1}},
+	}
+	if len(cmds) != len(expected) {
+		t.Fatalf("expected %d commands got %d: %v", len(expected), len(cmds), cmds)
+	}
+	for i := range expected {
+		// a reader without a name gives commands without a file
+		if cmds[i] != expected[i] {
+			t.Errorf("expected %#v got %#v", expected[i], cmds[i])
+		}
+	}
+
+	f, err := os.Open("../FunctionCalls/SimpleFunction/SimpleFunction.vm")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	fileCmds, err := NewParser(f).ParseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileCmds[0].File != "SimpleFunction.vm" {
+		t.Errorf("expected commands of a file to have its base name, got %q", fileCmds[0].File)
+	}
+}
@@ -0,0 +1,387 @@
+// Package peephole rewrites short windows of generated Hack assembly into
+// cheaper sequences that leave the machine in the same observable state.
+package peephole
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Line is one line of assembly output. Comment-only lines have an empty Code.
+type Line struct {
+	Code    string // instruction or label declaration, e.g. "@SP", "AM=M-1" or "(LOOP)"
+	Comment string // comment text without the leading "//"
+
+	// Origin is an opaque index chosen by the caller to identify where the line
+	// was generated from. Rewritten instructions inherit the origin of the first
+	// instruction of the window they replace.
+	Origin int
+}
+
+// Parse splits generated assembly text into lines, separating each line's code from its comment.
+func Parse(asm string) []Line {
+	lines := []Line{}
+	for _, raw := range strings.Split(asm, "\n") {
+		var line Line
+		if i := strings.Index(raw, "//"); i != -1 {
+			line.Comment = strings.TrimSpace(raw[i+2:])
+			raw = raw[:i]
+		}
+		line.Code = strings.TrimSpace(raw)
+		if line.Code == "" && line.Comment == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Format writes lines back out as assembly text with labels in column 0 and
+// everything else indented by a tab.
+func Format(lines []Line) string {
+	var output strings.Builder
+	for _, line := range lines {
+		switch {
+		case line.Code == "":
+			output.WriteString("\t// " + line.Comment)
+		case isLabel(line.Code):
+			output.WriteString(line.Code)
+		default:
+			output.WriteString("\t" + line.Code)
+		}
+		if line.Code != "" && line.Comment != "" {
+			output.WriteString(" // " + line.Comment)
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// Count returns the number of instructions in lines. Labels and comments occupy no ROM and are not counted.
+func Count(lines []Line) int {
+	n := 0
+	for _, line := range lines {
+		if line.Code != "" && !isLabel(line.Code) {
+			n += 1
+		}
+	}
+	return n
+}
+
+// A rule inspects the instructions at the start of ins. If it recognizes a
+// window it returns the number of instructions consumed and their replacement.
+// Instructions past the window are only inspected to check whether the
+// registers the replacement clobbers are still needed.
+type rule func(ins []string) (int, []string, bool)
+
+// phases group the rules. Each phase runs until none of its rules match before
+// the next one starts, so the fusions see the unmodified templates before the
+// folds and the redundant load removal rewrite parts of them.
+var phases = [][]rule{
+	{fusePushPop, fusePushPopThroughAddress, fuseStackOperation},
+	{foldConstantOperand, foldConstantPush, removeDeadDWrite},
+}
+
+// Optimize applies every rule to lines until none of them matches anymore.
+func Optimize(lines []Line) []Line {
+	for {
+		changed := false
+		for _, rules := range phases {
+			for {
+				var ok bool
+				if lines, ok = applyRules(lines, rules); !ok {
+					break
+				}
+				changed = true
+			}
+		}
+		if removed, ok := removeRedundantLoads(lines); ok {
+			lines, changed = removed, true
+		}
+		if !changed {
+			return lines
+		}
+	}
+}
+
+// applyRules makes one pass over lines, rewriting every window one of rules matches.
+// Rules are tried in order at every position.
+func applyRules(lines []Line, rules []rule) ([]Line, bool) {
+	positions := []int{}
+	ins := []string{}
+	for i, line := range lines {
+		if line.Code != "" {
+			positions = append(positions, i)
+			ins = append(ins, line.Code)
+		}
+	}
+
+	output := make([]Line, 0, len(lines))
+	changed := false
+	next := 0 // index into lines of the first line not yet copied to output
+	for j := 0; j < len(ins); j++ {
+		for _, r := range rules {
+			n, replacement, ok := r(ins[j:])
+			if !ok {
+				continue
+			}
+			first, last := positions[j], positions[j+n-1]
+			output = append(output, lines[next:first]...)
+
+			// Comments inside the window are kept and placed before the replacement.
+			for i := first; i <= last; i++ {
+				if lines[i].Code == "" {
+					output = append(output, lines[i])
+				}
+			}
+			for _, code := range replacement {
+				output = append(output, Line{Code: code, Origin: lines[first].Origin})
+			}
+			next = last + 1
+			j += n - 1
+			changed = true
+			break
+		}
+	}
+	output = append(output, lines[next:]...)
+	return output, changed
+}
+
+// removeRedundantLoads deletes A-instructions that load the value A already holds.
+func removeRedundantLoads(lines []Line) ([]Line, bool) {
+	output := make([]Line, 0, len(lines))
+	known := ""
+	changed := false
+	for _, line := range lines {
+		code := line.Code
+		switch {
+		case code == "":
+		case isLabel(code):
+			known = ""
+		case strings.HasPrefix(code, "@"):
+			if code == known {
+				changed = true
+				if line.Comment != "" {
+					output = append(output, Line{Comment: line.Comment})
+				}
+				continue
+			}
+			known = code
+		default:
+			if writes(code, 'A') {
+				known = ""
+			}
+		}
+		output = append(output, line)
+	}
+	return output, changed
+}
+
+// A push immediately followed by a pop of the same value leaves D and SP untouched.
+//
+//	@SP, A=M, M=D, @SP, M=M+1, @SP, AM=M-1, D=M  =>  (nothing)
+func fusePushPop(ins []string) (int, []string, bool) {
+	window := []string{"@SP", "A=M", "M=D", "@SP", "M=M+1", "@SP", "AM=M-1", "D=M"}
+	if !hasPrefix(ins, window) || live('A', ins[len(window):]) {
+		return 0, nil, false
+	}
+	return len(window), []string{}, true
+}
+
+// A push followed by a pop into a computed segment address parks the value in R14
+// instead of on the stack while the address is calculated.
+//
+//	@SP, A=M, M=D, @SP, M=M+1, <address>, @SP, AM=M-1, D=M  =>  @R14, M=D, <address>, @R14, D=M
+func fusePushPopThroughAddress(ins []string) (int, []string, bool) {
+	push := []string{"@SP", "A=M", "M=D", "@SP", "M=M+1"}
+	pop := []string{"@SP", "AM=M-1", "D=M"}
+	const maxAddressLength = 8
+
+	if !hasPrefix(ins, push) {
+		return 0, nil, false
+	}
+	for n := 1; n <= maxAddressLength && len(push)+n < len(ins); n++ {
+		address := ins[len(push) : len(push)+n]
+		if !isAddressCalculation(address) {
+			return 0, nil, false
+		}
+		if hasPrefix(ins[len(push)+n:], pop) {
+			consumed := len(push) + n + len(pop)
+			if live('A', ins[consumed:]) {
+				return 0, nil, false
+			}
+			replacement := []string{"@R14", "M=D"}
+			replacement = append(replacement, address...)
+			replacement = append(replacement, "@R14", "D=M")
+			return consumed, replacement, true
+		}
+	}
+	return 0, nil, false
+}
+
+// isAddressCalculation reports whether ins can run while a pushed value sits in R14:
+// it must not touch the stack or R14, branch, or be a jump target, and may only
+// write memory through an address it loaded itself.
+func isAddressCalculation(ins []string) bool {
+	for i, code := range ins {
+		if code == "@SP" || code == "@R14" || isLabel(code) || strings.Contains(code, ";") {
+			return false
+		}
+		if !strings.HasPrefix(code, "@") && writes(code, 'M') {
+			if i == 0 || !strings.HasPrefix(ins[i-1], "@") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// An operation that pops its operand, computes into D and pushes D back can
+// compute straight into the top of the stack instead.
+//
+//	@SP, AM=M-1, D=<comp>, @SP, A=M, M=D, @SP, M=M+1  =>  @SP, A=M-1, M=<comp>
+func fuseStackOperation(ins []string) (int, []string, bool) {
+	if len(ins) < 8 || ins[0] != "@SP" || ins[1] != "AM=M-1" || !strings.HasPrefix(ins[2], "D=") {
+		return 0, nil, false
+	}
+	comp := strings.TrimPrefix(ins[2], "D=")
+	if strings.Contains(comp, ";") {
+		return 0, nil, false
+	}
+	if !hasPrefix(ins[3:], []string{"@SP", "A=M", "M=D", "@SP", "M=M+1"}) {
+		return 0, nil, false
+	}
+	if live('A', ins[8:]) || live('D', ins[8:]) {
+		return 0, nil, false
+	}
+	if comp == "M" { // popping and pushing back the same value
+		return 8, []string{}, true
+	}
+	return 8, []string{"@SP", "A=M-1", "M=" + comp}, true
+}
+
+// Adding or subtracting a constant 0 or 1 to the top of the stack needs no D register.
+//
+//	@1, D=A, @SP, A=M-1, M=D+M  =>  @SP, A=M-1, M=M+1
+//	@0, D=A, @SP, A=M-1, M=D+M  =>  (nothing)
+func foldConstantOperand(ins []string) (int, []string, bool) {
+	if len(ins) < 5 || ins[1] != "D=A" || ins[2] != "@SP" || ins[3] != "A=M-1" {
+		return 0, nil, false
+	}
+	c, err := strconv.Atoi(strings.TrimPrefix(ins[0], "@"))
+	if err != nil || !strings.HasPrefix(ins[0], "@") || live('D', ins[5:]) {
+		return 0, nil, false
+	}
+
+	var folded string
+	switch {
+	case c == 0 && (ins[4] == "M=D+M" || ins[4] == "M=M-D"):
+		if live('A', ins[5:]) {
+			return 0, nil, false
+		}
+		return 5, []string{}, true
+	case c == 1 && ins[4] == "M=D+M":
+		folded = "M=M+1"
+	case c == 1 && ins[4] == "M=M-D":
+		folded = "M=M-1"
+	default:
+		return 0, nil, false
+	}
+	return 5, []string{"@SP", "A=M-1", folded}, true
+}
+
+// Pushing the constants 0 or 1 can store them directly.
+//
+//	@0, D=A, @SP, A=M, M=D  =>  @SP, A=M, M=0
+func foldConstantPush(ins []string) (int, []string, bool) {
+	if len(ins) < 5 || ins[1] != "D=A" || ins[2] != "@SP" || ins[3] != "A=M" || ins[4] != "M=D" {
+		return 0, nil, false
+	}
+	if ins[0] != "@0" && ins[0] != "@1" {
+		return 0, nil, false
+	}
+	if live('D', ins[5:]) {
+		return 0, nil, false
+	}
+	return 5, []string{"@SP", "A=M", "M=" + strings.TrimPrefix(ins[0], "@")}, true
+}
+
+// A computation into D alone is dead if D is overwritten before it is read.
+func removeDeadDWrite(ins []string) (int, []string, bool) {
+	if len(ins) == 0 || !strings.HasPrefix(ins[0], "D=") || strings.Contains(ins[0], ";") {
+		return 0, nil, false
+	}
+	if live('D', ins[1:]) {
+		return 0, nil, false
+	}
+	return 1, []string{}, true
+}
+
+func isLabel(code string) bool {
+	return strings.HasPrefix(code, "(")
+}
+
+func hasPrefix(ins []string, prefix []string) bool {
+	if len(ins) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if ins[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// split breaks a C-instruction into its dest, comp and jump fields.
+func split(code string) (string, string, string) {
+	var dest, jump string
+	comp := code
+	if i := strings.Index(comp, "="); i != -1 {
+		dest, comp = comp[:i], comp[i+1:]
+	}
+	if i := strings.Index(comp, ";"); i != -1 {
+		comp, jump = comp[:i], comp[i+1:]
+	}
+	return dest, comp, jump
+}
+
+// reads reports whether the instruction uses the value of register reg ('A' or 'D').
+// Writing or reading memory and jumping all use A as an address.
+func reads(code string, reg byte) bool {
+	if strings.HasPrefix(code, "@") {
+		return false
+	}
+	dest, comp, jump := split(code)
+	if reg == 'A' {
+		return strings.ContainsAny(comp, "AM") || strings.Contains(dest, "M") || jump != ""
+	}
+	return strings.IndexByte(comp, reg) != -1
+}
+
+// writes reports whether the instruction stores into reg ('A', 'D' or 'M').
+func writes(code string, reg byte) bool {
+	if strings.HasPrefix(code, "@") {
+		return reg == 'A'
+	}
+	dest, _, _ := split(code)
+	return strings.IndexByte(dest, reg) != -1
+}
+
+// live reports whether the value of reg may be read by the instructions in rest
+// before it is overwritten. Labels and jumps make the answer unknown, so they
+// count as live.
+func live(reg byte, rest []string) bool {
+	for _, code := range rest {
+		if isLabel(code) || reads(code, reg) {
+			return true
+		}
+		if writes(code, reg) {
+			return false
+		}
+		if strings.Contains(code, ";") {
+			return true
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package peephole
+
+import (
+	"strings"
+	"testing"
+)
+
+func codes(lines []Line) []string {
+	output := []string{}
+	for _, line := range lines {
+		if line.Code != "" {
+			output = append(output, line.Code)
+		}
+	}
+	return output
+}
+
+func TestParse(t *testing.T) {
+	input := "\t// push constant 7\n\t@7\n\tD=A\n(LOOP)// goto LOOP\n\t@LOOP\n\n\t0;JMP\n"
+	expected := []Line{
+		{Comment: "push constant 7"},
+		{Code: "@7"},
+		{Code: "D=A"},
+		{Code: "(LOOP)", Comment: "goto LOOP"},
+		{Code: "@LOOP"},
+		{Code: "0;JMP"},
+	}
+
+	actual := Parse(input)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d lines got %d: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("line %d: expected %v got %v", i, expected[i], actual[i])
+		}
+	}
+
+	if formatted := Format(actual); len(Parse(formatted)) != len(expected) {
+		t.Errorf("formatting and reparsing changed the number of lines:\n%s", formatted)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		input    []string
+		expected []string
+	}{
+		{"push constant then pop pointer",
+			[]string{"@7", "D=A", "@SP", "A=M", "M=D", "@SP", "M=M+1", "@SP", "AM=M-1", "D=M", "@THIS", "M=D"},
+			[]string{"@7", "D=A", "@THIS", "M=D"},
+		},
+		{"push local then pop argument",
+			[]string{
+				"@0", "D=A", "@LCL", "A=D+M", "D=M", "@SP", "A=M", "M=D", "@SP", "M=M+1",
+				"@1", "D=A", "@ARG", "D=D+M", "@R13", "M=D", "@SP", "AM=M-1", "D=M", "@R13", "A=M", "M=D",
+			},
+			[]string{
+				"@0", "D=A", "@LCL", "A=D+M", "D=M", "@R14", "M=D",
+				"@1", "D=A", "@ARG", "D=D+M", "@R13", "M=D", "@R14", "D=M", "@R13", "A=M", "M=D",
+			},
+		},
+		{"push constant 1 then add",
+			[]string{
+				"@1", "D=A", "@SP", "A=M", "M=D", "@SP", "M=M+1",
+				"@SP", "AM=M-1", "D=M", "@SP", "AM=M-1", "D=D+M", "@SP", "A=M", "M=D", "@SP", "M=M+1",
+				"@5", "D=A",
+			},
+			[]string{"@SP", "A=M-1", "M=M+1", "@5", "D=A"},
+		},
+		{"push constant 9 then sub",
+			[]string{
+				"@9", "D=A", "@SP", "A=M", "M=D", "@SP", "M=M+1",
+				"@SP", "AM=M-1", "D=M", "@SP", "AM=M-1", "D=M-D", "@SP", "A=M", "M=D", "@SP", "M=M+1",
+				"@5", "D=A",
+			},
+			[]string{"@9", "D=A", "@SP", "A=M-1", "M=M-D", "@5", "D=A"},
+		},
+		{"neg",
+			[]string{"@SP", "AM=M-1", "D=-M", "@SP", "A=M", "M=D", "@SP", "M=M+1", "@5", "D=A"},
+			[]string{"@SP", "A=M-1", "M=-M", "@5", "D=A"},
+		},
+		{"push constant 0",
+			[]string{"@0", "D=A", "@SP", "A=M", "M=D", "@SP", "M=M+1", "@5", "D=A"},
+			[]string{"@SP", "A=M", "M=0", "@SP", "M=M+1", "@5", "D=A"},
+		},
+		{"redundant SP load while initializing locals",
+			[]string{"@SP", "A=M", "M=0", "@SP", "M=M+1", "@SP", "A=M", "M=0", "@SP", "M=M+1"},
+			[]string{"@SP", "A=M", "M=0", "@SP", "M=M+1", "A=M", "M=0", "@SP", "M=M+1"},
+		},
+		{"dead D write",
+			[]string{"@LCL", "D=M", "@5", "D=A", "@R13", "M=D"},
+			[]string{"@LCL", "@5", "D=A", "@R13", "M=D"},
+		},
+		{"D read by a conditional jump is live",
+			[]string{"@SP", "AM=M-1", "D=M", "@LOOP", "D;JNE"},
+			[]string{"@SP", "AM=M-1", "D=M", "@LOOP", "D;JNE"},
+		},
+		{"labels stop fusion",
+			[]string{"@SP", "A=M", "M=D", "@SP", "M=M+1", "(L)", "@SP", "AM=M-1", "D=M", "@L", "D;JNE"},
+			[]string{"@SP", "A=M", "M=D", "@SP", "M=M+1", "(L)", "@SP", "AM=M-1", "D=M", "@L", "D;JNE"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			input := []Line{}
+			for _, code := range test.input {
+				input = append(input, Line{Code: code})
+			}
+
+			actual := codes(Optimize(input))
+			if strings.Join(actual, " ") != strings.Join(test.expected, " ") {
+				t.Errorf("expected\n%v\ngot\n%v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsComments(t *testing.T) {
+	input := Parse("\t// push constant 7\n\t@7\n\tD=A\n\t@SP\n\tA=M\n\tM=D\n\t@SP\n\tM=M+1\n\t// pop pointer 0\n\t@SP\n\tAM=M-1\n\tD=M\n\t@THIS\n\tM=D\n")
+	output := Optimize(input)
+
+	comments := 0
+	for _, line := range output {
+		if line.Comment != "" {
+			comments += 1
+		}
+	}
+	if comments != 2 {
+		t.Errorf("expected both comments to survive optimization got:\n%s", Format(output))
+	}
+	if Count(output) != 4 {
+		t.Errorf("expected 4 instructions after optimization got %d:\n%s", Count(output), Format(output))
+	}
+}
+
+func TestOptimizeKeepsOrigins(t *testing.T) {
+	input := []Line{
+		{Code: "@7", Origin: 1},
+		{Code: "D=A", Origin: 1},
+		{Code: "@SP", Origin: 1},
+		{Code: "A=M", Origin: 1},
+		{Code: "M=D", Origin: 1},
+		{Code: "@SP", Origin: 1},
+		{Code: "M=M+1", Origin: 1},
+		{Code: "@SP", Origin: 2},
+		{Code: "AM=M-1", Origin: 2},
+		{Code: "D=-M", Origin: 2},
+		{Code: "@SP", Origin: 2},
+		{Code: "A=M", Origin: 2},
+		{Code: "M=D", Origin: 2},
+		{Code: "@SP", Origin: 2},
+		{Code: "M=M+1", Origin: 2},
+		{Code: "@3", Origin: 3},
+		{Code: "D=A", Origin: 3},
+	}
+
+	for _, line := range Optimize(input) {
+		if line.Origin < 1 || line.Origin > 3 {
+			t.Errorf("instruction %q lost its origin: %d", line.Code, line.Origin)
+		}
+		if line.Code == "@3" && line.Origin != 3 {
+			t.Errorf("expected untouched instruction %q to keep origin 3 got %d", line.Code, line.Origin)
+		}
+	}
+}
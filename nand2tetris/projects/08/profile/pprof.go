@@ -0,0 +1,148 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// protobuf encodes the fields of one protocol buffer message.
+type protobuf []byte
+
+func (b *protobuf) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protobuf) uint(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protobuf) packed(field int, values []uint64) {
+	var packed protobuf
+	for _, v := range values {
+		packed.varint(v)
+	}
+	b.bytes(field, packed)
+}
+
+// Field numbers of the messages of profile.proto from github.com/google/pprof.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format read by
+// go tool pprof. Every sample is the number of instructions executed at a line
+// of a VM function, with the stack of calls that led there.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	table := []string{""}
+	stringID := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		if id, ok := stringID[s]; ok {
+			return uint64(id)
+		}
+		stringID[s] = len(table)
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+
+	var profile protobuf
+	var valueType protobuf
+	valueType.uint(valueTypeType, str("instructions"))
+	valueType.uint(valueTypeUnit, str("count"))
+	profile.bytes(profileSampleType, valueType)
+
+	// samples in a fixed order so the same run always gives the same file
+	samples := make([]sample, 0, len(p.samples))
+	for s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].caller != samples[j].caller {
+			return samples[i].caller < samples[j].caller
+		}
+		return samples[i].location < samples[j].location
+	})
+	for _, s := range samples {
+		// location ids start at 1, leaf first
+		ids := []uint64{uint64(s.location) + 1}
+		for n := s.caller; n > 0; n = p.nodes[n].parent {
+			ids = append(ids, uint64(p.nodes[n].location)+1)
+		}
+		var message protobuf
+		message.packed(sampleLocationID, ids)
+		message.packed(sampleValue, []uint64{p.samples[s]})
+		profile.bytes(profileSample, message)
+	}
+
+	for i, l := range p.locations {
+		var line protobuf
+		line.uint(lineFunctionID, uint64(l[0])+1)
+		line.uint(lineLine, uint64(l[1]))
+		var message protobuf
+		message.uint(locationID, uint64(i)+1)
+		message.bytes(locationLine, line)
+		profile.bytes(profileLocation, message)
+	}
+
+	for i, f := range p.functions {
+		var message protobuf
+		message.uint(functionID, uint64(i)+1)
+		message.uint(functionName, str(f.Name))
+		message.uint(functionSystemName, str(f.Name))
+		message.uint(functionFilename, str(f.File))
+		message.uint(functionStartLine, uint64(f.Line))
+		profile.bytes(profileFunction, message)
+	}
+
+	var periodType protobuf
+	periodType.uint(valueTypeType, str("instructions"))
+	periodType.uint(valueTypeUnit, str("count"))
+	profile.bytes(profilePeriodType, periodType)
+	profile.uint(profilePeriod, 1)
+
+	// the string table goes last since the fields above add to it
+	for _, s := range table {
+		profile.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,336 @@
+// Package profile measures where translated VM programs spend their time. It
+// runs the assembled program and uses the source map of the CodeWriter to
+// attribute every executed instruction to the VM function and command it was
+// generated from.
+package profile
+
+import (
+	"VMtranslator/codewriter"
+	"VMtranslator/hack"
+	"VMtranslator/parser"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Function is the cost of one VM function. Code outside functions is counted
+// as a function named after its file in parentheses, like "(Main.vm)", and so
+// is code that doesn't come from a .vm file, like "(bootstrap)".
+type Function struct {
+	Name  string
+	File  string // .vm file the function is defined in
+	Line  int    // line of the function command in File
+	Calls uint64 // times the function was called
+	Flat  uint64 // instructions executed in the function itself
+	Cum   uint64 // instructions executed in the function and everything it called
+}
+
+// Kind is the number of instructions executed for one kind of VM command, like
+// "push" or "call". The code of the bootstrap and the shared routines has the
+// kinds "bootstrap", "runtime" and "runtime checks".
+type Kind struct {
+	Name         string
+	Instructions uint64
+}
+
+// origin is what the instruction at a ROM address was generated from.
+type origin struct {
+	fn      int // index into functions, or -1 for shared code that runs for the caller
+	kind    int // index into kinds
+	line    int // line of the VM command
+	calls   bool
+	returns bool
+}
+
+// frame is a function on the call stack of the running program.
+type frame struct {
+	fn     int
+	caller int   // node of the callers in the call tree
+	line   int   // line of the command the function is executing
+	base   int16 // SP when the function was called, 0 for the functions at the bottom
+}
+
+// node is a location in the call tree. It's the caller of every frame called
+// from that location through the same callers.
+type node struct {
+	parent   int
+	location int
+}
+
+// sample is where instructions were executed: a location in the function on
+// top of the stack and the node of the callers that led there.
+type sample struct {
+	caller   int
+	location int
+}
+
+// Profiler runs a program and counts the instructions it executes.
+type Profiler struct {
+	origins   []origin
+	entries   map[uint16]int // index of the function starting at a ROM address
+	functions []*Function
+	kinds     []Kind
+	runtime   int // index of the function charged for shared code run outside any function
+
+	locations  [][2]int       // function and line of every location
+	locationID map[[2]int]int // index into locations
+	nodes      []node
+	nodeID     map[node]int
+	samples    map[sample]uint64
+
+	stack     []frame
+	lastCalls bool // the last instruction belonged to a call and may have jumped to a function
+	total     uint64
+}
+
+// New returns a Profiler for prog, the program assembled from the output of a
+// CodeWriter with the source map mappings. cmds are the VM commands that were
+// translated, which tell which function every line of the source belongs to.
+func New(prog *hack.Program, mappings []codewriter.Mapping, cmds []parser.Command) *Profiler {
+	p := &Profiler{
+		entries:    map[uint16]int{},
+		locationID: map[[2]int]int{},
+		nodes:      []node{{parent: -1, location: -1}},
+		nodeID:     map[node]int{},
+		samples:    map[sample]uint64{},
+	}
+	functionID := map[string]int{}
+	function := func(name, file string, line int) int {
+		if id, ok := functionID[name]; ok {
+			return id
+		}
+		functionID[name] = len(p.functions)
+		p.functions = append(p.functions, &Function{Name: name, File: file, Line: line})
+		return len(p.functions) - 1
+	}
+	kindID := map[string]int{}
+	kind := func(name string) int {
+		if id, ok := kindID[name]; ok {
+			return id
+		}
+		kindID[name] = len(p.kinds)
+		p.kinds = append(p.kinds, Kind{Name: name})
+		return len(p.kinds) - 1
+	}
+	p.runtime = function("(runtime)", "", 0)
+
+	// the function of every line of the source
+	type fileLine struct {
+		file string
+		line int
+	}
+	functionOf := map[fileLine]int{}
+	curr := -1
+	for i, cmd := range cmds {
+		switch {
+		case cmd.Type == parser.C_FUNCTION:
+			curr = function(cmd.Arg1, cmd.File, cmd.Pos.Line)
+			if address, ok := prog.Symbols[cmd.Arg1]; ok {
+				p.entries[uint16(address)] = curr
+			}
+		case i == 0 || cmd.File != cmds[i-1].File:
+			curr = function("("+cmd.File+")", cmd.File, 0)
+		}
+		functionOf[fileLine{cmd.File, cmd.Pos.Line}] = curr
+	}
+
+	p.origins = make([]origin, len(prog.ROM))
+	for i := range p.origins {
+		p.origins[i] = origin{fn: -1, kind: kind("unknown")}
+	}
+	for _, m := range mappings {
+		if m.ROMAddress >= len(p.origins) {
+			continue
+		}
+		o := origin{fn: -1, line: m.Line}
+		if m.File == "" {
+			// shared code runs for the function on top of the stack
+			o.kind = kind(m.Command)
+			o.calls = true
+			if m.Command == "bootstrap" {
+				o.fn = function("(bootstrap)", "", 0)
+			}
+		} else {
+			name := m.Command
+			if i := strings.Index(name, " "); i != -1 {
+				name = name[:i]
+			}
+			o.kind = kind(name)
+			o.calls = name == "call"
+			o.returns = name == "return"
+			fn, ok := functionOf[fileLine{m.File, m.Line}]
+			if !ok {
+				fn = function("("+m.File+")", m.File, 0)
+			}
+			o.fn = fn
+		}
+		p.origins[m.ROMAddress] = o
+	}
+	return p
+}
+
+func (p *Profiler) location(fn, line int) int {
+	key := [2]int{fn, line}
+	if id, ok := p.locationID[key]; ok {
+		return id
+	}
+	p.locationID[key] = len(p.locations)
+	p.locations = append(p.locations, key)
+	return len(p.locations) - 1
+}
+
+func (p *Profiler) child(parent, location int) int {
+	n := node{parent, location}
+	if id, ok := p.nodeID[n]; ok {
+		return id
+	}
+	p.nodeID[n] = len(p.nodes)
+	p.nodes = append(p.nodes, n)
+	return len(p.nodes) - 1
+}
+
+// push calls fn from the function on top of the stack with SP at base.
+func (p *Profiler) push(fn int, base int16) {
+	caller := 0
+	if len(p.stack) != 0 {
+		top := p.stack[len(p.stack)-1]
+		caller = p.child(top.caller, p.location(top.fn, top.line))
+	}
+	p.stack = append(p.stack, frame{fn: fn, caller: caller, line: p.functions[fn].Line, base: base})
+}
+
+// Run executes the program on cpu until it halts or maxCycles instructions have
+// been executed, and returns the number executed. It can be called again to
+// continue profiling.
+func (p *Profiler) Run(cpu *hack.CPU, maxCycles uint64) uint64 {
+	start := cpu.Cycles
+	for cpu.Cycles-start < maxCycles && !cpu.Halted() {
+		o := origin{fn: -1}
+		if int(cpu.PC) < len(p.origins) {
+			o = p.origins[cpu.PC]
+		}
+
+		sp := cpu.RAM[0]
+		if fn, ok := p.entries[cpu.PC]; ok && p.lastCalls {
+			p.functions[fn].Calls += 1
+			p.push(fn, sp)
+		} else if o.fn >= 0 && !o.returns {
+			// Returning sets SP below the frame of the function that returned.
+			// Code reached some other way, like a jump, pops frames until the
+			// function it belongs to.
+			for len(p.stack) != 0 && sp < p.stack[len(p.stack)-1].base {
+				p.stack = p.stack[:len(p.stack)-1]
+			}
+			for len(p.stack) != 0 && p.stack[len(p.stack)-1].fn != o.fn {
+				p.stack = p.stack[:len(p.stack)-1]
+			}
+		}
+		switch {
+		case len(p.stack) == 0 && o.fn >= 0:
+			p.push(o.fn, 0)
+		case len(p.stack) == 0:
+			p.push(p.runtime, 0)
+		}
+
+		top := &p.stack[len(p.stack)-1]
+		if o.fn >= 0 {
+			top.line = o.line
+		}
+		p.functions[top.fn].Flat += 1
+		p.kinds[o.kind].Instructions += 1
+		p.samples[sample{top.caller, p.location(top.fn, top.line)}] += 1
+		p.lastCalls = o.calls
+		p.total += 1
+		cpu.Step()
+	}
+	return cpu.Cycles - start
+}
+
+// Total returns the number of instructions profiled.
+func (p *Profiler) Total() uint64 {
+	return p.total
+}
+
+// callers returns the functions of the call tree from n to the root.
+func (p *Profiler) callers(n int) []int {
+	functions := []int{}
+	for ; n > 0; n = p.nodes[n].parent {
+		functions = append(functions, p.locations[p.nodes[n].location][0])
+	}
+	return functions
+}
+
+// Functions returns the functions that executed instructions or were called,
+// most expensive first.
+func (p *Profiler) Functions() []*Function {
+	for _, f := range p.functions {
+		f.Cum = 0
+	}
+	for s, count := range p.samples {
+		counted := map[int]bool{p.locations[s.location][0]: true}
+		for _, fn := range p.callers(s.caller) {
+			counted[fn] = true
+		}
+		for fn := range counted {
+			p.functions[fn].Cum += count
+		}
+	}
+
+	functions := []*Function{}
+	for _, f := range p.functions {
+		if f.Flat != 0 || f.Calls != 0 {
+			functions = append(functions, f)
+		}
+	}
+	sort.SliceStable(functions, func(i, j int) bool {
+		if functions[i].Flat != functions[j].Flat {
+			return functions[i].Flat > functions[j].Flat
+		}
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
+
+// Kinds returns the instructions executed for every kind of command, most
+// expensive first.
+func (p *Profiler) Kinds() []Kind {
+	kinds := []Kind{}
+	for _, k := range p.kinds {
+		if k.Instructions != 0 {
+			kinds = append(kinds, k)
+		}
+	}
+	sort.SliceStable(kinds, func(i, j int) bool {
+		if kinds[i].Instructions != kinds[j].Instructions {
+			return kinds[i].Instructions > kinds[j].Instructions
+		}
+		return kinds[i].Name < kinds[j].Name
+	})
+	return kinds
+}
+
+func percent(n, total uint64) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return strconv.FormatFloat(100*float64(n)/float64(total), 'f', 1, 64) + "%"
+}
+
+// WriteReport writes a table of the cost of every function and every kind of
+// command.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Total: %d instructions\n\n", p.total)
+	fmt.Fprintf(bw, "%12s %6s %12s %6s %10s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "function")
+	for _, f := range p.Functions() {
+		fmt.Fprintf(bw, "%12d %6s %12d %6s %10d  %s\n", f.Flat, percent(f.Flat, p.total), f.Cum, percent(f.Cum, p.total), f.Calls, f.Name)
+	}
+	fmt.Fprintf(bw, "\n%12s %6s  %s\n", "instructions", "%", "command")
+	for _, k := range p.Kinds() {
+		fmt.Fprintf(bw, "%12d %6s  %s\n", k.Instructions, percent(k.Instructions, p.total), k.Name)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,176 @@
+package profile
+
+import (
+	"VMtranslator/codewriter"
+	"VMtranslator/hack"
+	"VMtranslator/parser"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// profileFiles translates the .vm files at paths with a bootstrap, like a
+// directory, runs the program for at most cycles and returns its profile.
+func profileFiles(t *testing.T, paths []string, cycles uint64, configure func(cw *codewriter.CodeWriter)) *Profiler {
+	f, err := os.Create(filepath.Join(t.TempDir(), "Program.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw := codewriter.NewCodeWriter(f)
+	cw.EnableSourceMap()
+	configure(cw)
+	cw.WriteInit()
+	cmds := []parser.Command{}
+	for _, path := range paths {
+		src, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileCmds, err := parser.NewParser(src).ParseAll()
+		src.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cw.SetFileName(filepath.Base(path))
+		for _, cmd := range fileCmds {
+			if err := cw.Write(cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmds = append(cmds, fileCmds...)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	asm, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer asm.Close()
+	prog, err := hack.Assemble(asm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(prog, cw.SourceMap(), cmds)
+	p.Run(hack.NewCPU(prog.ROM), cycles)
+	return p
+}
+
+var fibonacciElement = []string{"../FunctionCalls/FibonacciElement/Main.vm", "../FunctionCalls/FibonacciElement/Sys.vm"}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+	configurations := []struct {
+		name      string
+		configure func(cw *codewriter.CodeWriter)
+	}{
+		{"plain", func(cw *codewriter.CodeWriter) {}},
+		{"optimized", func(cw *codewriter.CodeWriter) { cw.EnableOptimization() }},
+		{"shared runtime", func(cw *codewriter.CodeWriter) { cw.EnableSharedRuntime() }},
+		{"checked", func(cw *codewriter.CodeWriter) { cw.EnableChecks(codewriter.DefaultStackLimit) }},
+	}
+
+	for _, c := range configurations {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			p := profileFiles(t, fibonacciElement, 100000, c.configure)
+			if p.Total() == 0 || p.Total() >= 100000 {
+				t.Fatalf("expected the program to halt got %d instructions", p.Total())
+			}
+
+			functions := map[string]*Function{}
+			var flat uint64
+			for _, f := range p.Functions() {
+				functions[f.Name] = f
+				flat += f.Flat
+			}
+			if flat != p.Total() {
+				t.Errorf("expected the flat costs to add up to %d got %d", p.Total(), flat)
+			}
+			var kinds uint64
+			for _, k := range p.Kinds() {
+				kinds += k.Instructions
+			}
+			if kinds != p.Total() {
+				t.Errorf("expected the command kinds to add up to %d got %d", p.Total(), kinds)
+			}
+
+			// fibonacci(4) calls itself 8 times
+			fib, init := functions["Main.fibonacci"], functions["Sys.init"]
+			if fib == nil || init == nil {
+				t.Fatalf("expected Main.fibonacci and Sys.init in the profile got %v", functions)
+			}
+			if fib.Calls != 9 || init.Calls != 1 {
+				t.Errorf("expected 9 calls of Main.fibonacci and 1 of Sys.init got %d and %d", fib.Calls, init.Calls)
+			}
+			if fib.File != "Main.vm" || fib.Line != 11 {
+				t.Errorf("expected Main.fibonacci at Main.vm:11 got %s:%d", fib.File, fib.Line)
+			}
+			if fib.Cum != fib.Flat || fib.Flat == 0 {
+				t.Errorf("expected Main.fibonacci to only cost its own instructions got flat %d cum %d", fib.Flat, fib.Cum)
+			}
+			if init.Cum != init.Flat+fib.Cum {
+				t.Errorf("expected Sys.init to cost %d with what it calls got %d", init.Flat+fib.Cum, init.Cum)
+			}
+			// checked code jumps over the shared routines before the bootstrap runs
+			bootstrap, runtime := functions["(bootstrap)"], functions["(runtime)"]
+			if runtime == nil {
+				runtime = &Function{}
+			}
+			if bootstrap == nil || bootstrap.Cum+runtime.Cum != p.Total() {
+				t.Errorf("expected the bootstrap to include every instruction got %+v", bootstrap)
+			}
+		})
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+	p := profileFiles(t, fibonacciElement, 100000, func(cw *codewriter.CodeWriter) {})
+	var report bytes.Buffer
+	if err := p.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(report.String(), "\n")
+	if !strings.HasPrefix(lines[0], "Total: ") || !strings.HasSuffix(lines[3], "Main.fibonacci") {
+		t.Errorf("expected Main.fibonacci to be the most expensive function got\n%s", report.String())
+	}
+	for _, kind := range []string{"call", "return", "push", "bootstrap"} {
+		if !strings.Contains(report.String(), "  "+kind+"\n") {
+			t.Errorf("expected the report to count %s commands got\n%s", kind, report.String())
+		}
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	t.Parallel()
+	p := profileFiles(t, fibonacciElement, 100000, func(cw *codewriter.CodeWriter) {})
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WritePprof(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	output, err := exec.Command("go", "tool", "pprof", "-top", "-nodecount=20", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool pprof could not read the profile: %v\n%s", err, output)
+	}
+	for _, name := range []string{"Main.fibonacci", "Sys.init", "(bootstrap)"} {
+		if !strings.Contains(string(output), name) {
+			t.Errorf("expected %s in the profile got\n%s", name, output)
+		}
+	}
+	if !strings.Contains(string(output), "100%") {
+		t.Errorf("expected the bootstrap to account for all instructions got\n%s", output)
+	}
+}
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"VMtranslator/hack"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader reads the events of a binary trace.
+type Reader struct {
+	r     *bufio.Reader
+	cycle uint64 // cycle of the last event
+}
+
+// NewReader returns a Reader for the binary trace r. It fails if r doesn't
+// start like a binary trace of a version it reads.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, errors.New("not a binary trace")
+	}
+	if header[len(magic)] != version {
+		return nil, fmt.Errorf("binary trace of version %d, expected %d", header[len(magic)], version)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next event. It returns io.EOF at the end of the trace and
+// io.ErrUnexpectedEOF if the trace ends within an event.
+func (r *Reader) Next() (hack.Event, error) {
+	delta, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return hack.Event{}, io.EOF
+	}
+	if err != nil {
+		return hack.Event{}, unexpected(err)
+	}
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return hack.Event{}, unexpected(err)
+	}
+	words := 4
+	if flags&(flagReadsM|flagWritesM) != 0 {
+		words++
+	}
+	if flags&flagReadsM != 0 {
+		words++
+	}
+	if flags&flagWritesM != 0 {
+		words++
+	}
+	var buf [7 * 2]byte
+	if _, err := io.ReadFull(r.r, buf[:words*2]); err != nil {
+		return hack.Event{}, unexpected(err)
+	}
+	word := func(i int) uint16 {
+		return binary.LittleEndian.Uint16(buf[i*2:])
+	}
+
+	r.cycle += delta
+	e := hack.Event{
+		Cycle:       r.cycle,
+		PC:          word(0),
+		Instruction: word(1),
+		A:           int16(word(2)),
+		D:           int16(word(3)),
+		ReadsM:      flags&flagReadsM != 0,
+		WritesM:     flags&flagWritesM != 0,
+	}
+	next := 4
+	if e.ReadsM || e.WritesM {
+		e.Address = word(next)
+		next++
+	}
+	if e.ReadsM {
+		e.Read = int16(word(next))
+		next++
+	}
+	if e.WritesM {
+		e.Written = int16(word(next))
+	}
+	return e, nil
+}
+
+// unexpected returns err, with io.EOF turned into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
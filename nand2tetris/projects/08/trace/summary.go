@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Summary condenses a trace into how often every instruction ran and every
+// RAM address was written.
+type Summary struct {
+	Instructions uint64            // events in the trace
+	Executed     map[uint16]uint64 // times the instruction at a ROM address ran
+	Words        map[uint16]uint16 // the instruction at every ROM address that ran
+	Writes       map[uint16]uint64 // writes to a RAM address
+}
+
+// Summarize reads the rest of the trace r into a Summary.
+func Summarize(r *Reader) (*Summary, error) {
+	s := &Summary{
+		Executed: map[uint16]uint64{},
+		Words:    map[uint16]uint16{},
+		Writes:   map[uint16]uint64{},
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", s.Instructions+1, err)
+		}
+		s.Instructions++
+		s.Executed[e.PC]++
+		s.Words[e.PC] = e.Instruction
+		if e.WritesM {
+			s.Writes[e.Address]++
+		}
+	}
+}
+
+// Count is how often something happened at an address.
+type Count struct {
+	Address uint16
+	Count   uint64
+}
+
+// Top returns the n addresses with the highest counts, highest first. Equal
+// counts are ordered by address.
+func Top(counts map[uint16]uint64, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for address, count := range counts {
+		top = append(top, Count{address, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Address < top[j].Address
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Region is a part of RAM the VM uses for one purpose.
+type Region struct {
+	Name     string
+	From, To uint16 // the addresses of the region, both included
+}
+
+// Regions are the parts of RAM of the VM's memory layout, in order.
+var Regions = []Region{
+	{"pointers", 0, 4}, // SP, LCL, ARG, THIS and THAT
+	{"temp", 5, 12},
+	{"R13-R15", 13, 15},
+	{"static", 16, 255},
+	{"stack", 256, 2047},
+	{"heap", 2048, 16383},
+	{"screen", 16384, 24575},
+	{"keyboard", 24576, 24576},
+}
+
+// RegionOf returns the name of the region of RAM address is in.
+func RegionOf(address uint16) string {
+	for _, r := range Regions {
+		if address >= r.From && address <= r.To {
+			return r.Name
+		}
+	}
+	return "unmapped"
+}
+
+// histogramWidth is the number of characters of the longest bar of the
+// histogram of writes.
+const histogramWidth = 40
+
+// WriteReport writes the top hottest instructions and most written addresses
+// of s to w, after a histogram of the writes to every region. Instructions are
+// named after the labels, if there are any, like LOOP+2.
+func (s *Summary) WriteReport(w io.Writer, top int, labels map[string]int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Total: %d instructions\n\n", s.Instructions)
+
+	fmt.Fprintf(bw, "%12s %6s %6s  %-14s %s\n", "executed", "%", "pc", "instruction", "location")
+	locations := newLocator(labels)
+	for _, c := range Top(s.Executed, top) {
+		line := fmt.Sprintf("%12d %6s %6d  %-14s %s", c.Count, percent(c.Count, s.Instructions), c.Address, Disassemble(s.Words[c.Address]), locations.locate(c.Address))
+		fmt.Fprintln(bw, strings.TrimRight(line, " "))
+	}
+
+	var writes uint64
+	byRegion := map[string]uint64{}
+	for address, count := range s.Writes {
+		writes += count
+		byRegion[RegionOf(address)] += count
+	}
+	var most uint64
+	for _, count := range byRegion {
+		if count > most {
+			most = count
+		}
+	}
+	fmt.Fprintf(bw, "\nWrites: %d\n\n", writes)
+	fmt.Fprintf(bw, "%12s %6s  %s\n", "writes", "%", "region")
+	names := []string{}
+	for _, r := range Regions {
+		names = append(names, r.Name)
+	}
+	for _, name := range append(names, "unmapped") {
+		count := byRegion[name]
+		if count == 0 {
+			continue
+		}
+		bar := strings.Repeat("#", int((count*histogramWidth+most-1)/most))
+		fmt.Fprintf(bw, "%12d %6s  %-10s %s\n", count, percent(count, writes), name, bar)
+	}
+
+	fmt.Fprintf(bw, "\n%12s %6s %6s  %s\n", "writes", "%", "ram", "region")
+	for _, c := range Top(s.Writes, top) {
+		fmt.Fprintf(bw, "%12d %6s %6d  %s\n", c.Count, percent(c.Count, writes), c.Address, RegionOf(c.Address))
+	}
+	return bw.Flush()
+}
+
+func percent(part, total uint64) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+// locator names ROM addresses after the closest label at or before them.
+type locator struct {
+	addresses []int
+	names     map[int]string
+}
+
+func newLocator(labels map[string]int) *locator {
+	l := &locator{names: map[int]string{}}
+	for name, address := range labels {
+		// of the labels of an address, the first by name is used
+		if other, ok := l.names[address]; !ok || name < other {
+			if !ok {
+				l.addresses = append(l.addresses, address)
+			}
+			l.names[address] = name
+		}
+	}
+	sort.Ints(l.addresses)
+	return l
+}
+
+// locate returns the label at or before address and the distance from it, or
+// "" if there's none.
+func (l *locator) locate(address uint16) string {
+	i := sort.SearchInts(l.addresses, int(address)+1) - 1
+	if i < 0 {
+		return ""
+	}
+	label := l.addresses[i]
+	if label == int(address) {
+		return l.names[label]
+	}
+	return fmt.Sprintf("%s+%d", l.names[label], int(address)-label)
+}
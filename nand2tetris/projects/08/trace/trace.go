@@ -0,0 +1,222 @@
+// Package trace records every instruction a Hack program executes, for
+// diagnosing generated code one cycle at a time. Traces are written as text to
+// be read, or in a compact binary format that Reader reads back and Summarize
+// condenses into the hottest addresses and the memory written.
+package trace
+
+import (
+	"VMtranslator/hack"
+	"assembler/isa"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is how a Writer writes events.
+type Format int
+
+const (
+	Text   Format = iota // one line per instruction
+	Binary               // compact records that Reader reads
+)
+
+// ParseFormat returns the format called name, "text" or "binary".
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "text":
+		return Text, nil
+	case "binary":
+		return Binary, nil
+	}
+	return 0, fmt.Errorf("unknown trace format %q, expected text or binary", name)
+}
+
+// magic starts every binary trace. The byte after it is the version of the
+// format.
+const (
+	magic   = "HACKTRACE"
+	version = 1
+)
+
+// maxRecord is the size of the longest binary record: the cycle since the
+// last record, the flags and seven words.
+const maxRecord = binary.MaxVarintLen64 + 1 + 7*2
+
+// Flags of a binary record.
+const (
+	flagReadsM = 1 << iota
+	flagWritesM
+)
+
+// Writer writes the events of a CPU that pass its filter. Its Trace method is
+// meant to be the Trace of a hack.CPU.
+type Writer struct {
+	w      *bufio.Writer
+	format Format
+	filter Filter
+	last   uint64 // cycle of the last binary record
+	buf    [maxRecord]byte
+}
+
+// NewWriter returns a Writer that writes the events filter matches to w in
+// format. Call Flush when the program has run.
+func NewWriter(w io.Writer, format Format, filter Filter) *Writer {
+	t := &Writer{w: bufio.NewWriter(w), format: format, filter: filter}
+	if format == Binary {
+		t.w.WriteString(magic)
+		t.w.WriteByte(version)
+	}
+	return t
+}
+
+// Trace writes e if the filter matches its PC. Errors are reported by Flush.
+func (t *Writer) Trace(e hack.Event) {
+	if !t.filter.Match(e.PC) {
+		return
+	}
+	if t.format == Text {
+		t.w.WriteString(FormatEvent(e))
+		t.w.WriteByte('\n')
+		return
+	}
+
+	b := t.buf[:]
+	n := binary.PutUvarint(b, e.Cycle-t.last)
+	t.last = e.Cycle
+	var flags byte
+	if e.ReadsM {
+		flags |= flagReadsM
+	}
+	if e.WritesM {
+		flags |= flagWritesM
+	}
+	b[n] = flags
+	n++
+	put := func(word uint16) {
+		binary.LittleEndian.PutUint16(b[n:], word)
+		n += 2
+	}
+	put(e.PC)
+	put(e.Instruction)
+	put(uint16(e.A))
+	put(uint16(e.D))
+	if e.ReadsM || e.WritesM {
+		put(e.Address)
+	}
+	if e.ReadsM {
+		put(uint16(e.Read))
+	}
+	if e.WritesM {
+		put(uint16(e.Written))
+	}
+	t.w.Write(b[:n])
+}
+
+// Flush writes any buffered events and returns the first error writing them.
+func (t *Writer) Flush() error {
+	return t.w.Flush()
+}
+
+// Disassemble returns the Hack assembly of word, or word in binary if it isn't
+// a Hack instruction.
+func Disassemble(word uint16) string {
+	code, err := isa.Hack.Disassemble(uint32(word))
+	if err != nil {
+		return isa.Hack.FormatWord(uint32(word))
+	}
+	return code
+}
+
+// FormatEvent returns e as a line of a text trace: the cycle, PC, instruction,
+// the registers after it and the memory it read and wrote, e.g.
+//
+//	1042    17  M=D+M          A=256    D=5      read M[256]=3 write M[256]=8
+func FormatEvent(e hack.Event) string {
+	line := fmt.Sprintf("%8d %5d  %-14s A=%-6d D=%-6d", e.Cycle, e.PC, Disassemble(e.Instruction), e.A, e.D)
+	if e.ReadsM {
+		line += fmt.Sprintf(" read M[%d]=%d", e.Address, e.Read)
+	}
+	if e.WritesM {
+		line += fmt.Sprintf(" write M[%d]=%d", e.Address, e.Written)
+	}
+	return strings.TrimRight(line, " ")
+}
+
+// Range is the ROM addresses From to To, both included.
+type Range struct {
+	From, To uint16
+}
+
+// Filter selects the instructions to trace by their address. The empty Filter
+// selects all of them.
+type Filter []Range
+
+// Match reports whether the instruction at pc is traced.
+func (f Filter) Match(pc uint16) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, r := range f {
+		if pc >= r.From && pc <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFilter parses a comma separated list of ranges of prog. A range is an
+// address, two addresses separated by a dash like 100-200, or a label, which
+// stands for its instructions up to the next label.
+func ParseFilter(s string, prog *hack.Program) (Filter, error) {
+	f := Filter{}
+	if s == "" {
+		return f, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if address, ok := prog.Labels[item]; ok {
+			r, err := labelRange(item, address, prog)
+			if err != nil {
+				return nil, err
+			}
+			f = append(f, r)
+			continue
+		}
+		from, to := item, item
+		if i := strings.Index(item, "-"); i > 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		start, err := strconv.ParseUint(from, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address, a range of addresses nor a label", item)
+		}
+		end, err := strconv.ParseUint(to, 10, 16)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("%q is neither an address, a range of addresses nor a label", item)
+		}
+		f = append(f, Range{From: uint16(start), To: uint16(end)})
+	}
+	return f, nil
+}
+
+// labelRange returns the range of the instructions from label, at address, up
+// to the next label.
+func labelRange(label string, address int, prog *hack.Program) (Range, error) {
+	if address >= len(prog.ROM) {
+		return Range{}, fmt.Errorf("label %s has no instructions after it", label)
+	}
+	addresses := []int{}
+	for _, a := range prog.Labels {
+		addresses = append(addresses, a)
+	}
+	sort.Ints(addresses)
+	end := len(prog.ROM)
+	if i := sort.SearchInts(addresses, address+1); i < len(addresses) && addresses[i] < end {
+		end = addresses[i]
+	}
+	return Range{From: uint16(address), To: uint16(end - 1)}, nil
+}
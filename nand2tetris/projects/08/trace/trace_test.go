@@ -0,0 +1,199 @@
+package trace
+
+import (
+	"VMtranslator/hack"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// program multiplies R0 by R1 into R2 by repeated addition.
+var program = []string{
+	"@R2", "M=0",
+	"(LOOP)",
+	"@R0", "D=M", "@END", "D;JEQ",
+	"@R1", "D=M", "@R2", "M=D+M",
+	"@R0", "M=M-1",
+	"@LOOP", "0;JMP",
+	"(END)",
+	"@END", "0;JMP",
+}
+
+func assemble(t *testing.T) *hack.Program {
+	p, err := hack.Assemble(strings.NewReader(strings.Join(program, "\n") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// run runs prog with R0 = 3 and R1 = 5, writing the trace to w, and returns
+// every event of the run.
+func run(prog *hack.Program, w *Writer) []hack.Event {
+	events := []hack.Event{}
+	cpu := hack.NewCPU(prog.ROM)
+	cpu.RAM[0], cpu.RAM[1] = 3, 5
+	cpu.Trace = func(e hack.Event) {
+		events = append(events, e)
+		w.Trace(e)
+	}
+	cpu.Run(1000)
+	return events
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	prog := assemble(t)
+	filter, err := ParseFilter("LOOP", prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Binary, filter)
+	events := run(prog, w)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range events {
+		if !filter.Match(expected.PC) {
+			continue
+		}
+		actual, err := r.Next()
+		if err != nil {
+			t.Fatalf("expected %+v got %v", expected, err)
+		}
+		if actual != expected {
+			t.Fatalf("expected %+v got %+v", expected, actual)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF after the last event got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("       0     0  @2")); err == nil {
+		t.Error("expected a text trace not to be read")
+	}
+	if _, err := NewReader(strings.NewReader(magic + "\x02")); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("expected an error for version 2 got %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Binary, nil)
+	w.Trace(hack.Event{Cycle: 3, PC: 4, WritesM: true, Address: 100, Written: -1})
+	w.Flush()
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected a truncated event to be an unexpected EOF got %v", err)
+	}
+}
+
+func TestText(t *testing.T) {
+	prog := assemble(t)
+	filter, err := ParseFilter("10-11", prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Text, filter)
+	run(prog, w)
+	w.Flush()
+	expected := strings.Join([]string{
+		"      10    10  @0             A=0      D=5",
+		"      11    11  M=M-1          A=0      D=5      read M[0]=3 write M[0]=2",
+		"      22    10  @0             A=0      D=5",
+		"      23    11  M=M-1          A=0      D=5      read M[0]=2 write M[0]=1",
+		"      34    10  @0             A=0      D=5",
+		"      35    11  M=M-1          A=0      D=5      read M[0]=1 write M[0]=0",
+	}, "\n") + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	prog := assemble(t)
+	tests := []struct {
+		s        string
+		expected Filter
+	}{
+		{"", Filter{}},
+		{"7", Filter{{7, 7}}},
+		{"2-5, 12", Filter{{2, 5}, {12, 12}}},
+		{"LOOP", Filter{{2, 13}}},
+		{"END,0", Filter{{14, 15}, {0, 0}}},
+	}
+	for _, test := range tests {
+		f, err := ParseFilter(test.s, prog)
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+			continue
+		}
+		if len(f) != len(test.expected) {
+			t.Errorf("%q: expected %v got %v", test.s, test.expected, f)
+			continue
+		}
+		for i := range f {
+			if f[i] != test.expected[i] {
+				t.Errorf("%q: expected %v got %v", test.s, test.expected, f)
+			}
+		}
+	}
+	for _, s := range []string{"NOPE", "5-2", "-1", "70000", "1-x"} {
+		if _, err := ParseFilter(s, prog); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	prog := assemble(t)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Binary, nil)
+	events := run(prog, w)
+	w.Flush()
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Summarize(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Instructions != uint64(len(events)) {
+		t.Errorf("expected %d instructions got %d", len(events), s.Instructions)
+	}
+	// the loop test runs once more than the body
+	if s.Executed[2] != 4 || s.Executed[6] != 3 || s.Executed[0] != 1 {
+		t.Errorf("unexpected counts %v", s.Executed)
+	}
+	top := Top(s.Writes, 2)
+	if len(top) != 2 || top[0] != (Count{2, 4}) || top[1] != (Count{0, 3}) {
+		t.Errorf("unexpected top writes %v", top)
+	}
+
+	var report bytes.Buffer
+	if err := s.WriteReport(&report, 3, prog.Labels); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Total: 42 instructions",
+		"           4   9.5%      2  @0             LOOP\n",
+		"           4   9.5%      3  D=M            LOOP+1\n",
+		"           7 100.0%  pointers   ########################################\n",
+		"           4  57.1%      2  pointers\n",
+	} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("expected the report to contain %q\n%s", expected, report.String())
+		}
+	}
+}
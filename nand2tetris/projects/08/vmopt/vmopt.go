@@ -0,0 +1,316 @@
+// Package vmopt rewrites parsed VM commands into shorter sequences that compute
+// the same results, before any backend translates them.
+package vmopt
+
+import (
+	"VMtranslator/parser"
+	"fmt"
+	"strings"
+)
+
+// A rule inspects the commands at the start of cmds. If it recognizes a window
+// it returns the number of commands consumed and their replacement.
+type rule func(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool)
+
+var rules = []rule{
+	foldConstants,
+	reduceStrength,
+	removeIdentityOperand,
+	collapsePushPop,
+	removeDoubleNot,
+	negateComparison,
+	jumpIfNotEqual,
+	jumpIfZero,
+}
+
+type optimizer struct {
+	skipLabels int    // labels added by jumpIfZero so far
+	function   string // function of the command being rewritten, empty outside functions
+}
+
+// Optimize applies every rule to cmds until none of them matches anymore. The
+// replacement commands take the file and position of the first command they
+// replace. cmds must come from a single file, since new labels are only unique
+// within one call and the file they are named after.
+func Optimize(cmds []parser.Command) []parser.Command {
+	o := &optimizer{}
+	for {
+		var changed bool
+		if cmds, changed = o.apply(cmds); !changed {
+			return cmds
+		}
+	}
+}
+
+// apply makes one pass over cmds, rewriting every window a rule matches.
+func (o *optimizer) apply(cmds []parser.Command) ([]parser.Command, bool) {
+	output := make([]parser.Command, 0, len(cmds))
+	changed := false
+	o.function = ""
+	for i := 0; i < len(cmds); {
+		if cmds[i].Type == parser.C_FUNCTION {
+			o.function = cmds[i].Arg1
+		}
+		matched := false
+		for _, r := range rules {
+			n, replacement, ok := r(o, cmds[i:])
+			if !ok {
+				continue
+			}
+			for _, cmd := range replacement {
+				cmd.File, cmd.Pos = cmds[i].File, cmds[i].Pos
+				output = append(output, cmd)
+			}
+			i += n
+			matched, changed = true, true
+			break
+		}
+		if !matched {
+			output = append(output, cmds[i])
+			i += 1
+		}
+	}
+	return output, changed
+}
+
+func arithmetic(op string) parser.Command {
+	return parser.Command{Type: parser.C_ARITHMETIC, Arg1: op, Arg2: -1}
+}
+
+func pushConstant(c int) parser.Command {
+	return parser.Command{Type: parser.C_PUSH, Arg1: "constant", Arg2: c}
+}
+
+func isArithmetic(cmd parser.Command, ops ...string) bool {
+	if cmd.Type != parser.C_ARITHMETIC {
+		return false
+	}
+	for _, op := range ops {
+		if cmd.Arg1 == op {
+			return true
+		}
+	}
+	return false
+}
+
+// isCall reports whether cmd calls the function name with two arguments.
+func isCall(cmd parser.Command, name string) bool {
+	return cmd.Type == parser.C_CALL && cmd.Arg1 == name && cmd.Arg2 == 2
+}
+
+// constant returns the value cmd pushes if it's a push constant.
+func constant(cmd parser.Command) (int, bool) {
+	if cmd.Type != parser.C_PUSH || cmd.Arg1 != "constant" {
+		return 0, false
+	}
+	return cmd.Arg2, true
+}
+
+// materialize returns the shortest commands that push the 16 bit value v.
+// push constant only takes 0 to 32767, so negative values need a neg or not.
+func materialize(v int16) []parser.Command {
+	switch {
+	case v >= 0:
+		return []parser.Command{pushConstant(int(v))}
+	case v == -32768:
+		return []parser.Command{pushConstant(32767), arithmetic("not")}
+	default:
+		return []parser.Command{pushConstant(int(-v)), arithmetic("neg")}
+	}
+}
+
+func truth(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// evaluate computes op the way the VM does, on 16 bit values.
+func evaluate(op string, x, y int16) int16 {
+	switch op {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return truth(x == y)
+	case "gt":
+		return truth(x > y)
+	case "lt":
+		return truth(x < y)
+	case "neg":
+		return -y
+	case "not":
+		return ^y
+	}
+	panic("unknown operation " + op)
+}
+
+// Operations on constants are computed at translation time if the result can be
+// pushed with fewer commands.
+//
+//	push constant 2, push constant 3, add  =>  push constant 5
+//	push constant 0, neg                   =>  push constant 0
+func foldConstants(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	x, ok := constant(cmds[0])
+	if !ok || len(cmds) < 2 {
+		return 0, nil, false
+	}
+	if isArithmetic(cmds[1], "neg", "not") {
+		folded := materialize(evaluate(cmds[1].Arg1, 0, int16(x)))
+		if len(folded) >= 2 {
+			return 0, nil, false
+		}
+		return 2, folded, true
+	}
+
+	y, ok := constant(cmds[1])
+	if !ok || len(cmds) < 3 || !isArithmetic(cmds[2], "add", "sub", "and", "or", "eq", "gt", "lt") {
+		return 0, nil, false
+	}
+	return 3, materialize(evaluate(cmds[2].Arg1, int16(x), int16(y))), true
+}
+
+// Multiplying and dividing by constants is done without calling the OS where
+// that's cheaper. Pushes have no side effects, so a value that's pushed can be
+// pushed twice or not at all. This assumes Math.multiply and Math.divide are the
+// ones of the Jack OS, which compute the 16 bit product and quotient.
+//
+//	push constant 3, push constant 4, call Math.multiply 2  =>  push constant 12
+//	push local 0, push constant 2, call Math.multiply 2     =>  push local 0, push local 0, add
+//	push local 0, push constant 0, call Math.multiply 2     =>  push constant 0
+//	push constant 1, call Math.multiply 2                   =>  (nothing)
+//	push constant 0, call Math.multiply 2                   =>  push constant 0, and
+//	push constant 1, call Math.divide 2                     =>  (nothing)
+func reduceStrength(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) >= 3 && cmds[0].Type == parser.C_PUSH && cmds[1].Type == parser.C_PUSH {
+		x, xConstant := constant(cmds[0])
+		y, yConstant := constant(cmds[1])
+		switch {
+		case xConstant && yConstant && isCall(cmds[2], "Math.multiply"):
+			return 3, materialize(int16(x) * int16(y)), true
+		case xConstant && yConstant && isCall(cmds[2], "Math.divide") && y != 0:
+			// both are positive, so there's no rounding to get wrong
+			return 3, materialize(int16(x / y)), true
+		case isCall(cmds[2], "Math.multiply") && (xConstant || yConstant):
+			push, c := cmds[0], y
+			if xConstant {
+				push, c = cmds[1], x
+			}
+			switch c {
+			case 0:
+				return 3, []parser.Command{pushConstant(0)}, true
+			case 1:
+				return 3, []parser.Command{push}, true
+			case 2:
+				return 3, []parser.Command{push, push, arithmetic("add")}, true
+			}
+		case isCall(cmds[2], "Math.divide") && y == 1 && yConstant:
+			return 3, []parser.Command{cmds[0]}, true
+		}
+	}
+
+	c, ok := constant(cmds[0])
+	if !ok || len(cmds) < 2 {
+		return 0, nil, false
+	}
+	switch {
+	case c == 1 && (isCall(cmds[1], "Math.multiply") || isCall(cmds[1], "Math.divide")):
+		return 2, []parser.Command{}, true
+	case c == 0 && isCall(cmds[1], "Math.multiply"):
+		return 2, []parser.Command{pushConstant(0), arithmetic("and")}, true
+	}
+	return 0, nil, false
+}
+
+// Adding, subtracting or or-ing 0 leaves the top of the stack as it is.
+//
+//	push constant 0, add  =>  (nothing)
+func removeIdentityOperand(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if c, ok := constant(cmds[0]); !ok || c != 0 || len(cmds) < 2 || !isArithmetic(cmds[1], "add", "sub", "or") {
+		return 0, nil, false
+	}
+	return 2, []parser.Command{}, true
+}
+
+// Pushing a value and popping it straight back to where it came from does nothing.
+//
+//	push local 2, pop local 2  =>  (nothing)
+func collapsePushPop(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) < 2 || cmds[0].Type != parser.C_PUSH || cmds[1].Type != parser.C_POP {
+		return 0, nil, false
+	}
+	if cmds[0].Arg1 != cmds[1].Arg1 || cmds[0].Arg2 != cmds[1].Arg2 {
+		return 0, nil, false
+	}
+	return 2, []parser.Command{}, true
+}
+
+// not, not  =>  (nothing)
+func removeDoubleNot(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) < 2 || !isArithmetic(cmds[0], "not") || !isArithmetic(cmds[1], "not") {
+		return 0, nil, false
+	}
+	return 2, []parser.Command{}, true
+}
+
+// Comparing the result of a comparison with 0 negates it. Comparisons only
+// produce true (-1) or false (0), so not gives the same result.
+//
+//	lt, push constant 0, eq  =>  lt, not
+func negateComparison(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) < 3 || !isArithmetic(cmds[0], "eq", "gt", "lt") || !isArithmetic(cmds[2], "eq") {
+		return 0, nil, false
+	}
+	if c, ok := constant(cmds[1]); !ok || c != 0 {
+		return 0, nil, false
+	}
+	return 3, []parser.Command{cmds[0], arithmetic("not")}, true
+}
+
+// if-goto jumps on any value other than 0, so testing for inequality only needs
+// the difference of the operands, which is 0 exactly when they are equal.
+//
+//	eq, not, if-goto L  =>  sub, if-goto L
+func jumpIfNotEqual(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) < 3 || !isArithmetic(cmds[0], "eq") || !isArithmetic(cmds[1], "not") || cmds[2].Type != parser.C_IF {
+		return 0, nil, false
+	}
+	return 3, []parser.Command{arithmetic("sub"), cmds[2]}, true
+}
+
+// Jumping when a value is zero can test it directly instead of comparing it to 0.
+//
+//	push constant 0, eq, if-goto L  =>  if-goto SKIP, goto L, label SKIP
+func jumpIfZero(o *optimizer, cmds []parser.Command) (int, []parser.Command, bool) {
+	if len(cmds) < 3 || !isArithmetic(cmds[1], "eq") || cmds[2].Type != parser.C_IF {
+		return 0, nil, false
+	}
+	if c, ok := constant(cmds[0]); !ok || c != 0 {
+		return 0, nil, false
+	}
+	skip := o.skipLabel(cmds[0])
+	return 3, []parser.Command{
+		{Type: parser.C_IF, Arg1: skip, Arg2: -1},
+		{Type: parser.C_GOTO, Arg1: cmds[2].Arg1, Arg2: -1},
+		{Type: parser.C_LABEL, Arg1: skip, Arg2: -1},
+	}, true
+}
+
+// skipLabel returns a new label for jumpIfZero to add before cmd. The backends
+// put the function in front of the labels in functions. Outside functions the
+// label starts with the file instead, like the CodeWriter's own labels, so the
+// labels of different files never clash. The parser rejects labels that
+// contain $, so they don't clash with the labels of the program either.
+func (o *optimizer) skipLabel(cmd parser.Command) string {
+	o.skipLabels += 1
+	if o.function != "" {
+		return fmt.Sprintf("skip$%d", o.skipLabels)
+	}
+	return fmt.Sprintf("%s$skip$%d", strings.Split(cmd.File, ".")[0], o.skipLabels)
+}
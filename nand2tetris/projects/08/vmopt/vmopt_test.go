@@ -0,0 +1,456 @@
+package vmopt
+
+import (
+	"VMtranslator/gowriter"
+	"VMtranslator/parser"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parse returns the commands of the .vm file at path.
+func parse(t *testing.T, path string) []parser.Command {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmds, err := parser.NewParser(f).ParseAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmds
+}
+
+// parseString returns the commands of the .vm source src.
+func parseString(t *testing.T, src string) []parser.Command {
+	path := filepath.Join(t.TempDir(), "Main.vm")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return parse(t, path)
+}
+
+func format(cmds []parser.Command) string {
+	lines := []string{}
+	for _, cmd := range cmds {
+		lines = append(lines, cmd.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		input    []string
+		expected []string
+	}{
+		{"fold add",
+			[]string{"push constant 2", "push constant 3", "add"},
+			[]string{"push constant 5"},
+		},
+		{"fold to a negative value",
+			[]string{"push constant 2", "push constant 3", "sub"},
+			[]string{"push constant 1", "neg"},
+		},
+		{"fold comparison",
+			[]string{"push constant 2", "push constant 3", "lt"},
+			[]string{"push constant 1", "neg"},
+		},
+		{"fold chain",
+			[]string{"push constant 1", "push constant 2", "add", "push constant 3", "add"},
+			[]string{"push constant 6"},
+		},
+		{"neg of 0",
+			[]string{"push constant 0", "neg"},
+			[]string{"push constant 0"},
+		},
+		{"add 0",
+			[]string{"push local 0", "push constant 0", "add", "pop local 1"},
+			[]string{"push local 0", "pop local 1"},
+		},
+		{"push then pop to the same place",
+			[]string{"push static 3", "pop static 3", "push local 0", "pop local 1"},
+			[]string{"push local 0", "pop local 1"},
+		},
+		{"double not",
+			[]string{"push local 0", "not", "not", "pop local 1"},
+			[]string{"push local 0", "pop local 1"},
+		},
+		{"comparison compared with 0",
+			[]string{"push local 0", "push local 1", "gt", "push constant 0", "eq", "pop local 2"},
+			[]string{"push local 0", "push local 1", "gt", "not", "pop local 2"},
+		},
+		{"jump if not equal",
+			[]string{"push local 0", "push local 1", "eq", "not", "if-goto L"},
+			[]string{"push local 0", "push local 1", "sub", "if-goto L"},
+		},
+		{"jump if not zero",
+			[]string{"push local 0", "push constant 0", "eq", "not", "if-goto L"},
+			[]string{"push local 0", "if-goto L"},
+		},
+		{"jump if zero",
+			[]string{"push local 0", "push constant 0", "eq", "if-goto L"},
+			[]string{"push local 0", "if-goto Main$skip$1", "goto L", "label Main$skip$1"},
+		},
+		{"jump if zero in a function",
+			[]string{"function Main.f 0", "push local 0", "push constant 0", "eq", "if-goto L"},
+			[]string{"function Main.f 0", "push local 0", "if-goto skip$1", "goto L", "label skip$1"},
+		},
+		{"fold multiply",
+			[]string{"push constant 300", "push constant 200", "call Math.multiply 2"},
+			[]string{"push constant 5536", "neg"},
+		},
+		{"fold divide",
+			[]string{"push constant 17", "push constant 5", "call Math.divide 2"},
+			[]string{"push constant 3"},
+		},
+		{"divide by 0 is kept",
+			[]string{"push constant 17", "push constant 0", "call Math.divide 2"},
+			[]string{"push constant 17", "push constant 0", "call Math.divide 2"},
+		},
+		{"multiply by 2",
+			[]string{"push local 0", "push constant 2", "call Math.multiply 2", "pop local 1"},
+			[]string{"push local 0", "push local 0", "add", "pop local 1"},
+		},
+		{"multiply 2 by a value",
+			[]string{"push constant 2", "push argument 1", "call Math.multiply 2"},
+			[]string{"push argument 1", "push argument 1", "add"},
+		},
+		{"multiply by 1",
+			[]string{"push constant 1", "push this 2", "call Math.multiply 2"},
+			[]string{"push this 2"},
+		},
+		{"multiply by 0",
+			[]string{"push local 0", "push constant 0", "call Math.multiply 2"},
+			[]string{"push constant 0"},
+		},
+		{"multiply a result by 0",
+			[]string{"push local 0", "push local 1", "add", "push constant 0", "call Math.multiply 2"},
+			[]string{"push local 0", "push local 1", "add", "push constant 0", "and"},
+		},
+		{"multiply and divide a result by 1",
+			[]string{"push local 0", "not", "push constant 1", "call Math.multiply 2", "push constant 1", "call Math.divide 2"},
+			[]string{"push local 0", "not"},
+		},
+		{"divide by 1",
+			[]string{"push local 0", "push constant 1", "call Math.divide 2"},
+			[]string{"push local 0"},
+		},
+		{"multiply by 3 is kept",
+			[]string{"push local 0", "push constant 3", "call Math.multiply 2"},
+			[]string{"push local 0", "push constant 3", "call Math.multiply 2"},
+		},
+		{"calls of other functions are kept",
+			[]string{"push local 0", "push constant 1", "call Main.multiply 2"},
+			[]string{"push local 0", "push constant 1", "call Main.multiply 2"},
+		},
+		{"labels stop folding",
+			[]string{"push constant 1", "label L", "push constant 2", "add"},
+			[]string{"push constant 1", "label L", "push constant 2", "add"},
+		},
+		{"eq of a value that may not be a boolean is kept",
+			[]string{"push local 0", "push constant 0", "eq", "pop local 1"},
+			[]string{"push local 0", "push constant 0", "eq", "pop local 1"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			input := parseString(t, strings.Join(test.input, "\n")+"\n")
+			actual := format(Optimize(input))
+			if actual != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), actual)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsPositions(t *testing.T) {
+	cmds := Optimize(parseString(t, "push local 0\npush constant 1\npush constant 2\nadd\npop local 1\n"))
+	if len(cmds) != 3 {
+		t.Fatalf("expected 3 commands got %s", format(cmds))
+	}
+	for i, line := range []int{1, 2, 5} {
+		if cmds[i].Pos.Line != line || cmds[i].File != "Main.vm" {
+			t.Errorf("expected %q from Main.vm:%d got %s:%d", cmds[i].String(), line, cmds[i].File, cmds[i].Pos.Line)
+		}
+	}
+}
+
+func TestOptimizeLabelsOfFiles(t *testing.T) {
+	t.Parallel()
+	src := "push local 0\npush constant 0\neq\nif-goto L\n"
+	labels := map[string]bool{}
+	for _, name := range []string{"Main.vm", "Other.vm"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, cmd := range Optimize(parse(t, path)) {
+			if cmd.Type == parser.C_LABEL {
+				if labels[cmd.Arg1] {
+					t.Errorf("expected the labels of different files to differ got %s twice", cmd.Arg1)
+				}
+				labels[cmd.Arg1] = true
+			}
+		}
+	}
+	if len(labels) != 2 {
+		t.Errorf("expected a label in each file got %v", labels)
+	}
+}
+
+func TestOptimizeLabelsOfTheProgram(t *testing.T) {
+	t.Parallel()
+	// the optimizer names its labels skip$N, which a program can't use
+	for _, src := range []string{"label skip$1\n", "goto Main$skip$1\n"} {
+		if _, err := parser.NewParser(strings.NewReader(src)).ParseAll(); err == nil {
+			t.Errorf("expected %q to be rejected", src)
+		}
+	}
+
+	src := "function Main.f 0\npush local 0\npush constant 0\neq\nif-goto skip\nlabel skip\nlabel skip1\nreturn\n"
+	program := map[string]bool{}
+	for _, cmd := range parseString(t, src) {
+		if cmd.Type == parser.C_LABEL {
+			program[cmd.Arg1] = true
+		}
+	}
+	generated := 0
+	for _, cmd := range Optimize(parseString(t, src)) {
+		if cmd.Type == parser.C_LABEL && !program[cmd.Arg1] {
+			generated += 1
+			if !strings.Contains(cmd.Arg1, "$") {
+				t.Errorf("expected the generated label %s to contain $", cmd.Arg1)
+			}
+		}
+	}
+	if generated != 1 {
+		t.Errorf("expected a generated label got %d", generated)
+	}
+}
+
+// run translates the files with the Go backend, optimizing each one first if
+// optimize is set, runs the program with args and returns its output.
+func run(t *testing.T, files map[string][]parser.Command, names []string, optimize bool, args ...string) string {
+	dir := t.TempDir()
+	src, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	gw := gowriter.NewGoWriter(src)
+	for _, name := range names {
+		cmds := files[name]
+		if optimize {
+			cmds = Optimize(cmds)
+		}
+		gw.SetFileName(name)
+		for _, cmd := range cmds {
+			if err := gw.Write(cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exe := filepath.Join(dir, "program")
+	if output, err := exec.Command("go", "build", "-o", exe, src.Name()).CombinedOutput(); err != nil {
+		t.Fatalf("could not build the generated program: %v\n%s", err, output)
+	}
+	output, err := exec.Command(exe, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+	return string(output)
+}
+
+// liveRAM returns the lines of output printing RAM, without the stack cells at
+// or above SP. Those only hold values that were already popped, which the
+// optimizer may never have pushed.
+func liveRAM(t *testing.T, output string) string {
+	lines := strings.Fields(output)
+	var sp int
+	if _, err := fmt.Sscanf(lines[0], "RAM[0]=%d", &sp); err != nil {
+		t.Fatalf("expected RAM[0] first got %q", lines[0])
+	}
+	live := []string{}
+	for _, line := range lines {
+		var address, value int
+		if _, err := fmt.Sscanf(line, "RAM[%d]=%d", &address, &value); err != nil {
+			t.Fatalf("unexpected output %q", line)
+		}
+		if address < sp || address >= 2048 {
+			live = append(live, line)
+		}
+	}
+	return strings.Join(live, "\n")
+}
+
+// TestOptimizePreservesResults runs the sample programs and a program using
+// every rule with and without optimization and compares the final RAM.
+func TestOptimizePreservesResults(t *testing.T) {
+	t.Parallel()
+	everyRule := strings.Join([]string{
+		"function Sys.init 2",
+		"push constant 7",
+		"pop local 0",
+		"label LOOP",
+		"push local 0",
+		"push constant 0",
+		"eq",
+		"if-goto END",
+		"push local 1",
+		"push constant 2",
+		"push constant 3",
+		"add",
+		"add",
+		"push constant 0",
+		"add",
+		"pop local 1",
+		"push local 1",
+		"pop local 1",
+		"push local 0",
+		"push constant 1",
+		"sub",
+		"pop local 0",
+		"push local 0",
+		"push constant 3",
+		"eq",
+		"not",
+		"if-goto LOOP",
+		"push local 1",
+		"push constant 100",
+		"lt",
+		"push constant 0",
+		"eq",
+		"not",
+		"not",
+		"pop static 0",
+		"goto LOOP",
+		"label END",
+		"push local 1",
+		"pop static 1",
+		"push local 1",
+		"push constant 2",
+		"call Math.multiply 2",
+		"pop static 2",
+		"push constant 6",
+		"push constant 7",
+		"call Math.multiply 2",
+		"push static 1",
+		"push constant 0",
+		"call Math.multiply 2",
+		"add",
+		"push constant 1",
+		"call Math.divide 2",
+		"pop static 3",
+		"push constant 45",
+		"push constant 7",
+		"call Math.divide 2",
+		"pop static 4",
+		"label HALT",
+		"goto HALT",
+	}, "\n") + "\n"
+	// Math multiplies and divides positive numbers by repeated addition and subtraction
+	math := strings.Join([]string{
+		"function Math.multiply 1",
+		"label LOOP",
+		"push argument 1",
+		"push constant 0",
+		"eq",
+		"if-goto END",
+		"push local 0",
+		"push argument 0",
+		"add",
+		"pop local 0",
+		"push argument 1",
+		"push constant 1",
+		"sub",
+		"pop argument 1",
+		"goto LOOP",
+		"label END",
+		"push local 0",
+		"return",
+		"function Math.divide 1",
+		"label LOOP",
+		"push argument 0",
+		"push argument 1",
+		"lt",
+		"if-goto END",
+		"push argument 0",
+		"push argument 1",
+		"sub",
+		"pop argument 0",
+		"push local 0",
+		"push constant 1",
+		"add",
+		"pop local 0",
+		"goto LOOP",
+		"label END",
+		"push local 0",
+		"return",
+	}, "\n") + "\n"
+
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{"BasicLoop", []string{"../ProgramFlow/BasicLoop/BasicLoop.vm"}},
+		{"FibonacciSeries", []string{"../ProgramFlow/FibonacciSeries/FibonacciSeries.vm"}},
+		{"SimpleFunction", []string{"../FunctionCalls/SimpleFunction/SimpleFunction.vm"}},
+		{"NestedCall", []string{"../FunctionCalls/NestedCall/Sys.vm"}},
+		{"FibonacciElement", []string{"../FunctionCalls/FibonacciElement/Main.vm", "../FunctionCalls/FibonacciElement/Sys.vm"}},
+		{"StaticsTest", []string{"../FunctionCalls/StaticsTest/Class1.vm", "../FunctionCalls/StaticsTest/Class2.vm", "../FunctionCalls/StaticsTest/Sys.vm"}},
+		{"EveryRule", nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			files := map[string][]parser.Command{}
+			names := []string{}
+			for _, path := range test.paths {
+				names = append(names, filepath.Base(path))
+				files[filepath.Base(path)] = parse(t, path)
+			}
+			if test.paths == nil {
+				names = []string{"Main.vm", "Math.vm"}
+				files["Main.vm"] = parseString(t, everyRule)
+				mathPath := filepath.Join(t.TempDir(), "Math.vm")
+				if err := os.WriteFile(mathPath, []byte(math), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files["Math.vm"] = parse(t, mathPath)
+			}
+
+			// the RAM the CPUEmulator test scripts set up for the programs without a bootstrap
+			args := []string{
+				"-set", "0=317", "-set", "1=317", "-set", "2=310", "-set", "3=3000", "-set", "4=4000",
+				"-set", "310=1234", "-set", "311=37", "-set", "312=1000", "-set", "313=305", "-set", "314=300",
+				"-set", "400=3000", "-set", "401=4000",
+				"-print", "0-20", "-print", "256-320", "-print", "3000-3010", "-print", "4000-4010",
+			}
+			before := liveRAM(t, run(t, files, names, false, args...))
+			after := liveRAM(t, run(t, files, names, true, args...))
+			if before != after {
+				t.Errorf("optimization changed the final RAM\nbefore:\n%s\nafter:\n%s", before, after)
+			}
+
+			optimized := len(Optimize(files["Main.vm"]))
+			if test.paths == nil && optimized >= len(files["Main.vm"]) {
+				t.Errorf("expected the optimizer to remove commands got %d of %d", optimized, len(files["Main.vm"]))
+			}
+		})
+	}
+}